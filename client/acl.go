@@ -0,0 +1,84 @@
+package redislike
+
+// ACLUser describes an ACL user returned by ACLGetUser.
+type ACLUser struct {
+	Flags     []string `json:"flags"`
+	Passwords []string `json:"passwords"` // SHA-256 hashes of passwords
+	Commands  string   `json:"commands"`  // rules of allowed commands
+	Keys      []string `json:"keys"`      // glob patterns of allowed keys
+}
+
+// Auth authenticates the connection as the default user.
+func (c *Client) Auth(password string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "AUTH", password)
+}
+
+// AuthUser authenticates the connection as the user.
+func (c *Client) AuthUser(username string, password string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "AUTH", username, password)
+}
+
+// ACLSetUser creates the user or modifies it with the rules,
+// e.g. "on", ">password", "~cache:*", "+@read".
+func (c *Client) ACLSetUser(username string, rules ...string) (int, error) {
+	var result int
+	args := append([]string{"SETUSER", username}, rules...)
+	return result, c.genericCommand(&result, "ACL", args...)
+}
+
+// ACLGetUser returns the user or nil if there is no such user.
+func (c *Client) ACLGetUser(username string) (*ACLUser, error) {
+	var result *ACLUser
+	return result, c.genericCommand(&result, "ACL", "GETUSER", username)
+}
+
+// ACLDelUser removes the users and disconnects their clients.
+// Returns the number of removed users.
+func (c *Client) ACLDelUser(usernames ...string) (int, error) {
+	var result int
+	args := append([]string{"DELUSER"}, usernames...)
+	return result, c.genericCommand(&result, "ACL", args...)
+}
+
+// ACLList returns rules of all users in the format of the ACL file.
+func (c *Client) ACLList() ([]string, error) {
+	var result []string
+	return result, c.genericCommand(&result, "ACL", "LIST")
+}
+
+// ACLUsers returns names of all users.
+func (c *Client) ACLUsers() ([]string, error) {
+	var result []string
+	return result, c.genericCommand(&result, "ACL", "USERS")
+}
+
+// ACLWhoAmI returns the name of the user the connection is authenticated as.
+func (c *Client) ACLWhoAmI() (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "ACL", "WHOAMI")
+}
+
+// ACLCat returns command categories or commands of the category
+// if it is not empty.
+func (c *Client) ACLCat(category string) ([]string, error) {
+	var result []string
+	args := []string{"CAT"}
+	if category != "" {
+		args = append(args, category)
+	}
+	return result, c.genericCommand(&result, "ACL", args...)
+}
+
+// ACLSave writes users to the ACL file of the server.
+func (c *Client) ACLSave() (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "ACL", "SAVE")
+}
+
+// ACLLoad replaces users with the ones from the ACL file of the server.
+func (c *Client) ACLLoad() (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "ACL", "LOAD")
+}
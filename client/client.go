@@ -2,14 +2,42 @@ package redislike
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/bannerlog/redislike/protocol"
 )
 
+// binaryString is a string reply which may hold arbitrary bytes. Strings
+// which are not valid UTF-8 are replied as {"base64": "..."}.
+type binaryString string
+
+func (b *binaryString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Base64 []byte `json:"base64"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*b = binaryString(v.Base64)
+		return nil
+	}
+
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s != nil {
+		*b = binaryString(*s)
+	}
+	return nil
+}
+
 // ErrCommandResult ...
 type ErrCommandResult struct {
 	s string
@@ -21,7 +49,9 @@ func (e *ErrCommandResult) Error() string {
 
 // Client represents a wrapepr for server requests and response.
 type Client struct {
-	conn net.Conn
+	conn   net.Conn
+	reader *bufio.Reader
+	pushes []*redislike.Response // messages received while waiting for a response
 }
 
 // NewClient returns a new Client given an ip and port of a server.
@@ -36,6 +66,41 @@ func NewClient(ip string, port uint16) (*Client, error) {
 	return &c, nil
 }
 
+// NewTLSClient works like NewClient but connects to a TLS listener
+// of the server. If cfg has no ServerName, ip is used to verify
+// the certificate of the server.
+func NewTLSClient(ip string, port uint16, cfg *tls.Config) (*Client, error) {
+	c := Client{}
+	if err := c.connect(ip, port); err != nil {
+		return nil, err
+	}
+
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = ip
+	}
+	conn := tls.Client(c.conn, cfg)
+	if err := conn.Handshake(); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	return &c, nil
+}
+
+// NewUnixClient works like NewClient but connects to the Unix socket
+// of a server running on the same host.
+func NewUnixClient(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
 func (c *Client) connect(ip string, port uint16) error {
 	tcpAddr, err := net.ResolveTCPAddr("tcp4", fmt.Sprintf("%s:%d", ip, port))
 	if err != nil {
@@ -46,6 +111,7 @@ func (c *Client) connect(ip string, port uint16) error {
 	if err != nil {
 		return err
 	}
+	c.reader = bufio.NewReader(c.conn)
 
 	return nil
 }
@@ -56,10 +122,11 @@ func (c *Client) Ping() (string, error) {
 	return result, c.genericCommand(&result, "PING")
 }
 
-// Info returns state of the storage (golang representation of entries and expiries).
-func (c *Client) Info() (string, error) {
+// Info returns statistics of the server. Without sections the default ones are
+// returned, "all" returns every section including commandstats.
+func (c *Client) Info(sections ...string) (string, error) {
 	var result string
-	return result, c.genericCommand(&result, "INFO")
+	return result, c.genericCommand(&result, "INFO", sections...)
 }
 func (c *Client) Summary() (string, error) {
 	var result string
@@ -72,6 +139,12 @@ func (c *Client) Keys() ([]string, error) {
 	return result, c.genericCommand(&result, "KEYS")
 }
 
+// KeysMatch returns keys matching the glob pattern.
+func (c *Client) KeysMatch(pattern string) ([]string, error) {
+	var result []string
+	return result, c.genericCommand(&result, "KEYS", pattern)
+}
+
 // Set key to hold the string value. If key already holds a value,
 // it is overwritten, regardless of its type. Any previous time
 // to live associated with the key is discarded on successful SET operation.
@@ -82,19 +155,386 @@ func (c *Client) Set(key string, value string) (int, error) {
 	return result, err
 }
 
+// SetOptions modifies the behaviour of SetWithOptions and SetGet.
+type SetOptions struct {
+	NX      bool          // Only set the key if it does not already exist.
+	XX      bool          // Only set the key if it already exists.
+	TTL     time.Duration // Set a timeout on key with millisecond precision.
+	KeepTTL bool          // Retain the time to live associated with the key.
+}
+
+func (o SetOptions) args() []string {
+	var args []string
+	if o.NX {
+		args = append(args, "NX")
+	}
+	if o.XX {
+		args = append(args, "XX")
+	}
+	if o.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(o.TTL/time.Millisecond), 10))
+	}
+	if o.KeepTTL {
+		args = append(args, "KEEPTTL")
+	}
+	return args
+}
+
+// SetWithOptions works like Set but allows to set a key conditionally and
+// with a timeout. Returns 1 if the key was set or 0 if it was not because
+// of NX or XX condition.
+func (c *Client) SetWithOptions(key string, value string, opt SetOptions) (int, error) {
+	var result int
+	args := append([]string{key, value}, opt.args()...)
+	return result, c.genericCommand(&result, "SET", args...)
+}
+
+// SetGet works like SetWithOptions but returns the old string stored at key
+// or empty string if the key did not exist.
+func (c *Client) SetGet(key string, value string, opt SetOptions) (string, error) {
+	var result binaryString
+	args := append([]string{key, value}, opt.args()...)
+	err := c.genericCommand(&result, "SET", append(args, "GET")...)
+	return string(result), err
+}
+
+// SetNX sets key to hold the string value if key does not exist.
+// Returns 1 if the key was set or 0 if it already exists.
+func (c *Client) SetNX(key string, value string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SETNX", key, value)
+}
+
+// MSet sets the given keys to their respective values.
+func (c *Client) MSet(values map[string]string) (int, error) {
+	var result int
+	args := make([]string, 0, len(values)*2)
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+	return result, c.genericCommand(&result, "MSET", args...)
+}
+
+// MGet returns the values of all specified keys. Empty string is returned
+// for every key that does not exist or does not hold a string.
+func (c *Client) MGet(keys []string) ([]string, error) {
+	var result []binaryString
+	err := c.genericCommand(&result, "MGET", keys...)
+	values := make([]string, len(result))
+	for i, v := range result {
+		values[i] = string(v)
+	}
+	return values, err
+}
+
+// GetSet atomically sets key to value and returns the old value stored at key.
+func (c *Client) GetSet(key string, value string) (string, error) {
+	var result binaryString
+	err := c.genericCommand(&result, "GETSET", key, value)
+	return string(result), err
+}
+
+// GetDel gets the value of key and deletes the key.
+func (c *Client) GetDel(key string) (string, error) {
+	var result binaryString
+	err := c.genericCommand(&result, "GETDEL", key)
+	return string(result), err
+}
+
+// GetEx gets the value of key and sets a timeout on it with millisecond precision.
+// Zero ttl removes the timeout associated with the key.
+func (c *Client) GetEx(key string, ttl time.Duration) (string, error) {
+	var result binaryString
+	var err error
+	if ttl <= 0 {
+		err = c.genericCommand(&result, "GETEX", key, "PERSIST")
+	} else {
+		ms := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+		err = c.genericCommand(&result, "GETEX", key, "PX", ms)
+	}
+	return string(result), err
+}
+
+// Append appends the value at the end of the string stored at key.
+// Returns the length of the string after the append operation.
+func (c *Client) Append(key string, value string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "APPEND", key, value)
+}
+
+// StrLen returns the length of the string stored at key.
+func (c *Client) StrLen(key string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "STRLEN", key)
+}
+
+// GetRange returns the substring of the string stored at key between
+// the start and end offsets (both inclusive). Negative offsets are
+// counted from the end of the string.
+func (c *Client) GetRange(key string, start int, end int) (string, error) {
+	var result binaryString
+	err := c.genericCommand(&result, "GETRANGE", key, strconv.Itoa(start), strconv.Itoa(end))
+	return string(result), err
+}
+
+// SetRange overwrites part of the string stored at key starting at
+// the offset. The string is padded with zero bytes if it's shorter than
+// the offset. Returns the length of the string after it was modified.
+func (c *Client) SetRange(key string, offset int, value string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SETRANGE", key, strconv.Itoa(offset), value)
+}
+
+// SetBit sets or clears the bit at offset in the string stored at key.
+// Returns the original bit value.
+func (c *Client) SetBit(key string, offset int, value int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SETBIT", key, strconv.Itoa(offset), strconv.Itoa(value))
+}
+
+// GetBit returns the bit value at offset in the string stored at key.
+func (c *Client) GetBit(key string, offset int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "GETBIT", key, strconv.Itoa(offset))
+}
+
+// BitCount returns the number of bits set to 1 in the string stored at key.
+func (c *Client) BitCount(key string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "BITCOUNT", key)
+}
+
+// BitCountRange works like BitCount but counts bits between the start and end
+// offsets only. Offsets are in bytes, or in bits if unit is "BIT".
+func (c *Client) BitCountRange(key string, start int, end int, unit string) (int, error) {
+	var result int
+	args := []string{key, strconv.Itoa(start), strconv.Itoa(end)}
+	if unit != "" {
+		args = append(args, unit)
+	}
+	return result, c.genericCommand(&result, "BITCOUNT", args...)
+}
+
+// BitPos returns the position of the first bit set to 1 or 0 in the string
+// stored at key.
+func (c *Client) BitPos(key string, bit int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "BITPOS", key, strconv.Itoa(bit))
+}
+
+// BitPosRange works like BitPos but looks for the bit between the start and
+// end offsets only. Offsets are in bytes, or in bits if unit is "BIT".
+// Returns -1 if there is no such bit.
+func (c *Client) BitPosRange(key string, bit int, start int, end int, unit string) (int, error) {
+	var result int
+	args := []string{key, strconv.Itoa(bit), strconv.Itoa(start), strconv.Itoa(end)}
+	if unit != "" {
+		args = append(args, unit)
+	}
+	return result, c.genericCommand(&result, "BITPOS", args...)
+}
+
+// BitOp performs a bitwise operation (AND, OR, XOR or NOT) between strings
+// stored at keys and stores the result at destkey.
+// Returns the length of the string stored at destkey.
+func (c *Client) BitOp(op string, destkey string, keys []string) (int, error) {
+	var result int
+	args := append([]string{op, destkey}, keys...)
+	return result, c.genericCommand(&result, "BITOP", args...)
+}
+
 // Get the value of key. If the key does not exist empty string is returned.
 // An error is returned if the value stored at key is not a string,
 // because GET command only handles string values.
 func (c *Client) Get(key string) (string, error) {
-	var result string
-	return result, c.genericCommand(&result, "GET", key)
+	var result binaryString
+	err := c.genericCommand(&result, "GET", key)
+	return string(result), err
 }
 
-// Del removes the specified key. A key is ignored if it does not exist.
+// Del removes the specified keys. A key is ignored if it does not exist.
 // Return the number of keys that were removed.
-func (c *Client) Del(key string) (int, error) {
+func (c *Client) Del(keys ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "DEL", keys...)
+}
+
+// Unlink works like Del, the server takes constant time for big values either way.
+func (c *Client) Unlink(keys ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "UNLINK", keys...)
+}
+
+// Exists returns the number of existing keys among the specified ones.
+func (c *Client) Exists(keys ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "EXISTS", keys...)
+}
+
+// Touch returns the number of existing keys among the specified ones.
+func (c *Client) Touch(keys ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "TOUCH", keys...)
+}
+
+// Type returns the type of the value stored at key, "none" if there is no key.
+func (c *Client) Type(key string) (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "TYPE", key)
+}
+
+// Rename renames key to newkey keeping its expiry. An existing newkey is overwritten.
+func (c *Client) Rename(key string, newkey string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "RENAME", key, newkey)
+}
+
+// RenameNX works like Rename unless newkey exists. Returns 1 if the key was renamed.
+func (c *Client) RenameNX(key string, newkey string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "RENAMENX", key, newkey)
+}
+
+// Copy copies the value stored at src along with its expiry to dst.
+// With replace an existing dst is overwritten. Returns 1 if the value was copied.
+func (c *Client) Copy(src string, dst string, replace bool) (int, error) {
+	var result int
+	args := []string{src, dst}
+	if replace {
+		args = append(args, "REPLACE")
+	}
+	return result, c.genericCommand(&result, "COPY", args...)
+}
+
+// CopyDB works like Copy but stores the copy in another database.
+func (c *Client) CopyDB(src string, dst string, db int, replace bool) (int, error) {
+	var result int
+	args := []string{src, dst, "DB", strconv.Itoa(db)}
+	if replace {
+		args = append(args, "REPLACE")
+	}
+	return result, c.genericCommand(&result, "COPY", args...)
+}
+
+// Move moves the key to another database. Returns 1 if the key was moved
+// or 0 if it does not exist or the database already holds it.
+func (c *Client) Move(key string, db int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "MOVE", key, strconv.Itoa(db))
+}
+
+// RandomKey returns a random key or an empty string if the database is empty.
+func (c *Client) RandomKey() (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "RANDOMKEY")
+}
+
+// Dump returns the value stored at key and its deadline serialized (and base64
+// encoded) to be passed to Restore, or an empty string if the key does not exist.
+func (c *Client) Dump(key string) (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "DUMP", key)
+}
+
+// RestoreOptions modifies the behaviour of Restore.
+type RestoreOptions struct {
+	Replace bool // Overwrite the key if it already exists.
+	AbsTTL  bool // The ttl is a unix time in milliseconds.
+}
+
+// Restore creates the key from the value serialized by Dump. The ttl is
+// in milliseconds, 0 means the key gets the deadline it had when it was
+// dumped, if any. Returns 1 if the key was restored.
+func (c *Client) Restore(key string, ttl int64, value string, opt RestoreOptions) (int, error) {
+	var result int
+	args := []string{key, strconv.FormatInt(ttl, 10), value}
+	if opt.Replace {
+		args = append(args, "REPLACE")
+	}
+	if opt.AbsTTL {
+		args = append(args, "ABSTTL")
+	}
+	return result, c.genericCommand(&result, "RESTORE", args...)
+}
+
+// MigrateArgs are arguments of Migrate.
+type MigrateArgs struct {
+	Host    string
+	Port    uint16
+	DB      int           // Database of the target instance.
+	Timeout time.Duration // Timeout of every request to the target instance.
+	Copy    bool          // Keep keys on this instance.
+	Replace bool          // Overwrite existing keys on the target instance.
+
+	// Credentials of the target instance. Username may be empty
+	// for the default user.
+	Username string
+	Password string
+}
+
+// Migrate transfers keys to another instance. Returns "OK" or "NOKEY"
+// if none of the keys exist.
+func (c *Client) Migrate(args MigrateArgs, keys ...string) (string, error) {
+	var result string
+	argv := []string{
+		args.Host,
+		strconv.Itoa(int(args.Port)),
+		"",
+		strconv.Itoa(args.DB),
+		strconv.FormatInt(int64(args.Timeout/time.Millisecond), 10),
+	}
+	if args.Copy {
+		argv = append(argv, "COPY")
+	}
+	if args.Replace {
+		argv = append(argv, "REPLACE")
+	}
+	if args.Username != "" {
+		argv = append(argv, "AUTH2", args.Username, args.Password)
+	} else if args.Password != "" {
+		argv = append(argv, "AUTH", args.Password)
+	}
+	argv = append(argv, "KEYS")
+	return result, c.genericCommand(&result, "MIGRATE", append(argv, keys...)...)
+}
+
+// Select switches the connection to the database with the given index.
+func (c *Client) Select(db int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SELECT", strconv.Itoa(db))
+}
+
+// SwapDB swaps two databases.
+func (c *Client) SwapDB(db1 int, db2 int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SWAPDB", strconv.Itoa(db1), strconv.Itoa(db2))
+}
+
+// FlushDB removes all keys of the current database. The server takes
+// constant time either way, async is accepted for compatibility with Redis.
+func (c *Client) FlushDB(async bool) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "FLUSHDB", flushMode(async))
+}
+
+// FlushAll removes all keys of all databases. The server takes
+// constant time either way, async is accepted for compatibility with Redis.
+func (c *Client) FlushAll(async bool) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "FLUSHALL", flushMode(async))
+}
+
+func flushMode(async bool) string {
+	if async {
+		return "ASYNC"
+	}
+	return "SYNC"
+}
+
+// DBSize returns the number of keys in the current database.
+func (c *Client) DBSize() (int, error) {
 	var result int
-	return result, c.genericCommand(&result, "DEL", key)
+	return result, c.genericCommand(&result, "DBSIZE")
 }
 
 // Expire set a timeout on key. After the timeout has expired,
@@ -197,6 +637,71 @@ func (c *Client) HLen(key string) (int, error) {
 	return result, c.genericCommand(&result, "HLEN", key)
 }
 
+// HExpire sets a timeout in seconds on fields of the hash stored at key.
+// For every field it returns -2 if there is no such field, 0 if the condition
+// was not met, 1 if the timeout was set and 2 if the field was deleted
+// because the timeout is zero.
+func (c *Client) HExpire(key string, sec int, fields []string) ([]int, error) {
+	return c.genericFieldsCommand("HEXPIRE", key, strconv.Itoa(sec), fields)
+}
+
+// HPExpire works exactly like HExpire but the timeout is in milliseconds.
+func (c *Client) HPExpire(key string, ms int, fields []string) ([]int, error) {
+	return c.genericFieldsCommand("HPEXPIRE", key, strconv.Itoa(ms), fields)
+}
+
+// HTTL returns the remaining time to live in seconds of fields of the hash
+// stored at key, -1 if a field has no timeout or -2 if there is no such field.
+func (c *Client) HTTL(key string, fields []string) ([]int, error) {
+	return c.genericFieldsCommand("HTTL", key, "", fields)
+}
+
+// HPTTL works exactly like HTTL but returns time to live in milliseconds.
+func (c *Client) HPTTL(key string, fields []string) ([]int, error) {
+	return c.genericFieldsCommand("HPTTL", key, "", fields)
+}
+
+// HPersist removes timeouts of fields of the hash stored at key.
+// For every field it returns 1 if the timeout was removed, -1 if the field
+// has no timeout or -2 if there is no such field.
+func (c *Client) HPersist(key string, fields []string) ([]int, error) {
+	return c.genericFieldsCommand("HPERSIST", key, "", fields)
+}
+
+func (c *Client) genericFieldsCommand(cmd string, key string, t string, fields []string) ([]int, error) {
+	var result []int
+	args := []string{key}
+	if t != "" {
+		args = append(args, t)
+	}
+	args = append(args, "FIELDS", strconv.Itoa(len(fields)))
+	args = append(args, fields...)
+	return result, c.genericCommand(&result, cmd, args...)
+}
+
+// PFAdd adds the elements to the HyperLogLog stored at key.
+// Returns 1 if the estimated cardinality was altered and 0 otherwise.
+// Unlike in Redis, the HyperLogLog isn't a string, its type is "hyperloglog".
+func (c *Client) PFAdd(key string, elements []string) (int, error) {
+	var result int
+	args := append([]string{key}, elements...)
+	return result, c.genericCommand(&result, "PFADD", args...)
+}
+
+// PFCount returns the approximated cardinality of the union of HyperLogLogs
+// stored at keys.
+func (c *Client) PFCount(keys []string) (int64, error) {
+	var result int64
+	return result, c.genericCommand(&result, "PFCOUNT", keys...)
+}
+
+// PFMerge merges HyperLogLogs stored at the source keys into destkey.
+func (c *Client) PFMerge(destkey string, keys []string) (int, error) {
+	var result int
+	args := append([]string{destkey}, keys...)
+	return result, c.genericCommand(&result, "PFMERGE", args...)
+}
+
 func (c *Client) genericCommand(result interface{}, cmd string, args ...string) error {
 	resp, err := c.request(cmd, args...)
 	if err != nil {
@@ -220,10 +725,17 @@ func (c *Client) request(cmd string, args ...string) (*redislike.Response, error
 		return nil, err
 	}
 
-	// get response
-	resp, err := redislike.ReadResponse(bufio.NewReader(c.conn))
-	if err != nil {
-		return nil, err
+	// get response, messages of subscribed channels are kept for Receive
+	var resp *redislike.Response
+	for {
+		resp, err = redislike.ReadResponse(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if !resp.IsPush() {
+			break
+		}
+		c.pushes = append(c.pushes, resp)
 	}
 
 	if resp.IsErr() {
@@ -0,0 +1,26 @@
+package redislike
+
+// ConfigGet returns values of the server parameters matching the glob pattern.
+func (c *Client) ConfigGet(pattern string) (map[string]string, error) {
+	var result map[string]string
+	return result, c.genericCommand(&result, "CONFIG", "GET", pattern)
+}
+
+// ConfigSet changes server parameters at runtime. Either all
+// of the parameters are changed or none of them.
+func (c *Client) ConfigSet(params map[string]string) (int, error) {
+	args := []string{"SET"}
+	for name, v := range params {
+		args = append(args, name, v)
+	}
+
+	var result int
+	return result, c.genericCommand(&result, "CONFIG", args...)
+}
+
+// ConfigRewrite writes the current configuration to the config file
+// the server was started with.
+func (c *Client) ConfigRewrite() (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CONFIG", "REWRITE")
+}
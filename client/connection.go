@@ -0,0 +1,93 @@
+package redislike
+
+import "strconv"
+
+// ClientInfo describes a connection returned by ClientInfo and ClientList.
+type ClientInfo struct {
+	ID   int64  `json:"id"`
+	Addr string `json:"addr"`
+	Name string `json:"name"`
+	Age  int64  `json:"age"`  // seconds since the connection was opened
+	Idle int64  `json:"idle"` // seconds since the last command
+	DB   int    `json:"db"`
+	Sub  int    `json:"sub"`  // number of subscribed channels
+	PSub int    `json:"psub"` // number of subscribed patterns
+	User string `json:"user"`
+	Cmd  string `json:"cmd"`  // the last command
+	QBuf int64  `json:"qbuf"` // bytes of requests read but not executed yet
+	OLL  int    `json:"oll"`  // messages waiting to be pushed
+}
+
+// ClientKillArgs are filters of ClientKillFilter. Clients matching
+// all of the given filters are killed.
+type ClientKillArgs struct {
+	ID     int64
+	Addr   string
+	User   string
+	KillMe bool // kill this connection too if it matches
+}
+
+// ClientID returns the ID of the connection.
+func (c *Client) ClientID() (int64, error) {
+	var result int64
+	return result, c.genericCommand(&result, "CLIENT", "ID")
+}
+
+// ClientInfo returns the state of the connection.
+func (c *Client) ClientInfo() (*ClientInfo, error) {
+	var result *ClientInfo
+	return result, c.genericCommand(&result, "CLIENT", "INFO")
+}
+
+// ClientList returns connected clients or the ones with the given IDs.
+func (c *Client) ClientList(ids ...int64) ([]ClientInfo, error) {
+	args := []string{"LIST"}
+	if len(ids) > 0 {
+		args = append(args, "ID")
+		for _, id := range ids {
+			args = append(args, strconv.FormatInt(id, 10))
+		}
+	}
+
+	var result []ClientInfo
+	return result, c.genericCommand(&result, "CLIENT", args...)
+}
+
+// ClientSetName names the connection, an empty name removes it.
+func (c *Client) ClientSetName(name string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CLIENT", "SETNAME", name)
+}
+
+// ClientGetName returns the name of the connection.
+func (c *Client) ClientGetName() (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "CLIENT", "GETNAME")
+}
+
+// ClientKill closes the connection of the client with the address.
+func (c *Client) ClientKill(addr string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CLIENT", "KILL", addr)
+}
+
+// ClientKillFilter closes connections matching the filters.
+// Returns the number of closed connections.
+func (c *Client) ClientKillFilter(args ClientKillArgs) (int, error) {
+	argv := []string{"KILL"}
+	if args.ID != 0 {
+		argv = append(argv, "ID", strconv.FormatInt(args.ID, 10))
+	}
+	if args.Addr != "" {
+		argv = append(argv, "ADDR", args.Addr)
+	}
+	if args.User != "" {
+		argv = append(argv, "USER", args.User)
+	}
+	if args.KillMe {
+		argv = append(argv, "SKIPME", "no")
+	}
+
+	var result int
+	return result, c.genericCommand(&result, "CLIENT", argv...)
+}
@@ -0,0 +1,96 @@
+package redislike
+
+import (
+	"strconv"
+)
+
+// BFReserve creates a scalable Bloom filter for capacity items with the given
+// error rate. Zero expansion creates a non-scaling filter.
+func (c *Client) BFReserve(key string, errorRate float64, capacity int64, expansion int64) (int, error) {
+	var result int
+	args := []string{key, strconv.FormatFloat(errorRate, 'g', -1, 64), strconv.FormatInt(capacity, 10)}
+	if expansion == 0 {
+		args = append(args, "NONSCALING")
+	} else {
+		args = append(args, "EXPANSION", strconv.FormatInt(expansion, 10))
+	}
+	return result, c.genericCommand(&result, "BF.RESERVE", args...)
+}
+
+// BFAdd adds the item to the Bloom filter stored at key. Returns 1 if
+// the item was added and 0 if it may already exist.
+func (c *Client) BFAdd(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "BF.ADD", key, item)
+}
+
+// BFMAdd adds items to the Bloom filter stored at key.
+func (c *Client) BFMAdd(key string, items ...string) ([]int, error) {
+	var result []int
+	args := append([]string{key}, items...)
+	return result, c.genericCommand(&result, "BF.MADD", args...)
+}
+
+// BFExists returns 0 if the item definitely is not in the Bloom filter
+// and 1 if it may be.
+func (c *Client) BFExists(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "BF.EXISTS", key, item)
+}
+
+// BFMExists works like BFExists for several items.
+func (c *Client) BFMExists(key string, items ...string) ([]int, error) {
+	var result []int
+	args := append([]string{key}, items...)
+	return result, c.genericCommand(&result, "BF.MEXISTS", args...)
+}
+
+// CFReserve creates a cuckoo filter for capacity items. Zero bucketSize and
+// maxIterations mean server defaults, zero expansion creates a non-scaling filter.
+func (c *Client) CFReserve(key string, capacity int64, bucketSize int, maxIterations int, expansion int64) (int, error) {
+	var result int
+	args := []string{key, strconv.FormatInt(capacity, 10)}
+	if bucketSize > 0 {
+		args = append(args, "BUCKETSIZE", strconv.Itoa(bucketSize))
+	}
+	if maxIterations > 0 {
+		args = append(args, "MAXITERATIONS", strconv.Itoa(maxIterations))
+	}
+	if expansion == 0 {
+		args = append(args, "NONSCALING")
+	} else {
+		args = append(args, "EXPANSION", strconv.FormatInt(expansion, 10))
+	}
+	return result, c.genericCommand(&result, "CF.RESERVE", args...)
+}
+
+// CFAdd adds the item to the cuckoo filter stored at key.
+func (c *Client) CFAdd(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CF.ADD", key, item)
+}
+
+// CFAddNX adds the item unless it may already exist. Returns 1 if the item was added.
+func (c *Client) CFAddNX(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CF.ADDNX", key, item)
+}
+
+// CFExists returns 0 if the item definitely is not in the cuckoo filter
+// and 1 if it may be.
+func (c *Client) CFExists(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CF.EXISTS", key, item)
+}
+
+// CFCount returns the number of times the item may have been added.
+func (c *Client) CFCount(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CF.COUNT", key, item)
+}
+
+// CFDel deletes one occurrence of the item. Returns 1 if it was deleted.
+func (c *Client) CFDel(key string, item string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "CF.DEL", key, item)
+}
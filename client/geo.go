@@ -0,0 +1,150 @@
+package redislike
+
+import (
+	"strconv"
+)
+
+// GeoLocation is a named point.
+type GeoLocation struct {
+	Name      string
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoAddArgs are arguments of GeoAdd.
+type GeoAddArgs struct {
+	NX        bool // Only add new members.
+	XX        bool // Only update existing members.
+	CH        bool // Count updated members as well as added ones.
+	Locations []GeoLocation
+}
+
+// GeoSearchArgs are arguments of GeoSearch. The center of the area is
+// the position of Member if it is set, otherwise Longitude and Latitude.
+// The area is a box if Width or Height is set, otherwise a circle.
+type GeoSearchArgs struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	Radius    float64
+	Width     float64
+	Height    float64
+	Unit      string // m, km, ft or mi, meters by default.
+	Desc      bool
+	Count     int // Maximum number of members, 0 means no limit.
+	Any       bool
+	WithCoord bool
+	WithDist  bool
+	WithHash  bool
+}
+
+// GeoSearchResult is a member found by GeoSearch. Dist, Hash and Coord
+// are set only if they were requested.
+type GeoSearchResult struct {
+	Name  string      `json:"name"`
+	Dist  string      `json:"dist"`
+	Hash  int64       `json:"hash"`
+	Coord *[2]float64 `json:"coord"`
+}
+
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// GeoAdd adds locations to the geospatial index stored at key.
+// Returns the number of added (or, with CH, changed) members.
+func (c *Client) GeoAdd(key string, a GeoAddArgs) (int, error) {
+	var result int
+	args := []string{key}
+	if a.NX {
+		args = append(args, "NX")
+	}
+	if a.XX {
+		args = append(args, "XX")
+	}
+	if a.CH {
+		args = append(args, "CH")
+	}
+	for _, l := range a.Locations {
+		args = append(args, formatCoord(l.Longitude), formatCoord(l.Latitude), l.Name)
+	}
+	return result, c.genericCommand(&result, "GEOADD", args...)
+}
+
+// GeoDist returns the distance between two members in the given unit.
+// The second return value is false if any of the members does not exist.
+func (c *Client) GeoDist(key string, member1 string, member2 string, unit string) (float64, bool, error) {
+	var result *string
+	args := []string{key, member1, member2}
+	if unit != "" {
+		args = append(args, unit)
+	}
+	if err := c.genericCommand(&result, "GEODIST", args...); err != nil || result == nil {
+		return 0, false, err
+	}
+	f, err := strconv.ParseFloat(*result, 64)
+	return f, err == nil, err
+}
+
+// GeoPos returns [longitude, latitude] pairs of members, nil for missing ones.
+func (c *Client) GeoPos(key string, members ...string) ([]*[2]float64, error) {
+	var result []*[2]float64
+	args := append([]string{key}, members...)
+	return result, c.genericCommand(&result, "GEOPOS", args...)
+}
+
+// GeoSearch returns members within the area sorted by distance from its center.
+func (c *Client) GeoSearch(key string, a GeoSearchArgs) ([]GeoSearchResult, error) {
+	args := []string{key}
+	if a.Member != "" {
+		args = append(args, "FROMMEMBER", a.Member)
+	} else {
+		args = append(args, "FROMLONLAT", formatCoord(a.Longitude), formatCoord(a.Latitude))
+	}
+
+	unit := a.Unit
+	if unit == "" {
+		unit = "m"
+	}
+	if a.Width > 0 || a.Height > 0 {
+		args = append(args, "BYBOX", formatCoord(a.Width), formatCoord(a.Height), unit)
+	} else {
+		args = append(args, "BYRADIUS", formatCoord(a.Radius), unit)
+	}
+
+	if a.Desc {
+		args = append(args, "DESC")
+	}
+	if a.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(a.Count))
+		if a.Any {
+			args = append(args, "ANY")
+		}
+	}
+
+	withAny := a.WithCoord || a.WithDist || a.WithHash
+	if a.WithCoord {
+		args = append(args, "WITHCOORD")
+	}
+	if a.WithDist {
+		args = append(args, "WITHDIST")
+	}
+	if a.WithHash {
+		args = append(args, "WITHHASH")
+	}
+
+	if withAny {
+		var result []GeoSearchResult
+		return result, c.genericCommand(&result, "GEOSEARCH", args...)
+	}
+
+	var names []string
+	if err := c.genericCommand(&names, "GEOSEARCH", args...); err != nil {
+		return nil, err
+	}
+	result := make([]GeoSearchResult, len(names))
+	for i, n := range names {
+		result[i].Name = n
+	}
+	return result, nil
+}
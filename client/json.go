@@ -0,0 +1,86 @@
+package redislike
+
+import (
+	"encoding/json"
+)
+
+// JSONSet sets the value (encoded to JSON) at the path of the JSON document
+// stored at key. A new document can be created at the root path "$" only.
+// Returns 1 or 0 if nothing was set.
+func (c *Client) JSONSet(key string, path string, value interface{}) (int, error) {
+	return c.jsonSet(key, path, value)
+}
+
+// JSONSetNX works like JSONSet but sets the value only if the path does not exist.
+func (c *Client) JSONSetNX(key string, path string, value interface{}) (int, error) {
+	return c.jsonSet(key, path, value, "NX")
+}
+
+// JSONSetXX works like JSONSet but sets the value only if the path exists.
+func (c *Client) JSONSetXX(key string, path string, value interface{}) (int, error) {
+	return c.jsonSet(key, path, value, "XX")
+}
+
+func (c *Client) jsonSet(key string, path string, value interface{}, opts ...string) (int, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+
+	var result *int
+	args := append([]string{key, path, string(b)}, opts...)
+	if err := c.genericCommand(&result, "JSON.SET", args...); err != nil || result == nil {
+		return 0, err
+	}
+	return *result, nil
+}
+
+// JSONGet returns the JSON text of values at the paths of the JSON document
+// stored at key. See JSON.GET for the format of the result.
+func (c *Client) JSONGet(key string, paths ...string) (string, error) {
+	var result string
+	args := append([]string{key}, paths...)
+	return result, c.genericCommand(&result, "JSON.GET", args...)
+}
+
+// JSONDel deletes values at the path. Returns the number of deleted values.
+func (c *Client) JSONDel(key string, path string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "JSON.DEL", key, path)
+}
+
+// JSONNumIncrBy increments numbers at the path by value.
+// Returns the JSON text of the new values.
+func (c *Client) JSONNumIncrBy(key string, path string, value float64) (string, error) {
+	var result string
+	b, _ := json.Marshal(value)
+	return result, c.genericCommand(&result, "JSON.NUMINCRBY", key, path, string(b))
+}
+
+// JSONArrAppend appends values (encoded to JSON) to arrays at the path.
+// Returns new lengths of the arrays, nil for matches which aren't arrays.
+func (c *Client) JSONArrAppend(key string, path string, values ...interface{}) ([]*int, error) {
+	args := []string{key, path}
+	for _, v := range values {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(b))
+	}
+
+	var reply json.RawMessage
+	if err := c.genericCommand(&reply, "JSON.ARRAPPEND", args...); err != nil {
+		return nil, err
+	}
+
+	var result []*int
+	if len(reply) > 0 && reply[0] != '[' {
+		var n int
+		if err := json.Unmarshal(reply, &n); err != nil {
+			return nil, err
+		}
+		return []*int{&n}, nil
+	}
+	return result, json.Unmarshal(reply, &result)
+}
@@ -0,0 +1,57 @@
+package redislike
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MonitoredCommand is a command executed by the server.
+type MonitoredCommand struct {
+	Time time.Time
+	DB   int
+	Addr string   // address of the client which issued the command
+	Args []string // the command followed by its arguments
+}
+
+// Monitor makes the server push every command it executes to the client.
+// The commands are returned by ReceiveCommand. The connection should be
+// dedicated to monitoring, as Receive fails on monitored commands.
+func (c *Client) Monitor() (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "MONITOR")
+}
+
+// ReceiveCommand blocks until the next command executed by the server
+// arrives and returns it.
+func (c *Client) ReceiveCommand() (*MonitoredCommand, error) {
+	resp, err := c.receivePush()
+	if err != nil {
+		return nil, err
+	}
+
+	v := resp.Values
+	if len(v) < 5 || v[0] != "monitor" {
+		return nil, ErrBadMessage
+	}
+	sec, usec, _ := strings.Cut(v[1], ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return nil, ErrBadMessage
+	}
+	us, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return nil, ErrBadMessage
+	}
+	db, err := strconv.Atoi(v[2])
+	if err != nil {
+		return nil, ErrBadMessage
+	}
+
+	return &MonitoredCommand{
+		Time: time.Unix(s, us*1000),
+		DB:   db,
+		Addr: v[3],
+		Args: v[4:],
+	}, nil
+}
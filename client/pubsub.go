@@ -0,0 +1,115 @@
+package redislike
+
+import (
+	"errors"
+
+	"github.com/bannerlog/redislike/protocol"
+)
+
+// ErrBadMessage rises when the server pushes a message of unknown kind.
+var ErrBadMessage = errors.New("Malformed pub/sub message")
+
+// Message is a message published to a channel the client is subscribed to.
+type Message struct {
+	Channel string
+	Pattern string // Pattern which matched the channel, empty for channel subscriptions.
+	Payload string
+}
+
+// Subscribe subscribes the client to the channels. Messages published to them
+// are returned by Receive. The connection can still be used for other commands.
+// Returns the number of channels and patterns the client is subscribed to.
+func (c *Client) Subscribe(channels ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SUBSCRIBE", channels...)
+}
+
+// Unsubscribe unsubscribes the client from the channels or from all channels
+// if none are given. Returns the number of channels and patterns
+// the client is still subscribed to.
+func (c *Client) Unsubscribe(channels ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "UNSUBSCRIBE", channels...)
+}
+
+// PSubscribe subscribes the client to channels matching the glob patterns.
+// Returns the number of channels and patterns the client is subscribed to.
+func (c *Client) PSubscribe(patterns ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "PSUBSCRIBE", patterns...)
+}
+
+// PUnsubscribe unsubscribes the client from the patterns or from all patterns
+// if none are given. Returns the number of channels and patterns
+// the client is still subscribed to.
+func (c *Client) PUnsubscribe(patterns ...string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "PUNSUBSCRIBE", patterns...)
+}
+
+// Publish posts the message to the channel.
+// Returns the number of clients that received the message.
+func (c *Client) Publish(channel string, message string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "PUBLISH", channel, message)
+}
+
+// PubSubChannels returns active channels (having at least one subscriber)
+// matching the glob pattern or all of them if the pattern is empty.
+func (c *Client) PubSubChannels(pattern string) ([]string, error) {
+	var result []string
+	args := []string{"CHANNELS"}
+	if pattern != "" {
+		args = append(args, pattern)
+	}
+	return result, c.genericCommand(&result, "PUBSUB", args...)
+}
+
+// PubSubNumSub returns the number of subscribers of the channels.
+func (c *Client) PubSubNumSub(channels ...string) (map[string]int, error) {
+	var result map[string]int
+	return result, c.genericCommand(&result, "PUBSUB", append([]string{"NUMSUB"}, channels...)...)
+}
+
+// PubSubNumPat returns the number of patterns clients are subscribed to.
+func (c *Client) PubSubNumPat() (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "PUBSUB", "NUMPAT")
+}
+
+// Receive blocks until the next message published to subscribed channels
+// arrives and returns it.
+func (c *Client) Receive() (*Message, error) {
+	resp, err := c.receivePush()
+	if err != nil {
+		return nil, err
+	}
+
+	v := resp.Values
+	switch {
+	case len(v) == 3 && v[0] == "message":
+		return &Message{Channel: v[1], Payload: v[2]}, nil
+	case len(v) == 4 && v[0] == "pmessage":
+		return &Message{Pattern: v[1], Channel: v[2], Payload: v[3]}, nil
+	}
+	return nil, ErrBadMessage
+}
+
+// receivePush returns the next message pushed by the server.
+func (c *Client) receivePush() (*redislike.Response, error) {
+	if len(c.pushes) > 0 {
+		var resp *redislike.Response
+		resp, c.pushes = c.pushes[0], c.pushes[1:]
+		return resp, nil
+	}
+
+	for {
+		resp, err := redislike.ReadResponse(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if resp.IsPush() {
+			return resp, nil
+		}
+	}
+}
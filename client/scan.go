@@ -0,0 +1,90 @@
+package redislike
+
+import (
+	"strconv"
+)
+
+// ScanArgs are arguments of Scan.
+type ScanArgs struct {
+	Match string // Glob pattern keys have to match.
+	Count int    // Number of keys to visit per request, 0 means server default.
+	Type  string // Type keys have to hold, e.g. "string" or "hash".
+}
+
+// ScanIterator iterates over keys with SCAN requesting them from the server
+// in batches. Every key present during the whole iteration is returned,
+// although a key may be returned more than once.
+//
+//	it := c.Scan(ScanArgs{Match: "user:*"})
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ScanIterator struct {
+	c      *Client
+	args   ScanArgs
+	cursor string
+	keys   []string
+	key    string
+	done   bool
+	err    error
+}
+
+type scanReply struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// Scan returns an iterator over keys of the current database.
+func (c *Client) Scan(a ScanArgs) *ScanIterator {
+	return &ScanIterator{c: c, args: a, cursor: "0"}
+}
+
+// ScanPage requests a single batch of keys starting from the cursor.
+// Returns the cursor to continue from, which is "0" once the iteration is over.
+func (c *Client) ScanPage(cursor string, a ScanArgs) (string, []string, error) {
+	var result scanReply
+	args := []string{cursor}
+	if a.Match != "" {
+		args = append(args, "MATCH", a.Match)
+	}
+	if a.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(a.Count))
+	}
+	if a.Type != "" {
+		args = append(args, "TYPE", a.Type)
+	}
+	err := c.genericCommand(&result, "SCAN", args...)
+	return result.Cursor, result.Keys, err
+}
+
+// Next advances the iterator to the next key. It returns false once
+// there are no more keys or an error occurred.
+func (it *ScanIterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		it.cursor, it.keys, it.err = it.c.ScanPage(it.cursor, it.args)
+		if it.err != nil {
+			return false
+		}
+		it.done = it.cursor == "0"
+	}
+
+	it.key, it.keys = it.keys[0], it.keys[1:]
+	return true
+}
+
+// Key returns the current key.
+func (it *ScanIterator) Key() string {
+	return it.key
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *ScanIterator) Err() error {
+	return it.err
+}
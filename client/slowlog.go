@@ -0,0 +1,32 @@
+package redislike
+
+import "strconv"
+
+// SlowlogEntry is a command recorded in the slow log.
+type SlowlogEntry struct {
+	ID       int64    `json:"id"`
+	Time     int64    `json:"time"`     // unix time the command was executed at
+	Duration int64    `json:"duration"` // microseconds
+	Args     []string `json:"args"`     // the command followed by its arguments
+	Addr     string   `json:"addr"`
+	Name     string   `json:"name"`
+}
+
+// SlowlogGet returns up to count of the latest entries of the slow log,
+// the newest goes first. Count -1 returns all of them.
+func (c *Client) SlowlogGet(count int) ([]SlowlogEntry, error) {
+	var result []SlowlogEntry
+	return result, c.genericCommand(&result, "SLOWLOG", "GET", strconv.Itoa(count))
+}
+
+// SlowlogLen returns the number of entries of the slow log.
+func (c *Client) SlowlogLen() (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "SLOWLOG", "LEN")
+}
+
+// SlowlogReset removes all entries of the slow log.
+func (c *Client) SlowlogReset() (string, error) {
+	var result string
+	return result, c.genericCommand(&result, "SLOWLOG", "RESET")
+}
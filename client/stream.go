@@ -0,0 +1,291 @@
+package redislike
+
+import (
+	"strconv"
+	"time"
+)
+
+// StreamEntry represents an entry of a stream. Fields holds field value pairs.
+// Fields of an entry which was deleted while pending for a consumer are nil.
+type StreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// Stream holds entries read from a stream.
+type Stream struct {
+	Stream  string        `json:"stream"`
+	Entries []StreamEntry `json:"entries"`
+}
+
+// XReadArgs are arguments of XRead.
+type XReadArgs struct {
+	Streams []string // Keys of streams.
+	IDs     []string // Entries with greater IDs are returned, $ means the last ID of a stream.
+	Count   int      // Maximum number of entries per stream, 0 means no limit.
+	Block   time.Duration
+}
+
+// XReadGroupArgs are arguments of XReadGroup.
+type XReadGroupArgs struct {
+	Group    string
+	Consumer string
+	Streams  []string // Keys of streams.
+	IDs      []string // > reads new entries, any other ID reads pending ones.
+	Count    int      // Maximum number of entries per stream, 0 means no limit.
+	Block    time.Duration
+	NoAck    bool
+}
+
+// XPendingSummary summarizes the pending entries list of a consumer group.
+type XPendingSummary struct {
+	Count     int            `json:"count"`
+	Min       string         `json:"min"`
+	Max       string         `json:"max"`
+	Consumers map[string]int `json:"consumers"`
+}
+
+// XPendingEntry describes an entry of the pending entries list.
+type XPendingEntry struct {
+	ID         string `json:"id"`
+	Consumer   string `json:"consumer"`
+	Idle       int64  `json:"idle"`
+	Deliveries int64  `json:"deliveries"`
+}
+
+// XPendingExtArgs are arguments of XPendingExt.
+type XPendingExtArgs struct {
+	Stream   string
+	Group    string
+	Idle     time.Duration
+	Start    string
+	End      string
+	Count    int
+	Consumer string
+}
+
+// XClaimArgs are arguments of XClaim.
+type XClaimArgs struct {
+	Stream   string
+	Group    string
+	Consumer string
+	MinIdle  time.Duration
+	IDs      []string
+}
+
+// XAutoClaimArgs are arguments of XAutoClaim.
+type XAutoClaimArgs struct {
+	Stream   string
+	Group    string
+	Consumer string
+	MinIdle  time.Duration
+	Start    string
+	Count    int
+}
+
+// XAutoClaimResult is the result of XAutoClaim. Next is the ID to continue
+// scanning from or "0-0" if the whole pending entries list was scanned.
+// Deleted holds IDs of entries which no longer exist in the stream and
+// were removed from the pending entries list.
+type XAutoClaimResult struct {
+	Next    string        `json:"next"`
+	Entries []StreamEntry `json:"entries"`
+	Deleted []string      `json:"deleted"`
+}
+
+func blockArgs(block time.Duration) []string {
+	switch {
+	case block < 0:
+		return []string{"BLOCK", "0"}
+	case block > 0:
+		return []string{"BLOCK", strconv.FormatInt(int64(block/time.Millisecond), 10)}
+	}
+	return nil
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+// XAdd appends an entry with the given field value pairs to the stream
+// stored at key. ID "*" makes the server generate an ID.
+// Returns the ID of the added entry.
+func (c *Client) XAdd(key string, id string, fields []string) (string, error) {
+	var result string
+	args := append([]string{key, id}, fields...)
+	return result, c.genericCommand(&result, "XADD", args...)
+}
+
+// XAddMaxLen works like XAdd but trims the stream to maxlen entries.
+func (c *Client) XAddMaxLen(key string, maxlen int, id string, fields []string) (string, error) {
+	var result string
+	args := append([]string{key, "MAXLEN", strconv.Itoa(maxlen), id}, fields...)
+	return result, c.genericCommand(&result, "XADD", args...)
+}
+
+// XLen returns the number of entries in the stream stored at key.
+func (c *Client) XLen(key string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "XLEN", key)
+}
+
+// XRange returns up to count entries with IDs between start and end. Special
+// IDs "-" and "+" mean the smallest and the greatest ID. Zero count means no limit.
+func (c *Client) XRange(key string, start string, end string, count int) ([]StreamEntry, error) {
+	return c.genericRange("XRANGE", key, start, end, count)
+}
+
+// XRevRange works like XRange but returns entries in reverse order.
+func (c *Client) XRevRange(key string, end string, start string, count int) ([]StreamEntry, error) {
+	return c.genericRange("XREVRANGE", key, end, start, count)
+}
+
+func (c *Client) genericRange(cmd string, key string, first string, last string, count int) ([]StreamEntry, error) {
+	var result []StreamEntry
+	args := []string{key, first, last}
+	if count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(count))
+	}
+	return result, c.genericCommand(&result, cmd, args...)
+}
+
+// XRead reads entries with IDs greater than the given ones from streams.
+// With positive Block it waits up to Block for new entries if there are none,
+// negative Block waits forever. Returns nil if there are no entries.
+func (c *Client) XRead(a XReadArgs) ([]Stream, error) {
+	var result []Stream
+	var args []string
+	if a.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(a.Count))
+	}
+	args = append(args, blockArgs(a.Block)...)
+	args = append(args, "STREAMS")
+	args = append(args, a.Streams...)
+	args = append(args, a.IDs...)
+	return result, c.genericCommand(&result, "XREAD", args...)
+}
+
+// XReadGroup reads entries from streams on behalf of a consumer of the group.
+func (c *Client) XReadGroup(a XReadGroupArgs) ([]Stream, error) {
+	var result []Stream
+	args := []string{"GROUP", a.Group, a.Consumer}
+	if a.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(a.Count))
+	}
+	args = append(args, blockArgs(a.Block)...)
+	if a.NoAck {
+		args = append(args, "NOACK")
+	}
+	args = append(args, "STREAMS")
+	args = append(args, a.Streams...)
+	args = append(args, a.IDs...)
+	return result, c.genericCommand(&result, "XREADGROUP", args...)
+}
+
+// XGroupCreate creates a consumer group which will deliver entries with IDs
+// greater than id ($ means the last ID of the stream). With mkstream
+// an empty stream is created if it does not exist.
+func (c *Client) XGroupCreate(key string, group string, id string, mkstream bool) (int, error) {
+	var result int
+	args := []string{"CREATE", key, group, id}
+	if mkstream {
+		args = append(args, "MKSTREAM")
+	}
+	return result, c.genericCommand(&result, "XGROUP", args...)
+}
+
+// XGroupSetID sets the last delivered ID of the consumer group.
+func (c *Client) XGroupSetID(key string, group string, id string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "XGROUP", "SETID", key, group, id)
+}
+
+// XGroupDestroy destroys the consumer group. Returns the number of destroyed groups.
+func (c *Client) XGroupDestroy(key string, group string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "XGROUP", "DESTROY", key, group)
+}
+
+// XGroupCreateConsumer creates a consumer in the group.
+// Returns the number of created consumers.
+func (c *Client) XGroupCreateConsumer(key string, group string, consumer string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "XGROUP", "CREATECONSUMER", key, group, consumer)
+}
+
+// XGroupDelConsumer deletes the consumer from the group.
+// Returns the number of entries which were pending for the consumer.
+func (c *Client) XGroupDelConsumer(key string, group string, consumer string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "XGROUP", "DELCONSUMER", key, group, consumer)
+}
+
+// XAck removes entries from the pending entries list of the group.
+// Returns the number of acknowledged entries.
+func (c *Client) XAck(key string, group string, ids []string) (int, error) {
+	var result int
+	args := append([]string{key, group}, ids...)
+	return result, c.genericCommand(&result, "XACK", args...)
+}
+
+// XPending returns the summary of the pending entries list of the group.
+func (c *Client) XPending(key string, group string) (*XPendingSummary, error) {
+	var result XPendingSummary
+	return &result, c.genericCommand(&result, "XPENDING", key, group)
+}
+
+// XPendingExt returns entries of the pending entries list of the group.
+func (c *Client) XPendingExt(a XPendingExtArgs) ([]XPendingEntry, error) {
+	var result []XPendingEntry
+	args := []string{a.Stream, a.Group}
+	if a.Idle > 0 {
+		args = append(args, "IDLE", ms(a.Idle))
+	}
+	args = append(args, a.Start, a.End, strconv.Itoa(a.Count))
+	if a.Consumer != "" {
+		args = append(args, a.Consumer)
+	}
+	return result, c.genericCommand(&result, "XPENDING", args...)
+}
+
+// XClaim transfers ownership of pending entries idle for at least MinIdle
+// to the consumer. Returns the claimed entries.
+func (c *Client) XClaim(a XClaimArgs) ([]StreamEntry, error) {
+	var result []StreamEntry
+	args := append([]string{a.Stream, a.Group, a.Consumer, ms(a.MinIdle)}, a.IDs...)
+	return result, c.genericCommand(&result, "XCLAIM", args...)
+}
+
+// XClaimJustID works like XClaim but returns IDs of the claimed entries only
+// and does not increment their delivery counters.
+func (c *Client) XClaimJustID(a XClaimArgs) ([]string, error) {
+	var result []string
+	args := append([]string{a.Stream, a.Group, a.Consumer, ms(a.MinIdle)}, a.IDs...)
+	return result, c.genericCommand(&result, "XCLAIM", append(args, "JUSTID")...)
+}
+
+// XAutoClaim scans the pending entries list of the group starting from Start
+// and transfers entries idle for at least MinIdle to the consumer.
+func (c *Client) XAutoClaim(a XAutoClaimArgs) (*XAutoClaimResult, error) {
+	var result XAutoClaimResult
+	args := []string{a.Stream, a.Group, a.Consumer, ms(a.MinIdle), a.Start}
+	if a.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(a.Count))
+	}
+	return &result, c.genericCommand(&result, "XAUTOCLAIM", args...)
+}
+
+// XTrim trims the stream stored at key to maxlen entries.
+// Returns the number of removed entries.
+func (c *Client) XTrim(key string, maxlen int) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "XTRIM", key, "MAXLEN", strconv.Itoa(maxlen))
+}
+
+// XDel removes entries from the stream stored at key.
+// Returns the number of removed entries.
+func (c *Client) XDel(key string, ids []string) (int, error) {
+	var result int
+	args := append([]string{key}, ids...)
+	return result, c.genericCommand(&result, "XDEL", args...)
+}
@@ -0,0 +1,80 @@
+package redislike
+
+import (
+	"strconv"
+)
+
+// TSSample is a sample of a time series. Value is kept as the server
+// replies it, so an overflowed aggregation (e.g. "+Inf") is not lost.
+type TSSample struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// TSRangeArgs are arguments of TSRange. From and To are timestamps in
+// milliseconds, "-" and "+" mean the earliest and the latest sample.
+type TSRangeArgs struct {
+	From        string
+	To          string
+	Count       int    // Maximum number of returned samples, 0 means no limit.
+	Aggregation string // avg, sum, min, max, count, first or last.
+	Bucket      int64  // Bucket duration in milliseconds for Aggregation.
+}
+
+// TSCreate creates a time series. Zero retention keeps samples forever,
+// empty policy means "block".
+func (c *Client) TSCreate(key string, retention int64, policy string) (int, error) {
+	var result int
+	args := []string{key, "RETENTION", strconv.FormatInt(retention, 10)}
+	if policy != "" {
+		args = append(args, "DUPLICATE_POLICY", policy)
+	}
+	return result, c.genericCommand(&result, "TS.CREATE", args...)
+}
+
+// TSAdd adds a sample to the time series stored at key creating it
+// if necessary. Timestamp "*" means the current time.
+// Returns the timestamp of the sample.
+func (c *Client) TSAdd(key string, timestamp string, value float64) (int64, error) {
+	var result int64
+	return result, c.genericCommand(&result, "TS.ADD", key, timestamp, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// TSAddOnDuplicate works like TSAdd but resolves a duplicate timestamp with the policy.
+func (c *Client) TSAddOnDuplicate(key string, timestamp string, value float64, policy string) (int64, error) {
+	var result int64
+	return result, c.genericCommand(&result, "TS.ADD", key, timestamp,
+		strconv.FormatFloat(value, 'g', -1, 64), "ON_DUPLICATE", policy)
+}
+
+// TSGet returns the latest sample or nil if the series is empty.
+func (c *Client) TSGet(key string) (*TSSample, error) {
+	var result *TSSample
+	return result, c.genericCommand(&result, "TS.GET", key)
+}
+
+// TSRange returns samples (or aggregated buckets) of the time series within the range.
+func (c *Client) TSRange(key string, a TSRangeArgs) ([]TSSample, error) {
+	var result []TSSample
+	args := []string{key, a.From, a.To}
+	if a.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(a.Count))
+	}
+	if a.Aggregation != "" {
+		args = append(args, "AGGREGATION", a.Aggregation, strconv.FormatInt(a.Bucket, 10))
+	}
+	return result, c.genericCommand(&result, "TS.RANGE", args...)
+}
+
+// TSCreateRule makes the server keep destKey a downsampled version of sourceKey.
+func (c *Client) TSCreateRule(sourceKey string, destKey string, aggregation string, bucket int64) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "TS.CREATERULE", sourceKey, destKey,
+		"AGGREGATION", aggregation, strconv.FormatInt(bucket, 10))
+}
+
+// TSDeleteRule deletes the compaction rule.
+func (c *Client) TSDeleteRule(sourceKey string, destKey string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "TS.DELETERULE", sourceKey, destKey)
+}
@@ -0,0 +1,78 @@
+package redislike
+
+import (
+	"strconv"
+)
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+type zMemberReply struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+// ZAdd adds members with scores to the sorted set stored at key or updates
+// scores of existing ones. Returns the number of added members.
+func (c *Client) ZAdd(key string, members ...ZMember) (int, error) {
+	var result int
+	args := []string{key}
+	for _, m := range members {
+		args = append(args, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+	}
+	return result, c.genericCommand(&result, "ZADD", args...)
+}
+
+// ZScore returns the score of the member of the sorted set stored at key.
+// The second return value is false if the member does not exist.
+func (c *Client) ZScore(key string, member string) (float64, bool, error) {
+	var result *string
+	if err := c.genericCommand(&result, "ZSCORE", key, member); err != nil || result == nil {
+		return 0, false, err
+	}
+	f, err := strconv.ParseFloat(*result, 64)
+	return f, err == nil, err
+}
+
+// ZRem removes members from the sorted set stored at key.
+// Returns the number of removed members.
+func (c *Client) ZRem(key string, members ...string) (int, error) {
+	var result int
+	args := append([]string{key}, members...)
+	return result, c.genericCommand(&result, "ZREM", args...)
+}
+
+// ZCard returns the number of members of the sorted set stored at key.
+func (c *Client) ZCard(key string) (int, error) {
+	var result int
+	return result, c.genericCommand(&result, "ZCARD", key)
+}
+
+// ZRange returns members between start and stop indexes (both inclusive)
+// ordered by score. Negative indexes are counted from the end.
+func (c *Client) ZRange(key string, start int, stop int) ([]string, error) {
+	var result []string
+	return result, c.genericCommand(&result, "ZRANGE", key, strconv.Itoa(start), strconv.Itoa(stop))
+}
+
+// ZRangeWithScores works like ZRange but returns scores as well.
+func (c *Client) ZRangeWithScores(key string, start int, stop int) ([]ZMember, error) {
+	var reply []zMemberReply
+	err := c.genericCommand(&reply, "ZRANGE", key, strconv.Itoa(start), strconv.Itoa(stop), "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ZMember, len(reply))
+	for i, m := range reply {
+		f, err := strconv.ParseFloat(m.Score, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = ZMember{m.Member, f}
+	}
+	return result, nil
+}
@@ -48,5 +48,20 @@ Example of ERR response.
   29\r\n
   Wrong number of arguments\r\n
 
+A client subscribed to pub/sub channels also receives messages which are not
+responses to its requests. They have MSG type and may arrive between a request
+and its response. Parts of a message are the kind of the message ("message" or
+"pmessage"), the pattern which matched the channel (only for "pmessage"),
+the channel and the payload.
+
+  MSG\r\n
+  3\r\n
+  9\r\n
+  message\r\n
+  6\r\n
+  news\r\n
+  7\r\n
+  hello\r\n
+
 */
 package redislike
@@ -84,10 +84,10 @@ func ReadRequest(r *bufio.Reader) (*Request, error) {
 				if remaining < 1 {
 					break
 				}
-				n, err := r.Read(buf)
-				if n > remaining {
-					n = remaining
+				if remaining < buflen {
+					buf = buf[:remaining]
 				}
+				n, err := r.Read(buf)
 				part.Write(buf[0:n])
 
 				if err != nil {
@@ -98,7 +98,7 @@ func ReadRequest(r *bufio.Reader) (*Request, error) {
 				}
 			}
 		}
-		parts = append(parts, strings.TrimSpace(part.String()))
+		parts = append(parts, strings.TrimSuffix(part.String(), "\r\n"))
 	}
 
 	if len(parts) < 1 {
@@ -11,12 +11,13 @@ import (
 )
 
 const (
-	errType = "ERR"
-	okType  = "OK"
+	errType  = "ERR"
+	okType   = "OK"
+	pushType = "MSG"
 )
 
 // ErrWrongResponseType rises when status header is incorrect
-var ErrWrongResponseType = errors.New("Response type must be OK or ERR")
+var ErrWrongResponseType = errors.New("Response type must be OK, ERR or MSG")
 
 // Response represents the response from an request.
 type Response struct {
@@ -44,8 +45,13 @@ func (r *Response) IsOk() bool {
 	return r.Type == okType
 }
 
+// IsPush checks if the response is a message pushed by the server
+func (r *Response) IsPush() bool {
+	return r.Type == pushType
+}
+
 func (r *Response) checkType() error {
-	if r.Type == okType || r.Type == errType {
+	if r.Type == okType || r.Type == errType || r.Type == pushType {
 		return nil
 	}
 
@@ -116,10 +122,10 @@ func ReadResponse(r *bufio.Reader) (*Response, error) {
 				if remaining < 1 {
 					break
 				}
-				n, err := r.Read(buf)
-				if n > remaining {
-					n = remaining
+				if remaining < buflen {
+					buf = buf[:remaining]
 				}
+				n, err := r.Read(buf)
 				part.Write(buf[0:n])
 
 				if err != nil {
@@ -130,7 +136,7 @@ func ReadResponse(r *bufio.Reader) (*Response, error) {
 				}
 			}
 		}
-		parts = append(parts, strings.TrimSpace(part.String()))
+		parts = append(parts, strings.TrimSuffix(part.String(), "\r\n"))
 	}
 
 	resp.Values = parts
@@ -148,6 +154,12 @@ func NewErrResponse(args ...string) (*Response, error) {
 	return NewResponse(errType, args...)
 }
 
+// NewPushResponse returns a new message Response pushed to a client
+// without a request given its parts.
+func NewPushResponse(args ...string) (*Response, error) {
+	return NewResponse(pushType, args...)
+}
+
 // NewResponse returns a new Response given a status and optional body.
 func NewResponse(rtype string, args ...string) (*Response, error) {
 	r := Response{rtype, args}
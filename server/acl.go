@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Access to commands and keys is controlled by ACL users. Every connection
+// starts as the "default" user, which is allowed to do everything unless
+// it requires a password (see the requirepass parameter). Then AUTH must be
+// called before any other command. Users are changed with ACL SETUSER rules
+// (the same as in Redis):
+//
+//	on, off              enables or disables authentication as the user
+//	>password, <password adds or removes a password
+//	#hash, !hash         adds or removes a SHA-256 hash of a password
+//	nopass, resetpass    allows any password or removes all of them
+//	~pattern, allkeys    allows keys matching the glob pattern or all keys
+//	resetkeys            forbids all keys
+//	+command, -command   allows or forbids the command
+//	+@category           allows or forbids commands of the category,
+//	-@category           see ACL CAT for categories
+//	allcommands          alias for +@all
+//	nocommands           alias for -@all
+//	reset                makes the user off with no passwords, keys and commands
+//
+// Commands without key arguments, like KEYS or SCAN, are not restricted by
+// key patterns, so users limited to some keys should not have @dangerous.
+// Users are persisted in the file given by the aclfile parameter, a user
+// per line in the format of ACL LIST.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords map[string]bool // SHA-256 hashes in hex
+	commands  map[string]bool // allowed commands
+	cmdRules  []string        // rules the commands were built from
+	keys      []string        // glob patterns of allowed keys
+}
+
+var (
+	// ErrNoAuth rises when a command is sent before AUTH.
+	ErrNoAuth = errors.New("NOAUTH Authentication required")
+	// ErrWrongPass rises when AUTH fails.
+	ErrWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled")
+	// ErrNoPermKey rises when the user is not allowed to access a key of the command.
+	ErrNoPermKey = errors.New("NOPERM No permissions to access a key")
+	// ErrACLUsername rises on ACL SETUSER if the username can't be saved.
+	ErrACLUsername = errors.New("ERR Usernames can't contain spaces or null characters")
+	// ErrACLFile rises on ACL SAVE and ACL LOAD if the aclfile parameter is not set.
+	ErrACLFile = errors.New("ERR There is no configured ACL file")
+)
+
+var aclUsers map[string]*aclUser
+
+// Categories of commands. The all, read and write categories are derived
+// from cmdList in init. Read commands are commands which are not write ones
+// and don't belong to the admin, pubsub or connection categories.
+var aclCategories = map[string][]string{
+	"keyspace": {"del", "exists", "unlink", "touch", "type", "rename", "renamenx",
+		"copy", "move", "randomkey", "expire", "keys", "scan", "dump", "restore",
+		"migrate", "dbsize", "flushdb", "flushall", "swapdb"},
+	"string": {"set", "get", "setnx", "getset", "getdel", "getex", "mset", "mget",
+		"append", "strlen", "getrange", "setrange"},
+	"bitmap": {"setbit", "getbit", "bitcount", "bitpos", "bitop"},
+	"list":   {"lpush", "rpush", "llen", "lindex", "lrange", "lset", "lpop", "rpop"},
+	"hash": {"hset", "hget", "hgetall", "hexists", "hvals", "hdel", "hkeys", "hlen",
+		"hexpire", "hpexpire", "hexpireat", "hpexpireat", "httl", "hpttl", "hpersist"},
+	"hyperloglog": {"pfadd", "pfcount", "pfmerge"},
+	"stream": {"xadd", "xlen", "xrange", "xrevrange", "xread", "xreadgroup", "xgroup",
+		"xack", "xpending", "xclaim", "xautoclaim", "xtrim", "xdel"},
+	"sortedset": {"zadd", "zscore", "zrem", "zcard", "zrange"},
+	"geo":       {"geoadd", "geodist", "geopos", "geosearch"},
+	"json":      {"json.set", "json.get", "json.del", "json.numincrby", "json.arrappend"},
+	"bloom":     {"bf.reserve", "bf.add", "bf.madd", "bf.exists", "bf.mexists", "bf.info"},
+	"cuckoo": {"cf.reserve", "cf.add", "cf.addnx", "cf.exists", "cf.count", "cf.del",
+		"cf.info"},
+	"timeseries": {"ts.create", "ts.add", "ts.get", "ts.range", "ts.createrule",
+		"ts.deleterule", "ts.info"},
+	"pubsub": {"subscribe", "unsubscribe", "psubscribe", "punsubscribe", "publish",
+		"pubsub"},
+	"connection": {"auth", "ping", "select", "client"},
+	"admin":      {"config", "acl", "migrate", "slowlog", "monitor"},
+	"dangerous": {"keys", "flushdb", "flushall", "swapdb", "config", "acl", "migrate",
+		"restore", "info", "client", "slowlog", "monitor"},
+}
+
+func init() {
+	for name, c := range cmdList {
+		aclCategories["all"] = append(aclCategories["all"], name)
+		if c.write == 1 {
+			aclCategories["write"] = append(aclCategories["write"], name)
+		} else if !inCategory(name, "admin", "pubsub", "connection") {
+			aclCategories["read"] = append(aclCategories["read"], name)
+		}
+	}
+	for _, cmds := range aclCategories {
+		sort.Strings(cmds)
+	}
+
+	aclUsers = map[string]*aclUser{"default": newDefaultACLUser()}
+}
+
+func inCategory(name string, cats ...string) bool {
+	for _, cat := range cats {
+		for _, cmd := range aclCategories[cat] {
+			if cmd == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newACLUser(name string) *aclUser {
+	return &aclUser{
+		name:      name,
+		passwords: make(map[string]bool),
+		commands:  make(map[string]bool),
+		cmdRules:  []string{"-@all"},
+	}
+}
+
+func newDefaultACLUser() *aclUser {
+	u := newACLUser("default")
+	for _, rule := range []string{"on", "nopass", "allkeys", "allcommands"} {
+		u.setRule(rule)
+	}
+	return u
+}
+
+func (u *aclUser) copy() *aclUser {
+	c := *u
+	c.passwords = make(map[string]bool, len(u.passwords))
+	for h := range u.passwords {
+		c.passwords[h] = true
+	}
+	c.commands = make(map[string]bool, len(u.commands))
+	for cmd := range u.commands {
+		c.commands[cmd] = true
+	}
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	c.keys = append([]string(nil), u.keys...)
+	return &c
+}
+
+func hashPassword(password string) string {
+	h := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(h[:])
+}
+
+func (u *aclUser) setRule(rule string) error {
+	switch r := strings.ToLower(rule); {
+	case r == "on":
+		u.enabled = true
+	case r == "off":
+		u.enabled = false
+	case r == "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]bool)
+	case r == "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]bool)
+	case r == "allkeys":
+		u.keys = []string{"*"}
+	case r == "resetkeys":
+		u.keys = nil
+	case r == "allcommands":
+		return u.setRule("+@all")
+	case r == "nocommands":
+		return u.setRule("-@all")
+	case r == "reset":
+		*u = *newACLUser(u.name)
+
+	case rule[0] == '>':
+		u.passwords[hashPassword(rule[1:])] = true
+		u.nopass = false
+	case rule[0] == '<':
+		h := hashPassword(rule[1:])
+		if !u.passwords[h] {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': no such password", rule)
+		}
+		delete(u.passwords, h)
+	case rule[0] == '#' || rule[0] == '!':
+		h := r[1:]
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': the password hash must be exactly 64 characters and contain only lowercase hexadecimal characters", rule)
+		}
+		if rule[0] == '#' {
+			u.passwords[h] = true
+			u.nopass = false
+		} else {
+			delete(u.passwords, h)
+		}
+	case rule[0] == '~':
+		// rules are saved separated by spaces
+		if hasSpaces(rule) {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': syntax error", rule)
+		}
+		u.keys = append(u.keys, rule[1:])
+
+	case r[0] == '+' || r[0] == '-':
+		allow := r[0] == '+'
+		var cmds []string
+		if strings.HasPrefix(r[1:], "@") {
+			var ok bool
+			if cmds, ok = aclCategories[r[2:]]; !ok {
+				return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': unknown command category", rule)
+			}
+		} else {
+			if !inCategory(r[1:], "all") {
+				return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': unknown command", rule)
+			}
+			cmds = []string{r[1:]}
+		}
+
+		for _, cmd := range cmds {
+			if allow {
+				u.commands[cmd] = true
+			} else {
+				delete(u.commands, cmd)
+			}
+		}
+		if r == "+@all" || r == "-@all" {
+			u.cmdRules = nil
+		}
+		u.cmdRules = append(u.cmdRules, r)
+
+	default:
+		return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': syntax error", rule)
+	}
+	return nil
+}
+
+// hasSpaces reports whether the string contains whitespace or NUL characters.
+func hasSpaces(v string) bool {
+	return strings.IndexFunc(v, func(r rune) bool { return unicode.IsSpace(r) || r == 0 }) >= 0
+}
+
+// setRules applies the rules to the user. If one of them fails,
+// the user stays intact.
+func (u *aclUser) setRules(rules []string) error {
+	c := u.copy()
+	for _, rule := range rules {
+		if rule == "" {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '': syntax error")
+		}
+		if err := c.setRule(rule); err != nil {
+			return err
+		}
+	}
+	*u = *c
+	return nil
+}
+
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	if len(u.keys) == 1 && u.keys[0] == "*" {
+		flags = append(flags, "allkeys")
+	}
+	return flags
+}
+
+func (u *aclUser) hashes() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for h := range u.passwords {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// describe returns rules which create the user.
+func (u *aclUser) describe() string {
+	rules := []string{"user", u.name}
+	if u.enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, h := range u.hashes() {
+		rules = append(rules, "#"+h)
+	}
+	for _, k := range u.keys {
+		rules = append(rules, "~"+k)
+	}
+	return strings.Join(append(rules, u.cmdRules...), " ")
+}
+
+func (u *aclUser) authenticate(password string) bool {
+	return u.enabled && (u.nopass || u.passwords[hashPassword(password)])
+}
+
+// checkPermission returns an error if the client is not allowed to execute
+// the command with the arguments. Clients without a connection replay
+// the cmdlog or the snapshot and are not checked. The caller must hold execMutex.
+func checkPermission(c *client, name string, argv []string) error {
+	if c.conn == nil {
+		return nil
+	}
+	if c.user == nil {
+		// the first command of the connection
+		c.user = aclUsers["default"]
+		c.authenticated = c.user.enabled && c.user.nopass
+		if u, ok := aclUsers[c.certName]; ok && c.certName != "" && config.tlsAuthClientsUser == "CN" && u.enabled {
+			c.user = u
+			c.authenticated = true
+		}
+	}
+
+	if name == "auth" {
+		return nil
+	}
+	if !c.authenticated {
+		return ErrNoAuth
+	}
+	if !c.user.commands[name] {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", c.user.name, name)
+	}
+
+	for _, k := range commandKeys(name, argv) {
+		allowed := false
+		for _, pattern := range c.user.keys {
+			if globMatch(pattern, k) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrNoPermKey
+		}
+	}
+	return nil
+}
+
+// A keySpec tells which arguments of a command are keys: from first to last
+// with step. A negative last counts from the end of arguments.
+type keySpec struct {
+	first, last, step int
+}
+
+// Key specs of commands whose only key is not the first argument.
+// Commands without keys have no spec.
+var commandKeySpecs = map[string]keySpec{
+	"mset":          {0, -1, 2},
+	"mget":          {0, -1, 1},
+	"del":           {0, -1, 1},
+	"exists":        {0, -1, 1},
+	"unlink":        {0, -1, 1},
+	"touch":         {0, -1, 1},
+	"pfcount":       {0, -1, 1},
+	"pfmerge":       {0, -1, 1},
+	"rename":        {0, 1, 1},
+	"renamenx":      {0, 1, 1},
+	"copy":          {0, 1, 1},
+	"ts.createrule": {0, 1, 1},
+	"ts.deleterule": {0, 1, 1},
+	"bitop":         {1, -1, 1},
+	"xgroup":        {1, 1, 1},
+}
+
+var keylessCommands = map[string]bool{
+	"keys": true, "scan": true, "randomkey": true, "info": true, "ping": true,
+	"select": true, "swapdb": true, "flushdb": true, "flushall": true, "dbsize": true,
+	"subscribe": true, "unsubscribe": true, "psubscribe": true, "punsubscribe": true,
+	"publish": true, "pubsub": true, "config": true, "auth": true, "acl": true,
+	"client": true, "slowlog": true, "monitor": true,
+}
+
+// commandKeys returns keys the command accesses.
+func commandKeys(name string, argv []string) []string {
+	switch name {
+	case "xread", "xreadgroup":
+		// group and consumer names and option values may be "streams" too
+		for i := 0; i < len(argv); i++ {
+			switch strings.ToLower(argv[i]) {
+			case "group":
+				i += 2
+			case "count", "block":
+				i++
+			case "streams":
+				keys := argv[i+1:]
+				return keys[:len(keys)/2]
+			}
+		}
+		return nil
+	case "migrate":
+		if len(argv) > 2 && argv[2] != "" {
+			return argv[2:3]
+		}
+		for i := 5; i < len(argv); i++ {
+			switch strings.ToLower(argv[i]) {
+			case "auth":
+				i++
+			case "auth2":
+				i += 2
+			case "keys":
+				return argv[i+1:]
+			}
+		}
+		return nil
+	}
+	if keylessCommands[name] {
+		return nil
+	}
+
+	spec, ok := commandKeySpecs[name]
+	if !ok {
+		spec = keySpec{0, 0, 1}
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(argv)
+	}
+
+	keys := []string{}
+	for i := spec.first; i <= last && i < len(argv); i += spec.step {
+		keys = append(keys, argv[i])
+	}
+	return keys
+}
+
+// setRequirepass sets the password of the default user, an empty password
+// makes the default user nopass.
+func setRequirepass(password string) {
+	config.requirepass = password
+	if password == "" {
+		aclUsers["default"].setRule("nopass")
+		return
+	}
+	aclUsers["default"].setRules([]string{"resetpass", ">" + password})
+}
+
+// readACLFile parses users from the file. If the file doesn't have
+// the default user, it is created with default rules and requirepass.
+func readACLFile(path string) (map[string]*aclUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open ACL file: %v", err)
+	}
+	defer f.Close()
+
+	users := map[string]*aclUser{"default": newDefaultACLUser()}
+	if config.requirepass != "" {
+		users["default"].setRules([]string{"resetpass", ">" + config.requirepass})
+	}
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || fields[0] != "user" {
+			return nil, fmt.Errorf("%s:%d: line should start with user keyword", path, n)
+		}
+
+		name := fields[1]
+		if seen[name] {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s'", path, n, name)
+		}
+		seen[name] = true
+
+		u := newACLUser(name)
+		if err := u.setRules(fields[2:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, strings.TrimPrefix(err.Error(), "ERR "))
+		}
+		users[name] = u
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// loadACL replaces users with the ones from the file. Existing users are
+// updated in place, so clients authenticated as them get new permissions.
+// Clients of removed users are disconnected. The caller must hold execMutex.
+func loadACL(path string) error {
+	users, err := readACLFile(path)
+	if err != nil {
+		return err
+	}
+
+	for name, u := range aclUsers {
+		if nu, ok := users[name]; ok {
+			*u = *nu
+			users[name] = u
+		} else {
+			disconnectUser(u)
+		}
+	}
+	aclUsers = users
+	return nil
+}
+
+func saveACL(path string) error {
+	lines := []string{}
+	for _, u := range aclUsers {
+		lines = append(lines, u.describe())
+	}
+	sort.Strings(lines)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// disconnectUser closes connections of clients authenticated as the user.
+// The caller must hold execMutex.
+func disconnectUser(u *aclUser) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	for c := range clients {
+		if c.user == u {
+			c.kill()
+		}
+	}
+}
+
+// AUTH [username] password
+// Authenticates the connection as the user or as the default user.
+func authCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 || r.argc > 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	name, password := "default", r.argv[0]
+	if r.argc == 2 {
+		name, password = r.argv[0], r.argv[1]
+	} else if u := aclUsers["default"]; u.nopass {
+		return nil, errors.New("ERR AUTH <password> called without any password configured for the default user")
+	}
+
+	u, ok := aclUsers[name]
+	if !ok || !u.authenticate(password) {
+		logf(logVerbose, "Failed authentication as %s from %s\n", name, r.client.addr())
+		return nil, ErrWrongPass
+	}
+
+	r.client.user = u
+	r.client.authenticated = true
+	return 1, nil
+}
+
+// aclGetUserReply is the reply of ACL GETUSER.
+type aclGetUserReply struct {
+	Flags     []string `json:"flags"`
+	Passwords []string `json:"passwords"`
+	Commands  string   `json:"commands"`
+	Keys      []string `json:"keys"`
+}
+
+// ACL SETUSER username [rule [rule ...]]
+// ACL GETUSER username
+// ACL DELUSER username [username ...]
+// ACL LIST
+// ACL USERS
+// ACL WHOAMI
+// ACL CAT [category]
+// ACL SAVE
+// ACL LOAD
+// SETUSER creates or modifies the user, either all rules are applied
+// or none of them. GETUSER returns nil for unknown users. DELUSER returns
+// the number of deleted users and disconnects their clients. CAT lists
+// categories or commands of the category. SAVE and LOAD write users
+// to the ACL file and read them from it.
+func aclCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	switch sub := strings.ToLower(r.argv[0]); sub {
+	case "setuser":
+		if r.argc < 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		if hasSpaces(r.argv[1]) {
+			return nil, ErrACLUsername
+		}
+		u, ok := aclUsers[r.argv[1]]
+		if !ok {
+			u = newACLUser(r.argv[1])
+		}
+		if err := u.setRules(r.argv[2:]); err != nil {
+			return nil, err
+		}
+		aclUsers[u.name] = u
+		return 1, nil
+
+	case "getuser":
+		if r.argc != 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		u, ok := aclUsers[r.argv[1]]
+		if !ok {
+			return nil, nil
+		}
+		return aclGetUserReply{
+			Flags:     u.flags(),
+			Passwords: u.hashes(),
+			Commands:  strings.Join(u.cmdRules, " "),
+			Keys:      append([]string{}, u.keys...),
+		}, nil
+
+	case "deluser":
+		if r.argc < 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		n := 0
+		for _, name := range r.argv[1:] {
+			if name == "default" {
+				return nil, errors.New("ERR The 'default' user cannot be removed")
+			}
+		}
+		for _, name := range r.argv[1:] {
+			if u, ok := aclUsers[name]; ok {
+				delete(aclUsers, name)
+				disconnectUser(u)
+				n++
+			}
+		}
+		return n, nil
+
+	case "list", "users":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		res := []string{}
+		for name, u := range aclUsers {
+			if sub == "list" {
+				res = append(res, u.describe())
+			} else {
+				res = append(res, name)
+			}
+		}
+		sort.Strings(res)
+		return res, nil
+
+	case "whoami":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		if r.client.user == nil {
+			return "default", nil
+		}
+		return r.client.user.name, nil
+
+	case "cat":
+		switch r.argc {
+		case 1:
+			cats := []string{}
+			for cat := range aclCategories {
+				cats = append(cats, cat)
+			}
+			sort.Strings(cats)
+			return cats, nil
+		case 2:
+			cmds, ok := aclCategories[strings.ToLower(r.argv[1])]
+			if !ok {
+				return nil, fmt.Errorf("ERR Unknown category '%s'", r.argv[1])
+			}
+			return cmds, nil
+		}
+		return nil, ErrWrongNumOfArguments
+
+	case "save", "load":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		if config.aclfile == "" {
+			return nil, ErrACLFile
+		}
+		if sub == "save" {
+			if err := saveACL(config.aclfile); err != nil {
+				return nil, fmt.Errorf("ERR There was an error trying to save the ACLs: %v", err)
+			}
+			return 1, nil
+		}
+		if err := loadACL(config.aclfile); err != nil {
+			return nil, fmt.Errorf("ERR Error loading ACLs: %v", err)
+		}
+		return 1, nil
+	}
+
+	return nil, ErrBadArguments
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestACLCategories(t *testing.T) {
+	t.Log("Given command categories")
+	{
+		for cat, cmds := range aclCategories {
+			for _, cmd := range cmds {
+				if _, ok := cmdList[cmd]; !ok {
+					t.Errorf("\t%s\tCommand %s of @%s should exist", failed, cmd, cat)
+				}
+			}
+		}
+		t.Logf("\t%s\tShould refer to existing commands", succeed)
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want []string
+	}{
+		{"get", []string{"a"}, []string{"a"}},
+		{"mset", []string{"a", "1", "b", "2"}, []string{"a", "b"}},
+		{"del", []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"rename", []string{"a", "b"}, []string{"a", "b"}},
+		{"copy", []string{"a", "b", "DB", "1"}, []string{"a", "b"}},
+		{"bitop", []string{"AND", "d", "a", "b"}, []string{"d", "a", "b"}},
+		{"xgroup", []string{"CREATE", "s", "g", "$"}, []string{"s"}},
+		{"xread", []string{"COUNT", "2", "STREAMS", "s1", "s2", "0", "0"}, []string{"s1", "s2"}},
+		{"xread", []string{"BLOCK", "streams", "STREAMS", "s1", "0"}, []string{"s1"}},
+		{"xreadgroup", []string{"GROUP", "g", "streams", "STREAMS", "allowed", "secret", ">", ">"}, []string{"allowed", "secret"}},
+		{"xreadgroup", []string{"GROUP", "streams", "c", "COUNT", "1", "NOACK", "STREAMS", "s1", ">"}, []string{"s1"}},
+		{"migrate", []string{"h", "1", "", "0", "0", "AUTH2", "u", "p", "KEYS", "a", "b"}, []string{"a", "b"}},
+		{"migrate", []string{"h", "1", "a", "0", "0"}, []string{"a"}},
+		{"keys", []string{"*"}, nil},
+		{"get", []string{}, []string{}},
+	}
+
+	t.Log("Given commands with arguments")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen getting keys of %s %v", i, tt.cmd, tt.argv)
+
+		if got := commandKeys(tt.cmd, tt.argv); reflect.DeepEqual(got, tt.want) {
+			t.Logf("\t%s\tShould get %v", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %v, got %v", failed, tt.want, got)
+		}
+	}
+}
+
+func TestCheckPermission(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	u := newACLUser("alice")
+	if err := u.setRules([]string{"on", ">secret", "~cache:*", "+@read", "-hgetall"}); err != nil {
+		t.Fatal(err)
+	}
+	c := &client{conn: conn, user: u}
+
+	t.Log("Given a user allowed to read keys matching cache:*")
+	{
+		t.Log("\tWhen the client is not authenticated")
+		{
+			if err := checkPermission(c, "get", []string{"cache:a"}); err == ErrNoAuth {
+				t.Logf("\t%s\tShould require authentication", succeed)
+			} else {
+				t.Errorf("\t%s\tShould require authentication, got %v", failed, err)
+			}
+		}
+
+		c.authenticated = u.authenticate("secret")
+
+		tests := []struct {
+			cmd     string
+			argv    []string
+			allowed bool
+		}{
+			{"get", []string{"cache:a"}, true},
+			{"mget", []string{"cache:a", "cache:b"}, true},
+			{"get", []string{"user:a"}, false},
+			{"mget", []string{"cache:a", "user:b"}, false},
+			{"set", []string{"cache:a", "v"}, false},
+			{"hgetall", []string{"cache:a"}, false},
+			{"config", []string{"GET", "*"}, false},
+		}
+		for _, tt := range tests {
+			t.Logf("\tWhen running %s %v", tt.cmd, tt.argv)
+
+			if err := checkPermission(c, tt.cmd, tt.argv); (err == nil) == tt.allowed {
+				t.Logf("\t%s\tShould be allowed: %v", succeed, tt.allowed)
+			} else {
+				t.Errorf("\t%s\tShould be allowed: %v, got %v", failed, tt.allowed, err)
+			}
+		}
+
+		t.Log("\tWhen rules contain an error")
+		{
+			before := u.describe()
+			if err := u.setRules([]string{"+set", "+nosuchcommand"}); err != nil && u.describe() == before {
+				t.Logf("\t%s\tShould leave the user intact", succeed)
+			} else {
+				t.Errorf("\t%s\tShould leave the user intact, got %s", failed, u.describe())
+			}
+		}
+	}
+}
+
+func TestACLSetUserSpaces(t *testing.T) {
+	defer func(users map[string]*aclUser) { aclUsers = users }(aclUsers)
+	aclUsers = map[string]*aclUser{}
+
+	tests := []struct {
+		argv []string
+		err  bool
+	}{
+		{[]string{"SETUSER", "bob", "on", "~a b"}, true},
+		{[]string{"SETUSER", "bob", "on", "~a\x00b"}, true},
+		{[]string{"SETUSER", "bob", "on", "~a\tb"}, true},
+		{[]string{"SETUSER", "bob smith", "on"}, true},
+		{[]string{"SETUSER", "bob\x00", "on"}, true},
+		{[]string{"SETUSER", "bob", "on", ">pass word", "~a*"}, false},
+	}
+
+	t.Log("Given ACL SETUSER with names and rules containing spaces")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen running ACL %q", i, tt.argv)
+
+		_, err := execute(nil, "acl", tt.argv...)
+		if (err != nil) == tt.err {
+			t.Logf("\t%s\tShould fail: %v", succeed, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould fail: %v, got %v", failed, tt.err, err)
+		}
+		if _, ok := aclUsers[tt.argv[1]]; ok != !tt.err {
+			t.Errorf("\t%s\tShould create the user: %v", failed, !tt.err)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Bits are numbered from the most significant bit of the first byte,
+// so the bit 0 is 0x80 of the byte 0 and the bit 9 is 0x40 of the byte 1.
+
+// parseBitOffset parses an offset of a bit in a string.
+func parseBitOffset(v string) (int64, error) {
+	offset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || offset < 0 || offset>>3 >= stringMaxSize {
+		return 0, ErrBadArguments
+	}
+	return offset, nil
+}
+
+// parseBitValue parses a value of a bit which must be 0 or 1.
+func parseBitValue(v string) (byte, error) {
+	switch v {
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	}
+	return 0, ErrBadArguments
+}
+
+// parseRangeUnit parses optional BYTE or BIT unit of a range. It returns true for BIT.
+func parseRangeUnit(argv []string) (bool, error) {
+	if len(argv) == 0 {
+		return false, nil
+	}
+	if len(argv) > 1 {
+		return false, ErrBadArguments
+	}
+
+	switch strings.ToLower(argv[0]) {
+	case "byte":
+		return false, nil
+	case "bit":
+		return true, nil
+	}
+	return false, ErrBadArguments
+}
+
+func getBit(b []byte, offset int64) byte {
+	if offset>>3 >= int64(len(b)) {
+		return 0
+	}
+	return b[offset>>3] >> (7 - uint(offset&7)) & 1
+}
+
+// SETBIT key offset value
+// Return value is the original bit value stored at offset.
+func setbitCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	offset, err := parseBitOffset(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	bit, err := parseBitValue(r.argv[2])
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := findBytesEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	b = growBytes(b, int(offset>>3)+1)
+	old := getBit(b, offset)
+	mask := byte(1) << (7 - uint(offset&7))
+	if bit == 1 {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+	s.setKeepTTL(r.argv[0], b)
+	notifyKeyspaceEvent(notifyString, "setbit", r.argv[0], r.db)
+
+	return old, nil
+}
+
+// GETBIT key offset
+func getbitCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	offset, err := parseBitOffset(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := peekBytesEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return getBit(b, offset), nil
+}
+
+// BITCOUNT key [start end [BYTE | BIT]]
+// Return value is the number of bits set to 1 in the given range
+// (the whole string by default).
+func bitcountCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 && r.argc != 3 && r.argc != 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	var start, end int64
+	var isBit bool
+	if r.argc > 1 {
+		var serr, eerr error
+		start, serr = strconv.ParseInt(r.argv[1], 10, 64)
+		end, eerr = strconv.ParseInt(r.argv[2], 10, 64)
+		if serr != nil || eerr != nil {
+			return nil, ErrBadArguments
+		}
+		var err error
+		if isBit, err = parseRangeUnit(r.argv[3:]); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := peekBytesEntry(s, r.argv[0])
+	if err != nil || b == nil {
+		return 0, err
+	}
+
+	if r.argc == 1 {
+		return popcount(b), nil
+	}
+
+	n := int64(len(b))
+	if isBit {
+		n *= 8
+	}
+	start, end, ok := normalizeRange(start, end, n)
+	if !ok {
+		return 0, nil
+	}
+	if !isBit {
+		return popcount(b[start : end+1]), nil
+	}
+
+	// count whole bytes and mask the first and the last ones
+	first, last := start>>3, end>>3
+	count := popcount(b[first : last+1])
+	count -= bits.OnesCount8(b[first] >> (8 - uint(start&7)))
+	count -= bits.OnesCount8(b[last] << (uint(end&7) + 1))
+
+	return count, nil
+}
+
+func popcount(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n += bits.OnesCount8(c)
+	}
+	return n
+}
+
+// BITPOS key bit [start [end [BYTE | BIT]]]
+// Return value is the position of the first bit set to 1 or 0 in the given
+// range or -1 if there is no such bit. Looking for a clear bit in a string
+// without an explicit end returns the first bit after the end of the string.
+func bitposCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 || r.argc > 5 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	bit, err := parseBitValue(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := int64(0), int64(-1)
+	var serr, eerr error
+	if r.argc > 2 {
+		start, serr = strconv.ParseInt(r.argv[2], 10, 64)
+	}
+	if r.argc > 3 {
+		end, eerr = strconv.ParseInt(r.argv[3], 10, 64)
+	}
+	if serr != nil || eerr != nil {
+		return nil, ErrBadArguments
+	}
+	var isBit bool
+	if r.argc == 5 {
+		if isBit, err = parseRangeUnit(r.argv[4:]); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := peekBytesEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+
+	n := int64(len(b))
+	if isBit {
+		n *= 8
+	}
+	start, end, ok := normalizeRange(start, end, n)
+	if !ok {
+		return -1, nil
+	}
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+
+	skip := byte(0xff)
+	if bit == 1 {
+		skip = 0
+	}
+	for i := start; i <= end; {
+		// skip the whole byte if it can't contain the bit
+		if i&7 == 0 && i+7 <= end && b[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if getBit(b, i) == bit {
+			return i, nil
+		}
+		i++
+	}
+
+	if bit == 0 && r.argc < 4 {
+		return int64(len(b)) * 8, nil
+	}
+	return -1, nil
+}
+
+// BITOP AND | OR | XOR | NOT destkey key [key ...]
+// Return value is the length of the string stored at destkey.
+// Missing keys and shorter strings are treated as padded with zero bytes.
+func bitopCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	op := strings.ToLower(r.argv[0])
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if r.argc != 3 {
+			return nil, ErrBadArguments
+		}
+	default:
+		return nil, ErrBadArguments
+	}
+
+	srcs := make([][]byte, 0, r.argc-2)
+	maxlen := 0
+	for _, k := range r.argv[2:] {
+		b, err := peekBytesEntry(s, k)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxlen {
+			maxlen = len(b)
+		}
+		srcs = append(srcs, b)
+	}
+
+	if maxlen == 0 {
+		if s.del(r.argv[1]) {
+			notifyKeyspaceEvent(notifyGeneric, "del", r.argv[1], r.db)
+		}
+		return 0, nil
+	}
+
+	res := growBytes(nil, maxlen)
+	copy(res, srcs[0])
+	for i := range res {
+		if op == "not" {
+			res[i] = ^res[i]
+			continue
+		}
+		for _, b := range srcs[1:] {
+			var c byte
+			if i < len(b) {
+				c = b[i]
+			}
+			switch op {
+			case "and":
+				res[i] &= c
+			case "or":
+				res[i] |= c
+			case "xor":
+				res[i] ^= c
+			}
+		}
+	}
+	s.set(r.argv[1], res)
+	notifyKeyspaceEvent(notifyString, "set", r.argv[1], r.db)
+
+	return len(res), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestBitOperations(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+	}{
+		{"setbit", []string{"a", "7", "1"}, "0"},
+		{"setbit", []string{"a", "7", "1"}, "1"},
+		{"getbit", []string{"a", "7"}, "1"},
+		{"getbit", []string{"a", "6"}, "0"},
+		{"getbit", []string{"a", "100"}, "0"},
+		{"get", []string{"a"}, `"\u0001"`},
+		{"setbit", []string{"b", "0", "1"}, "0"},
+		{"get", []string{"b"}, `{"base64":"gA=="}`},
+		{"mget", []string{"a", "b"}, `["\u0001",{"base64":"gA=="}]`},
+		{"getrange", []string{"b", "0", "0"}, `{"base64":"gA=="}`},
+		{"strlen", []string{"b"}, "1"},
+		{"setbit", []string{"b", "17", "1"}, "0"},
+		{"get", []string{"b"}, `{"base64":"gABA"}`},
+		{"bitcount", []string{"b"}, "2"},
+		{"bitcount", []string{"b", "1", "-1"}, "1"},
+		{"bitcount", []string{"b", "1", "16", "BIT"}, "0"},
+		{"bitpos", []string{"b", "1"}, "0"},
+		{"bitpos", []string{"b", "1", "1"}, "17"},
+		{"bitpos", []string{"b", "0"}, "1"},
+		{"bitpos", []string{"b", "1", "2", "-1", "BIT"}, "17"},
+		{"set", []string{"c", "\xff"}, "1"},
+		{"bitpos", []string{"c", "0"}, "8"},
+		{"bitpos", []string{"c", "0", "0", "0"}, "-1"},
+		{"bitop", []string{"OR", "d", "a", "b"}, "3"},
+		{"get", []string{"d"}, `{"base64":"gQBA"}`},
+		{"bitop", []string{"AND", "d", "a", "b"}, "3"},
+		{"get", []string{"d"}, `"\u0000\u0000\u0000"`},
+		{"bitop", []string{"XOR", "d", "b", "b"}, "3"},
+		{"bitcount", []string{"d"}, "0"},
+		{"bitop", []string{"NOT", "d", "a"}, "1"},
+		{"get", []string{"d"}, `{"base64":"/g=="}`},
+		{"bitop", []string{"NOT", "d", "a", "b"}, "null"},
+		{"setbit", []string{"a", "-1", "1"}, "null"},
+		{"setbit", []string{"a", "0", "2"}, "null"},
+	}
+
+	t.Log("Given bit operations on strings")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %q", i, tt.cmd, tt.argv)
+
+		res, err := execute(s, tt.cmd, tt.argv...)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want && (err != nil) == (tt.want == "null") {
+			t.Logf("\t%s\tShould get %s", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %s, got %s, %v", failed, tt.want, got, fmt.Sprint(err))
+		}
+	}
+}
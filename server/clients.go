@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+// Connected clients are registered, so they can be listed and killed with
+// CLIENT commands and drained on shutdown. New connections are rejected once
+// there are maxclients of them. Clients idle for longer than the timeout
+// parameter are disconnected, subscribers, monitors and clients blocked
+// in a command are never idle.
+var (
+	clientsMutex sync.Mutex
+	clients      = make(map[*client]struct{})
+	clientsWG    sync.WaitGroup
+	nextClientID int64
+	shuttingDown bool // reads of clients are interrupted by shutdown
+)
+
+var (
+	// ErrMaxClients is sent to connections rejected because of maxclients.
+	ErrMaxClients = errors.New("ERR max number of clients reached")
+	// ErrNoSuchClient rises when CLIENT KILL addr doesn't find the client.
+	ErrNoSuchClient = errors.New("ERR No such client")
+	// ErrClientName rises on invalid names of CLIENT SETNAME.
+	ErrClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters")
+)
+
+// addClient registers the client. It returns false if there are maxclients already.
+func addClient(c *client) bool {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	stats.connectionsReceived++
+	if len(clients) >= int(atomic.LoadInt32(&config.maxclients)) {
+		stats.rejectedConnections++
+		return false
+	}
+
+	nextClientID++
+	c.id = nextClientID
+	c.ctime = mstime()
+	c.lastActive = c.ctime
+	clients[c] = struct{}{}
+	clientsWG.Add(1)
+	return true
+}
+
+// kill closes the connection of the client. The client blocked in a command
+// is woken up to return. The caller must hold execMutex.
+func (c *client) kill() {
+	c.closed = true
+	c.conn.Close()
+	streamReady.Broadcast()
+}
+
+// watchHangup watches the connection of the client blocked in a command
+// and kills the client once the peer closes the connection, so the command
+// returns and the client is removed. Requests pipelined meanwhile stay
+// buffered for the next read. The caller must hold execMutex and
+// unwatchHangup must be called after the command returns.
+func (c *client) watchHangup() {
+	if c.rd == nil || c.hangup != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	c.hangup = done
+	go func() {
+		defer close(done)
+		_, err := c.rd.Peek(1)
+		if err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		execMutex.Lock()
+		if !c.closed {
+			logf(logVerbose, "Client %s closed the connection while blocked\n", c.addr())
+			c.kill()
+		}
+		execMutex.Unlock()
+	}()
+}
+
+// unwatchHangup interrupts the read of watchHangup and waits for it to stop.
+// It must be called without execMutex.
+func (c *client) unwatchHangup() {
+	c.conn.SetReadDeadline(time.Now())
+	<-c.hangup
+	c.hangup = nil
+
+	// the read deadline set by shutdown is kept
+	clientsMutex.Lock()
+	if !shuttingDown {
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	clientsMutex.Unlock()
+}
+
+func removeClient(c *client) {
+	clientsMutex.Lock()
+	delete(clients, c)
+	clientsMutex.Unlock()
+	clientsWG.Done()
+}
+
+// rejectClient sends the error to the connection and closes it.
+func rejectClient(conn net.Conn) {
+	defer conn.Close()
+
+	logf(logVerbose, "Rejected connection from %s: max number of clients reached\n", conn.RemoteAddr())
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	resp, _ := redislike.NewErrResponse(ErrMaxClients.Error())
+	resp.Write(conn)
+}
+
+// runClientsCron disconnects idle clients.
+func runClientsCron() {
+	ticker := time.NewTicker(time.Second)
+	for range ticker.C {
+		execMutex.Lock()
+		closeIdleClients(mstime())
+		execMutex.Unlock()
+	}
+}
+
+// closeIdleClients disconnects clients idle for longer than the timeout
+// at the time now. The caller must hold execMutex.
+func closeIdleClients(now int64) {
+	if config.timeout <= 0 {
+		return
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for c := range clients {
+		if !c.inCommand && !c.monitor && c.subscriptions() == 0 && now-c.lastActive > int64(config.timeout)*1000 {
+			logf(logVerbose, "Closing idle client %s\n", c.addr())
+			c.kill()
+		}
+	}
+}
+
+// clientInfoReply is the reply of CLIENT INFO and an element of CLIENT LIST.
+type clientInfoReply struct {
+	ID   int64  `json:"id"`
+	Addr string `json:"addr"`
+	Name string `json:"name"`
+	Age  int64  `json:"age"`  // seconds since the connection was opened
+	Idle int64  `json:"idle"` // seconds since the last command
+	DB   int    `json:"db"`
+	Sub  int    `json:"sub"`  // number of subscribed channels
+	PSub int    `json:"psub"` // number of subscribed patterns
+	User string `json:"user"`
+	Cmd  string `json:"cmd"`  // the last command
+	QBuf int64  `json:"qbuf"` // bytes of requests read but not executed yet
+	OLL  int    `json:"oll"`  // messages waiting to be pushed
+}
+
+func (c *client) userName() string {
+	if c.user == nil {
+		return "default"
+	}
+	return c.user.name
+}
+
+// info returns the state of the client. The caller must hold execMutex.
+func (c *client) info() clientInfoReply {
+	now := mstime()
+	return clientInfoReply{
+		ID:   c.id,
+		Addr: c.addr(),
+		Name: c.name,
+		Age:  (now - c.ctime) / 1000,
+		Idle: (now - c.lastActive) / 1000,
+		DB:   c.db,
+		Sub:  len(c.channels),
+		PSub: len(c.patterns),
+		User: c.userName(),
+		Cmd:  c.lastCmd,
+		QBuf: atomic.LoadInt64(&c.qbuf),
+		OLL:  len(c.pushes),
+	}
+}
+
+// CLIENT ID
+// CLIENT INFO
+// CLIENT LIST [ID client-id [client-id ...]]
+// CLIENT SETNAME connection-name
+// CLIENT GETNAME
+// CLIENT KILL addr
+// CLIENT KILL [ID client-id] [ADDR addr] [USER username] [SKIPME yes|no]
+// LIST returns clients ordered by ID. GETNAME returns nil if the name
+// is not set. The first form of KILL returns 1 or an error if there is no
+// such client, the second one closes all the clients matching all
+// of the filters, except the calling client unless SKIPME is no, and
+// returns their number.
+func clientCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	switch strings.ToLower(r.argv[0]) {
+	case "id":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		return r.client.id, nil
+
+	case "info":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		return r.client.info(), nil
+
+	case "list":
+		var ids map[int64]bool
+		if r.argc > 1 {
+			if strings.ToLower(r.argv[1]) != "id" || r.argc == 2 {
+				return nil, ErrBadArguments
+			}
+			ids = make(map[int64]bool)
+			for _, v := range r.argv[2:] {
+				id, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return nil, ErrBadArguments
+				}
+				ids[id] = true
+			}
+		}
+
+		res := []clientInfoReply{}
+		clientsMutex.Lock()
+		for c := range clients {
+			if ids == nil || ids[c.id] {
+				res = append(res, c.info())
+			}
+		}
+		clientsMutex.Unlock()
+		sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+		return res, nil
+
+	case "setname":
+		if r.argc != 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		for _, b := range []byte(r.argv[1]) {
+			if b <= ' ' || b > '~' {
+				return nil, ErrClientName
+			}
+		}
+		r.client.name = r.argv[1]
+		return 1, nil
+
+	case "getname":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		if r.client.name == "" {
+			return nil, nil
+		}
+		return r.client.name, nil
+
+	case "kill":
+		return clientKill(r)
+	}
+
+	return nil, ErrBadArguments
+}
+
+func clientKill(r *request) (interface{}, error) {
+	if r.argc == 2 {
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+		for c := range clients {
+			if c.addr() == r.argv[1] {
+				c.kill()
+				return 1, nil
+			}
+		}
+		return nil, ErrNoSuchClient
+	}
+	if r.argc < 3 || r.argc%2 == 0 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	var id int64
+	addr, user, skipme := "", "", true
+	for i := 1; i < r.argc; i += 2 {
+		v := r.argv[i+1]
+		switch strings.ToLower(r.argv[i]) {
+		case "id":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, ErrBadArguments
+			}
+			id = n
+		case "addr":
+			addr = v
+		case "user":
+			user = v
+		case "skipme":
+			switch strings.ToLower(v) {
+			case "yes":
+				skipme = true
+			case "no":
+				skipme = false
+			default:
+				return nil, ErrBadArguments
+			}
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	n := 0
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for c := range clients {
+		if id != 0 && c.id != id || addr != "" && c.addr() != addr ||
+			user != "" && c.userName() != user || skipme && c == r.client {
+			continue
+		}
+		c.kill()
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// registerClients registers n clients connected via TCP, so each of them
+// has its own address. They are removed from the registry once the test ends.
+func registerClients(t *testing.T, n int) []*client {
+	t.Helper()
+	return registerClientsOn(t, "tcp", "127.0.0.1:0", n)
+}
+
+// registerClientsOn registers n clients connected to the address.
+func registerClientsOn(t *testing.T, network, address string, n int) []*client {
+	t.Helper()
+
+	li, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer li.Close()
+
+	cs := make([]*client, n)
+	for i := range cs {
+		peer, err := net.Dial(network, li.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := li.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs[i] = &client{conn: conn}
+		if !addClient(cs[i]) {
+			t.Fatalf("\t%s\tShould register the client %d", failed, i)
+		}
+		t.Cleanup(func() {
+			peer.Close()
+			conn.Close()
+		})
+	}
+	t.Cleanup(func() {
+		for _, c := range cs {
+			unregisterClient(c)
+		}
+	})
+	return cs
+}
+
+// unregisterClient removes the client from the registry unless it's removed already.
+func unregisterClient(c *client) {
+	clientsMutex.Lock()
+	_, ok := clients[c]
+	clientsMutex.Unlock()
+	if ok {
+		removeClient(c)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	defer atomic.StoreInt32(&config.maxclients, atomic.LoadInt32(&config.maxclients))
+	atomic.StoreInt32(&config.maxclients, 2)
+
+	t.Log("Given the limit of 2 clients")
+	{
+		cs := registerClients(t, 2)
+
+		t.Log("\tWhen the third client connects")
+		{
+			rejected := stats.rejectedConnections
+			c := &client{}
+			if !addClient(c) && stats.rejectedConnections == rejected+1 {
+				t.Logf("\t%s\tShould reject the client", succeed)
+			} else {
+				t.Errorf("\t%s\tShould reject the client", failed)
+			}
+		}
+
+		t.Log("\tWhen one of the clients disconnects")
+		{
+			unregisterClient(cs[0])
+			c := &client{}
+			if addClient(c) {
+				t.Logf("\t%s\tShould accept the client", succeed)
+				removeClient(c)
+			} else {
+				t.Errorf("\t%s\tShould accept the client", failed)
+			}
+		}
+	}
+}
+
+func TestIdleClients(t *testing.T) {
+	defer func(timeout int) { config.timeout = timeout }(config.timeout)
+	defer atomic.StoreInt32(&config.maxclients, atomic.LoadInt32(&config.maxclients))
+	atomic.StoreInt32(&config.maxclients, 10)
+
+	cs := registerClients(t, 4)
+	now := mstime()
+	for _, c := range cs {
+		c.lastActive = now - 11000
+	}
+	cs[1].monitor = true
+	cs[2].subscribe("ch")
+	defer cs[2].unsubscribe("ch")
+	cs[3].lastActive = now - 9000
+
+	t.Log("Given clients idle for 11 seconds and a client idle for 9 seconds")
+	{
+		t.Log("\tWhen the timeout is not set")
+		{
+			config.timeout = 0
+			closeIdleClients(now)
+			if !cs[0].closed {
+				t.Logf("\t%s\tShould keep clients", succeed)
+			} else {
+				t.Errorf("\t%s\tShould keep clients", failed)
+			}
+		}
+
+		t.Log("\tWhen the timeout is 10 seconds")
+		{
+			config.timeout = 10
+			closeIdleClients(now)
+
+			want := []bool{true, false, false, false}
+			for i, c := range cs {
+				if c.closed == want[i] {
+					t.Logf("\t%s\tShould close the client %d: %v", succeed, i, want[i])
+				} else {
+					t.Errorf("\t%s\tShould close the client %d: %v", failed, i, want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	defer atomic.StoreInt32(&config.maxclients, atomic.LoadInt32(&config.maxclients))
+	atomic.StoreInt32(&config.maxclients, 10)
+	cs := registerClients(t, 4)
+	cs[0].user = newACLUser("bob")
+	cs[1].user = newACLUser("bob")
+	me := cs[3]
+
+	tests := []struct {
+		argv   []string
+		want   string // the reply encoded to JSON
+		err    error
+		killed []int // clients closed by the command
+	}{
+		{[]string{"KILL", "ID", "x"}, "null", ErrBadArguments, nil},
+		{[]string{"KILL", "ID", "1", "USER"}, "null", ErrWrongNumOfArguments, nil},
+		{[]string{"KILL", "SKIPME", "maybe"}, "null", ErrBadArguments, nil},
+		{[]string{"KILL", "NAME", "x"}, "null", ErrBadArguments, nil},
+		{[]string{"KILL", "127.0.0.1:1"}, "null", ErrNoSuchClient, nil},
+		{[]string{"KILL", "ID", "0", "USER", "nobody"}, "0", nil, nil},
+		{[]string{"KILL", "USER", "bob", "ADDR", cs[1].addr()}, "1", nil, []int{1}},
+		{[]string{"KILL", "ID", idString(cs[0]), "USER", "default"}, "0", nil, nil},
+		{[]string{"KILL", "ID", idString(cs[0])}, "1", nil, []int{0}},
+		{[]string{"KILL", "USER", "default"}, "1", nil, []int{2}},
+		{[]string{"KILL", "ID", idString(me)}, "0", nil, nil},
+		{[]string{"KILL", "ID", idString(me), "SKIPME", "no"}, "1", nil, []int{3}},
+	}
+
+	t.Log("Given clients of users bob and default")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing CLIENT %v", i, tt.argv)
+
+		res, err := clientCommand(nil, &request{cmd: "client", argv: tt.argv, argc: len(tt.argv), client: me})
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want && err == tt.err {
+			t.Logf("\t%s\tShould get %s, %v", succeed, tt.want, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %s, %v, got %s, %v", failed, tt.want, tt.err, got, err)
+		}
+
+		for _, k := range tt.killed {
+			if cs[k].closed {
+				t.Logf("\t%s\tShould close the client %d", succeed, k)
+			} else {
+				t.Errorf("\t%s\tShould close the client %d", failed, k)
+			}
+			// the connection handler removes closed clients
+			unregisterClient(cs[k])
+		}
+	}
+}
+
+func TestClientKillUnix(t *testing.T) {
+	defer atomic.StoreInt32(&config.maxclients, atomic.LoadInt32(&config.maxclients))
+	atomic.StoreInt32(&config.maxclients, 10)
+	path := filepath.Join(t.TempDir(), "redislike.sock")
+	cs := registerClientsOn(t, "unix", path, 2)
+
+	t.Log("Given two clients connected via the same Unix socket")
+	if cs[0].addr() != cs[1].addr() {
+		t.Logf("\t%s\tShould have distinct addresses", succeed)
+	} else {
+		t.Errorf("\t%s\tShould have distinct addresses, got %s", failed, cs[0].addr())
+	}
+
+	tests := []struct {
+		argv   []string
+		killed int // the client closed by the command
+	}{
+		{[]string{"KILL", "ADDR", cs[1].addr()}, 1},
+		{[]string{"KILL", cs[0].addr()}, 0},
+	}
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing CLIENT %v", i, tt.argv)
+
+		res, err := clientCommand(nil, &request{cmd: "client", argv: tt.argv, argc: len(tt.argv), client: &client{}})
+		if res == 1 && err == nil && cs[tt.killed].closed && (tt.killed == 0 || !cs[0].closed) {
+			t.Logf("\t%s\tShould close the client %d only", succeed, tt.killed)
+		} else {
+			t.Errorf("\t%s\tShould close the client %d only, got %v, %v", failed, tt.killed, res, err)
+		}
+		unregisterClient(cs[tt.killed])
+	}
+}
+
+func idString(c *client) string {
+	return strconv.FormatInt(c.id, 10)
+}
+
+func TestClientSetName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"worker-1", nil},
+		{"", nil},
+		{"my worker", ErrClientName},
+		{"worker\n", ErrClientName},
+		{"wörker", ErrClientName},
+		{"a\x00", ErrClientName},
+		{"~!@#$%^&*()_+{}", nil},
+	}
+
+	t.Log("Given a client")
+
+	c := &client{}
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen setting the name %q", i, tt.name)
+
+		before := c.name
+		_, err := clientCommand(nil, &request{cmd: "client", argv: []string{"SETNAME", tt.name}, argc: 2, client: c})
+		if err == tt.err && (err == nil && c.name == tt.name || err != nil && c.name == before) {
+			t.Logf("\t%s\tShould get %v", succeed, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %v, got %v and the name %q", failed, tt.err, err, c.name)
+		}
+	}
+}
@@ -5,8 +5,13 @@ to the file in which data will be stored.
 
   server -cmdlog /tmp/cmdlog.log
 
+The file is synced to disk according to the cmdlog-fsync parameter: after every
+command (always), once a second (everysec) or left to the OS (no).
+
 Every time server starts up, cmdlog restores everything from command log file
 into storage. Cmdlog uses same protocol for read and write operations as the server.
+Whenever a command is executed against another database than the previous one,
+SELECT is logged before it, so the commands are replayed against the right database.
 */
 package main
 
@@ -15,13 +20,35 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	redislike "github.com/bannerlog/redislike/protocol"
 )
 
+const (
+	fsyncAlways = iota
+	fsyncEverysec
+	fsyncNo
+)
+
+var fsyncPolicyNames = map[int32]string{
+	fsyncAlways:   "always",
+	fsyncEverysec: "everysec",
+	fsyncNo:       "no",
+}
+
 type cmdlog struct {
 	file    *os.File
 	logchan chan *request
+	done    chan struct{} // closed once every request from logchan is written
+	db      int           // the database selected in the log
+
+	// Times in milliseconds of the last sync and of the first write which
+	// is not synced yet or 0 if there is no such write, accessed atomically.
+	lastSync   int64
+	dirtySince int64
 }
 
 func newCmdlog(filepath string) *cmdlog {
@@ -30,51 +57,108 @@ func newCmdlog(filepath string) *cmdlog {
 		log.Fatalln(err)
 	}
 
-	return &cmdlog{f, make(chan *request)}
+	return &cmdlog{f, make(chan *request), make(chan struct{}), 0, mstime(), 0}
 }
 
 func (l *cmdlog) listen() {
-	for r := range l.logchan {
-		<-l.logchan
-		l.write(r)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r, ok := <-l.logchan:
+			if !ok {
+				close(l.done)
+				return
+			}
+			l.write(r)
+			if atomic.LoadInt32(&config.cmdlogFsync) == fsyncAlways {
+				l.sync()
+			}
+		case <-ticker.C:
+			if atomic.LoadInt32(&config.cmdlogFsync) == fsyncEverysec {
+				l.sync()
+			}
+		}
+	}
+}
+
+func (l *cmdlog) sync() {
+	if atomic.LoadInt64(&l.dirtySince) == 0 {
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		logf(logWarning, "Could not sync cmdlog: %v\n", err)
+		return
 	}
+	atomic.StoreInt64(&l.lastSync, mstime())
+	atomic.StoreInt64(&l.dirtySince, 0)
 }
 
 func (l *cmdlog) write(r *request) {
+	if r.db != l.db {
+		sel, _ := redislike.NewRequest("SELECT", strconv.Itoa(r.db))
+		sel.Write(l.file)
+		l.db = r.db
+	}
+
 	req, err := redislike.NewRequest(r.cmd, r.argv...)
 	if err != nil {
 		log.Panicln("Could not save command request to disk")
 	}
 
 	req.Write(l.file)
+	atomic.CompareAndSwapInt64(&l.dirtySince, 0, mstime())
+}
+
+func (l *cmdlog) restore() {
+	logf(logNotice, "Restoring storage from %s\n", l.file.Name())
+	l.db = replay(l.file)
+	logf(logNotice, "Storage restored successfully\n")
+}
+
+// close stops logging, waits until pending requests are written and syncs
+// the file to disk. The caller must hold execMutex.
+func (l *cmdlog) close() error {
+	setCommandLogger(nil)
+	close(l.logchan)
+	<-l.done
+
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	return l.file.Close()
 }
 
-func (l *cmdlog) restore(s *storage) {
-	log.Printf("Restoring storage from %s\n", l.file.Name())
-	fr := bufio.NewReader(l.file)
+// replay executes commands read from r. It returns the database selected
+// by the last of them.
+func replay(r io.Reader) int {
+	loading = true
+	defer func() { loading = false }()
+
+	c := &client{}
+	fr := bufio.NewReader(r)
 	for {
 		req, err := redislike.ReadRequest(fr)
 		if err != nil {
 			if err != io.EOF {
-				log.Print(err)
+				logf(logWarning, "%v\n", err)
 			}
 			break
 		}
 
-		r := &request{
-			cmd:  req.Command,
-			argv: req.Args,
-			argc: len(req.Args),
-		}
-
-		executeCmd(s, r)
+		executeCmd(&request{
+			cmd:    req.Command,
+			argv:   req.Args,
+			argc:   len(req.Args),
+			client: c,
+		})
 	}
-
-	log.Println("Storage restored successfully")
+	return c.db
 }
 
-func (l *cmdlog) run(s *storage) {
-	l.restore(s)
+func (l *cmdlog) run() {
+	l.restore()
 	go l.listen()
 	setCommandLogger(l)
 }
@@ -6,13 +6,19 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type request struct {
-	cmd  string
-	argv []string
-	argc int
+	cmd    string
+	argv   []string
+	argc   int
+	client *client
+	db     int  // the database the command is executed against
+	nolog  bool // the command changed nothing and must not be replayed
+
+	blocked time.Duration // time the command waited for streams, it's not a part of its duration
 }
 
 var (
@@ -20,30 +26,138 @@ var (
 		fn    func(*storage, *request) (interface{}, error)
 		write int
 	}{
-		"set":     {setCommand, 1},
-		"get":     {getCommand, 0},
-		"del":     {delCommand, 1},
-		"exists":  {existsCommand, 0},
-		"expire":  {expireCommand, 1},
-		"lpush":   {lpushCommand, 1},
-		"rpush":   {rpushCommand, 1},
-		"llen":    {llenCommand, 0},
-		"lindex":  {lindexCommand, 0},
-		"lrange":  {lrangeCommand, 0},
-		"lset":    {lsetCommand, 1},
-		"lpop":    {lpopCommand, 1},
-		"rpop":    {rpopCommand, 1},
-		"hset":    {hsetCommand, 1},
-		"hget":    {hgetCommand, 0},
-		"hgetall": {hgetallCommand, 0},
-		"hexists": {hexistsCommand, 0},
-		"hvals":   {hvalsCommand, 0},
-		"hdel":    {hdelCommand, 1},
-		"hkeys":   {hkeysCommand, 0},
-		"hlen":    {hlenCommand, 0},
-		"keys":    {keysCommand, 0},
-		"info":    {infoCommand, 0},
-		"ping":    {pingCommand, 0},
+		"set":        {setCommand, 1},
+		"get":        {getCommand, 0},
+		"setnx":      {setnxCommand, 1},
+		"getset":     {getsetCommand, 1},
+		"getdel":     {getdelCommand, 1},
+		"getex":      {getexCommand, 1},
+		"mset":       {msetCommand, 1},
+		"mget":       {mgetCommand, 0},
+		"append":     {appendCommand, 1},
+		"strlen":     {strlenCommand, 0},
+		"getrange":   {getrangeCommand, 0},
+		"setrange":   {setrangeCommand, 1},
+		"setbit":     {setbitCommand, 1},
+		"getbit":     {getbitCommand, 0},
+		"bitcount":   {bitcountCommand, 0},
+		"bitpos":     {bitposCommand, 0},
+		"bitop":      {bitopCommand, 1},
+		"del":        {delCommand, 1},
+		"exists":     {existsCommand, 0},
+		"unlink":     {unlinkCommand, 1},
+		"touch":      {touchCommand, 0},
+		"type":       {typeCommand, 0},
+		"rename":     {renameCommand, 1},
+		"renamenx":   {renamenxCommand, 1},
+		"copy":       {copyCommand, 1},
+		"move":       {moveCommand, 1},
+		"randomkey":  {randomkeyCommand, 0},
+		"expire":     {expireCommand, 1},
+		"lpush":      {lpushCommand, 1},
+		"rpush":      {rpushCommand, 1},
+		"llen":       {llenCommand, 0},
+		"lindex":     {lindexCommand, 0},
+		"lrange":     {lrangeCommand, 0},
+		"lset":       {lsetCommand, 1},
+		"lpop":       {lpopCommand, 1},
+		"rpop":       {rpopCommand, 1},
+		"hset":       {hsetCommand, 1},
+		"hget":       {hgetCommand, 0},
+		"hgetall":    {hgetallCommand, 0},
+		"hexists":    {hexistsCommand, 0},
+		"hvals":      {hvalsCommand, 0},
+		"hdel":       {hdelCommand, 1},
+		"hkeys":      {hkeysCommand, 0},
+		"hlen":       {hlenCommand, 0},
+		"hexpire":    {hexpireCommand, 1},
+		"hpexpire":   {hpexpireCommand, 1},
+		"hexpireat":  {hexpireatCommand, 1},
+		"hpexpireat": {hpexpireatCommand, 1},
+		"httl":       {httlCommand, 0},
+		"hpttl":      {hpttlCommand, 0},
+		"hpersist":   {hpersistCommand, 1},
+		"pfadd":      {pfaddCommand, 1},
+		"pfcount":    {pfcountCommand, 0},
+		"pfmerge":    {pfmergeCommand, 1},
+		"xadd":       {xaddCommand, 1},
+		"xlen":       {xlenCommand, 0},
+		"xrange":     {xrangeCommand, 0},
+		"xrevrange":  {xrevrangeCommand, 0},
+		"xread":      {xreadCommand, 0},
+		"xreadgroup": {xreadgroupCommand, 1},
+		"xgroup":     {xgroupCommand, 1},
+		"xack":       {xackCommand, 1},
+		"xpending":   {xpendingCommand, 0},
+		"xclaim":     {xclaimCommand, 1},
+		"xautoclaim": {xautoclaimCommand, 1},
+		"xtrim":      {xtrimCommand, 1},
+		"xdel":       {xdelCommand, 1},
+		"zadd":       {zaddCommand, 1},
+		"zscore":     {zscoreCommand, 0},
+		"zrem":       {zremCommand, 1},
+		"zcard":      {zcardCommand, 0},
+		"zrange":     {zrangeCommand, 0},
+		"geoadd":     {geoaddCommand, 1},
+		"geodist":    {geodistCommand, 0},
+		"geopos":     {geoposCommand, 0},
+		"geosearch":  {geosearchCommand, 0},
+		"keys":       {keysCommand, 0},
+		"scan":       {scanCommand, 0},
+		"info":       {infoCommand, 0},
+		"ping":       {pingCommand, 0},
+
+		"json.set":       {jsonsetCommand, 1},
+		"json.get":       {jsongetCommand, 0},
+		"json.del":       {jsondelCommand, 1},
+		"json.numincrby": {jsonnumincrbyCommand, 1},
+		"json.arrappend": {jsonarrappendCommand, 1},
+
+		"bf.reserve": {bfreserveCommand, 1},
+		"bf.add":     {bfaddCommand, 1},
+		"bf.madd":    {bfmaddCommand, 1},
+		"bf.exists":  {bfexistsCommand, 0},
+		"bf.mexists": {bfmexistsCommand, 0},
+		"bf.info":    {bfinfoCommand, 0},
+		"cf.reserve": {cfreserveCommand, 1},
+		"cf.add":     {cfaddCommand, 1},
+		"cf.addnx":   {cfaddnxCommand, 1},
+		"cf.exists":  {cfexistsCommand, 0},
+		"cf.count":   {cfcountCommand, 0},
+		"cf.del":     {cfdelCommand, 1},
+		"cf.info":    {cfinfoCommand, 0},
+
+		"ts.create":     {tscreateCommand, 1},
+		"ts.add":        {tsaddCommand, 1},
+		"ts.get":        {tsgetCommand, 0},
+		"ts.range":      {tsrangeCommand, 0},
+		"ts.createrule": {tscreateruleCommand, 1},
+		"ts.deleterule": {tsdeleteruleCommand, 1},
+		"ts.info":       {tsinfoCommand, 0},
+
+		"select":   {selectCommand, 0},
+		"swapdb":   {swapdbCommand, 1},
+		"flushdb":  {flushdbCommand, 1},
+		"flushall": {flushallCommand, 1},
+		"dbsize":   {dbsizeCommand, 0},
+
+		"subscribe":    {subscribeCommand, 0},
+		"unsubscribe":  {unsubscribeCommand, 0},
+		"psubscribe":   {psubscribeCommand, 0},
+		"punsubscribe": {punsubscribeCommand, 0},
+		"publish":      {publishCommand, 0},
+		"pubsub":       {pubsubCommand, 0},
+
+		"dump":    {dumpCommand, 0},
+		"restore": {restoreCommand, 1},
+		"migrate": {migrateCommand, 0},
+
+		"config":  {configCommand, 0},
+		"auth":    {authCommand, 0},
+		"acl":     {aclCommand, 0},
+		"client":  {clientCommand, 0},
+		"slowlog": {slowlogCommand, 0},
+		"monitor": {monitorCommand, 0},
 	}
 
 	// ErrWrongNumOfArguments ...
@@ -52,24 +166,86 @@ var (
 	ErrBadArguments = errors.New("Invalid arguments of the command")
 	// ErrOperationAgainstWrongType ...
 	ErrOperationAgainstWrongType = errors.New("Operation against a key holding the wrong kind of value")
+	// ErrNoSuchKey ...
+	ErrNoSuchKey = errors.New("ERR no such key")
+	// ErrSameObject ...
+	ErrSameObject = errors.New("ERR source and destination objects are the same")
+	// ErrDBIndexOutOfRange ...
+	ErrDBIndexOutOfRange = errors.New("ERR DB index is out of range")
 
 	cmdlogger *cmdlog
+
+	// execMutex serializes execution of commands, so every command
+	// is applied to the storage atomically (the same way Redis does).
+	execMutex sync.Mutex
 )
 
 func setCommandLogger(l *cmdlog) {
 	cmdlogger = l
 }
 
-func executeCmd(s *storage, r *request) (string, error) {
-	if c, ok := cmdList[strings.ToLower(r.cmd)]; ok {
-		res, err := c.fn(s, r)
+// rewrite replaces the command and its arguments before the request
+// is written to the cmdlog, e.g. to log absolute deadlines instead of relative ones.
+func (r *request) rewrite(cmd string, argv ...string) {
+	r.cmd = cmd
+	r.argv = argv
+	r.argc = len(argv)
+}
+
+// propagate writes the request to the cmdlog on behalf of a command which
+// is not logged itself, e.g. because replaying it would have side effects.
+// The caller must hold execMutex.
+func propagate(r *request) {
+	if cmdlogger != nil {
+		cmdlogger.logchan <- r
+	}
+}
+
+func executeCmd(r *request) (string, error) {
+	execMutex.Lock()
+	defer execMutex.Unlock()
+
+	cl := r.client
+	cl.lastActive = mstime()
+	cl.inCommand = true
+	defer func() {
+		cl.lastActive = mstime()
+		cl.inCommand = false
+	}()
+
+	name := strings.ToLower(r.cmd)
+	if c, ok := cmdList[name]; ok {
+		cl.lastCmd = name
+
+		if err := checkPermission(r.client, name, r.argv); err != nil {
+			return "", err
+		}
+
+		if c.write == 1 && !oomAllowedCommands[name] {
+			if err := freeMemoryIfNeeded(); err != nil {
+				return "", err
+			}
+		}
+
+		if len(monitors) > 0 && r.client.conn != nil {
+			feedMonitors(r, name)
+		}
+
+		r.db = r.client.db
+		stats.countLookups = c.write == 0
+		start := time.Now()
+		res, err := c.fn(databases[r.db], r)
+		d := time.Since(start) - r.blocked
+		recordCommand(name, d, err)
+		slowlogPush(r, d.Microseconds())
+		stats.countLookups = false
 
 		b, e := json.Marshal(res)
 		if e != nil {
 			panic("Cannot json encode command result")
 		}
 
-		if err == nil && c.write == 1 && cmdlogger != nil {
+		if err == nil && c.write == 1 && !r.nolog && cmdlogger != nil {
 			cmdlogger.logchan <- r
 		}
 
@@ -79,27 +255,192 @@ func executeCmd(s *storage, r *request) (string, error) {
 	return "", fmt.Errorf("ERR Unknown command '%s'", r.cmd)
 }
 
-// EXISTS key
+// EXISTS key [key ...]
+// Return value is the number of existing keys. A key mentioned several
+// times is counted several times.
 func existsCommand(s *storage, r *request) (interface{}, error) {
-	if r.argc != 1 {
+	if r.argc < 1 {
 		return nil, ErrWrongNumOfArguments
 	}
 
-	return s.exists(r.argv[0]), nil
+	n := 0
+	for _, k := range r.argv {
+		if s.exists(k) {
+			n++
+		}
+	}
+
+	return n, nil
 }
 
-// DEL key
+// DEL key [key ...]
+// Return value is the number of removed keys.
 func delCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	n := 0
+	for _, k := range r.argv {
+		if s.del(k) {
+			notifyKeyspaceEvent(notifyGeneric, "del", k, r.db)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// UNLINK key [key ...]
+// Works like DEL. Removing a key only drops the reference to its value,
+// so big values are freed in the background by the garbage collector
+// either way. Return value is the number of removed keys.
+func unlinkCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	n := 0
+	for _, k := range r.argv {
+		if _, ok := s.unlink(k); ok {
+			notifyKeyspaceEvent(notifyGeneric, "del", k, r.db)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// TOUCH key [key ...]
+// Updates the last access time of the keys, which is used by LRU eviction.
+// Return value is the number of existing keys.
+func touchCommand(s *storage, r *request) (interface{}, error) {
+	return existsCommand(s, r)
+}
+
+// TYPE key
+// Return value is the type of the value stored at key or "none".
+func typeCommand(s *storage, r *request) (interface{}, error) {
 	if r.argc != 1 {
 		return nil, ErrWrongNumOfArguments
 	}
 
-	s.del(r.argv[0])
+	return typeName(s.get(r.argv[0])), nil
+}
+
+// RENAME key newkey
+// Renames the key keeping its expiry. An existing newkey is overwritten.
+func renameCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	if !s.rename(r.argv[0], r.argv[1]) {
+		return nil, ErrNoSuchKey
+	}
+	notifyRename(r)
 
 	return 1, nil
 }
 
+func notifyRename(r *request) {
+	notifyKeyspaceEvent(notifyGeneric, "rename_from", r.argv[0], r.db)
+	notifyKeyspaceEvent(notifyGeneric, "rename_to", r.argv[1], r.db)
+}
+
+// RENAMENX key newkey
+// Return value is 1 if the key was renamed and 0 if newkey already exists.
+func renamenxCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	if !s.exists(r.argv[0]) {
+		return nil, ErrNoSuchKey
+	}
+	if s.exists(r.argv[1]) {
+		return 0, nil
+	}
+
+	s.rename(r.argv[0], r.argv[1])
+	notifyRename(r)
+	return 1, nil
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+// Copies the value along with its expiry. Return value is 1 if the value
+// was copied and 0 if destination already exists and REPLACE is not given.
+func copyCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	db, replace := r.db, false
+	for i := 2; i < r.argc; i++ {
+		switch opt := strings.ToLower(r.argv[i]); {
+		case opt == "replace":
+			replace = true
+		case opt == "db" && i+1 < r.argc:
+			var err error
+			if db, err = parseDBIndex(r.argv[i+1]); err != nil {
+				return nil, err
+			}
+			i++
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	if r.argv[0] == r.argv[1] && db == r.db {
+		return nil, ErrSameObject
+	}
+
+	if s.copy(r.argv[0], databases[db], r.argv[1], replace) {
+		notifyKeyspaceEvent(notifyGeneric, "copy_to", r.argv[1], db)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// MOVE key db
+// Moves the key along with its expiry to another database. Return value
+// is 1 if the key was moved and 0 if it does not exist or db already holds it.
+func moveCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	db, err := parseDBIndex(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	if db == r.db {
+		return nil, ErrSameObject
+	}
+
+	if s.move(r.argv[0], databases[db]) {
+		notifyKeyspaceEvent(notifyGeneric, "move_from", r.argv[0], r.db)
+		notifyKeyspaceEvent(notifyGeneric, "move_to", r.argv[0], db)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// RANDOMKEY
+// Return value is a random key or nil if the database is empty.
+func randomkeyCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 0 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	if k := s.randomKey(); k != "" {
+		return k, nil
+	}
+	return nil, nil
+}
+
 // EXPIRE key seconds
+// Return value is 1 if the deadline was set and 0 if the key does not exist.
 func expireCommand(s *storage, r *request) (interface{}, error) {
 	if r.argc != 2 {
 		return nil, ErrWrongNumOfArguments
@@ -110,35 +451,121 @@ func expireCommand(s *storage, r *request) (interface{}, error) {
 		return nil, ErrBadArguments
 	}
 
-	s.setExpire(r.argv[0], time.Now().Unix()+sec)
-	return 1, nil
+	if !s.exists(r.argv[0]) {
+		return 0, nil
+	}
 
+	s.setExpire(r.argv[0], mstime()+sec*1000)
+	notifyKeyspaceEvent(notifyGeneric, "expire", r.argv[0], r.db)
+	return 1, nil
 }
 
-// KEYS
+// KEYS [pattern]
+// Return value is a list of keys matching the glob pattern (all keys by default).
 func keysCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc > 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	now := mstime()
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	sl := []string{}
-	for k := range s.entries {
+	for k, e := range s.entries {
+		if e.expiry != nil && e.expiry.ttl <= now {
+			continue
+		}
+		if r.argc == 1 && !globMatch(r.argv[0], k) {
+			continue
+		}
 		sl = append(sl, k)
 	}
 
 	return sl, nil
 }
 
-// INFO [summary]
-func infoCommand(s *storage, r *request) (interface{}, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// typeName returns the name of the type of the value as TYPE replies it.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case string, []byte:
+		return "string"
+	case *hyperLogLog:
+		return "hyperloglog"
+	case []string:
+		return "list"
+	case map[string]string:
+		return "hash"
+	case *sortedSet:
+		return "zset"
+	case *stream:
+		return "stream"
+	case *jsonDocument:
+		return "ReJSON-RL"
+	case *bloomFilter:
+		return "MBbloom--"
+	case *cuckooFilter:
+		return "MBbloomCF"
+	case *timeSeries:
+		return "TSDB-TYPE"
+	}
+	return "none"
+}
 
-	if len(r.argv) == 1 && r.argv[0] == "summary" {
-		kln, xln := s.len()
-		return fmt.Sprintf("Number of Keys: %d\nNumber of Expiries %d", kln, xln), nil
+type scanReply struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// Return value is the cursor to continue the iteration from (0 once it's
+// over) and a batch of keys. Every key present during the whole iteration
+// is returned at least once. COUNT is a hint of how many keys to visit
+// per call, filters are applied after keys are visited, so a batch
+// may be empty while the iteration isn't over.
+func scanCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	cursor, err := strconv.ParseUint(r.argv[0], 10, 64)
+	if err != nil {
+		return nil, ErrBadArguments
 	}
 
-	return fmt.Sprintf("%+v", s), nil
+	var pattern, typ string
+	count := 10
+	for i := 1; i < r.argc; i += 2 {
+		if i+1 >= r.argc {
+			return nil, ErrBadArguments
+		}
+		switch strings.ToLower(r.argv[i]) {
+		case "match":
+			pattern = r.argv[i+1]
+		case "count":
+			if count, err = strconv.Atoi(r.argv[i+1]); err != nil || count < 1 {
+				return nil, ErrBadArguments
+			}
+		case "type":
+			typ = r.argv[i+1]
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	keys := []string{}
+	cursor = s.scan(cursor, count, func(k string, v interface{}) {
+		if pattern != "" && !globMatch(pattern, k) {
+			return
+		}
+		if typ != "" && !strings.EqualFold(typ, typeName(v)) {
+			return
+		}
+		keys = append(keys, k)
+	})
+
+	return scanReply{strconv.FormatUint(cursor, 10), keys}, nil
 }
 
 // PING
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	defer func(dbs []*storage) { databases = dbs }(databases)
+	databases = newDatabases(2)
+
+	big := []string{"big"}
+	for i := 0; i < 64; i++ {
+		big = append(big, strconv.Itoa(i))
+	}
+
+	tests := []struct {
+		db   int
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+		err  error
+	}{
+		{0, "randomkey", nil, "null", nil},
+		{0, "set", []string{"str", "v"}, "1", nil},
+		{0, "randomkey", nil, `"str"`, nil},
+		{0, "rpush", []string{"list", "a", "b"}, "2", nil},
+		{0, "hset", []string{"hash", "f", "v"}, "1", nil},
+		{0, "zadd", []string{"zset", "1", "m"}, "1", nil},
+		{0, "xadd", []string{"stream", "1-1", "f", "v"}, `"1-1"`, nil},
+		{0, "ts.create", []string{"ts"}, "1", nil},
+		{0, "type", []string{"str"}, `"string"`, nil},
+		{0, "type", []string{"list"}, `"list"`, nil},
+		{0, "type", []string{"hash"}, `"hash"`, nil},
+		{0, "type", []string{"zset"}, `"zset"`, nil},
+		{0, "type", []string{"stream"}, `"stream"`, nil},
+		{0, "type", []string{"ts"}, `"TSDB-TYPE"`, nil},
+		{0, "type", []string{"none"}, `"none"`, nil},
+		{0, "pfadd", []string{"hll", "a"}, "1", nil},
+		{0, "type", []string{"hll"}, `"hyperloglog"`, nil},
+		{0, "get", []string{"hll"}, "null", ErrOperationAgainstWrongType},
+		{0, "scan", []string{"0", "TYPE", "hyperloglog"}, `{"cursor":"0","keys":["hll"]}`, nil},
+		{0, "touch", []string{"str", "list", "none"}, "2", nil},
+		{0, "expire", []string{"none", "100"}, "0", nil},
+		{0, "expire", []string{"hash", "100"}, "1", nil},
+		{0, "expire", []string{"hash", "1.5"}, "null", ErrBadArguments},
+
+		{0, "rename", []string{"str", "str2"}, "1", nil},
+		{0, "type", []string{"str"}, `"none"`, nil},
+		{0, "get", []string{"str2"}, `"v"`, nil},
+		{0, "rename", []string{"str", "str2"}, "null", ErrNoSuchKey},
+		{0, "rename", []string{"list", "str2"}, "1", nil},
+		{0, "type", []string{"str2"}, `"list"`, nil},
+		{0, "renamenx", []string{"str2", "hash"}, "0", nil},
+		{0, "renamenx", []string{"str2", "list"}, "1", nil},
+
+		{0, "copy", []string{"list", "list"}, "null", ErrSameObject},
+		{0, "copy", []string{"list", "hash"}, "0", nil},
+		{0, "copy", []string{"list", "hash", "REPLACE"}, "1", nil},
+		{0, "lrange", []string{"hash", "0", "-1"}, `["a","b"]`, nil},
+		{0, "rpush", []string{"hash", "c"}, "3", nil},
+		{0, "llen", []string{"list"}, "2", nil},
+		{0, "copy", []string{"list", "list", "DB", "1"}, "1", nil},
+		{1, "lrange", []string{"list", "0", "-1"}, `["a","b"]`, nil},
+		{0, "copy", []string{"none", "x"}, "0", nil},
+		{0, "copy", []string{"list", "x", "DB", "2"}, "null", ErrDBIndexOutOfRange},
+
+		{0, "move", []string{"list", "0"}, "null", ErrSameObject},
+		{0, "move", []string{"list", "1"}, "0", nil},
+		{0, "move", []string{"zset", "1"}, "1", nil},
+		{0, "type", []string{"zset"}, `"none"`, nil},
+		{1, "type", []string{"zset"}, `"zset"`, nil},
+		{0, "move", []string{"none", "1"}, "0", nil},
+
+		{0, "rpush", big, "64", nil},
+		{0, "unlink", []string{"big", "stream", "none"}, "2", nil},
+		{0, "type", []string{"big"}, `"none"`, nil},
+		{1, "unlink", []string{"list", "zset"}, "2", nil},
+		{1, "randomkey", nil, "null", nil},
+	}
+
+	t.Log("Given two databases")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %v in the database %d", i, tt.cmd, tt.argv, tt.db)
+
+		r := &request{cmd: tt.cmd, argv: tt.argv, argc: len(tt.argv), db: tt.db, client: &client{}}
+		res, err := cmdList[tt.cmd].fn(databases[tt.db], r)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want && err == tt.err {
+			t.Logf("\t%s\tShould get %s, %v", succeed, tt.want, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %s, %v, got %s, %v", failed, tt.want, tt.err, got, err)
+		}
+	}
+
+	t.Log("\tWhen a list pushed to is changed")
+	argv := []string{"l", "a", "b"}
+	execute(databases[0], "rpush", argv...)
+	execute(databases[0], "lset", "l", "0", "x")
+	execute(databases[0], "lset", "l", "-1", "x")
+	if argv[1] == "a" && argv[2] == "b" {
+		t.Logf("\t%s\tShould leave arguments of the requests intact", succeed)
+	} else {
+		t.Errorf("\t%s\tShould leave arguments of the requests intact, got %v", failed, argv)
+	}
+}
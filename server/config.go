@@ -0,0 +1,784 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The server is configured with parameters read from the config file given
+// by the -config flag. Every parameter can also be given as a command line
+// flag of the same name, which takes precedence over the file. The file
+// holds a parameter per line followed by its value, lines starting with "#"
+// are comments. Values with spaces or empty values are quoted:
+//
+//	# listen on two addresses
+//	addr "127.0.0.1:9000 10.0.0.1:9000"
+//	cmdlog /var/lib/redislike/cmdlog.log
+//	maxmemory 512mb
+//	maxmemory-policy allkeys-lru
+//	notify-keyspace-events ""
+//
+// Parameters marked live can be changed at runtime with CONFIG SET,
+// CONFIG REWRITE writes the current values back to the config file.
+var config struct {
+	addrs            []string
+	databases        int
+	cmdlog           string
+	cmdlogFsync      int32 // accessed atomically
+	snapshot         string
+	shutdownTimeout  time.Duration
+	maxmemory        int64
+	maxmemoryPolicy  int
+	maxmemorySamples int
+	logfile          string
+	loglevel         int32 // accessed atomically
+	requirepass      string
+	aclfile          string
+
+	maxclients int32 // accessed atomically
+	timeout    int
+
+	unixsocket     string
+	unixsocketPerm os.FileMode
+
+	metricsAddr string
+
+	slowlogSlowerThan int64
+	slowlogMaxLen     int
+
+	tlsAddrs           []string
+	tlsCertFile        string
+	tlsKeyFile         string
+	tlsCACertFile      string
+	tlsAuthClients     string
+	tlsAuthClientsUser string
+	tlsCiphers         string
+	tlsMinVersion      string
+}
+
+// configFile is the path to the config file the server was started with.
+var configFile string
+
+type configParam struct {
+	name  string
+	value string // default value
+	live  bool   // can be changed with CONFIG SET
+	usage string
+	get   func() string
+	set   func(v string) error // validates and applies the value
+	tls   bool                 // TLS configuration is rebuilt after the value is changed
+}
+
+// ErrConfigFile rises on CONFIG REWRITE if the server was started without a config file.
+var ErrConfigFile = errors.New("ERR The server is running without a config file")
+
+var configParams []*configParam
+
+// configParams is filled in init as some parameters refer to commands.
+// Every parameter is also registered as a command line flag.
+func init() {
+	configParams = []*configParam{
+		{
+			name:  "addr",
+			value: ":9000",
+			usage: "Addresses (host:port) to listen on separated by spaces",
+			get:   func() string { return strings.Join(config.addrs, " ") },
+			set: func(v string) error {
+				config.addrs = strings.Fields(v)
+				return nil
+			},
+		},
+		{
+			name:  "databases",
+			value: "16",
+			usage: "Number of databases",
+			get:   func() string { return strconv.Itoa(config.databases) },
+			set: func(v string) error {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					return errors.New("argument must be a positive integer")
+				}
+				config.databases = n
+				return nil
+			},
+		},
+		{
+			name:  "cmdlog",
+			value: "",
+			usage: "Path to command log file",
+			get:   func() string { return config.cmdlog },
+			set: func(v string) error {
+				config.cmdlog = v
+				return nil
+			},
+		},
+		{
+			name:  "cmdlog-fsync",
+			value: "everysec",
+			live:  true,
+			usage: "When the command log is synced to disk: always, everysec or no",
+			get:   func() string { return fsyncPolicyNames[atomic.LoadInt32(&config.cmdlogFsync)] },
+			set: func(v string) error {
+				for p, name := range fsyncPolicyNames {
+					if strings.EqualFold(v, name) {
+						atomic.StoreInt32(&config.cmdlogFsync, p)
+						return nil
+					}
+				}
+				return errors.New("argument must be one of always, everysec, no")
+			},
+		},
+		{
+			name:  "snapshot",
+			value: "",
+			live:  true,
+			usage: "Path to snapshot file written on shutdown",
+			get:   func() string { return config.snapshot },
+			set: func(v string) error {
+				config.snapshot = v
+				return nil
+			},
+		},
+		{
+			name:  "shutdown-timeout",
+			value: "10s",
+			live:  true,
+			usage: "Time given to clients to finish their commands on shutdown",
+			get:   func() string { return config.shutdownTimeout.String() },
+			set: func(v string) error {
+				d, err := time.ParseDuration(v)
+				if err != nil || d < 0 {
+					return errors.New("argument must be a duration like 10s")
+				}
+				config.shutdownTimeout = d
+				return nil
+			},
+		},
+		{
+			name:  "notify-keyspace-events",
+			value: "",
+			live:  true,
+			usage: "Classes of keyspace events to publish (e.g. KEA)",
+			get:   func() string { return formatNotifyFlags(notifyKeyspaceEvents) },
+			set: func(v string) error {
+				flags, err := parseNotifyFlags(v)
+				if err != nil {
+					return err
+				}
+				notifyKeyspaceEvents = flags
+				return nil
+			},
+		},
+		{
+			name:  "maxmemory",
+			value: "0",
+			live:  true,
+			usage: "Memory limit in bytes (units k, kb, m, mb, g, gb are accepted), 0 is no limit",
+			get:   func() string { return strconv.FormatInt(config.maxmemory, 10) },
+			set: func(v string) error {
+				n, err := parseMemory(v)
+				if err != nil {
+					return err
+				}
+				setMaxmemory(n)
+				return nil
+			},
+		},
+		{
+			name:  "maxmemory-policy",
+			value: "noeviction",
+			live:  true,
+			usage: "How keys are evicted once maxmemory is reached",
+			get: func() string {
+				for name, p := range maxmemoryPolicies {
+					if p == config.maxmemoryPolicy {
+						return name
+					}
+				}
+				return ""
+			},
+			set: func(v string) error {
+				p, ok := maxmemoryPolicies[strings.ToLower(v)]
+				if !ok {
+					return errors.New("unknown eviction policy")
+				}
+				config.maxmemoryPolicy = p
+				return nil
+			},
+		},
+		{
+			name:  "maxmemory-samples",
+			value: "5",
+			live:  true,
+			usage: "Number of keys of every database sampled to find a key to evict",
+			get:   func() string { return strconv.Itoa(config.maxmemorySamples) },
+			set: func(v string) error {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 || n > 64 {
+					return errors.New("argument must be between 1 and 64")
+				}
+				config.maxmemorySamples = n
+				return nil
+			},
+		},
+		{
+			name:  "requirepass",
+			value: "",
+			live:  true,
+			usage: "Password of the default user, no password is required if empty",
+			get:   func() string { return config.requirepass },
+			set: func(v string) error {
+				setRequirepass(v)
+				return nil
+			},
+		},
+		{
+			name:  "aclfile",
+			value: "",
+			usage: "Path to ACL file with users",
+			get:   func() string { return config.aclfile },
+			set: func(v string) error {
+				config.aclfile = v
+				return nil
+			},
+		},
+		{
+			name:  "maxclients",
+			value: "10000",
+			live:  true,
+			usage: "Maximal number of connected clients",
+			get:   func() string { return strconv.Itoa(int(atomic.LoadInt32(&config.maxclients))) },
+			set: func(v string) error {
+				n, err := strconv.ParseInt(v, 10, 32)
+				if err != nil || n < 1 {
+					return errors.New("argument must be a positive integer")
+				}
+				atomic.StoreInt32(&config.maxclients, int32(n))
+				return nil
+			},
+		},
+		{
+			name:  "timeout",
+			value: "0",
+			live:  true,
+			usage: "Seconds after which idle clients are disconnected, 0 disables the timeout",
+			get:   func() string { return strconv.Itoa(config.timeout) },
+			set: func(v string) error {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					return errors.New("argument must be a non-negative integer")
+				}
+				config.timeout = n
+				return nil
+			},
+		},
+		{
+			name:  "slowlog-log-slower-than",
+			value: "10000",
+			live:  true,
+			usage: "Microseconds a command must exceed to be logged in the slow log, 0 logs every command, -1 disables the log",
+			get:   func() string { return strconv.FormatInt(config.slowlogSlowerThan, 10) },
+			set: func(v string) error {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || n < -1 {
+					return errors.New("argument must be -1 or a non-negative integer")
+				}
+				config.slowlogSlowerThan = n
+				return nil
+			},
+		},
+		{
+			name:  "slowlog-max-len",
+			value: "128",
+			live:  true,
+			usage: "Maximal number of entries of the slow log",
+			get:   func() string { return strconv.Itoa(config.slowlogMaxLen) },
+			set: func(v string) error {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					return errors.New("argument must be a non-negative integer")
+				}
+				config.slowlogMaxLen = n
+				slowlogTrim()
+				return nil
+			},
+		},
+		{
+			name:  "unixsocket",
+			value: "",
+			usage: "Path to Unix socket to listen on",
+			get:   func() string { return config.unixsocket },
+			set: func(v string) error {
+				config.unixsocket = v
+				return nil
+			},
+		},
+		{
+			name:  "unixsocketperm",
+			value: "0",
+			usage: "Permissions of the Unix socket in octal, e.g. 770, umask is applied if 0",
+			get:   func() string { return strconv.FormatUint(uint64(config.unixsocketPerm), 8) },
+			set: func(v string) error {
+				perm, err := strconv.ParseUint(v, 8, 32)
+				if err != nil || perm > 0777 {
+					return errors.New("argument must be an octal number of permissions")
+				}
+				config.unixsocketPerm = os.FileMode(perm)
+				return nil
+			},
+		},
+		{
+			name:  "metrics-addr",
+			value: "",
+			usage: "Address of HTTP listener serving Prometheus metrics on /metrics",
+			get:   func() string { return config.metricsAddr },
+			set: func(v string) error {
+				config.metricsAddr = v
+				return nil
+			},
+		},
+		{
+			name:  "tls-addr",
+			value: "",
+			usage: "Addresses (host:port) to listen on with TLS separated by spaces",
+			get:   func() string { return strings.Join(config.tlsAddrs, " ") },
+			set: func(v string) error {
+				config.tlsAddrs = strings.Fields(v)
+				return nil
+			},
+		},
+		{
+			name:  "tls-cert-file",
+			value: "",
+			live:  true,
+			tls:   true,
+			usage: "Path to certificate of the server in PEM format",
+			get:   func() string { return config.tlsCertFile },
+			set: func(v string) error {
+				config.tlsCertFile = v
+				return nil
+			},
+		},
+		{
+			name:  "tls-key-file",
+			value: "",
+			live:  true,
+			tls:   true,
+			usage: "Path to private key of the server in PEM format",
+			get:   func() string { return config.tlsKeyFile },
+			set: func(v string) error {
+				config.tlsKeyFile = v
+				return nil
+			},
+		},
+		{
+			name:  "tls-ca-cert-file",
+			value: "",
+			live:  true,
+			tls:   true,
+			usage: "Path to CA certificates in PEM format to verify clients with",
+			get:   func() string { return config.tlsCACertFile },
+			set: func(v string) error {
+				config.tlsCACertFile = v
+				return nil
+			},
+		},
+		{
+			name:  "tls-auth-clients",
+			value: "no",
+			live:  true,
+			tls:   true,
+			usage: "Whether certificates of clients are required: no, optional or yes",
+			get:   func() string { return config.tlsAuthClients },
+			set: func(v string) error {
+				v = strings.ToLower(v)
+				if _, ok := tlsClientAuthModes[v]; !ok {
+					return errors.New("argument must be one of no, optional, yes")
+				}
+				config.tlsAuthClients = v
+				return nil
+			},
+		},
+		{
+			name:  "tls-auth-clients-user",
+			value: "off",
+			live:  true,
+			usage: "Set to CN to authenticate clients as ACL users named by common names of their certificates",
+			get:   func() string { return config.tlsAuthClientsUser },
+			set: func(v string) error {
+				switch {
+				case strings.EqualFold(v, "off"):
+					config.tlsAuthClientsUser = "off"
+				case strings.EqualFold(v, "cn"):
+					config.tlsAuthClientsUser = "CN"
+				default:
+					return errors.New("argument must be one of off, CN")
+				}
+				return nil
+			},
+		},
+		{
+			name:  "tls-ciphers",
+			value: "",
+			live:  true,
+			tls:   true,
+			usage: "Cipher suites of TLS 1.2 separated by colons, Go defaults are used if empty",
+			get:   func() string { return config.tlsCiphers },
+			set: func(v string) error {
+				config.tlsCiphers = v
+				return nil
+			},
+		},
+		{
+			name:  "tls-min-version",
+			value: "1.2",
+			live:  true,
+			tls:   true,
+			usage: "Minimal version of TLS: 1.0, 1.1, 1.2 or 1.3",
+			get:   func() string { return config.tlsMinVersion },
+			set: func(v string) error {
+				if _, ok := tlsVersions[v]; !ok {
+					return errors.New("argument must be one of 1.0, 1.1, 1.2, 1.3")
+				}
+				config.tlsMinVersion = v
+				return nil
+			},
+		},
+		{
+			name:  "logfile",
+			value: "",
+			usage: "Path to log file, logs are written to stderr if empty",
+			get:   func() string { return config.logfile },
+			set: func(v string) error {
+				config.logfile = v
+				return nil
+			},
+		},
+		{
+			name:  "loglevel",
+			value: "verbose",
+			live:  true,
+			usage: "Minimal level of logged messages: verbose, notice or warning",
+			get: func() string {
+				level := int(atomic.LoadInt32(&config.loglevel))
+				for name, l := range logLevels {
+					if l == level {
+						return name
+					}
+				}
+				return ""
+			},
+			set: func(v string) error {
+				l, ok := logLevels[strings.ToLower(v)]
+				if !ok {
+					return errors.New("argument must be one of verbose, notice, warning")
+				}
+				atomic.StoreInt32(&config.loglevel, int32(l))
+				return nil
+			},
+		},
+	}
+
+	flag.StringVar(&configFile, "config", "", "Path to config file")
+	registerConfigFlags()
+}
+
+func findConfigParam(name string) *configParam {
+	for _, p := range configParams {
+		if p.name == strings.ToLower(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// configOverrides keeps parameters given as command line flags in order.
+var configOverrides [][2]string
+
+// registerConfigFlags makes every parameter a command line flag.
+func registerConfigFlags() {
+	for _, p := range configParams {
+		name := p.name
+		usage := p.usage
+		if p.value != "" {
+			usage += fmt.Sprintf(" (default %q)", p.value)
+		}
+		flag.Func(name, usage, func(v string) error {
+			configOverrides = append(configOverrides, [2]string{name, v})
+			return nil
+		})
+	}
+}
+
+// loadConfig applies default values, then the config file if there is one,
+// then the command line flags. The first invalid value is reported with
+// the place it comes from.
+func loadConfig() error {
+	for _, p := range configParams {
+		if err := p.set(p.value); err != nil {
+			panic(fmt.Sprintf("Invalid default value of %s: %v", p.name, err))
+		}
+	}
+
+	if configFile != "" {
+		if err := readConfigFile(configFile); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range configOverrides {
+		if err := findConfigParam(o[0]).set(o[1]); err != nil {
+			return fmt.Errorf("Invalid value %q of flag -%s: %v", o[1], o[0], err)
+		}
+	}
+
+	if len(config.addrs) == 0 && len(config.tlsAddrs) == 0 && config.unixsocket == "" {
+		return errors.New("No addresses to listen on, addr, tls-addr or unixsocket is required")
+	}
+	if err := reloadTLS(); err != nil {
+		return err
+	}
+
+	if config.logfile != "" {
+		f, err := os.OpenFile(config.logfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("Could not open log file: %v", err)
+		}
+		log.SetOutput(f)
+	}
+	return nil
+}
+
+func readConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Could not open config file: %v", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		name, v, ok, err := parseConfigLine(sc.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if !ok {
+			continue
+		}
+
+		p := findConfigParam(name)
+		if p == nil {
+			return fmt.Errorf("%s:%d: unknown parameter '%s'", path, n, name)
+		}
+		if err := p.set(v); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q of %s: %v", path, n, v, name, err)
+		}
+	}
+	return sc.Err()
+}
+
+// parseConfigLine splits the line of the config file into the name
+// and the value. It returns false for empty lines and comments.
+func parseConfigLine(line string) (name string, v string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return "", "", false, fmt.Errorf("missing value of '%s'", line)
+	}
+	name, v = line[:i], strings.TrimSpace(line[i:])
+
+	if strings.HasPrefix(v, `"`) {
+		if v, err = strconv.Unquote(v); err != nil {
+			return "", "", false, fmt.Errorf("invalid quoted value of '%s'", name)
+		}
+	}
+	return name, v, true, nil
+}
+
+func formatConfigLine(p *configParam) string {
+	v := p.get()
+	if v == "" || strings.ContainsAny(v, " \t\"#") {
+		v = strconv.Quote(v)
+	}
+	return p.name + " " + v
+}
+
+// rewriteConfig writes the current values of parameters to the config file.
+// Lines of parameters are updated in place keeping comments and the order,
+// parameters missing in the file are appended unless they have default values.
+// The file is replaced atomically.
+func rewriteConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := []string{}
+	written := make(map[string]bool)
+	if len(data) > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			name, _, ok, _ := parseConfigLine(line)
+			if p := findConfigParam(name); ok && p != nil {
+				if !written[p.name] {
+					lines = append(lines, formatConfigLine(p))
+					written[p.name] = true
+				}
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+	for _, p := range configParams {
+		if !written[p.name] && p.get() != p.value {
+			lines = append(lines, formatConfigLine(p))
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// parseMemory parses the number of bytes with an optional unit:
+// k, m, g are powers of 1000 and kb, mb, gb are powers of 1024.
+func parseMemory(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		n      int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"b", 1},
+	}
+
+	v = strings.ToLower(v)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mul = strings.TrimSuffix(v, u.suffix), u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// CONFIG GET pattern [pattern ...]
+// CONFIG SET parameter value [parameter value ...]
+// CONFIG REWRITE
+// GET returns values of parameters matching the glob patterns. SET changes
+// live parameters, either all of them or none if a value is invalid.
+// REWRITE writes the current configuration to the config file.
+func configCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	switch strings.ToLower(r.argv[0]) {
+	case "get":
+		if r.argc < 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		res := make(map[string]string)
+		for _, p := range configParams {
+			for _, pattern := range r.argv[1:] {
+				if globMatch(strings.ToLower(pattern), p.name) {
+					res[p.name] = p.get()
+					break
+				}
+			}
+		}
+		return res, nil
+
+	case "set":
+		if r.argc < 3 || r.argc%2 == 0 {
+			return nil, ErrWrongNumOfArguments
+		}
+		return configSet(r.argv[1:])
+
+	case "rewrite":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		if configFile == "" {
+			return nil, ErrConfigFile
+		}
+		if err := rewriteConfig(configFile); err != nil {
+			return nil, fmt.Errorf("ERR Rewriting config file: %v", err)
+		}
+		logf(logNotice, "CONFIG REWRITE executed with success\n")
+		return 1, nil
+	}
+
+	return nil, ErrBadArguments
+}
+
+func configSet(args []string) (interface{}, error) {
+	params := make([]*configParam, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		p := findConfigParam(args[i])
+		if p == nil {
+			return nil, fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i])
+		}
+		if !p.live {
+			return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", p.name)
+		}
+		for _, prev := range params {
+			if prev == p {
+				return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", p.name)
+			}
+		}
+		params = append(params, p)
+	}
+
+	// values are restored if one of them fails, so that no partial change is applied
+	old := make([]string, len(params))
+	rollback := func(n int) {
+		for j := n - 1; j >= 0; j-- {
+			params[j].set(old[j])
+		}
+	}
+	var tlsParam *configParam
+	for i, p := range params {
+		old[i] = p.get()
+		if err := p.set(args[2*i+1]); err != nil {
+			rollback(i)
+			return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", p.name, err)
+		}
+		if p.tls {
+			tlsParam = p
+		}
+	}
+
+	// TLS parameters are validated together, e.g. a new certificate with its key
+	if tlsParam != nil {
+		if err := reloadTLS(); err != nil {
+			rollback(len(params))
+			return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", tlsParam.name, err)
+		}
+	}
+
+	for _, p := range params {
+		logf(logNotice, "CONFIG SET %s\n", p.name)
+	}
+
+	return 1, nil
+}
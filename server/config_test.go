@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		v    string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1024", 1024, true},
+		{"100b", 100, true},
+		{"1k", 1000, true},
+		{"1kb", 1024, true},
+		{"2MB", 2 << 20, true},
+		{"3g", 3e9, true},
+		{"1gb", 1 << 30, true},
+		{"", 0, false},
+		{"mb", 0, false},
+		{"-1", 0, false},
+		{"12x", 0, false},
+		{"8589934591gb", 8589934591 << 30, true},
+		{"8589934592gb", 0, false},
+		{"9000000000gb", 0, false},
+	}
+
+	t.Log("Given memory values with units")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen parsing %q", i, tt.v)
+
+		got, err := parseMemory(tt.v)
+		if (err == nil) == tt.ok && got == tt.want {
+			t.Logf("\t%s\tShould get %d", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %d, got %d, %v", failed, tt.want, got, err)
+		}
+	}
+}
+
+func TestRewriteConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redislike.conf")
+	os.WriteFile(path, []byte("# comment\naddr :9001\nmaxmemory 1mb\n\nmaxmemory 2mb\n"), 0644)
+
+	configFile = path
+	defer func() { configFile = "" }()
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer setMaxmemory(0)
+
+	t.Log("Given a config file")
+	{
+		t.Log("\tWhen the file is loaded")
+		{
+			if config.maxmemory == 2<<20 && config.addrs[0] == ":9001" {
+				t.Logf("\t%s\tShould apply the last value of a parameter", succeed)
+			} else {
+				t.Errorf("\t%s\tShould apply the last value of a parameter, got %d", failed, config.maxmemory)
+			}
+		}
+
+		t.Log("\tWhen parameters are changed and the file is rewritten")
+		{
+			configSet([]string{"maxmemory", "0", "notify-keyspace-events", ""})
+			configSet([]string{"maxmemory-policy", "allkeys-lru"})
+			if err := rewriteConfig(path); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "# comment\naddr :9001\nmaxmemory 0\n\nmaxmemory-policy allkeys-lru\n"
+			if b, _ := os.ReadFile(path); string(b) == want {
+				t.Logf("\t%s\tShould update lines in place and append changed parameters", succeed)
+			} else {
+				t.Errorf("\t%s\tShould update lines in place and append changed parameters, got %q", failed, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Keys live in numbered databases. Every connection starts with database 0
+// and switches to another one with SELECT. Writes are logged to the cmdlog
+// along with the number of the database they were executed against.
+var databases []*storage
+
+func newDatabases(n int) []*storage {
+	dbs := make([]*storage, n)
+	for i := range dbs {
+		dbs[i] = newStorage()
+	}
+	return dbs
+}
+
+// dbIndex returns the number of the database or -1 if it's not one of databases.
+func dbIndex(s *storage) int {
+	for i, db := range databases {
+		if db == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseDBIndex parses the number of a database.
+func parseDBIndex(v string) (int, error) {
+	db, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, ErrBadArguments
+	}
+	if db < 0 || db >= len(databases) {
+		return 0, ErrDBIndexOutOfRange
+	}
+	return db, nil
+}
+
+// checkFlushMode checks the optional ASYNC or SYNC modifier of FLUSHDB
+// and FLUSHALL, both of which flush the same way, like UNLINK.
+func checkFlushMode(r *request) error {
+	switch {
+	case r.argc == 0:
+		return nil
+	case r.argc > 1:
+		return ErrWrongNumOfArguments
+	}
+
+	switch strings.ToLower(r.argv[0]) {
+	case "async", "sync":
+		return nil
+	}
+	return ErrBadArguments
+}
+
+// SELECT index
+func selectCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	db, err := parseDBIndex(r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	r.client.db = db
+	return 1, nil
+}
+
+// SWAPDB index1 index2
+// Swaps two databases, so clients connected to one of them see
+// the data of the other one right away.
+func swapdbCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	i, err := parseDBIndex(r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	j, err := parseDBIndex(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+
+	databases[i], databases[j] = databases[j], databases[i]
+	return 1, nil
+}
+
+// FLUSHDB [ASYNC|SYNC]
+// Removes all keys of the current database.
+func flushdbCommand(s *storage, r *request) (interface{}, error) {
+	if err := checkFlushMode(r); err != nil {
+		return nil, err
+	}
+
+	s.flush()
+	return 1, nil
+}
+
+// FLUSHALL [ASYNC|SYNC]
+// Removes all keys of all databases.
+func flushallCommand(s *storage, r *request) (interface{}, error) {
+	if err := checkFlushMode(r); err != nil {
+		return nil, err
+	}
+
+	for _, db := range databases {
+		db.flush()
+	}
+	return 1, nil
+}
+
+// DBSIZE
+// Return value is the number of keys in the current database.
+func dbsizeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 0 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	n, _ := s.len()
+	return n, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// dbKeys returns sorted keys of every database.
+func dbKeys() [][]string {
+	res := make([][]string, len(databases))
+	for i, s := range databases {
+		res[i] = []string{}
+		for k := range s.entries {
+			res[i] = append(res[i], k)
+		}
+		sort.Strings(res[i])
+	}
+	return res
+}
+
+func TestDatabases(t *testing.T) {
+	defer func(dbs []*storage, l *cmdlog) { databases, cmdlogger = dbs, l }(databases, cmdlogger)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "cmdlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	databases = newDatabases(3)
+	l := &cmdlog{file: f, logchan: make(chan *request, 1)}
+	cmdlogger = l
+
+	tests := []struct {
+		cmd  []string
+		want string // the reply encoded to JSON
+		err  error
+	}{
+		{[]string{"set", "a0", "v"}, "1", nil},
+		{[]string{"select", "3"}, "null", ErrDBIndexOutOfRange},
+		{[]string{"select", "-1"}, "null", ErrDBIndexOutOfRange},
+		{[]string{"select", "one"}, "null", ErrBadArguments},
+		{[]string{"select"}, "null", ErrWrongNumOfArguments},
+		{[]string{"select", "1"}, "1", nil},
+		{[]string{"get", "a0"}, "null", nil},
+		{[]string{"mset", "b1", "v", "c1", "v"}, "1", nil},
+		{[]string{"dbsize"}, "2", nil},
+		{[]string{"dbsize", "x"}, "null", ErrWrongNumOfArguments},
+		{[]string{"select", "2"}, "1", nil},
+		{[]string{"set", "d2", "v"}, "1", nil},
+		{[]string{"set", "e2", "v"}, "1", nil},
+		{[]string{"flushdb", "sync"}, "1", nil},
+		{[]string{"flushdb", "now"}, "null", ErrBadArguments},
+		{[]string{"flushdb", "sync", "async"}, "null", ErrWrongNumOfArguments},
+		{[]string{"dbsize"}, "0", nil},
+		{[]string{"set", "f2", "v"}, "1", nil},
+
+		// the client stays in database 2, which is database 0 now
+		{[]string{"swapdb", "0", "2"}, "1", nil},
+		{[]string{"swapdb", "0", "3"}, "null", ErrDBIndexOutOfRange},
+		{[]string{"swapdb", "0"}, "null", ErrWrongNumOfArguments},
+		{[]string{"get", "a0"}, `"v"`, nil},
+		{[]string{"set", "g2", "v"}, "1", nil},
+		{[]string{"select", "0"}, "1", nil},
+		{[]string{"get", "f2"}, `"v"`, nil},
+		{[]string{"set", "h0", "v"}, "1", nil},
+		{[]string{"swapdb", "1", "1"}, "1", nil},
+		{[]string{"select", "1"}, "1", nil},
+		{[]string{"del", "c1"}, "1", nil},
+		{[]string{"dbsize"}, "1", nil},
+	}
+
+	t.Log("Given three databases logged to the cmdlog")
+
+	c := &client{}
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %v", i, tt.cmd)
+
+		got, err := executeCmd(&request{cmd: tt.cmd[0], argv: tt.cmd[1:], argc: len(tt.cmd) - 1, client: c})
+		if got == tt.want && err == tt.err {
+			t.Logf("\t%s\tShould get %s, %v", succeed, tt.want, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %s, %v, got %s, %v", failed, tt.want, tt.err, got, err)
+		}
+		select {
+		case r := <-l.logchan:
+			l.write(r)
+		default:
+		}
+	}
+
+	want := [][]string{{"f2", "h0"}, {"b1"}, {"a0", "g2"}}
+	if got := dbKeys(); reflect.DeepEqual(got, want) {
+		t.Logf("\t%s\tShould have keys %v", succeed, want)
+	} else {
+		t.Errorf("\t%s\tShould have keys %v, got %v", failed, want, got)
+	}
+
+	t.Log("\tWhen the cmdlog is replayed")
+	{
+		b, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(b, []byte("SELECT")); n == 4 {
+			t.Logf("\t%s\tShould log SELECT before switching databases", succeed)
+		} else {
+			t.Errorf("\t%s\tShould log SELECT before switching databases 4 times, got %d", failed, n)
+		}
+
+		databases, cmdlogger = newDatabases(3), nil
+		if db := replay(bytes.NewReader(b)); db == 1 {
+			t.Logf("\t%s\tShould end in the database 1", succeed)
+		} else {
+			t.Errorf("\t%s\tShould end in the database 1, got %d", failed, db)
+		}
+		if got := dbKeys(); reflect.DeepEqual(got, want) {
+			t.Logf("\t%s\tShould restore keys %v", succeed, want)
+		} else {
+			t.Errorf("\t%s\tShould restore keys %v, got %v", failed, want, got)
+		}
+	}
+
+	t.Log("\tWhen all databases are flushed")
+	{
+		execute(databases[0], "flushall", "async")
+		if got := dbKeys(); reflect.DeepEqual(got, [][]string{{}, {}, {}}) {
+			t.Logf("\t%s\tShould remove keys of all databases", succeed)
+		} else {
+			t.Errorf("\t%s\tShould remove keys of all databases, got %v", failed, got)
+		}
+	}
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DUMP serializes a value and the deadline of its key into the following payload:
+//
+//	type | value | deadline | version | checksum
+//
+// The type takes one byte, the version takes two bytes and the checksum is
+// CRC-64 (ECMA) of everything before it taking eight bytes. Integers and
+// lengths of the value are varint encoded, floats take eight bytes and
+// strings are prefixed with their length. Deadlines of hash fields are
+// a part of the value. The deadline is a unix time in milliseconds or 0
+// for keys without one. Payloads of version 1 have no deadline.
+const dumpVersion = 2
+
+const (
+	dumpTypeString = iota
+	dumpTypeList
+	dumpTypeHash
+	dumpTypeZSet
+	dumpTypeHyperLogLog
+	dumpTypeStream
+	dumpTypeJSON
+	dumpTypeBloom
+	dumpTypeCuckoo
+	dumpTypeTimeSeries
+)
+
+// ErrDumpPayload rises when RESTORE gets a malformed payload.
+var ErrDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+// ErrBusyKey rises when RESTORE is about to overwrite a key without REPLACE.
+var ErrBusyKey = errors.New("BUSYKEY Target key name already exists")
+
+var dumpCRCTable = crc64.MakeTable(crc64.ECMA)
+
+type dumpEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *dumpEncoder) byte(v byte) {
+	e.buf.WriteByte(v)
+}
+
+func (e *dumpEncoder) uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (e *dumpEncoder) int(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *dumpEncoder) float(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	e.buf.Write(b[:])
+}
+
+func (e *dumpEncoder) string(v string) {
+	e.uint(uint64(len(v)))
+	e.buf.WriteString(v)
+}
+
+func (e *dumpEncoder) streamID(id streamID) {
+	e.uint(id.ms)
+	e.uint(id.seq)
+}
+
+// dumpDecoder reads values written by dumpEncoder. The first error
+// is kept and every following read returns zero values.
+type dumpDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *dumpDecoder) fail() {
+	d.err = ErrDumpPayload
+	d.b = nil
+}
+
+func (d *dumpDecoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *dumpDecoder) uint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *dumpDecoder) int() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// len reads the number of elements which follow. Every element takes
+// at least a byte, so a number greater than what's left is an error.
+func (d *dumpDecoder) len() int {
+	n := d.uint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *dumpDecoder) float() float64 {
+	if len(d.b) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *dumpDecoder) string() string {
+	n := d.len()
+	v := string(d.b[:n])
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *dumpDecoder) streamID() streamID {
+	return streamID{d.uint(), d.uint()}
+}
+
+// dumpValue serializes the value of the entry and its deadline.
+func dumpValue(e *entry) []byte {
+	enc := &dumpEncoder{}
+
+	switch v := e.value.(type) {
+	case string:
+		enc.byte(dumpTypeString)
+		enc.string(v)
+	case []byte:
+		enc.byte(dumpTypeString)
+		enc.string(string(v))
+	case []string:
+		enc.byte(dumpTypeList)
+		enc.uint(uint64(len(v)))
+		for _, x := range v {
+			enc.string(x)
+		}
+	case map[string]string:
+		enc.byte(dumpTypeHash)
+		enc.uint(uint64(len(v)))
+		for f, x := range v {
+			enc.string(f)
+			enc.string(x)
+			var deadline int64
+			if fx, ok := e.fields[f]; ok {
+				deadline = fx.ttl
+			}
+			enc.int(deadline)
+		}
+	case *sortedSet:
+		enc.byte(dumpTypeZSet)
+		enc.uint(uint64(len(v.members)))
+		for _, m := range v.members {
+			enc.string(m.member)
+			enc.float(m.score)
+		}
+	case *hyperLogLog:
+		enc.byte(dumpTypeHyperLogLog)
+		dumpHyperLogLog(enc, v)
+	case *stream:
+		enc.byte(dumpTypeStream)
+		dumpStream(enc, v)
+	case *jsonDocument:
+		enc.byte(dumpTypeJSON)
+		s, _ := formatJSONValue(v.root)
+		enc.string(s)
+	case *bloomFilter:
+		enc.byte(dumpTypeBloom)
+		dumpBloomFilter(enc, v)
+	case *cuckooFilter:
+		enc.byte(dumpTypeCuckoo)
+		dumpCuckooFilter(enc, v)
+	case *timeSeries:
+		enc.byte(dumpTypeTimeSeries)
+		enc.int(v.retention)
+		enc.string(v.policy)
+		enc.uint(uint64(len(v.samples)))
+		for _, x := range v.samples {
+			enc.int(x.ts)
+			enc.float(x.value)
+		}
+	}
+
+	var deadline int64
+	if e.expiry != nil {
+		deadline = e.expiry.ttl
+	}
+	enc.int(deadline)
+
+	var footer [10]byte
+	binary.LittleEndian.PutUint16(footer[:2], dumpVersion)
+	enc.buf.Write(footer[:2])
+	binary.LittleEndian.PutUint64(footer[2:], crc64.Checksum(enc.buf.Bytes(), dumpCRCTable))
+	enc.buf.Write(footer[2:])
+
+	return enc.buf.Bytes()
+}
+
+func dumpHyperLogLog(enc *dumpEncoder, h *hyperLogLog) {
+	if !h.isSparse() {
+		enc.byte(1)
+		enc.buf.Write(h.dense)
+		return
+	}
+
+	enc.byte(0)
+	enc.uint(uint64(h.sparseLen()))
+	for j := 0; j < h.sparseLen(); j++ {
+		i, v := h.sparseAt(j)
+		enc.uint(uint64(i))
+		enc.byte(v)
+	}
+}
+
+func dumpStream(enc *dumpEncoder, st *stream) {
+	enc.uint(uint64(len(st.entries)))
+	for _, x := range st.entries {
+		enc.streamID(x.id)
+		enc.uint(uint64(len(x.fields)))
+		for _, f := range x.fields {
+			enc.string(f)
+		}
+	}
+	enc.streamID(st.lastID)
+
+	enc.uint(uint64(len(st.groups)))
+	for name, g := range st.groups {
+		enc.string(name)
+		enc.streamID(g.lastID)
+		enc.uint(uint64(len(g.pending)))
+		for id, p := range g.pending {
+			enc.streamID(id)
+			enc.string(p.consumer)
+			enc.int(p.deliveryTime)
+			enc.int(p.deliveryCount)
+		}
+		enc.uint(uint64(len(g.consumers)))
+		for name, c := range g.consumers {
+			enc.string(name)
+			enc.int(c.seenTime)
+			enc.int(int64(c.pending))
+		}
+	}
+}
+
+func dumpBloomFilter(enc *dumpEncoder, f *bloomFilter) {
+	enc.float(f.errorRate)
+	enc.int(f.expansion)
+	enc.uint(uint64(len(f.layers)))
+	for _, l := range f.layers {
+		enc.uint(l.m)
+		enc.uint(l.k)
+		enc.int(l.capacity)
+		enc.int(l.count)
+		enc.uint(uint64(len(l.bits)))
+		for _, w := range l.bits {
+			enc.uint(w)
+		}
+	}
+}
+
+func dumpCuckooFilter(enc *dumpEncoder, f *cuckooFilter) {
+	enc.int(f.capacity)
+	enc.uint(uint64(f.bucketSize))
+	enc.uint(uint64(f.maxIterations))
+	enc.int(f.expansion)
+	enc.int(f.inserted)
+	enc.int(f.deleted)
+	enc.uint(uint64(len(f.layers)))
+	for _, l := range f.layers {
+		enc.uint(l.numBuckets)
+		for _, b := range l.buckets {
+			enc.buf.Write(b)
+		}
+	}
+}
+
+// restoreValue deserializes the value written by dumpValue. Along with
+// the value it returns the deadline of the key and deadlines of hash fields.
+func restoreValue(payload []byte) (interface{}, int64, map[string]int64, error) {
+	n := len(payload) - 10
+	if n < 1 {
+		return nil, 0, nil, ErrDumpPayload
+	}
+	version := binary.LittleEndian.Uint16(payload[n:])
+	if version < 1 || version > dumpVersion ||
+		binary.LittleEndian.Uint64(payload[n+2:]) != crc64.Checksum(payload[:n+2], dumpCRCTable) {
+		return nil, 0, nil, ErrDumpPayload
+	}
+
+	d := &dumpDecoder{b: payload[:n]}
+	var v interface{}
+	var deadlines map[string]int64
+
+	switch d.byte() {
+	case dumpTypeString:
+		v = d.string()
+	case dumpTypeList:
+		l := make([]string, d.len())
+		for i := range l {
+			l[i] = d.string()
+		}
+		v = l
+	case dumpTypeHash:
+		n := d.len()
+		h := make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			f := d.string()
+			h[f] = d.string()
+			if deadline := d.int(); deadline > 0 {
+				if deadlines == nil {
+					deadlines = make(map[string]int64)
+				}
+				deadlines[f] = deadline
+			}
+		}
+		v = h
+	case dumpTypeZSet:
+		z := newSortedSet()
+		for n := d.len(); n > 0; n-- {
+			m := d.string()
+			z.add(m, d.float())
+		}
+		v = z
+	case dumpTypeHyperLogLog:
+		v = restoreHyperLogLog(d)
+	case dumpTypeStream:
+		v = restoreStream(d)
+	case dumpTypeJSON:
+		root, err := parseJSONValue(d.string())
+		if err != nil {
+			d.fail()
+		}
+		v = &jsonDocument{root}
+	case dumpTypeBloom:
+		v = restoreBloomFilter(d)
+	case dumpTypeCuckoo:
+		v = restoreCuckooFilter(d)
+	case dumpTypeTimeSeries:
+		v = restoreTimeSeries(d)
+	default:
+		d.fail()
+	}
+
+	var deadline int64
+	if version > 1 {
+		if deadline = d.int(); deadline < 0 {
+			d.fail()
+		}
+	}
+
+	if d.err == nil && len(d.b) > 0 {
+		d.fail()
+	}
+	if d.err != nil {
+		return nil, 0, nil, d.err
+	}
+	return v, deadline, deadlines, nil
+}
+
+// restoreHyperLogLog restores registers. A register counts at most
+// hllQ+1 zeros, greater values are out of the histogram of count.
+func restoreHyperLogLog(d *dumpDecoder) *hyperLogLog {
+	h := newHyperLogLog()
+	if d.byte() == 1 {
+		if len(d.b) < hllRegisters {
+			d.fail()
+			return h
+		}
+		for _, v := range d.b[:hllRegisters] {
+			if v > hllQ+1 {
+				d.fail()
+				return h
+			}
+		}
+		h.dense = append([]uint8(nil), d.b[:hllRegisters]...)
+		h.sparse = nil
+		d.b = d.b[hllRegisters:]
+		return h
+	}
+
+	for n := d.len(); n > 0; n-- {
+		i, v := d.uint(), d.byte()
+		if i >= hllRegisters || v > hllQ+1 {
+			d.fail()
+			return h
+		}
+		h.set(uint16(i), v)
+	}
+	return h
+}
+
+// restoreStream restores a stream. Entries must be ordered by IDs with
+// no duplicates, since the stream is searched by them, and the last ID
+// must not be less than the ID of the last entry, so XADD never adds
+// an entry out of order.
+func restoreStream(d *dumpDecoder) *stream {
+	st := newStream()
+	st.entries = make([]streamEntry, d.len())
+	for i := range st.entries {
+		st.entries[i].id = d.streamID()
+		if i > 0 && !st.entries[i-1].id.less(st.entries[i].id) {
+			d.fail()
+			return st
+		}
+		st.entries[i].fields = make([]string, d.len())
+		for j := range st.entries[i].fields {
+			st.entries[i].fields[j] = d.string()
+		}
+	}
+	st.lastID = d.streamID()
+	if n := len(st.entries); n > 0 && st.lastID.less(st.entries[n-1].id) {
+		d.fail()
+		return st
+	}
+
+	for n := d.len(); n > 0; n-- {
+		name := d.string()
+		g := newStreamGroup(d.streamID())
+		for m := d.len(); m > 0; m-- {
+			id := d.streamID()
+			g.pending[id] = &streamPending{d.string(), d.int(), d.int()}
+		}
+		for m := d.len(); m > 0; m-- {
+			consumer := d.string()
+			g.consumers[consumer] = &streamConsumer{d.int(), int(d.int())}
+		}
+		st.groups[name] = g
+	}
+	return st
+}
+
+func restoreBloomFilter(d *dumpDecoder) *bloomFilter {
+	f := &bloomFilter{errorRate: d.float(), expansion: d.int()}
+	if !(f.errorRate > 0 && f.errorRate < 1) || f.expansion < 0 {
+		d.fail()
+		return f
+	}
+	f.layers = make([]*bloomLayer, d.len())
+	for i := range f.layers {
+		l := &bloomLayer{m: d.uint(), k: d.uint(), capacity: d.int(), count: d.int()}
+		// positions of bits are taken modulo m
+		if l.m == 0 || l.k == 0 || l.capacity < 1 || l.count < 0 {
+			d.fail()
+			return f
+		}
+		l.bits = make([]uint64, d.len())
+		for j := range l.bits {
+			l.bits[j] = d.uint()
+		}
+		if uint64(len(l.bits)) != (l.m+63)/64 {
+			d.fail()
+		}
+		f.layers[i] = l
+	}
+	if len(f.layers) == 0 {
+		d.fail()
+	}
+	return f
+}
+
+func restoreCuckooFilter(d *dumpDecoder) *cuckooFilter {
+	f := &cuckooFilter{capacity: d.int()}
+	bucketSize, maxIterations := d.uint(), d.uint()
+	f.expansion, f.inserted, f.deleted = d.int(), d.int(), d.int()
+	if f.capacity < 1 || bucketSize < 1 || bucketSize > cuckooMaxBucketSize ||
+		maxIterations < 1 || maxIterations > math.MaxInt32 || f.expansion < 0 {
+		d.fail()
+		return f
+	}
+	f.bucketSize, f.maxIterations = int(bucketSize), int(maxIterations)
+
+	f.layers = make([]*cuckooLayer, d.len())
+	for i := range f.layers {
+		n := d.uint()
+		// n*bucketSize must not overflow before it's compared
+		if n == 0 || n&(n-1) != 0 || n > uint64(len(d.b))/bucketSize {
+			d.fail()
+			return f
+		}
+		l := &cuckooLayer{buckets: make([][]uint8, n), numBuckets: n}
+		slots := append([]uint8(nil), d.b[:n*uint64(f.bucketSize)]...)
+		d.b = d.b[len(slots):]
+		for j := range l.buckets {
+			l.buckets[j] = slots[j*f.bucketSize : (j+1)*f.bucketSize]
+		}
+		f.layers[i] = l
+	}
+	if len(f.layers) == 0 {
+		d.fail()
+	}
+	return f
+}
+
+// restoreTimeSeries restores a series. Samples must be ordered by
+// timestamps with no duplicates, since the series is searched by them.
+func restoreTimeSeries(d *dumpDecoder) *timeSeries {
+	t := newTimeSeries(d.int(), d.string())
+	if t.retention < 0 || !tsPolicies[t.policy] {
+		d.fail()
+		return t
+	}
+
+	t.samples = make([]tsSample, d.len())
+	for i := range t.samples {
+		t.samples[i] = tsSample{d.int(), d.float()}
+		if t.samples[i].ts < 0 || i > 0 && t.samples[i].ts <= t.samples[i-1].ts {
+			d.fail()
+			return t
+		}
+	}
+	return t
+}
+
+// base64Dump serializes the value of the entry for replies and arguments,
+// which must be valid UTF-8 strings.
+func base64Dump(e *entry) string {
+	return base64.StdEncoding.EncodeToString(dumpValue(e))
+}
+
+// DUMP key
+// Return value is the serialized value encoded with base64
+// or nil if the key does not exist.
+func dumpCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	e := s.getEntry(r.argv[0])
+	if e == nil {
+		return nil, nil
+	}
+
+	return base64Dump(e), nil
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+// Creates the key from the value serialized by DUMP. The ttl is in
+// milliseconds (or a unix time in milliseconds with ABSTTL), 0 means
+// the deadline the key had when it was dumped, if any.
+func restoreCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	replace, absttl := false, false
+	for _, opt := range r.argv[3:] {
+		switch strings.ToLower(opt) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absttl = true
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	ttl, err := strconv.ParseInt(r.argv[1], 10, 64)
+	if err != nil || ttl < 0 {
+		return nil, ErrBadArguments
+	}
+	payload, err := base64.StdEncoding.DecodeString(r.argv[2])
+	if err != nil {
+		return nil, ErrDumpPayload
+	}
+	v, deadline, deadlines, err := restoreValue(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	k := r.argv[0]
+	if !replace && s.exists(k) {
+		return nil, ErrBusyKey
+	}
+
+	now := mstime()
+	switch {
+	case ttl == 0:
+		ttl = deadline
+	case !absttl:
+		ttl += now
+	}
+	argv := []string{k, strconv.FormatInt(ttl, 10), r.argv[2]}
+	if replace {
+		argv = append(argv, "REPLACE")
+	}
+	r.rewrite("restore", append(argv, "ABSTTL")...)
+
+	if h, ok := v.(map[string]string); ok {
+		for f, deadline := range deadlines {
+			if deadline <= now {
+				delete(h, f)
+				delete(deadlines, f)
+			}
+		}
+		if len(h) == 0 {
+			v = nil
+		}
+	}
+
+	// the value has already expired
+	if v == nil || ttl > 0 && ttl <= now {
+		if s.del(k) {
+			notifyKeyspaceEvent(notifyGeneric, "del", k, r.db)
+		}
+		return 1, nil
+	}
+
+	s.set(k, v)
+	if ttl > 0 {
+		s.setExpire(k, ttl)
+	}
+	for f, deadline := range deadlines {
+		s.setFieldExpire(k, f, deadline)
+	}
+	notifyKeyspaceEvent(notifyGeneric, "restore", k, r.db)
+
+	return 1, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDumpRestore(t *testing.T) {
+	hll := newHyperLogLog()
+	denseHLL := newHyperLogLog()
+	z := newSortedSet()
+	st := newStream()
+	bf := newBloomFilter(100, 0.01, 2)
+	cf := newCuckooFilter(64, 2, 20, 2)
+	ts := newTimeSeries(0, "block")
+	for i := 0; i < 200; i++ {
+		item := strconv.Itoa(i)
+		denseHLL.add(item)
+		z.add(item, float64(i)/3)
+		st.add(streamID{uint64(i), 0}, []string{"f", item})
+		bf.add(item)
+		cf.add(item)
+		ts.add(int64(i), float64(i)*1.5, "")
+	}
+	hll.add("a")
+	denseHLL.toDense()
+	st.lastID = streamID{199, 0}
+	g := newStreamGroup(streamID{10, 0})
+	g.deliver(streamID{5, 0}, "alice", 1000)
+	st.groups["workers"] = g
+	doc, _ := parseJSONValue(`{"a":[1,2.5,"x",null,true],"b":{"c":{}}}`)
+
+	tests := []interface{}{
+		"value",
+		[]string{"a", "", "c"},
+		map[string]string{"f": "v", "g": ""},
+		z,
+		hll,
+		denseHLL,
+		st,
+		&jsonDocument{doc},
+		bf,
+		cf,
+		ts,
+	}
+
+	t.Log("Given values of every type")
+	{
+		for _, v := range tests {
+			t.Logf("\tWhen %T value is dumped and restored", v)
+			{
+				payload := dumpValue(&entry{value: v})
+				res, _, _, err := restoreValue(payload)
+				if err != nil {
+					t.Errorf("\t%s\tShould restore the value: %v", failed, err)
+					continue
+				}
+				if reflect.DeepEqual(res, v) {
+					t.Logf("\t%s\tShould get the same value", succeed)
+				} else {
+					t.Errorf("\t%s\tShould get the same value, got %#v", failed, res)
+				}
+			}
+		}
+	}
+
+	t.Log("Given a corrupted payload")
+	{
+		payload := dumpValue(&entry{value: []string{"a", "b"}})
+		payload[2] ^= 1
+
+		if _, _, _, err := restoreValue(payload); err == ErrDumpPayload {
+			t.Logf("\t%s\tShould fail the checksum", succeed)
+		} else {
+			t.Errorf("\t%s\tShould fail the checksum, got %v", failed, err)
+		}
+	}
+
+	t.Log("Given a hash with a field deadline")
+	{
+		e := &entry{
+			value:  map[string]string{"f": "v", "g": "w"},
+			fields: map[string]*expiry{"f": newFieldExpiry("h", "f", 12345)},
+		}
+
+		_, _, deadlines, err := restoreValue(dumpValue(e))
+		if err == nil && reflect.DeepEqual(deadlines, map[string]int64{"f": 12345}) {
+			t.Logf("\t%s\tShould restore the deadline", succeed)
+		} else {
+			t.Errorf("\t%s\tShould restore the deadline, got %v, %v", failed, deadlines, err)
+		}
+	}
+}
+
+func TestRestoreMalformed(t *testing.T) {
+	bloom := func(f func(*bloomFilter)) interface{} {
+		bf := newBloomFilter(100, 0.01, 2)
+		bf.add("a")
+		f(bf)
+		return bf
+	}
+	cuckoo := func(f func(*cuckooFilter)) interface{} {
+		cf := newCuckooFilter(64, 2, 20, 2)
+		cf.add("a")
+		f(cf)
+		return cf
+	}
+	sparse := newHyperLogLog()
+	sparse.add("a")
+	sparse.sparse[2] = hllQ + 2 // the value of the only register
+	dense := newHyperLogLog()
+	dense.add("a")
+	dense.toDense()
+	dense.dense[7] = 200
+	series := func(f func(*timeSeries)) interface{} {
+		ts := newTimeSeries(0, "block")
+		ts.add(1, 1, "")
+		ts.add(2, 2, "")
+		ts.add(3, 3, "")
+		f(ts)
+		return ts
+	}
+
+	entries := func(f func(*stream)) interface{} {
+		st := newStream()
+		st.add(streamID{1, 0}, []string{"f", "v"})
+		st.add(streamID{2, 0}, []string{"f", "v"})
+		st.add(streamID{3, 0}, []string{"f", "v"})
+		f(st)
+		return st
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"sparse HyperLogLog with a register out of range", sparse},
+		{"dense HyperLogLog with a register out of range", dense},
+		{"bloom filter with no bits", bloom(func(f *bloomFilter) { f.layers[0].m, f.layers[0].bits = 0, nil })},
+		{"bloom filter with no hash functions", bloom(func(f *bloomFilter) { f.layers[0].k = 0 })},
+		{"bloom filter with zero error rate", bloom(func(f *bloomFilter) { f.errorRate = 0 })},
+		{"bloom filter with error rate over 1", bloom(func(f *bloomFilter) { f.errorRate = 1.5 })},
+		{"bloom filter with zero capacity", bloom(func(f *bloomFilter) { f.layers[0].capacity = 0 })},
+		{"bloom filter with no layers", bloom(func(f *bloomFilter) { f.layers = nil })},
+		{"cuckoo filter with empty buckets", cuckoo(func(f *cuckooFilter) { f.bucketSize = 0 })},
+		{"cuckoo filter with too big buckets", cuckoo(func(f *cuckooFilter) { f.bucketSize = cuckooMaxBucketSize + 1 })},
+		{"cuckoo filter with buckets overflowing", cuckoo(func(f *cuckooFilter) { f.bucketSize = 1 << 62 })},
+		{"cuckoo filter with too many buckets", cuckoo(func(f *cuckooFilter) {
+			f.bucketSize, f.layers[0].numBuckets = cuckooMaxBucketSize, 1<<60
+		})},
+		{"cuckoo filter with buckets not a power of two", cuckoo(func(f *cuckooFilter) { f.layers[0].numBuckets = 31 })},
+		{"cuckoo filter with no iterations", cuckoo(func(f *cuckooFilter) { f.maxIterations = 0 })},
+		{"time series with unknown policy", series(func(ts *timeSeries) { ts.policy = "newest" })},
+		{"time series with negative retention", series(func(ts *timeSeries) { ts.retention = -1 })},
+		{"time series with unordered samples", series(func(ts *timeSeries) { ts.samples[0].ts = 5 })},
+		{"time series with duplicate timestamps", series(func(ts *timeSeries) { ts.samples[2].ts = 2 })},
+		{"time series with negative timestamps", series(func(ts *timeSeries) { ts.samples[0].ts = -1 })},
+		{"stream with unordered entries", entries(func(st *stream) { st.entries[0].id = streamID{5, 0} })},
+		{"stream with duplicate IDs", entries(func(st *stream) { st.entries[2].id = streamID{2, 0} })},
+		{"stream with the last ID less than an entry", entries(func(st *stream) { st.lastID = streamID{2, 9} })},
+	}
+
+	t.Log("Given payloads of values which can't be valid")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen restoring a %s", i, tt.name)
+
+		if _, _, _, err := restoreValue(dumpValue(&entry{value: tt.value})); err == ErrDumpPayload {
+			t.Logf("\t%s\tShould reject the payload", succeed)
+		} else {
+			t.Errorf("\t%s\tShould reject the payload, got %v", failed, err)
+		}
+	}
+}
+
+func TestDumpTTL(t *testing.T) {
+	s := newStorage()
+	execute(s, "set", "a", "1")
+	s.setExpire("a", mstime()+60000)
+	payload, _ := execute(s, "dump", "a")
+
+	deadline := func(k string) int64 {
+		if e := s.getEntry(k); e != nil && e.expiry != nil {
+			return e.expiry.ttl
+		}
+		return 0
+	}
+
+	t.Log("Given the payload of a key with a deadline")
+	{
+		t.Log("\tWhen it's restored with the ttl 0")
+		{
+			execute(s, "restore", "b", "0", payload.(string))
+			if d := deadline("b"); d == deadline("a") {
+				t.Logf("\t%s\tShould set the deadline of the payload", succeed)
+			} else {
+				t.Errorf("\t%s\tShould set the deadline of the payload, got %d", failed, d)
+			}
+		}
+
+		t.Log("\tWhen it's restored with the ttl")
+		{
+			now := mstime()
+			execute(s, "restore", "c", "5000", payload.(string))
+			if d := deadline("c"); d >= now+5000 && d <= mstime()+5000 {
+				t.Logf("\t%s\tShould set the deadline from the ttl", succeed)
+			} else {
+				t.Errorf("\t%s\tShould set the deadline from the ttl, got %d", failed, d-now)
+			}
+		}
+	}
+
+	t.Log("Given the payload of a key without a deadline")
+	{
+		execute(s, "set", "d", "1")
+		payload, _ := execute(s, "dump", "d")
+
+		t.Log("\tWhen it's restored with the ttl 0")
+		{
+			execute(s, "restore", "e", "0", payload.(string))
+			if d := deadline("e"); s.exists("e") && d == 0 {
+				t.Logf("\t%s\tShould not set a deadline", succeed)
+			} else {
+				t.Errorf("\t%s\tShould not set a deadline, got %d", failed, d)
+			}
+		}
+	}
+
+	t.Log("Given the payload of a key whose deadline has passed")
+	{
+		payload := base64Dump(&entry{value: "1", expiry: newExpiry("f", mstime()-1000)})
+
+		t.Log("\tWhen it's restored with the ttl 0")
+		{
+			execute(s, "restore", "f", "0", payload)
+			if !s.exists("f") {
+				t.Logf("\t%s\tShould not create the key", succeed)
+			} else {
+				t.Errorf("\t%s\tShould not create the key", failed)
+			}
+		}
+	}
+
+	t.Log("Given a payload of version 1")
+	{
+		// the value "1" of a string with the checksum of version 1
+		payload := []byte{dumpTypeString, 1, '1', 1, 0}
+		var sum [8]byte
+		binary.LittleEndian.PutUint64(sum[:], crc64.Checksum(payload, dumpCRCTable))
+		payload = append(payload, sum[:]...)
+
+		t.Log("\tWhen it's restored")
+		{
+			v, d, _, err := restoreValue(payload)
+			if err == nil && v == "1" && d == 0 {
+				t.Logf("\t%s\tShould restore the value without a deadline", succeed)
+			} else {
+				t.Errorf("\t%s\tShould restore the value without a deadline, got %v, %d, %v", failed, v, d, err)
+			}
+		}
+	}
+}
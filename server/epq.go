@@ -5,8 +5,9 @@ import (
 )
 
 type expiry struct {
-	key string
-	ttl int64 // The priority of the item in the queue.
+	key   string
+	field string // Set only for expiries of hash fields.
+	ttl   int64  // The priority of the item in the queue.
 	// The index is needed by update and is maintained by the heap.Interface methods.
 	index int // The index of the item in the heap.
 }
@@ -15,6 +16,10 @@ func newExpiry(k string, ttl int64) *expiry {
 	return &expiry{key: k, ttl: ttl}
 }
 
+func newFieldExpiry(k string, f string, ttl int64) *expiry {
+	return &expiry{key: k, field: f, ttl: ttl}
+}
+
 // A expiryPriorityQueue implements heap.Interface and holds Items.
 type expiryPriorityQueue []*expiry
 
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"runtime/metrics"
+)
+
+// Once the memory used by the server exceeds maxmemory, keys are evicted
+// before write commands are executed according to maxmemory-policy:
+//
+//	noeviction       nothing is evicted, write commands are rejected
+//	allkeys-lru      the least recently used keys are evicted
+//	volatile-lru     the least recently used keys with an expiry are evicted
+//	allkeys-random   random keys are evicted
+//	volatile-random  random keys with an expiry are evicted
+//	volatile-ttl     keys with the nearest expiry are evicted
+//
+// Like in Redis the policies are approximated: maxmemory-samples random
+// keys of every database are sampled and the best candidate is evicted.
+const (
+	evictNoEviction = iota
+	evictAllKeysLRU
+	evictVolatileLRU
+	evictAllKeysRandom
+	evictVolatileRandom
+	evictVolatileTTL
+)
+
+var maxmemoryPolicies = map[string]int{
+	"noeviction":      evictNoEviction,
+	"allkeys-lru":     evictAllKeysLRU,
+	"volatile-lru":    evictVolatileLRU,
+	"allkeys-random":  evictAllKeysRandom,
+	"volatile-random": evictVolatileRandom,
+	"volatile-ttl":    evictVolatileTTL,
+}
+
+// ErrOOM rises when a write command can't be executed because
+// no keys could be evicted to get under maxmemory.
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+// Write commands which are allowed when the memory is over the limit,
+// as they only remove data.
+var oomAllowedCommands = map[string]bool{
+	"del":      true,
+	"unlink":   true,
+	"getdel":   true,
+	"lpop":     true,
+	"rpop":     true,
+	"hdel":     true,
+	"zrem":     true,
+	"xdel":     true,
+	"xtrim":    true,
+	"json.del": true,
+	"cf.del":   true,
+	"flushdb":  true,
+	"flushall": true,
+}
+
+var (
+	memoryMetrics = []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/gc/cycles/total:gc-cycles"},
+	}
+
+	// loading is set while data is restored from the cmdlog or the snapshot,
+	// nothing is evicted meanwhile.
+	loading bool
+
+	// Evicted values keep occupying the heap until the garbage collector
+	// runs, so their size is subtracted from the heap size meanwhile.
+	evictedPending int64
+	evictedCycle   uint64
+)
+
+// setMaxmemory changes the limit. The runtime is given no soft limit:
+// it also counts stacks and metadata of the garbage collector, so it would
+// be reached before the heap and keep the collector running back to back.
+// Eviction keeps the heap under the limit instead.
+func setMaxmemory(n int64) {
+	config.maxmemory = n
+}
+
+// usedMemory returns the number of bytes taken by the heap.
+func usedMemory() int64 {
+	metrics.Read(memoryMetrics)
+	used := int64(memoryMetrics[0].Value.Uint64())
+
+	if cycle := memoryMetrics[1].Value.Uint64(); cycle != evictedCycle {
+		evictedCycle = cycle
+		evictedPending = 0
+	}
+	return used - evictedPending
+}
+
+// freeMemoryIfNeeded evicts keys until the used memory gets under maxmemory.
+// It returns ErrOOM if it is not possible. The caller must hold execMutex.
+func freeMemoryIfNeeded() error {
+	if config.maxmemory == 0 || loading {
+		return nil
+	}
+	used := usedMemory()
+	if used <= config.maxmemory {
+		return nil
+	}
+	if config.maxmemoryPolicy == evictNoEviction {
+		return ErrOOM
+	}
+
+	var freed int64
+	for freed < used-config.maxmemory {
+		db, k := evictionCandidate()
+		if k == "" {
+			return ErrOOM
+		}
+
+		s := databases[db]
+		v, _ := s.unlink(k)
+		freed += int64(len(k)) + valueSize(v)
+		stats.evictedKeys++
+		notifyKeyspaceEvent(notifyEvicted, "evicted", k, db)
+		propagate(&request{cmd: "del", argv: []string{k}, argc: 1, db: db})
+	}
+	evictedPending += freed
+
+	return nil
+}
+
+// evictionCandidate samples keys of all databases and returns the best key
+// to evict according to the policy or an empty key if there is nothing to evict.
+func evictionCandidate() (int, string) {
+	policy := config.maxmemoryPolicy
+	volatile := policy == evictVolatileLRU || policy == evictVolatileRandom || policy == evictVolatileTTL
+
+	bestDB, bestKey := 0, ""
+	var bestScore int64
+	start := rand.Intn(len(databases))
+	for j := range databases {
+		i := (start + j) % len(databases)
+		s := databases[i]
+		for _, k := range s.sampleKeys(config.maxmemorySamples, volatile) {
+			e := s.entries[k]
+
+			var score int64
+			switch policy {
+			case evictAllKeysRandom, evictVolatileRandom:
+				return i, k
+			case evictAllKeysLRU, evictVolatileLRU:
+				score = e.atime
+			case evictVolatileTTL:
+				score = e.expiry.ttl
+			}
+
+			if bestKey == "" || score < bestScore {
+				bestDB, bestKey, bestScore = i, k, score
+			}
+		}
+	}
+	return bestDB, bestKey
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geospatial members are stored in a sorted set with a 52-bit geohash of
+// their position as a score. Longitude bits take odd positions of the hash
+// and latitude bits take even ones, so nearby points share prefixes of the
+// hash and every geohash cell is a contiguous range of scores.
+const (
+	geoStepMax     = 26 // 26*2 = 52 bits
+	geoLatMin      = -85.05112878
+	geoLatMax      = 85.05112878
+	geoLonMin      = -180.0
+	geoLonMax      = 180.0
+	geoEarthRadius = 6372797.560856 // in meters
+	geoMercatorMax = 20037726.37
+	geoDegreeToRad = math.Pi / 180
+)
+
+var (
+	// ErrGeoInvalidCoordinates ...
+	ErrGeoInvalidCoordinates = errors.New("ERR invalid longitude,latitude pair")
+	// ErrGeoUnsupportedUnit ...
+	ErrGeoUnsupportedUnit = errors.New("ERR unsupported unit provided. please use m, km, ft, mi")
+	// ErrGeoNoMember ...
+	ErrGeoNoMember = errors.New("ERR could not decode requested zset member")
+
+	geoUnits = map[string]float64{
+		"m":  1,
+		"km": 1000,
+		"ft": 0.3048,
+		"mi": 1609.34,
+	}
+)
+
+// geohashCell is a cell of the grid of the given step. Its hash has step*2 bits.
+type geohashCell struct {
+	hash uint64
+	step uint
+}
+
+func geoValid(lon float64, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+// interleave spreads bits of x to even positions and bits of y to odd ones.
+func interleave(x uint32, y uint32) uint64 {
+	spread := func(v uint32) uint64 {
+		u := uint64(v)
+		u = (u | u<<16) & 0x0000FFFF0000FFFF
+		u = (u | u<<8) & 0x00FF00FF00FF00FF
+		u = (u | u<<4) & 0x0F0F0F0F0F0F0F0F
+		u = (u | u<<2) & 0x3333333333333333
+		u = (u | u<<1) & 0x5555555555555555
+		return u
+	}
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave is the reverse of interleave.
+func deinterleave(h uint64) (uint32, uint32) {
+	squash := func(u uint64) uint32 {
+		u &= 0x5555555555555555
+		u = (u | u>>1) & 0x3333333333333333
+		u = (u | u>>2) & 0x0F0F0F0F0F0F0F0F
+		u = (u | u>>4) & 0x00FF00FF00FF00FF
+		u = (u | u>>8) & 0x0000FFFF0000FFFF
+		u = (u | u>>16) & 0x00000000FFFFFFFF
+		return uint32(u)
+	}
+	return squash(h), squash(h >> 1)
+}
+
+func geohashEncode(lon float64, lat float64, step uint) geohashCell {
+	latOffset := (lat - geoLatMin) / (geoLatMax - geoLatMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+	n := float64(uint64(1) << step)
+	latBits := uint32(math.Min(latOffset*n, n-1))
+	lonBits := uint32(math.Min(lonOffset*n, n-1))
+	return geohashCell{interleave(latBits, lonBits), step}
+}
+
+// bounds returns longitude and latitude ranges of the cell.
+func (c geohashCell) bounds() (lonMin, lonMax, latMin, latMax float64) {
+	latBits, lonBits := deinterleave(c.hash)
+	n := float64(uint64(1) << c.step)
+	latScale := (geoLatMax - geoLatMin) / n
+	lonScale := (geoLonMax - geoLonMin) / n
+	latMin = geoLatMin + float64(latBits)*latScale
+	lonMin = geoLonMin + float64(lonBits)*lonScale
+	return lonMin, lonMin + lonScale, latMin, latMin + latScale
+}
+
+// center returns the position of the center of the cell.
+func (c geohashCell) center() (float64, float64) {
+	lonMin, lonMax, latMin, latMax := c.bounds()
+	lon := math.Max(geoLonMin, math.Min(geoLonMax, (lonMin+lonMax)/2))
+	lat := math.Max(geoLatMin, math.Min(geoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+// scoreRange returns the range of 52-bit scores [min, max) within the cell.
+func (c geohashCell) scoreRange() (float64, float64) {
+	shift := 2 * (geoStepMax - c.step)
+	return float64(c.hash << shift), float64((c.hash + 1) << shift)
+}
+
+func geoDecodeScore(score float64) (float64, float64) {
+	return geohashCell{uint64(score), geoStepMax}.center()
+}
+
+// geoDistance returns the distance in meters between two points
+// using the haversine formula.
+func geoDistance(lon1 float64, lat1 float64, lon2 float64, lat2 float64) float64 {
+	lat1r, lat2r := lat1*geoDegreeToRad, lat2*geoDegreeToRad
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * geoDegreeToRad / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoEstimateStep returns the step of cells big enough to cover the radius
+// (in meters) around a point with the given latitude by the cell and
+// its neighbours.
+func geoEstimateStep(radius float64, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for radius < geoMercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+
+	// cells are narrower near the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+// geoShape is an area of GEOSEARCH: a circle or a box around a center.
+type geoShape struct {
+	lon, lat      float64
+	radius        float64 // in meters, for a circle
+	width, height float64 // in meters, for a box
+	byBox         bool
+	unit          float64
+}
+
+// contains checks whether the point is within the shape and returns
+// its distance from the center.
+func (sh *geoShape) contains(lon float64, lat float64) (float64, bool) {
+	d := geoDistance(sh.lon, sh.lat, lon, lat)
+	if !sh.byBox {
+		return d, d <= sh.radius
+	}
+
+	// distances along the meridian and along the parallel of the center
+	dy := geoDistance(sh.lon, sh.lat, sh.lon, lat)
+	dx := geoDistance(sh.lon, lat, lon, lat)
+	return d, dy <= sh.height/2 && dx <= sh.width/2
+}
+
+// cells returns the cell containing the center of the shape and its
+// neighbours, which together cover the whole shape.
+func (sh *geoShape) cells() []geohashCell {
+	radius := sh.radius
+	if sh.byBox {
+		radius = math.Sqrt(sh.width*sh.width+sh.height*sh.height) / 2
+	}
+
+	// degrees covered by the radius along the meridian and along the parallel
+	// closest to the pole, where the shape is the widest in degrees
+	dlat := radius / geoEarthRadius / geoDegreeToRad
+	dlon := 360.0
+	if c := math.Cos(math.Min(90, math.Abs(sh.lat)+dlat) * geoDegreeToRad); c > 0 {
+		dlon = math.Min(360, dlat/c)
+	}
+
+	step := geoEstimateStep(radius, sh.lat)
+	for step > 1 {
+		lonMin, lonMax, latMin, latMax := geohashEncode(sh.lon, sh.lat, step).bounds()
+		if latMax-latMin >= dlat && lonMax-lonMin >= dlon {
+			break
+		}
+		step--
+	}
+
+	center := geohashEncode(sh.lon, sh.lat, step)
+	lonMin, lonMax, latMin, latMax := center.bounds()
+	clon, clat := (lonMin+lonMax)/2, (latMin+latMax)/2
+	w, h := lonMax-lonMin, latMax-latMin
+
+	seen := make(map[geohashCell]bool, 9)
+	cells := make([]geohashCell, 0, 9)
+	for _, dy := range []float64{0, -1, 1} {
+		for _, dx := range []float64{0, -1, 1} {
+			lat := clat + dy*h
+			if lat < geoLatMin || lat > geoLatMax {
+				continue
+			}
+			lon := clon + dx*w
+			if lon < geoLonMin {
+				lon += 360
+			} else if lon > geoLonMax {
+				lon -= 360
+			}
+			c := geohashEncode(lon, lat, step)
+			if !seen[c] {
+				seen[c] = true
+				cells = append(cells, c)
+			}
+		}
+	}
+	return cells
+}
+
+type geoPoint struct {
+	member   string
+	lon, lat float64
+	dist     float64
+	score    float64
+}
+
+// search returns members of the sorted set within the shape.
+func (sh *geoShape) search(z *sortedSet, count int, any bool) []geoPoint {
+	var points []geoPoint
+	for _, c := range sh.cells() {
+		min, max := c.scoreRange()
+		for _, m := range z.rangeByScore(min, max) {
+			lon, lat := geoDecodeScore(m.score)
+			if d, ok := sh.contains(lon, lat); ok {
+				points = append(points, geoPoint{m.member, lon, lat, d, m.score})
+				if any && count > 0 && len(points) >= count {
+					return points
+				}
+			}
+		}
+	}
+	return points
+}
+
+func parseLonLat(lonv string, latv string) (float64, float64, error) {
+	lon, lerr := strconv.ParseFloat(lonv, 64)
+	lat, aerr := strconv.ParseFloat(latv, 64)
+	if lerr != nil || aerr != nil {
+		return 0, 0, ErrNotFloat
+	}
+	if !geoValid(lon, lat) {
+		return 0, 0, ErrGeoInvalidCoordinates
+	}
+	return lon, lat, nil
+}
+
+func parseGeoUnit(v string) (float64, error) {
+	if u, ok := geoUnits[strings.ToLower(v)]; ok {
+		return u, nil
+	}
+	return 0, ErrGeoUnsupportedUnit
+}
+
+func parseDistance(v string, unit string) (float64, float64, error) {
+	d, err := strconv.ParseFloat(v, 64)
+	if err != nil || d < 0 || math.IsNaN(d) {
+		return 0, 0, ErrBadArguments
+	}
+	u, err := parseGeoUnit(unit)
+	if err != nil {
+		return 0, 0, err
+	}
+	return d * u, u, nil
+}
+
+func formatDistance(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
+// GEOADD key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]
+// Return value is the number of added members or, with CH option,
+// the number of added and updated members.
+func geoaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	var nx, xx, ch bool
+	i := 1
+	for ; i < r.argc; i++ {
+		opt := strings.ToLower(r.argv[i])
+		if opt == "nx" {
+			nx = true
+		} else if opt == "xx" {
+			xx = true
+		} else if opt == "ch" {
+			ch = true
+		} else {
+			break
+		}
+	}
+	if (nx && xx) || i == r.argc || (r.argc-i)%3 != 0 {
+		return nil, ErrBadArguments
+	}
+
+	scores := make([]float64, 0, (r.argc-i)/3)
+	for j := i; j < r.argc; j += 3 {
+		lon, lat, err := parseLonLat(r.argv[j], r.argv[j+1])
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, float64(geohashEncode(lon, lat, geoStepMax).hash))
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		if xx {
+			return 0, nil
+		}
+		z = newSortedSet()
+		s.set(r.argv[0], z)
+	}
+
+	changed := 0
+	for j, score := range scores {
+		member := r.argv[i+j*3+2]
+		old, exists := z.score(member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if z.add(member, score) || (ch && old != score) {
+			changed++
+		}
+	}
+	if z.len() == 0 {
+		s.del(r.argv[0])
+		return changed, nil
+	}
+	notifyKeyspaceEvent(notifyZset, "zadd", r.argv[0], r.db)
+
+	return changed, nil
+}
+
+// GEODIST key member1 member2 [M | KM | FT | MI]
+// Return value is the distance as a string with 4 decimal places
+// or nil if any of the members does not exist.
+func geodistCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 && r.argc != 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	unit := 1.0
+	if r.argc == 4 {
+		var err error
+		if unit, err = parseGeoUnit(r.argv[3]); err != nil {
+			return nil, err
+		}
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil || z == nil {
+		return nil, err
+	}
+
+	s1, ok1 := z.score(r.argv[1])
+	s2, ok2 := z.score(r.argv[2])
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+
+	lon1, lat1 := geoDecodeScore(s1)
+	lon2, lat2 := geoDecodeScore(s2)
+	return formatDistance(geoDistance(lon1, lat1, lon2, lat2) / unit), nil
+}
+
+// GEOPOS key [member [member ...]]
+// Return value is a list of [longitude, latitude] pairs, nil for missing members.
+func geoposCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]interface{}, r.argc-1)
+	if z == nil {
+		return res, nil
+	}
+	for i, m := range r.argv[1:] {
+		if score, ok := z.score(m); ok {
+			lon, lat := geoDecodeScore(score)
+			res[i] = [2]float64{lon, lat}
+		}
+	}
+
+	return res, nil
+}
+
+type geoSearchReply struct {
+	Name  string      `json:"name"`
+	Dist  string      `json:"dist,omitempty"`
+	Hash  int64       `json:"hash,omitempty"`
+	Coord *[2]float64 `json:"coord,omitempty"`
+}
+
+// GEOSEARCH key <FROMMEMBER member | FROMLONLAT longitude latitude>
+// <BYRADIUS radius <M | KM | FT | MI> | BYBOX width height <M | KM | FT | MI>>
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+// Return value is a list of members within the area sorted by distance
+// from the center (nearest first unless DESC is given). With COUNT ANY
+// the search stops as soon as enough members are found.
+func geosearchCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	sh := &geoShape{}
+	var from, member string
+	var by bool
+	var desc, any, withcoord, withdist, withhash bool
+	count := 0
+
+	for i := 1; i < r.argc; i++ {
+		opt := strings.ToLower(r.argv[i])
+		left := r.argc - i - 1
+		var err error
+		switch {
+		case opt == "frommember" && left >= 1 && from == "":
+			from, member = opt, r.argv[i+1]
+			i++
+		case opt == "fromlonlat" && left >= 2 && from == "":
+			from = opt
+			if sh.lon, sh.lat, err = parseLonLat(r.argv[i+1], r.argv[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		case opt == "byradius" && left >= 2 && !by:
+			by = true
+			if sh.radius, sh.unit, err = parseDistance(r.argv[i+1], r.argv[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		case opt == "bybox" && left >= 3 && !by:
+			by, sh.byBox = true, true
+			if sh.width, sh.unit, err = parseDistance(r.argv[i+1], r.argv[i+3]); err != nil {
+				return nil, err
+			}
+			if sh.height, _, err = parseDistance(r.argv[i+2], r.argv[i+3]); err != nil {
+				return nil, err
+			}
+			i += 3
+		case opt == "asc":
+			desc = false
+		case opt == "desc":
+			desc = true
+		case opt == "count" && left >= 1:
+			if count, err = strconv.Atoi(r.argv[i+1]); err != nil || count < 1 {
+				return nil, ErrBadArguments
+			}
+			i++
+			if i+1 < r.argc && strings.ToLower(r.argv[i+1]) == "any" {
+				any = true
+				i++
+			}
+		case opt == "withcoord":
+			withcoord = true
+		case opt == "withdist":
+			withdist = true
+		case opt == "withhash":
+			withhash = true
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+	if from == "" || !by {
+		return nil, ErrBadArguments
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		return []string{}, nil
+	}
+
+	if from == "frommember" {
+		score, ok := z.score(member)
+		if !ok {
+			return nil, ErrGeoNoMember
+		}
+		sh.lon, sh.lat = geoDecodeScore(score)
+	}
+
+	points := sh.search(z, count, any)
+	sort.Slice(points, func(i, j int) bool {
+		if desc {
+			return points[i].dist > points[j].dist
+		}
+		return points[i].dist < points[j].dist
+	})
+	if count > 0 && len(points) > count {
+		points = points[:count]
+	}
+
+	if !withcoord && !withdist && !withhash {
+		res := make([]string, len(points))
+		for i, p := range points {
+			res[i] = p.member
+		}
+		return res, nil
+	}
+
+	res := make([]geoSearchReply, len(points))
+	for i, p := range points {
+		res[i].Name = p.member
+		if withdist {
+			res[i].Dist = formatDistance(p.dist / sh.unit)
+		}
+		if withhash {
+			res[i].Hash = int64(p.score)
+		}
+		if withcoord {
+			res[i].Coord = &[2]float64{p.lon, p.lat}
+		}
+	}
+
+	return res, nil
+}
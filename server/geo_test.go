@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestGeohashPrecision(t *testing.T) {
+	tests := [][2]float64{
+		{0, 0},
+		{13.361389, 38.115556},
+		{-122.419416, 37.774929},
+		{151.209296, -33.868820},
+		{-180, -85.05112878},
+		{180, 85.05112878},
+	}
+
+	t.Log("Given positions encoded into 52-bit geohashes")
+
+	for i, p := range tests {
+		t.Logf("\tTest: %d\tWhen decoding the geohash of %v", i, p)
+
+		h := geohashEncode(p[0], p[1], geoStepMax)
+		lon, lat := geoDecodeScore(float64(h.hash))
+		if d := geoDistance(p[0], p[1], lon, lat); d < 0.5 {
+			t.Logf("\t%s\tShould get the position within 0.5 m, got %.3f m", succeed, d)
+		} else {
+			t.Errorf("\t%s\tShould get the position within 0.5 m, got %.3f m", failed, d)
+		}
+	}
+}
+
+func TestGeoCommands(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+	}{
+		{"geoadd", []string{"sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}, "2"},
+		{"zscore", []string{"sicily", "Palermo"}, `"3479099956230698"`},
+		{"geodist", []string{"sicily", "Palermo", "Catania"}, `"166274.1516"`},
+		{"geodist", []string{"sicily", "Palermo", "Catania", "km"}, `"166.2742"`},
+		{"geodist", []string{"sicily", "Palermo", "Catania", "mi"}, `"103.3182"`},
+		{"geodist", []string{"sicily", "Palermo", "Rome"}, "null"},
+		{"geosearch", []string{"sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST"},
+			`[{"name":"Catania","dist":"56.4413"},{"name":"Palermo","dist":"190.4424"}]`},
+		{"geosearch", []string{"sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"}, `["Catania"]`},
+		{"geosearch", []string{"sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "170", "km", "DESC"}, `["Catania","Palermo"]`},
+		{"geosearch", []string{"sicily", "FROMLONLAT", "15", "37", "BYBOX", "300", "300", "km", "ASC"}, `["Catania","Palermo"]`},
+		{"geosearch", []string{"sicily", "FROMLONLAT", "15", "37", "BYBOX", "200", "300", "km", "ASC"}, `["Catania"]`},
+		{"geosearch", []string{"sicily", "FROMLONLAT", "15", "37", "BYBOX", "300", "200", "km", "ASC"}, `["Catania"]`},
+		{"geoadd", []string{"sicily", "181", "0", "Nowhere"}, "null"},
+	}
+
+	t.Log("Given geospatial commands on known positions")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %v", i, tt.cmd, tt.argv)
+
+		res, _ := execute(s, tt.cmd, tt.argv...)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want {
+			t.Logf("\t%s\tShould get %s", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %s, got %s", failed, tt.want, got)
+		}
+	}
+}
+
+func TestGeoSearchRadius(t *testing.T) {
+	centers := [][2]float64{{0, 0}, {13.4, 52.5}, {179.9, -40}, {-70, 75}}
+	radii := []float64{500, 20000, 300000}
+
+	t.Log("Given members placed around a center")
+
+	for i, c := range centers {
+		for _, radius := range radii {
+			t.Logf("\tTest: %d\tWhen searching within %.0f m of %v", i, radius, c)
+
+			s := newStorage()
+			var want []string
+			args := []string{"points"}
+			for j := 0; j < 400; j++ {
+				// points up to twice the radius away in all directions
+				angle := float64(j) * 2 * math.Pi / 37
+				d := radius * 2 * float64(j) / 400 / geoEarthRadius / geoDegreeToRad
+				lat := c[1] + d*math.Sin(angle)
+				lon := c[0] + d*math.Cos(angle)/math.Cos(c[1]*geoDegreeToRad)
+				if lon > 180 {
+					lon -= 360
+				}
+				name := strconv.Itoa(j)
+				args = append(args, fmt.Sprint(lon), fmt.Sprint(lat), name)
+
+				plon, plat := geoDecodeScore(float64(geohashEncode(lon, lat, geoStepMax).hash))
+				if geoDistance(c[0], c[1], plon, plat) <= radius {
+					want = append(want, name)
+				}
+			}
+			if _, err := execute(s, "geoadd", args...); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := execute(s, "geosearch", "points", "FROMLONLAT", fmt.Sprint(c[0]), fmt.Sprint(c[1]),
+				"BYRADIUS", fmt.Sprint(radius), "m")
+			got, _ := res.([]string)
+			sort.Strings(got)
+			sort.Strings(want)
+			if err == nil && fmt.Sprint(got) == fmt.Sprint(want) {
+				t.Logf("\t%s\tShould find all %d members within the radius", succeed, len(want))
+			} else {
+				t.Errorf("\t%s\tShould find %d members within the radius, got %d, %v", failed, len(want), len(got), err)
+			}
+		}
+	}
+}
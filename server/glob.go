@@ -0,0 +1,109 @@
+package main
+
+// globMatch reports whether the string matches the glob pattern. It follows
+// Redis semantics and works on bytes: "*" matches any sequence of bytes
+// (including an empty one), "?" matches any single byte, "[abc]" matches
+// one of the bytes in brackets, "[a-z]" matches a byte in the range (bounds
+// may go in any order), "[^a]" matches a byte not in brackets and "\x"
+// matches x literally, also inside brackets. An unclosed bracket is closed
+// at the end of the pattern.
+func globMatch(pattern string, s string) bool {
+	p, i := 0, 0
+	// where to resume after the last star: the pattern after it
+	// and the position in s it currently swallows up to
+	star, starI := -1, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				star, starI = p, i
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if next, ok := globClass(pattern, p+1, s[i]); ok {
+					p = next
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == s[i] {
+						p += 2
+						i++
+						continue
+					}
+					break
+				}
+				fallthrough
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+
+		// mismatch: let the last star swallow one more byte
+		if star < 0 {
+			return false
+		}
+		starI++
+		p, i = star, starI
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// globClass matches the byte against the bracket expression starting at
+// pattern[p] (right after "["). It returns the position after the closing
+// bracket and whether the byte matched.
+func globClass(pattern string, p int, c byte) (int, bool) {
+	not := p < len(pattern) && pattern[p] == '^'
+	if not {
+		p++
+	}
+
+	match := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				match = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				match = true
+			}
+		}
+		p++
+	}
+	if p < len(pattern) {
+		p++ // skip "]"
+	}
+
+	return p, match != not
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"*", "with/slash", true},
+		{"a*", "abc", true},
+		{"a*", "bac", false},
+		{"*c", "abc", true},
+		{"a*c", "ac", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"a**b", "ab", true},
+		{"*a*b*", "xaybz", true},
+		{"*a*b*", "xbya", false},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"???", "abc", true},
+		{"???", "ab", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true},
+		{"[0-9][0-9]", "42", true},
+		{"[0-9][0-9]", "4x", false},
+		{"[a-]", "-", true},
+		{"[]", "a", false},
+		{"[abc", "b", true},
+		{"[abc", "d", false},
+		{"[\\]]", "]", true},
+		{"[\\-]", "-", true},
+		{"[a\\-z]", "b", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"\\?", "?", true},
+		{"\\[a]", "[a]", true},
+		{"a\\", "a\\", true},
+		{"*\\*", "abc*", true},
+		{"*\\*", "abc", false},
+		{"*[0-9]", "key7", true},
+		{"*[0-9]", "key", false},
+		{"a*b?c", "axxbyc", true},
+		{"a*b?c", "axxbc", false},
+		{"*.json", "data.tar.json", true},
+	}
+
+	t.Log("Given glob patterns which should be matched against strings")
+
+	for i, tt := range tests {
+		tf := func(t *testing.T) {
+			t.Logf("\tTest: %d\tWhen matching %q against %q", i, tt.s, tt.pattern)
+
+			if got := globMatch(tt.pattern, tt.s); got == tt.want {
+				t.Logf("\t%s\tShould return %v", succeed, tt.want)
+			} else {
+				t.Errorf("\t%s\tShould return %v", failed, tt.want)
+			}
+		}
+
+		t.Run(tt.pattern, tf)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math/bits"
+)
+
+// keyIndex spreads keys of the storage over a power of two number of buckets
+// so the keyspace can be scanned incrementally with a cursor.
+//
+// The cursor is a bucket number incremented in reverse binary order, i.e.
+// starting from the highest bit of the mask. When the table grows, every
+// bucket splits into buckets sharing its low bits, and when it shrinks,
+// buckets sharing low bits merge, so buckets visited with either mask have
+// the same reversed prefixes as the cursor. Thus a scan returns every key
+// which is present during the whole scan although it may return a key
+// more than once if the table shrinks.
+const keyIndexMinSize = 16
+
+type keyIndex struct {
+	buckets []map[string]struct{}
+	size    int
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{buckets: make([]map[string]struct{}, keyIndexMinSize)}
+}
+
+// keyHash is the 64-bit FNV-1a hash.
+func keyHash(k string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(k); i++ {
+		h ^= uint64(k[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (x *keyIndex) mask() uint64 {
+	return uint64(len(x.buckets) - 1)
+}
+
+func (x *keyIndex) add(k string) {
+	i := keyHash(k) & x.mask()
+	if x.buckets[i] == nil {
+		x.buckets[i] = make(map[string]struct{}, 1)
+	}
+	if _, ok := x.buckets[i][k]; ok {
+		return
+	}
+	x.buckets[i][k] = struct{}{}
+	x.size++
+
+	if x.size > len(x.buckets) {
+		x.resize(len(x.buckets) * 2)
+	}
+}
+
+func (x *keyIndex) remove(k string) {
+	i := keyHash(k) & x.mask()
+	if _, ok := x.buckets[i][k]; !ok {
+		return
+	}
+	delete(x.buckets[i], k)
+	if len(x.buckets[i]) == 0 {
+		x.buckets[i] = nil
+	}
+	x.size--
+
+	if len(x.buckets) > keyIndexMinSize && x.size < len(x.buckets)/8 {
+		x.resize(len(x.buckets) / 2)
+	}
+}
+
+func (x *keyIndex) resize(n int) {
+	old := x.buckets
+	x.buckets = make([]map[string]struct{}, n)
+	mask := x.mask()
+	for _, b := range old {
+		for k := range b {
+			i := keyHash(k) & mask
+			if x.buckets[i] == nil {
+				x.buckets[i] = make(map[string]struct{}, 1)
+			}
+			x.buckets[i][k] = struct{}{}
+		}
+	}
+}
+
+// scan calls fn for every key of the bucket pointed by the cursor and
+// returns the next cursor, which is 0 once the whole table was visited.
+func (x *keyIndex) scan(cursor uint64, fn func(k string)) uint64 {
+	m := x.mask()
+	for k := range x.buckets[cursor&m] {
+		fn(k)
+	}
+
+	// increment the reversed cursor
+	cursor |= ^m
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
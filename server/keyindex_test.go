@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyIndexScan(t *testing.T) {
+	t.Log("Given a key index which is resized during a scan")
+	{
+		x := newKeyIndex()
+		for i := 0; i < 1000; i++ {
+			x.add("stable:" + strconv.Itoa(i))
+		}
+		for i := 0; i < 3000; i++ {
+			x.add("temp:" + strconv.Itoa(i))
+		}
+
+		seen := make(map[string]bool)
+		visit := func(k string) { seen[k] = true }
+
+		var cursor uint64
+		steps := 0
+		for {
+			cursor = x.scan(cursor, visit)
+			steps++
+
+			switch steps {
+			case 10:
+				// shrink the table several times
+				for i := 0; i < 3000; i++ {
+					x.remove("temp:" + strconv.Itoa(i))
+				}
+			case 20:
+				// grow it again
+				for i := 0; i < 5000; i++ {
+					x.add("more:" + strconv.Itoa(i))
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+
+		t.Log("\tWhen the table shrinks and grows between steps")
+		{
+			missing := 0
+			for i := 0; i < 1000; i++ {
+				if !seen["stable:"+strconv.Itoa(i)] {
+					missing++
+				}
+			}
+
+			if missing == 0 {
+				t.Logf("\t%s\tShould return every key present during the whole scan", succeed)
+			} else {
+				t.Errorf("\t%s\tShould return every key present during the whole scan, %d missing", failed, missing)
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Messages are logged if their level is not below the loglevel parameter:
+//
+//	verbose  connections being opened and closed
+//	notice   start up, shutdown and persistence
+//	warning  errors
+const (
+	logVerbose = iota
+	logNotice
+	logWarning
+)
+
+var logLevels = map[string]int{
+	"verbose": logVerbose,
+	"notice":  logNotice,
+	"warning": logWarning,
+}
+
+func logf(level int, format string, v ...interface{}) {
+	if int32(level) >= atomic.LoadInt32(&config.loglevel) {
+		log.Printf(format, v...)
+	}
+}
@@ -2,92 +2,235 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"flag"
-	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	redislike "github.com/bannerlog/redislike/protocol"
 )
 
-var flagServerAddress string
-var flagCmdlogFilename string
-
 func init() {
 	// runtime.GOMAXPROCS(1)
-	flag.StringVar(&flagServerAddress, "addr", ":9000", "Start server on host:port")
-	flag.StringVar(&flagCmdlogFilename, "cmdlog", "", "Path to command log file")
 }
 
 func main() {
 	flag.Parse()
+	if err := loadConfig(); err != nil {
+		log.Fatalln(err)
+	}
 
-	var gracefulStop = make(chan os.Signal)
+	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-gracefulStop
-		fmt.Printf("caught sig: %+v\nShutting down server...\n", sig)
-		// Here we can do something usefuly (i.e. gracefuly close all connections
-		// and dump strorage to disk).
-		os.Exit(0)
-	}()
+
+	if config.aclfile != "" {
+		if err := loadACL(config.aclfile); err != nil {
+			log.Fatalln(err)
+		}
+	}
 
 	// storage
-	s := newStorage()
-	go runExpireMonitor(s)
+	databases = newDatabases(config.databases)
+	go runExpireMonitor()
+	go runClientsCron()
+	go runStatsCron()
 
 	// cmdlog
-	if flagCmdlogFilename != "" {
-		l := newCmdlog(flagCmdlogFilename)
-		l.run(s)
+	var l *cmdlog
+	if config.cmdlog != "" {
+		l = newCmdlog(config.cmdlog)
+		l.run()
+	} else if config.snapshot != "" {
+		loadSnapshot(config.snapshot)
 	}
 
-	// tcp listner
-	li, err := net.Listen("tcp", flagServerAddress)
-	if err != nil {
-		log.Fatalln(err)
+	// listeners
+	listeners := make([]net.Listener, 0, len(config.addrs)+len(config.tlsAddrs)+1)
+	for _, addr := range config.addrs {
+		li, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		logf(logNotice, "Server is running on %s\n", addr)
+		listeners = append(listeners, li)
+	}
+	for _, addr := range config.tlsAddrs {
+		li, err := listenTLS(addr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		logf(logNotice, "Server is running on %s with TLS\n", addr)
+		listeners = append(listeners, li)
+	}
+	if len(config.tlsAddrs) > 0 {
+		go runTLSReloader()
+	}
+	if config.unixsocket != "" {
+		li, err := listenUnix(config.unixsocket, config.unixsocketPerm)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		logf(logNotice, "Server is running on unix socket %s\n", config.unixsocket)
+		listeners = append(listeners, li)
 	}
-	defer li.Close()
-	log.Printf("Server is running on %s\n", flagServerAddress)
-	log.Println("Ready to accept connections")
+	var metricsListener net.Listener
+	if config.metricsAddr != "" {
+		li, err := net.Listen("tcp", config.metricsAddr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		logf(logNotice, "Metrics are served on http://%s/metrics\n", config.metricsAddr)
+		metricsListener = li
+		go serveMetrics(li)
+	}
+	logf(logNotice, "Ready to accept connections\n")
 
+	go func() {
+		sig := <-gracefulStop
+		logf(logNotice, "Caught %v, shutting down server...\n", sig)
+		for _, li := range listeners {
+			li.Close()
+		}
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, li := range listeners {
+		wg.Add(1)
+		go func(li net.Listener) {
+			defer wg.Done()
+			serve(li)
+		}(li)
+	}
+	wg.Wait()
+
+	execMutex.Lock()
+	timeout := config.shutdownTimeout
+	execMutex.Unlock()
+
+	shutdown(l, timeout)
+	logf(logNotice, "Server is now ready to exit\n")
+}
+
+// serve accepts connections until the listener is closed.
+func serve(li net.Listener) {
 	for {
 		conn, err := li.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Fatalln(err)
 		}
 
-		go handleConnection(s, conn)
+		c := &client{conn: conn}
+		if !addClient(c) {
+			go rejectClient(conn)
+			continue
+		}
+		go handleConnection(c)
 	}
 }
 
-func handleConnection(s *storage, conn net.Conn) {
-	log.Printf("Open connection from %s\n", conn.RemoteAddr())
+// A client holds the state of a connection.
+type client struct {
+	conn   net.Conn
+	wmutex sync.Mutex // serializes writes of responses and pushed messages
+	db     int        // selected database
+
+	id         int64
+	name       string
+	ctime      int64  // when the connection was opened, unix time in milliseconds
+	lastActive int64  // when the last command started or finished
+	lastCmd    string // name of the last command
+	inCommand  bool   // the command is being executed, it may be blocked
+	closed     bool   // the connection was closed by the server, guarded by execMutex
+	qbuf       int64  // bytes of requests read but not executed yet, accessed atomically
+
+	rd     *bufio.Reader // reads requests, nil for clients without a connection
+	hangup chan struct{} // closed once the connection is no longer watched for hangups
+
+	user          *aclUser // nil until the first command
+	authenticated bool
+	certName      string // common name of the verified TLS certificate
+
+	monitor  bool                     // the client issued MONITOR
+	channels map[string]struct{}      // subscribed pub/sub channels
+	patterns map[string]struct{}      // subscribed pub/sub patterns
+	pushes   chan *redislike.Response // messages waiting to be pushed
+}
+
+// addr returns the address of the client. Clients connected via a Unix socket
+// have no address of their own, so they are told apart by the path
+// of the socket followed by the client id.
+func (c *client) addr() string {
+	if c.conn.LocalAddr().Network() == "unix" {
+		return "unix:" + c.conn.LocalAddr().String() + ":" + strconv.FormatInt(c.id, 10)
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *client) write(resp *redislike.Response) error {
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+
+	return resp.Write(c.conn)
+}
+
+func handleConnection(c *client) {
+	conn := c.conn
+	logf(logVerbose, "Open connection from %s\n", c.addr())
 	defer func() {
+		c.unsubscribeAll()
 		conn.Close()
-		log.Printf("Close connection from %s\n", conn.RemoteAddr())
+		removeClient(c)
+		logf(logVerbose, "Close connection from %s\n", c.addr())
 	}()
 
+	if tc, ok := conn.(*tls.Conn); ok {
+		name, err := tlsHandshake(tc)
+		if err != nil {
+			logf(logVerbose, "TLS handshake with %s failed: %v\n", c.addr(), err)
+			return
+		}
+		c.certName = name
+	}
+
+	rd := bufio.NewReader(conn)
+	c.rd = rd
 	for {
 		// request part
-		req, err := redislike.ReadRequest(bufio.NewReader(conn))
+		req, err := redislike.ReadRequest(rd)
+		atomic.StoreInt64(&c.qbuf, int64(rd.Buffered()))
 		if err != nil {
-			if err != io.EOF {
-				log.Println(err)
+			// the deadline is set on shutdown, connections of deleted
+			// ACL users are closed
+			if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, net.ErrClosed) {
+				logf(logWarning, "%v\n", err)
 			}
 			return
 		}
 
-		r, err := executeCmd(s, &request{
-			cmd:  req.Command,
-			argv: req.Args,
-			argc: len(req.Args),
+		r, err := executeCmd(&request{
+			cmd:    req.Command,
+			argv:   req.Args,
+			argc:   len(req.Args),
+			client: c,
 		})
+		if c.hangup != nil {
+			c.unwatchHangup()
+		}
 
 		// response part
 		var resp *redislike.Response
@@ -97,20 +240,24 @@ func handleConnection(s *storage, conn net.Conn) {
 			resp, err = redislike.NewOkResponse(r)
 		}
 		if err != nil {
-			log.Println(err)
+			logf(logWarning, "%v\n", err)
 			return
 		}
 
-		resp.Write(conn)
+		c.write(resp)
 	}
 }
 
-func runExpireMonitor(s *storage) {
+func runExpireMonitor() {
 	ticker := time.NewTicker(5 * time.Second)
 	for {
 		select {
 		case <-ticker.C:
-			s.removeExpired()
+			execMutex.Lock()
+			for _, s := range databases {
+				s.removeExpired()
+			}
+			execMutex.Unlock()
 		}
 	}
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics of the server are exposed in the Prometheus text format on
+// /metrics of the HTTP listener started on the metrics-addr address. The
+// listener has no authentication, so it should only be reachable by the
+// scraper. Counters and histograms are copied under execMutex, keys are
+// counted by type while only the database being counted is locked.
+
+// labelEscaper escapes label values, the text format allows no other escapes.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+// header writes HELP and TYPE lines of the metric.
+func (m metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample of the metric, labels are pairs of names and values.
+func (m metricsWriter) sample(name string, v float64, labels ...string) {
+	m.w.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			m.w.WriteByte('{')
+		} else {
+			m.w.WriteByte(',')
+		}
+		m.w.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+		if i+2 >= len(labels) {
+			m.w.WriteByte('}')
+		}
+	}
+	m.w.WriteString(" " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+}
+
+// metric writes a metric with a single sample.
+func (m metricsWriter) metric(name, typ, help string, v float64) {
+	m.header(name, typ, help)
+	m.sample(name, v)
+}
+
+// metricsSnapshot holds values of the metrics guarded by execMutex.
+type metricsSnapshot struct {
+	commands []string
+	stats    []commandStats
+
+	keyspaceHits   int64
+	keyspaceMisses int64
+	expiredKeys    int64
+	evictedKeys    int64
+
+	usedMemory int64
+	maxmemory  int64
+
+	keys    []map[string]int // number of keys by type of every database
+	expires []int            // number of keys with an expiry of every database
+	cmdlog  *cmdlog
+}
+
+func takeMetricsSnapshot() *metricsSnapshot {
+	execMutex.Lock()
+	defer execMutex.Unlock()
+
+	ms := &metricsSnapshot{
+		keyspaceHits:   stats.keyspaceHits,
+		keyspaceMisses: stats.keyspaceMisses,
+		expiredKeys:    stats.expiredKeys,
+		evictedKeys:    stats.evictedKeys,
+		usedMemory:     usedMemory(),
+		maxmemory:      config.maxmemory,
+		cmdlog:         cmdlogger,
+	}
+	for _, s := range databases {
+		s.mutex.RLock()
+		types := make(map[string]int, len(s.types))
+		for t, n := range s.types {
+			types[t] = n
+		}
+		_, expires := s.len()
+		s.mutex.RUnlock()
+		ms.keys = append(ms.keys, types)
+		ms.expires = append(ms.expires, expires)
+	}
+	for name := range stats.commands {
+		ms.commands = append(ms.commands, name)
+	}
+	sort.Strings(ms.commands)
+	for _, name := range ms.commands {
+		ms.stats = append(ms.stats, *stats.commands[name])
+	}
+	return ms
+}
+
+// writeMetrics writes all the metrics.
+func writeMetrics(w *bufio.Writer) {
+	m := metricsWriter{w}
+	ms := takeMetricsSnapshot()
+
+	m.metric("redislike_uptime_seconds", "gauge", "Number of seconds since the server was started.",
+		time.Since(stats.startTime).Seconds())
+
+	// commands
+	m.header("redislike_commands_total", "counter", "Number of calls of the command.")
+	for i, name := range ms.commands {
+		m.sample("redislike_commands_total", float64(ms.stats[i].calls), "cmd", name)
+	}
+	m.header("redislike_commands_failed_total", "counter", "Number of calls of the command which returned an error.")
+	for i, name := range ms.commands {
+		m.sample("redislike_commands_failed_total", float64(ms.stats[i].failedCalls), "cmd", name)
+	}
+	m.header("redislike_command_duration_seconds", "histogram", "Execution time of the command.")
+	for i, name := range ms.commands {
+		cs := &ms.stats[i]
+		var n int64
+		for j, le := range latencyBuckets {
+			n += cs.buckets[j]
+			m.sample("redislike_command_duration_seconds_bucket", float64(n), "cmd", name, "le", strconv.FormatFloat(le.Seconds(), 'g', -1, 64))
+		}
+		m.sample("redislike_command_duration_seconds_bucket", float64(cs.calls), "cmd", name, "le", "+Inf")
+		m.sample("redislike_command_duration_seconds_sum", float64(cs.usec)/1e6, "cmd", name)
+		m.sample("redislike_command_duration_seconds_count", float64(cs.calls), "cmd", name)
+	}
+
+	// keyspace
+	m.header("redislike_keys", "gauge", "Number of keys in the database by type.")
+	for i, types := range ms.keys {
+		names := make([]string, 0, len(types))
+		for typ := range types {
+			names = append(names, typ)
+		}
+		sort.Strings(names)
+		for _, typ := range names {
+			m.sample("redislike_keys", float64(types[typ]), "db", strconv.Itoa(i), "type", typ)
+		}
+	}
+	m.header("redislike_keys_expiring", "gauge", "Number of keys with an expiry in the database.")
+	for i, n := range ms.expires {
+		m.sample("redislike_keys_expiring", float64(n), "db", strconv.Itoa(i))
+	}
+	m.metric("redislike_expired_keys_total", "counter", "Number of keys removed because they expired.", float64(ms.expiredKeys))
+	m.metric("redislike_evicted_keys_total", "counter", "Number of keys evicted because of maxmemory.", float64(ms.evictedKeys))
+	m.metric("redislike_keyspace_hits_total", "counter", "Number of keys found by read-only commands.", float64(ms.keyspaceHits))
+	m.metric("redislike_keyspace_misses_total", "counter", "Number of keys not found by read-only commands.", float64(ms.keyspaceMisses))
+
+	// connections
+	clientsMutex.Lock()
+	connected := len(clients)
+	received, rejected := stats.connectionsReceived, stats.rejectedConnections
+	clientsMutex.Unlock()
+	m.metric("redislike_connected_clients", "gauge", "Number of connected clients.", float64(connected))
+	m.metric("redislike_connections_received_total", "counter", "Number of accepted connections.", float64(received))
+	m.metric("redislike_rejected_connections_total", "counter", "Number of connections rejected because of maxclients.", float64(rejected))
+
+	// memory
+	m.metric("redislike_memory_used_bytes", "gauge", "Number of bytes taken by the heap.", float64(ms.usedMemory))
+	m.metric("redislike_maxmemory_bytes", "gauge", "Value of the maxmemory parameter, 0 if there is no limit.", float64(ms.maxmemory))
+
+	// cmdlog
+	if l := ms.cmdlog; l != nil {
+		var size int64
+		if fi, err := l.file.Stat(); err == nil {
+			size = fi.Size()
+		}
+		var lag int64
+		if since := atomic.LoadInt64(&l.dirtySince); since != 0 {
+			lag = mstime() - since
+		}
+		m.metric("redislike_cmdlog_size_bytes", "gauge", "Size of the cmdlog file.", float64(size))
+		m.metric("redislike_cmdlog_fsync_lag_seconds", "gauge", "Age of the oldest write of the cmdlog which is not synced to disk.", float64(lag)/1000)
+		m.metric("redislike_cmdlog_last_fsync_timestamp_seconds", "gauge", "Time of the last sync of the cmdlog.",
+			float64(atomic.LoadInt64(&l.lastSync))/1000)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeMetrics(bw)
+	bw.Flush()
+}
+
+// serveMetrics serves HTTP requests for metrics until the listener is closed.
+func serveMetrics(li net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	http.Serve(li, mux)
+}
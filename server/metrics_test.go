@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsSample(t *testing.T) {
+	tests := []struct {
+		v      float64
+		labels []string
+		want   string
+	}{
+		{1, nil, "m 1\n"},
+		{0.5, []string{"cmd", "get"}, `m{cmd="get"} 0.5` + "\n"},
+		{2e6, []string{"db", "0", "type", "string"}, `m{db="0",type="string"} 2e+06` + "\n"},
+		{3, []string{"l", `a"b\c` + "\n" + "d\té"}, `m{l="a\"b\\c\nd` + "\t" + `é"} 3` + "\n"},
+		{4, []string{"cmd"}, "m 4\n"},
+	}
+
+	t.Log("Given samples of the metric m")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen writing %v with labels %q", i, tt.v, tt.labels)
+
+		var b bytes.Buffer
+		w := bufio.NewWriter(&b)
+		metricsWriter{w}.sample("m", tt.v, tt.labels...)
+		w.Flush()
+		if got := b.String(); got == tt.want {
+			t.Logf("\t%s\tShould write %q", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould write %q, got %q", failed, tt.want, got)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	defer func(dbs []*storage, commands map[string]*commandStats) {
+		databases, stats.commands = dbs, commands
+	}(databases, stats.commands)
+	databases = newDatabases(2)
+	stats.commands = make(map[string]*commandStats)
+
+	execute(databases[0], "set", "a", "1")
+	execute(databases[0], "rpush", "l", "x")
+	execute(databases[1], "set", "b", "1")
+	execute(databases[1], "set", "c", "1")
+	databases[1].setExpire("c", mstime()+60000)
+	recordCommand("get", 2*time.Second, nil)
+	recordCommand("get", time.Microsecond, ErrBadArguments)
+
+	t.Log("Given keys in two databases and two calls of GET")
+	{
+		t.Log("\tWhen metrics are requested")
+		{
+			rec := httptest.NewRecorder()
+			metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+			body := rec.Body.String()
+
+			if ct := rec.Header().Get("Content-Type"); strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+				t.Logf("\t%s\tShould use the text format", succeed)
+			} else {
+				t.Errorf("\t%s\tShould use the text format, got %s", failed, ct)
+			}
+
+			for _, line := range []string{
+				"# TYPE redislike_commands_total counter",
+				`redislike_commands_total{cmd="get"} 2`,
+				`redislike_commands_failed_total{cmd="get"} 1`,
+				"# TYPE redislike_command_duration_seconds histogram",
+				`redislike_command_duration_seconds_bucket{cmd="get",le="+Inf"} 2`,
+				`redislike_command_duration_seconds_sum{cmd="get"} 2.000001`,
+				`redislike_command_duration_seconds_count{cmd="get"} 2`,
+				`redislike_keys{db="0",type="list"} 1`,
+				`redislike_keys{db="0",type="string"} 1`,
+				`redislike_keys{db="1",type="string"} 2`,
+				`redislike_keys_expiring{db="0"} 0`,
+				`redislike_keys_expiring{db="1"} 1`,
+			} {
+				if strings.Contains(body, line+"\n") {
+					t.Logf("\t%s\tShould write %s", succeed, line)
+				} else {
+					t.Errorf("\t%s\tShould write %s", failed, line)
+				}
+			}
+		}
+	}
+}
+
+// scanKeyTypes counts keys of the database by type walking all of them.
+func scanKeyTypes(s *storage) map[string]int {
+	types := make(map[string]int)
+	for _, e := range s.entries {
+		types[typeName(e.value)]++
+	}
+	return types
+}
+
+func TestKeyTypeCounts(t *testing.T) {
+	defer func(dbs []*storage) { databases = dbs }(databases)
+	databases = newDatabases(2)
+	s := databases[0]
+
+	tests := []struct {
+		name string
+		cmds [][]string
+	}{
+		{"keys are set", [][]string{{"set", "a", "1"}, {"rpush", "l", "x"}, {"hset", "h", "f", "v"}, {"zadd", "z", "1", "m"}}},
+		{"a key is overwritten by another type", [][]string{{"set", "l", "1"}}},
+		{"a key is overwritten keeping its TTL", [][]string{{"set", "h", "1", "KEEPTTL"}}},
+		{"a key is renamed over another one", [][]string{{"rpush", "l2", "x"}, {"rename", "l2", "a"}}},
+		{"a key is copied", [][]string{{"copy", "z", "z2"}, {"copy", "a", "z2", "REPLACE"}}},
+		{"a key is moved", [][]string{{"move", "z", "1"}}},
+		{"keys are deleted", [][]string{{"del", "a", "none"}, {"unlink", "z2"}}},
+		{"a key expires", [][]string{{"set", "e", "1", "PX", "1"}, {"sleep"}, {"get", "e"}}},
+		{"the last field of a hash expires", [][]string{{"hset", "h2", "f", "v"}, {"hpexpire", "h2", "1", "FIELDS", "1", "f"}, {"sleep"}, {"hget", "h2", "f"}}},
+		{"the database is flushed", [][]string{{"flushdb"}, {"set", "b", "1"}}},
+	}
+
+	t.Log("Given keys of several types")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen %s", i, tt.name)
+
+		for _, cmd := range tt.cmds {
+			if cmd[0] == "sleep" {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			if _, err := execute(s, cmd[0], cmd[1:]...); err != nil {
+				t.Fatalf("\t%s\tShould execute %v: %v", failed, cmd, err)
+			}
+		}
+		for db, d := range databases {
+			if want := scanKeyTypes(d); reflect.DeepEqual(d.types, want) {
+				t.Logf("\t%s\tShould count %v in the database %d", succeed, want, db)
+			} else {
+				t.Errorf("\t%s\tShould count %v in the database %d, got %v", failed, want, db, d.types)
+			}
+		}
+	}
+}
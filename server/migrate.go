@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+const migrateDefaultTimeout = time.Second
+
+// migrateConn sends requests to the target instance of MIGRATE.
+type migrateConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func dialMigrate(addr string, timeout time.Duration) (*migrateConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("IOERR error or timeout connecting to the client: %v", err)
+	}
+
+	return &migrateConn{conn, bufio.NewReader(conn), timeout}, nil
+}
+
+func (c *migrateConn) call(cmd string, args ...string) error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	req, err := redislike.NewRequest(cmd, args...)
+	if err != nil {
+		return err
+	}
+	if err := req.Write(c.conn); err != nil {
+		return fmt.Errorf("IOERR error or timeout writing to target instance: %v", err)
+	}
+
+	resp, err := redislike.ReadResponse(c.reader)
+	if err != nil {
+		return fmt.Errorf("IOERR error or timeout reading from target instance: %v", err)
+	}
+	if resp.IsErr() {
+		return fmt.Errorf("ERR Target instance replied with error: %s", strings.Join(resp.Values, " "))
+	}
+	return nil
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE]
+// [AUTH password | AUTH2 username password] [KEYS key [key ...]]
+// Transfers keys to another instance with RESTORE. Every key is removed
+// from this instance right after the target acknowledged it, unless COPY
+// is given. The timeout in milliseconds applies to every request to the target.
+// AUTH and AUTH2 authenticate on the target before keys are transferred.
+// Return value is "OK" or "NOKEY" if none of the keys exist.
+func migrateCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 5 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	if _, err := strconv.ParseUint(r.argv[1], 10, 16); err != nil {
+		return nil, ErrBadArguments
+	}
+	db, err := strconv.Atoi(r.argv[3])
+	if err != nil || db < 0 {
+		return nil, ErrBadArguments
+	}
+	ms, err := strconv.ParseInt(r.argv[4], 10, 64)
+	if err != nil {
+		return nil, ErrBadArguments
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	if timeout <= 0 {
+		timeout = migrateDefaultTimeout
+	}
+
+	keys := r.argv[2:3]
+	cp, replace := false, false
+	var auth []string
+	for i := 5; i < r.argc; i++ {
+		switch strings.ToLower(r.argv[i]) {
+		case "copy":
+			cp = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 == r.argc {
+				return nil, ErrBadArguments
+			}
+			auth = r.argv[i+1 : i+2]
+			i++
+		case "auth2":
+			if i+2 >= r.argc {
+				return nil, ErrBadArguments
+			}
+			auth = r.argv[i+1 : i+3]
+			i += 2
+		case "keys":
+			if r.argv[2] != "" || i+1 == r.argc {
+				return nil, ErrBadArguments
+			}
+			keys = r.argv[i+1:]
+			i = r.argc
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	type migrateItem struct {
+		key     string
+		ttl     int64
+		payload string
+	}
+	now := mstime()
+	items := []migrateItem{}
+	for _, k := range keys {
+		e := s.getEntry(k)
+		if e == nil {
+			continue
+		}
+		// the deadline is passed as a relative ttl, so the clocks
+		// of the servers may differ
+		var ttl int64
+		if e.expiry != nil {
+			ttl = e.expiry.ttl - now
+			if ttl < 1 {
+				// 0 would mean the deadline in the payload
+				ttl = 1
+			}
+		}
+		items = append(items, migrateItem{k, ttl, base64Dump(e)})
+	}
+	if len(items) == 0 {
+		return "NOKEY", nil
+	}
+
+	c, err := dialMigrate(net.JoinHostPort(r.argv[0], r.argv[1]), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.conn.Close()
+
+	if auth != nil {
+		if err := c.call("AUTH", auth...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.call("SELECT", strconv.Itoa(db)); err != nil {
+		return nil, err
+	}
+
+	migrated := []string{}
+	for _, it := range items {
+		args := []string{it.key, strconv.FormatInt(it.ttl, 10), it.payload}
+		if replace {
+			args = append(args, "REPLACE")
+		}
+		if err = c.call("RESTORE", args...); err != nil {
+			break
+		}
+
+		if !cp {
+			s.del(it.key)
+			notifyKeyspaceEvent(notifyGeneric, "del", it.key, r.db)
+			migrated = append(migrated, it.key)
+		}
+	}
+
+	if len(migrated) > 0 {
+		propagate(&request{cmd: "del", argv: migrated, argc: len(migrated), db: r.db})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Clients which issued MONITOR are pushed every command executed by the
+// server. A pushed message consists of "monitor", the unix time with
+// microseconds, the database, the address of the client and the command
+// followed by its arguments, secrets are redacted. Monitors are pushed
+// messages the same way pub/sub subscribers are, so a slow monitor is
+// disconnected instead of slowing down the server. Monitors are guarded
+// by execMutex.
+var monitors = make(map[*client]struct{})
+
+// feedMonitors pushes the request to monitors. The caller must hold
+// execMutex and should check there are monitors first.
+func feedMonitors(r *request, name string) {
+	now := time.Now()
+	values := make([]string, 0, r.argc+5)
+	values = append(values, "monitor", fmt.Sprintf("%d.%06d", now.Unix(), now.Nanosecond()/1000),
+		strconv.Itoa(r.client.db), r.client.addr(), r.cmd)
+	values = append(values, redactArgs(name, r.argv)...)
+
+	for c := range monitors {
+		c.push(values...)
+	}
+}
+
+// MONITOR
+// The client is pushed every command executed by the server until
+// it disconnects.
+func monitorCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 0 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	monitors[r.client] = struct{}{}
+	r.client.monitor = true
+	return "OK", nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+func TestFeedMonitors(t *testing.T) {
+	defer func(m map[*client]struct{}) { monitors = m }(monitors)
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	mon := &client{pushes: make(chan *redislike.Response, 1)}
+	monitors = map[*client]struct{}{mon: {}}
+	c := &client{conn: conn, db: 3}
+
+	tests := []struct {
+		cmd  []string
+		want []string
+	}{
+		{[]string{"SET", "k", "v"}, []string{"SET", "k", "v"}},
+		{[]string{"AUTH", "secret"}, []string{"AUTH", redacted}},
+		{[]string{"auth", "bob", "secret"}, []string{"auth", redacted, redacted}},
+		{[]string{"ACL", "SETUSER", "bob", "on", ">secret", "#5e88", "!5e88", "<secret", "~k*"},
+			[]string{"ACL", "SETUSER", "bob", "on", redacted, redacted, redacted, redacted, "~k*"}},
+		{[]string{"ACL", "SETUSER", ">bob", ""}, []string{"ACL", "SETUSER", ">bob", ""}},
+		{[]string{"ACL", "GETUSER", ">bob"}, []string{"ACL", "GETUSER", ">bob"}},
+		{[]string{"CONFIG", "SET", "requirepass", "secret", "maxmemory", "requirepass"},
+			[]string{"CONFIG", "SET", "requirepass", redacted, "maxmemory", "requirepass"}},
+		{[]string{"CONFIG", "GET", "requirepass"}, []string{"CONFIG", "GET", "requirepass"}},
+		{[]string{"MIGRATE", "h", "1", "", "0", "0", "AUTH", "secret", "KEYS", "a"},
+			[]string{"MIGRATE", "h", "1", "", "0", "0", "AUTH", redacted, "KEYS", "a"}},
+		{[]string{"MIGRATE", "h", "1", "a", "0", "0", "AUTH2", "bob", "secret"},
+			[]string{"MIGRATE", "h", "1", "a", "0", "0", "AUTH2", "bob", redacted}},
+	}
+
+	t.Log("Given a monitor and a client in the database 3")
+
+	stamp := regexp.MustCompile(`^\d+\.\d{6}$`)
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %q", i, tt.cmd)
+
+		argv := append([]string(nil), tt.cmd[1:]...)
+		feedMonitors(&request{cmd: tt.cmd[0], argv: argv, argc: len(argv), client: c}, strings.ToLower(tt.cmd[0]))
+		resp := <-mon.pushes
+
+		v := resp.Values
+		if len(v) > 4 && v[0] == "monitor" && stamp.MatchString(v[1]) && v[2] == "3" && v[3] == c.addr() &&
+			reflect.DeepEqual(v[4:], tt.want) {
+			t.Logf("\t%s\tShould push %q", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould push %q, got %q", failed, tt.want, v)
+		}
+		if !reflect.DeepEqual(argv, tt.cmd[1:]) {
+			t.Errorf("\t%s\tShould leave arguments intact, got %q", failed, argv)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Keyspace notifications are published to pub/sub channels whenever a key
+// is modified or expires. For every event two messages are published:
+//
+//	__keyspace@<db>__:<key> with the event as a payload
+//	__keyevent@<db>__:<event> with the key as a payload
+//
+// Which of them are published and for which classes of events is configured
+// with the notify-keyspace-events parameter (same flags as in Redis):
+//
+//	K  keyspace events
+//	E  keyevent events
+//	g  generic commands like DEL, EXPIRE, RENAME
+//	$  string commands
+//	l  list commands
+//	h  hash commands
+//	z  sorted set commands
+//	t  stream commands
+//	x  expired events (a key or a hash field has expired)
+//	e  evicted events (a key was evicted to free memory)
+//	A  alias for "g$lhztxe"
+//
+// At least one of K or E must be given, otherwise nothing is published.
+const (
+	notifyKeyspace = 1 << iota
+	notifyKeyevent
+	notifyGeneric
+	notifyString
+	notifyList
+	notifyHash
+	notifyZset
+	notifyStream
+	notifyExpired
+	notifyEvicted
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifyHash |
+		notifyZset | notifyStream | notifyExpired | notifyEvicted
+)
+
+// ErrNotifyFlags rises on unknown flags of keyspace notifications.
+var ErrNotifyFlags = errors.New("Invalid keyspace notification flags")
+
+var notifyKeyspaceEvents int
+
+func parseNotifyFlags(v string) (int, error) {
+	flags := 0
+	for _, c := range v {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZset
+		case 't':
+			flags |= notifyStream
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'A':
+			flags |= notifyAll
+		default:
+			return 0, ErrNotifyFlags
+		}
+	}
+	return flags, nil
+}
+
+// notifyKeyspaceEvent publishes the event of the class which happened
+// to the key in the database db. The caller must hold execMutex.
+func notifyKeyspaceEvent(class int, event string, key string, db int) {
+	if notifyKeyspaceEvents&class == 0 {
+		return
+	}
+
+	if notifyKeyspaceEvents&notifyKeyspace != 0 {
+		publish(fmt.Sprintf("__keyspace@%d__:%s", db, key), event)
+	}
+	if notifyKeyspaceEvents&notifyKeyevent != 0 {
+		publish(fmt.Sprintf("__keyevent@%d__:%s", db, event), key)
+	}
+}
+
+// formatNotifyFlags is the reverse of parseNotifyFlags.
+func formatNotifyFlags(flags int) string {
+	v := ""
+	if flags&notifyKeyspace != 0 {
+		v += "K"
+	}
+	if flags&notifyKeyevent != 0 {
+		v += "E"
+	}
+	if flags&notifyAll == notifyAll {
+		return v + "A"
+	}
+
+	for _, f := range []struct {
+		flag int
+		c    string
+	}{
+		{notifyGeneric, "g"},
+		{notifyString, "$"},
+		{notifyList, "l"},
+		{notifyHash, "h"},
+		{notifyZset, "z"},
+		{notifyStream, "t"},
+		{notifyExpired, "x"},
+		{notifyEvicted, "e"},
+	} {
+		if flags&f.flag != 0 {
+			v += f.c
+		}
+	}
+	return v
+}
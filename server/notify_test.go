@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+func TestNotifyFlags(t *testing.T) {
+	tests := []struct {
+		flags string
+		want  string // flags formatted back, "" if they are invalid
+	}{
+		{"", ""},
+		{"KEA", "KEA"},
+		{"AKE", "KEA"},
+		{"Kg$lhztxe", "KA"},
+		{"Ex", "Ex"},
+		{"Kgz", "Kgz"},
+		{"zgK", "Kgz"},
+		{"Ehh", "Eh"},
+		{"K$le", "K$le"},
+		{"KEq", ""},
+		{"k", ""},
+	}
+
+	t.Log("Given flags of keyspace notifications")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen parsing %q", i, tt.flags)
+
+		flags, err := parseNotifyFlags(tt.flags)
+		if tt.want == "" && tt.flags != "" {
+			if err == ErrNotifyFlags {
+				t.Logf("\t%s\tShould reject the flags", succeed)
+			} else {
+				t.Errorf("\t%s\tShould reject the flags, got %v", failed, err)
+			}
+			continue
+		}
+
+		if got := formatNotifyFlags(flags); err == nil && got == tt.want {
+			t.Logf("\t%s\tShould format them back as %q", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould format them back as %q, got %q, %v", failed, tt.want, got, err)
+		}
+		if again, _ := parseNotifyFlags(formatNotifyFlags(flags)); again != flags {
+			t.Errorf("\t%s\tShould parse the formatted flags to the same value", failed)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	defer func(events int) { notifyKeyspaceEvents = events }(notifyKeyspaceEvents)
+
+	// pushes are buffered in advance, so no goroutine writes them out
+	subscriber := func() *client { return &client{pushes: make(chan *redislike.Response, 8)} }
+	received := func(c *client) []string {
+		var msgs []string
+		for len(c.pushes) > 0 {
+			msgs = append(msgs, fmt.Sprint((<-c.pushes).Values))
+		}
+		return msgs
+	}
+
+	ch, pattern, other := subscriber(), subscriber(), subscriber()
+	ch.subscribe("news.tech")
+	pattern.psubscribe("news.*")
+	other.subscribe("sport")
+	other.psubscribe("__keyevent@0__:*")
+	defer func() {
+		for _, c := range []*client{ch, pattern, other} {
+			for k := range c.channels {
+				c.unsubscribe(k)
+			}
+			for p := range c.patterns {
+				c.punsubscribe(p)
+			}
+		}
+	}()
+
+	t.Log("Given subscribers of channels and patterns")
+
+	t.Log("\tTest: 0\tWhen publishing to a channel matching a pattern")
+	n := publish("news.tech", "hello")
+	if got, want := fmt.Sprint(n, received(ch), received(pattern), received(other)),
+		"2 [[message news.tech hello]] [[pmessage news.* news.tech hello]] []"; got == want {
+		t.Logf("\t%s\tShould deliver to the channel and the pattern subscribers", succeed)
+	} else {
+		t.Errorf("\t%s\tShould deliver to the channel and the pattern subscribers, got %s", failed, got)
+	}
+
+	t.Log("\tTest: 1\tWhen publishing to a channel matching nothing")
+	if n := publish("weather", "rain"); n == 0 && len(ch.pushes)+len(pattern.pushes)+len(other.pushes) == 0 {
+		t.Logf("\t%s\tShould deliver to nobody", succeed)
+	} else {
+		t.Errorf("\t%s\tShould deliver to nobody, got %d", failed, n)
+	}
+
+	t.Log("\tTest: 2\tWhen an expire event happens with keyevent notifications enabled")
+	notifyKeyspaceEvents, _ = parseNotifyFlags("Eg")
+	s := newStorage()
+	execute(s, "set", "k", "v")
+	execute(s, "expire", "k", "100")
+	execute(s, "expire", "none", "100")
+	if got, want := fmt.Sprint(received(other)), "[[pmessage __keyevent@0__:* __keyevent@0__:expire k]]"; got == want {
+		t.Logf("\t%s\tShould publish the event of the existing key only", succeed)
+	} else {
+		t.Errorf("\t%s\tShould publish the event of the existing key only, got %s", failed, got)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+// copyValue returns a deep copy of the value of any type, so the copy
+// can be modified independently.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case []string:
+		return append([]string(nil), v...)
+	case map[string]string:
+		c := make(map[string]string, len(v))
+		for f, x := range v {
+			c[f] = x
+		}
+		return c
+	case *hyperLogLog:
+		return v.copy()
+	case *sortedSet:
+		return v.copy()
+	case *stream:
+		return v.copy()
+	case *jsonDocument:
+		return v.copy()
+	case *bloomFilter:
+		return v.copy()
+	case *cuckooFilter:
+		return v.copy()
+	case *timeSeries:
+		return v.copy()
+	}
+	// strings are immutable
+	return v
+}
+
+// valueSize returns the approximate number of bytes held by the value.
+// It is used to account memory freed by eviction, so it only has to be
+// proportional to the real size.
+func valueSize(v interface{}) int64 {
+	const ptr = 16 // overhead of a string header or a map slot
+	var n int64
+	switch v := v.(type) {
+	case string:
+		n = int64(len(v))
+	case []byte:
+		n = int64(len(v))
+	case []string:
+		for _, x := range v {
+			n += int64(len(x)) + ptr
+		}
+	case map[string]string:
+		for f, x := range v {
+			n += int64(len(f)+len(x)) + 3*ptr
+		}
+	case *sortedSet:
+		for _, m := range v.members {
+			n += 2*int64(len(m.member)) + 5*ptr
+		}
+	case *hyperLogLog:
+		n = int64(len(v.dense)) + int64(len(v.sparse))
+	case *stream:
+		for _, e := range v.entries {
+			n += 3 * ptr
+			for _, f := range e.fields {
+				n += int64(len(f)) + ptr
+			}
+		}
+	case *jsonDocument:
+		s, _ := formatJSONValue(v.root)
+		n = int64(len(s)) * 2
+	case *bloomFilter:
+		for _, l := range v.layers {
+			n += int64(len(l.bits)) * 8
+		}
+	case *cuckooFilter:
+		for _, l := range v.layers {
+			n += int64(len(l.buckets)) * int64(v.bucketSize+ptr)
+		}
+	case *timeSeries:
+		n = int64(len(v.samples)) * 16
+	}
+	return n + ptr
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+// Clients subscribe to channels and to glob patterns of channel names.
+// Published messages are queued per subscriber and written to its connection
+// by a separate goroutine, so a slow subscriber doesn't block the server.
+// A subscriber which doesn't keep up with its queue is disconnected.
+// Subscriptions are guarded by execMutex like the storage.
+const pushQueueSize = 1024
+
+var (
+	pubsubChannels = make(map[string]map[*client]struct{})
+	pubsubPatterns = make(map[string]map[*client]struct{})
+)
+
+// subscriptions returns the number of channels and patterns the client is subscribed to.
+func (c *client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// push queues the message to be written to the client.
+func (c *client) push(values ...string) {
+	if c.pushes == nil {
+		c.pushes = make(chan *redislike.Response, pushQueueSize)
+		go c.writePushes()
+	}
+
+	resp, _ := redislike.NewPushResponse(values...)
+	select {
+	case c.pushes <- resp:
+	default:
+		logf(logWarning, "Too many pending messages for %s\n", c.addr())
+		c.kill()
+	}
+}
+
+func (c *client) writePushes() {
+	for resp := range c.pushes {
+		c.write(resp)
+	}
+}
+
+func (c *client) subscribe(channel string) {
+	if c.channels == nil {
+		c.channels = make(map[string]struct{})
+	}
+	c.channels[channel] = struct{}{}
+
+	if pubsubChannels[channel] == nil {
+		pubsubChannels[channel] = make(map[*client]struct{})
+	}
+	pubsubChannels[channel][c] = struct{}{}
+}
+
+func (c *client) unsubscribe(channel string) {
+	delete(c.channels, channel)
+	delete(pubsubChannels[channel], c)
+	if len(pubsubChannels[channel]) == 0 {
+		delete(pubsubChannels, channel)
+	}
+}
+
+func (c *client) psubscribe(pattern string) {
+	if c.patterns == nil {
+		c.patterns = make(map[string]struct{})
+	}
+	c.patterns[pattern] = struct{}{}
+
+	if pubsubPatterns[pattern] == nil {
+		pubsubPatterns[pattern] = make(map[*client]struct{})
+	}
+	pubsubPatterns[pattern][c] = struct{}{}
+}
+
+func (c *client) punsubscribe(pattern string) {
+	delete(c.patterns, pattern)
+	delete(pubsubPatterns[pattern], c)
+	if len(pubsubPatterns[pattern]) == 0 {
+		delete(pubsubPatterns, pattern)
+	}
+}
+
+// unsubscribeAll drops all subscriptions of a disconnected client,
+// stops monitoring and pushing messages to it.
+func (c *client) unsubscribeAll() {
+	execMutex.Lock()
+	defer execMutex.Unlock()
+
+	delete(monitors, c)
+
+	for ch := range c.channels {
+		c.unsubscribe(ch)
+	}
+	for p := range c.patterns {
+		c.punsubscribe(p)
+	}
+	if c.pushes != nil {
+		close(c.pushes)
+		c.pushes = nil
+	}
+}
+
+// publish pushes the message to subscribers of the channel and of patterns
+// matching the channel. It returns the number of clients that received it.
+// The caller must hold execMutex.
+func publish(channel string, message string) int {
+	n := 0
+	for c := range pubsubChannels[channel] {
+		c.push("message", channel, message)
+		n++
+	}
+	for p, clients := range pubsubPatterns {
+		if !globMatch(p, channel) {
+			continue
+		}
+		for c := range clients {
+			c.push("pmessage", p, channel, message)
+			n++
+		}
+	}
+	return n
+}
+
+// SUBSCRIBE channel [channel ...]
+// Return value is the number of channels and patterns the client is subscribed to.
+func subscribeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	for _, ch := range r.argv {
+		r.client.subscribe(ch)
+	}
+	return r.client.subscriptions(), nil
+}
+
+// UNSUBSCRIBE [channel [channel ...]]
+// Unsubscribes from the given channels or from all of them.
+// Return value is the number of channels and patterns the client is still subscribed to.
+func unsubscribeCommand(s *storage, r *request) (interface{}, error) {
+	channels := r.argv
+	if r.argc == 0 {
+		for ch := range r.client.channels {
+			channels = append(channels, ch)
+		}
+	}
+
+	for _, ch := range channels {
+		r.client.unsubscribe(ch)
+	}
+	return r.client.subscriptions(), nil
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+// Return value is the number of channels and patterns the client is subscribed to.
+func psubscribeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	for _, p := range r.argv {
+		r.client.psubscribe(p)
+	}
+	return r.client.subscriptions(), nil
+}
+
+// PUNSUBSCRIBE [pattern [pattern ...]]
+// Unsubscribes from the given patterns or from all of them.
+// Return value is the number of channels and patterns the client is still subscribed to.
+func punsubscribeCommand(s *storage, r *request) (interface{}, error) {
+	patterns := r.argv
+	if r.argc == 0 {
+		for p := range r.client.patterns {
+			patterns = append(patterns, p)
+		}
+	}
+
+	for _, p := range patterns {
+		r.client.punsubscribe(p)
+	}
+	return r.client.subscriptions(), nil
+}
+
+// PUBLISH channel message
+// Return value is the number of clients that received the message.
+func publishCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	return publish(r.argv[0], r.argv[1]), nil
+}
+
+// PUBSUB CHANNELS [pattern]
+// PUBSUB NUMSUB [channel ...]
+// PUBSUB NUMPAT
+func pubsubCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	switch strings.ToLower(r.argv[0]) {
+	case "channels":
+		if r.argc > 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		channels := []string{}
+		for ch := range pubsubChannels {
+			if r.argc == 1 || globMatch(r.argv[1], ch) {
+				channels = append(channels, ch)
+			}
+		}
+		sort.Strings(channels)
+		return channels, nil
+	case "numsub":
+		res := make(map[string]int, r.argc-1)
+		for _, ch := range r.argv[1:] {
+			res[ch] = len(pubsubChannels[ch])
+		}
+		return res, nil
+	case "numpat":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		return len(pubsubPatterns), nil
+	}
+
+	return nil, ErrBadArguments
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+)
+
+// shutdown is called once the server stopped accepting connections.
+// Reads of every connection are interrupted, so clients finish commands
+// they are executing and disconnect. Connections still open after the timeout
+// are closed. Then pending writes of the cmdlog are flushed to disk
+// and the snapshot is written. Commands are never executed after shutdown.
+func shutdown(l *cmdlog, timeout time.Duration) {
+	clientsMutex.Lock()
+	shuttingDown = true
+	for c := range clients {
+		c.conn.SetReadDeadline(time.Now())
+	}
+	clientsMutex.Unlock()
+
+	// clients blocked in commands return
+	execMutex.Lock()
+	streamReady.Broadcast()
+	execMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		clientsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logf(logWarning, "Timed out waiting for clients, closing connections\n")
+		clientsMutex.Lock()
+		for c := range clients {
+			c.conn.Close()
+		}
+		clientsMutex.Unlock()
+	}
+
+	execMutex.Lock()
+
+	if l != nil {
+		if err := l.close(); err != nil {
+			logf(logWarning, "Could not sync cmdlog: %v\n", err)
+		} else {
+			logf(logNotice, "Cmdlog is synced to disk\n")
+		}
+	}
+
+	if config.snapshot != "" {
+		if err := writeSnapshot(config.snapshot); err != nil {
+			logf(logWarning, "Could not write snapshot: %v\n", err)
+		} else {
+			logf(logNotice, "Snapshot is written to %s\n", config.snapshot)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	defer func(dbs []*storage, l *cmdlog, snapshot string) {
+		databases, cmdlogger, config.snapshot = dbs, l, snapshot
+		shuttingDown = false
+	}(databases, cmdlogger, config.snapshot)
+
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "cmdlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.snapshot = filepath.Join(dir, "snapshot")
+
+	// commands are queued as the cmdlog does not listen yet
+	databases = newDatabases(2)
+	l := &cmdlog{file: f, logchan: make(chan *request, 10), done: make(chan struct{})}
+	cmdlogger = l
+	c := &client{}
+	for _, cmd := range [][]string{{"set", "a", "1"}, {"select", "1"}, {"rpush", "b", "x", "y"}, {"del", "none"}} {
+		if _, err := executeCmd(&request{cmd: cmd[0], argv: cmd[1:], argc: len(cmd) - 1, client: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go l.listen()
+
+	t.Log("Given commands waiting to be written to the cmdlog")
+	{
+		t.Log("\tWhen the server is shut down")
+		{
+			shutdown(l, time.Second)
+			execMutex.Unlock()
+
+			if cmdlogger == nil {
+				t.Logf("\t%s\tShould stop logging", succeed)
+			} else {
+				t.Errorf("\t%s\tShould stop logging", failed)
+			}
+
+			want := [][]string{{"a"}, {"b"}}
+			b, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			databases = newDatabases(2)
+			replay(bytes.NewReader(b))
+			if got := dbKeys(); reflect.DeepEqual(got, want) {
+				t.Logf("\t%s\tShould flush the queued commands", succeed)
+			} else {
+				t.Errorf("\t%s\tShould flush the queued commands, got %v", failed, got)
+			}
+
+			databases = newDatabases(2)
+			loadSnapshot(config.snapshot)
+			if got := dbKeys(); reflect.DeepEqual(got, want) {
+				t.Logf("\t%s\tShould write the snapshot", succeed)
+			} else {
+				t.Errorf("\t%s\tShould write the snapshot, got %v", failed, got)
+			}
+		}
+	}
+}
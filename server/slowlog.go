@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Commands executing longer than slowlog-log-slower-than microseconds are
+// recorded in the slow log, which keeps up to slowlog-max-len of the latest
+// of them. Arguments are truncated, so a giant command doesn't blow up
+// the memory, and secrets like passwords are redacted. Once the slow log
+// is full, the newest entry overwrites the oldest one. The slow log is
+// guarded by execMutex.
+const (
+	slowlogMaxArgc   = 32  // the number of arguments kept
+	slowlogMaxArgLen = 128 // the number of bytes of an argument kept
+)
+
+var (
+	slowlog       []slowlogEntry // a ring buffer of up to slowlog-max-len entries
+	slowlogHead   int            // index of the oldest entry, 0 until the slow log is full
+	slowlogNextID int64
+)
+
+// slowlogEntry is an element of the SLOWLOG GET reply.
+type slowlogEntry struct {
+	ID       int64    `json:"id"`
+	Time     int64    `json:"time"`     // unix time the command was executed at
+	Duration int64    `json:"duration"` // microseconds
+	Args     []string `json:"args"`     // the command followed by its arguments
+	Addr     string   `json:"addr"`
+	Name     string   `json:"name"`
+}
+
+const redacted = "(redacted)"
+
+// redactArgs returns arguments of the command with passwords replaced.
+func redactArgs(name string, argv []string) []string {
+	var secret func(i int) bool
+	switch name {
+	case "auth":
+		secret = func(i int) bool { return true }
+	case "acl":
+		if len(argv) > 0 && strings.ToLower(argv[0]) == "setuser" {
+			secret = func(i int) bool { return i > 1 && strings.ContainsAny(argv[i][:1], "><#!") }
+		}
+	case "config":
+		if len(argv) > 0 && strings.ToLower(argv[0]) == "set" {
+			secret = func(i int) bool { return i > 1 && i%2 == 0 && strings.ToLower(argv[i-1]) == "requirepass" }
+		}
+	case "migrate":
+		secret = func(i int) bool {
+			return i > 0 && strings.ToLower(argv[i-1]) == "auth" || i > 1 && strings.ToLower(argv[i-2]) == "auth2"
+		}
+	}
+	if secret == nil {
+		return argv
+	}
+
+	res := make([]string, len(argv))
+	for i, v := range argv {
+		if v != "" && secret(i) {
+			v = redacted
+		}
+		res[i] = v
+	}
+	return res
+}
+
+// slowlogArgs returns the command with its arguments as they are recorded.
+func slowlogArgs(r *request) []string {
+	argv := redactArgs(strings.ToLower(r.cmd), r.argv)
+
+	n := len(argv)
+	if n >= slowlogMaxArgc {
+		n = slowlogMaxArgc - 2
+	}
+	args := make([]string, 0, n+2)
+	args = append(args, r.cmd)
+	for _, v := range argv[:n] {
+		if len(v) > slowlogMaxArgLen {
+			v = fmt.Sprintf("%s... (%d more bytes)", v[:slowlogMaxArgLen], len(v)-slowlogMaxArgLen)
+		}
+		args = append(args, v)
+	}
+	if n < len(argv) {
+		args = append(args, fmt.Sprintf("... (%d more arguments)", len(argv)-n))
+	}
+	return args
+}
+
+// slowlogPush records the request if it took longer than the threshold.
+// Commands replayed from the cmdlog have no connection and are not
+// recorded. The caller must hold execMutex.
+func slowlogPush(r *request, usec int64) {
+	if config.slowlogSlowerThan < 0 || usec < config.slowlogSlowerThan || config.slowlogMaxLen == 0 ||
+		r.client.conn == nil {
+		return
+	}
+
+	slowlogNextID++
+	e := slowlogEntry{
+		ID:       slowlogNextID,
+		Time:     mstime() / 1000,
+		Duration: usec,
+		Args:     slowlogArgs(r),
+		Addr:     r.client.addr(),
+		Name:     r.client.name,
+	}
+	if len(slowlog) < config.slowlogMaxLen {
+		slowlog = append(slowlog, e)
+		return
+	}
+	slowlog[slowlogHead] = e
+	slowlogHead = (slowlogHead + 1) % len(slowlog)
+}
+
+// slowlogAt returns the i-th of the entries, the oldest entry goes first.
+func slowlogAt(i int) slowlogEntry {
+	return slowlog[(slowlogHead+i)%len(slowlog)]
+}
+
+// slowlogTrim removes the oldest entries exceeding slowlog-max-len.
+// It's called once slowlog-max-len changes, so entries are reordered
+// with the oldest one first and the buffer could grow again.
+func slowlogTrim() {
+	n := len(slowlog) - config.slowlogMaxLen
+	if n < 0 {
+		n = 0
+	}
+	entries := make([]slowlogEntry, 0, len(slowlog)-n)
+	for i := n; i < len(slowlog); i++ {
+		entries = append(entries, slowlogAt(i))
+	}
+	slowlog, slowlogHead = entries, 0
+}
+
+// SLOWLOG GET [count]
+// SLOWLOG LEN
+// SLOWLOG RESET
+// GET returns up to count (10 by default, -1 for all) of the latest entries,
+// the newest goes first.
+func slowlogCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	switch strings.ToLower(r.argv[0]) {
+	case "get":
+		if r.argc > 2 {
+			return nil, ErrWrongNumOfArguments
+		}
+		count := 10
+		if r.argc == 2 {
+			n, err := strconv.Atoi(r.argv[1])
+			if err != nil || n < -1 {
+				return nil, ErrBadArguments
+			}
+			count = n
+		}
+		if count == -1 || count > len(slowlog) {
+			count = len(slowlog)
+		}
+
+		res := make([]slowlogEntry, 0, count)
+		for i := len(slowlog) - 1; i >= len(slowlog)-count; i-- {
+			res = append(res, slowlogAt(i))
+		}
+		return res, nil
+
+	case "len":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		return len(slowlog), nil
+
+	case "reset":
+		if r.argc != 1 {
+			return nil, ErrWrongNumOfArguments
+		}
+		slowlog, slowlogHead = nil, 0
+		return "OK", nil
+	}
+
+	return nil, ErrBadArguments
+}
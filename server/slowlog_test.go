@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlowlogArgs(t *testing.T) {
+	many := make([]string, 40)
+	for i := range many {
+		many[i] = fmt.Sprint(i)
+	}
+	wantMany := append([]string{"DEL"}, many[:30]...)
+	wantMany = append(wantMany, "... (10 more arguments)")
+
+	tests := []struct {
+		cmd  string
+		argv []string
+		want []string
+	}{
+		{"GET", []string{"a"}, []string{"GET", "a"}},
+		{"AUTH", []string{"alice", "secret"}, []string{"AUTH", redacted, redacted}},
+		{"ACL", []string{"SETUSER", "alice", "on", ">secret", "~*", "#abc"},
+			[]string{"ACL", "SETUSER", "alice", "on", redacted, "~*", redacted}},
+		{"CONFIG", []string{"SET", "maxmemory", "1mb", "requirepass", "secret"},
+			[]string{"CONFIG", "SET", "maxmemory", "1mb", "requirepass", redacted}},
+		{"MIGRATE", []string{"h", "1", "", "0", "0", "AUTH2", "u", "p", "KEYS", "a"},
+			[]string{"MIGRATE", "h", "1", "", "0", "0", "AUTH2", "u", redacted, "KEYS", "a"}},
+		{"SET", []string{"a", strings.Repeat("x", 130)},
+			[]string{"SET", "a", strings.Repeat("x", 128) + "... (2 more bytes)"}},
+		{"DEL", many, wantMany},
+	}
+
+	t.Log("Given commands with arguments")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen recording %s in the slow log", i, tt.cmd)
+
+		got := slowlogArgs(&request{cmd: tt.cmd, argv: tt.argv, argc: len(tt.argv)})
+		if reflect.DeepEqual(got, tt.want) {
+			t.Logf("\t%s\tShould get %v", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %v, got %v", failed, tt.want, got)
+		}
+	}
+}
+
+func TestSlowlogReplay(t *testing.T) {
+	defer func(slower int64, max int) {
+		config.slowlogSlowerThan, config.slowlogMaxLen = slower, max
+		slowlog, slowlogHead = nil, 0
+	}(config.slowlogSlowerThan, config.slowlogMaxLen)
+	config.slowlogSlowerThan, config.slowlogMaxLen = 0, 10
+
+	t.Log("Given the slow log recording every command")
+	t.Log("\tWhen a command is replayed from the cmdlog")
+
+	slowlogPush(&request{cmd: "SET", argv: []string{"a", "1"}, argc: 2, client: &client{}}, 1)
+	if len(slowlog) == 0 {
+		t.Logf("\t%s\tShould not record it", succeed)
+	} else {
+		t.Errorf("\t%s\tShould not record it, got %v", failed, slowlog)
+	}
+}
+
+func TestSlowlogBlocked(t *testing.T) {
+	defer func(slower int64, max int, dbs []*storage, l *cmdlog) {
+		config.slowlogSlowerThan, config.slowlogMaxLen = slower, max
+		databases, cmdlogger = dbs, l
+		slowlog, slowlogHead = nil, 0
+	}(config.slowlogSlowerThan, config.slowlogMaxLen, databases, cmdlogger)
+	config.slowlogSlowerThan, config.slowlogMaxLen = 100000, 10
+	databases = newDatabases(1)
+	cmdlogger = nil
+
+	t.Log("Given the slow log recording commands slower than 100 ms")
+	t.Log("\tWhen XREAD is blocked for 200 ms")
+
+	var before int64
+	if cs := stats.commands["xread"]; cs != nil {
+		before = cs.usec
+	}
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	argv := []string{"BLOCK", "200", "STREAMS", "s", "$"}
+	if _, err := executeCmd(&request{cmd: "xread", argv: argv, argc: len(argv), client: &client{conn: conn}}); err != nil {
+		t.Fatal(err)
+	}
+
+	execMutex.Lock()
+	usec := stats.commands["xread"].usec - before
+	execMutex.Unlock()
+	if len(slowlog) == 0 && usec < 100000 {
+		t.Logf("\t%s\tShould leave the blocked time out", succeed)
+	} else {
+		t.Errorf("\t%s\tShould leave the blocked time out, got %v, %d usec", failed, slowlog, usec)
+	}
+}
+
+func TestSlowlogRing(t *testing.T) {
+	defer func(slower int64, max int) {
+		config.slowlogSlowerThan, config.slowlogMaxLen = slower, max
+		slowlog, slowlogHead = nil, 0
+	}(config.slowlogSlowerThan, config.slowlogMaxLen)
+	config.slowlogSlowerThan, config.slowlogMaxLen = 0, 3
+
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	c := &client{conn: conn}
+	push := func(from, to int) {
+		for i := from; i <= to; i++ {
+			slowlogPush(&request{cmd: "GET", argv: []string{fmt.Sprint(i)}, argc: 1, client: c}, 1)
+		}
+	}
+	keys := func() []string {
+		res, _ := slowlogCommand(nil, &request{argv: []string{"GET", "-1"}, argc: 2})
+		var keys []string
+		for _, e := range res.([]slowlogEntry) {
+			keys = append(keys, e.Args[1])
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name   string
+		exec   func()
+		maxLen int
+		want   []string
+	}{
+		{"the slow log is filled up", func() { push(1, 3) }, 3, []string{"3", "2", "1"}},
+		{"more entries are recorded", func() { push(4, 7) }, 3, []string{"7", "6", "5"}},
+		{"slowlog-max-len grows", func() { push(8, 9) }, 5, []string{"9", "8", "7", "6", "5"}},
+		{"slowlog-max-len shrinks", func() { push(10, 10) }, 2, []string{"10", "9"}},
+	}
+
+	t.Log("Given the slow log of up to 3 entries")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen %s", i, tt.name)
+
+		if tt.maxLen != config.slowlogMaxLen {
+			config.slowlogMaxLen = tt.maxLen
+			slowlogTrim()
+		}
+		tt.exec()
+		if got := keys(); reflect.DeepEqual(got, tt.want) {
+			t.Logf("\t%s\tShould get %v", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %v, got %v", failed, tt.want, got)
+		}
+	}
+}
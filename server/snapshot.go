@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+// A snapshot keeps the content of all databases in the cmdlog format:
+// SELECT of every non-empty database is followed by RESTORE of its keys
+// with absolute deadlines and TS.CREATERULE of compaction rules between
+// its time series, which payloads of RESTORE don't hold. The snapshot
+// is written on shutdown if the snapshot parameter is set and loaded
+// on start up unless the cmdlog is used, as the cmdlog already holds
+// all the data.
+
+// writeSnapshot writes all databases to the file. The file is replaced
+// atomically, so a failed write never destroys the previous snapshot.
+// The caller must hold execMutex.
+func writeSnapshot(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	now := mstime()
+	for i, s := range databases {
+		selected := false
+		var rules []*redislike.Request
+		for k, e := range s.entries {
+			var ttl int64
+			if e.expiry != nil {
+				if e.expiry.ttl <= now {
+					continue
+				}
+				ttl = e.expiry.ttl
+			}
+
+			if !selected {
+				req, _ := redislike.NewRequest("SELECT", strconv.Itoa(i))
+				req.Write(w)
+				selected = true
+			}
+			req, _ := redislike.NewRequest("RESTORE", k, strconv.FormatInt(ttl, 10), base64Dump(&e), "ABSTTL")
+			if err := req.Write(w); err != nil {
+				f.Close()
+				return err
+			}
+
+			if t, ok := e.value.(*timeSeries); ok {
+				for _, r := range t.rules {
+					if d, ok := s.entries[r.dest]; !ok || d.expiry != nil && d.expiry.ttl <= now {
+						continue
+					}
+					req, _ := redislike.NewRequest("TS.CREATERULE", k, r.dest, "AGGREGATION", r.aggregation, strconv.FormatInt(r.bucket, 10))
+					rules = append(rules, req)
+				}
+			}
+		}
+
+		// both series of a rule must be restored before it
+		for _, req := range rules {
+			if err := req.Write(w); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadSnapshot restores databases from the file if it exists.
+func loadSnapshot(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalln(err)
+		}
+		return
+	}
+	defer f.Close()
+
+	logf(logNotice, "Loading snapshot from %s\n", path)
+	replay(f)
+	logf(logNotice, "Snapshot loaded successfully\n")
+}
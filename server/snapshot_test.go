@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	defer func(dbs []*storage) { databases = dbs }(databases)
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	if err := os.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	databases = newDatabases(3)
+	deadline := mstime() + 60000
+	execute(databases[0], "set", "str", "v")
+	execute(databases[0], "set", "expiring", "v")
+	databases[0].setExpire("expiring", deadline)
+	execute(databases[0], "set", "expired", "v")
+	databases[0].setExpire("expired", deadline)
+	expireNow(databases[0], "expired")
+	execute(databases[2], "rpush", "list", "a", "b")
+	execute(databases[2], "hset", "hash", "f", "v")
+
+	t.Log("Given databases with keys with and without deadlines")
+	{
+		t.Log("\tWhen the snapshot is written and loaded")
+		{
+			if err := writeSnapshot(path); err != nil {
+				t.Fatalf("\t%s\tShould write the snapshot: %v", failed, err)
+			}
+			if _, err := os.Stat(path + ".tmp"); os.IsNotExist(err) {
+				t.Logf("\t%s\tShould rename the temporary file", succeed)
+			} else {
+				t.Errorf("\t%s\tShould rename the temporary file, got %v", failed, err)
+			}
+
+			databases = newDatabases(3)
+			loadSnapshot(path)
+
+			want := [][]string{{"expiring", "str"}, {}, {"hash", "list"}}
+			if got := dbKeys(); reflect.DeepEqual(got, want) {
+				t.Logf("\t%s\tShould restore keys %v skipping expired ones", succeed, want)
+			} else {
+				t.Errorf("\t%s\tShould restore keys %v skipping expired ones, got %v", failed, want, got)
+			}
+
+			if e := databases[0].getEntry("expiring"); e != nil && e.expiry != nil && e.expiry.ttl == deadline {
+				t.Logf("\t%s\tShould keep the deadline", succeed)
+			} else {
+				t.Errorf("\t%s\tShould keep the deadline %d", failed, deadline)
+			}
+			if e := databases[0].getEntry("str"); e != nil && e.expiry == nil {
+				t.Logf("\t%s\tShould not set a deadline on persistent keys", succeed)
+			} else {
+				t.Errorf("\t%s\tShould not set a deadline on persistent keys", failed)
+			}
+
+			res, _ := execute(databases[2], "lrange", "list", "0", "-1")
+			if b, _ := json.Marshal(res); string(b) == `["a","b"]` {
+				t.Logf("\t%s\tShould restore values", succeed)
+			} else {
+				t.Errorf("\t%s\tShould restore values, got %s", failed, b)
+			}
+		}
+
+		t.Log("\tWhen the temporary file can't be created")
+		{
+			before, _ := os.ReadFile(path)
+			if err := os.Mkdir(path+".tmp", 0755); err != nil {
+				t.Fatal(err)
+			}
+			err := writeSnapshot(path)
+			if after, _ := os.ReadFile(path); err != nil && string(after) == string(before) {
+				t.Logf("\t%s\tShould keep the previous snapshot", succeed)
+			} else {
+				t.Errorf("\t%s\tShould keep the previous snapshot, got %v", failed, err)
+			}
+		}
+	}
+}
+
+func TestSnapshotRules(t *testing.T) {
+	defer func(dbs []*storage, l *cmdlog) { databases, cmdlogger = dbs, l }(databases, cmdlogger)
+	cmdlogger = nil
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	databases = newDatabases(2)
+	s := databases[1]
+	execute(s, "ts.create", "src")
+	execute(s, "ts.create", "dst")
+	execute(s, "ts.create", "expired")
+	execute(s, "ts.createrule", "src", "dst", "AGGREGATION", "sum", "10")
+	execute(s, "ts.createrule", "src", "expired", "AGGREGATION", "max", "10")
+	execute(s, "ts.add", "src", "1", "1")
+	s.setExpire("expired", mstime()-1)
+
+	t.Log("Given a compaction rule between time series")
+	{
+		t.Log("\tWhen the snapshot is written and loaded")
+		{
+			if err := writeSnapshot(path); err != nil {
+				t.Fatalf("\t%s\tShould write the snapshot: %v", failed, err)
+			}
+			databases = newDatabases(2)
+			loadSnapshot(path)
+			s := databases[1]
+
+			if got := seriesLinks(s); got == "dst<-src src->dst" {
+				t.Logf("\t%s\tShould restore the rule", succeed)
+			} else {
+				t.Errorf("\t%s\tShould restore the rule, got %q", failed, got)
+			}
+
+			execute(s, "ts.add", "src", "2", "2")
+			execute(s, "ts.add", "src", "11", "5")
+			res, _ := execute(s, "ts.range", "dst", "-", "+")
+			if b, _ := json.Marshal(res); string(b) == `[{"timestamp":0,"value":"3"}]` {
+				t.Logf("\t%s\tShould compact samples into the destination", succeed)
+			} else {
+				t.Errorf("\t%s\tShould compact samples into the destination, got %s", failed, b)
+			}
+		}
+	}
+}
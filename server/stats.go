@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Statistics of the server are reported by INFO. Counters are changed
+// while execMutex is held, except the ones of connections which are
+// changed with clientsMutex.
+var stats struct {
+	startTime time.Time
+
+	connectionsReceived int64
+	rejectedConnections int64
+
+	commandsProcessed int64
+	opsPerSec         int64 // commands processed during the last second
+	lastProcessed     int64
+
+	// lookups of keys are counted as hits and misses only while read-only
+	// commands are executed, as write ones look keys up to modify them
+	countLookups   bool
+	keyspaceHits   int64
+	keyspaceMisses int64
+	expiredKeys    int64
+	evictedKeys    int64
+
+	commands map[string]*commandStats
+}
+
+// commandStats are the statistics of a command.
+type commandStats struct {
+	calls       int64
+	failedCalls int64
+	usec        int64 // total execution time in microseconds
+
+	// the number of calls by execution time, calls taking longer than
+	// latencyBuckets[i-1] and up to latencyBuckets[i] are counted in
+	// buckets[i], the last one counts calls longer than all of them
+	buckets [len(latencyBuckets) + 1]int64
+}
+
+// latencyBuckets are upper bounds of execution time histograms.
+var latencyBuckets = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// infoSections are the sections of INFO in the order they are reported.
+// commandstats is not reported unless it's requested explicitly or with all.
+var infoSections = []struct {
+	name  string
+	fn    func() [][2]string
+	extra bool
+}{
+	{"server", infoServer, false},
+	{"clients", infoClients, false},
+	{"memory", infoMemory, false},
+	{"persistence", infoPersistence, false},
+	{"stats", infoStats, false},
+	{"keyspace", infoKeyspace, false},
+	{"commandstats", infoCommandStats, true},
+}
+
+func init() {
+	stats.startTime = time.Now()
+	stats.commands = make(map[string]*commandStats)
+}
+
+// recordCommand adds a call of the command to the statistics.
+// The caller must hold execMutex.
+func recordCommand(name string, d time.Duration, err error) {
+	cs, ok := stats.commands[name]
+	if !ok {
+		cs = &commandStats{}
+		stats.commands[name] = cs
+	}
+	cs.calls++
+	cs.usec += d.Microseconds()
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	cs.buckets[i]++
+	if err != nil {
+		cs.failedCalls++
+	}
+	stats.commandsProcessed++
+}
+
+// runStatsCron samples the number of processed commands every second.
+func runStatsCron() {
+	ticker := time.NewTicker(time.Second)
+	for range ticker.C {
+		execMutex.Lock()
+		stats.opsPerSec = stats.commandsProcessed - stats.lastProcessed
+		stats.lastProcessed = stats.commandsProcessed
+		execMutex.Unlock()
+	}
+}
+
+func infoServer() [][2]string {
+	uptime := time.Since(stats.startTime)
+	return [][2]string{
+		{"go_version", runtime.Version()},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"process_id", fmt.Sprint(os.Getpid())},
+		{"tcp_addrs", strings.Join(config.addrs, ",")},
+		{"tls_addrs", strings.Join(config.tlsAddrs, ",")},
+		{"unixsocket", config.unixsocket},
+		{"uptime_in_seconds", fmt.Sprint(int64(uptime.Seconds()))},
+		{"uptime_in_days", fmt.Sprint(int64(uptime.Hours() / 24))},
+		{"config_file", configFile},
+	}
+}
+
+func infoClients() [][2]string {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	pubsub := 0
+	for c := range clients {
+		if c.subscriptions() > 0 {
+			pubsub++
+		}
+	}
+	return [][2]string{
+		{"connected_clients", fmt.Sprint(len(clients))},
+		{"pubsub_clients", fmt.Sprint(pubsub)},
+		{"maxclients", fmt.Sprint(atomic.LoadInt32(&config.maxclients))},
+	}
+}
+
+func infoMemory() [][2]string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	return [][2]string{
+		{"used_memory", fmt.Sprint(usedMemory())},
+		{"heap_sys", fmt.Sprint(ms.HeapSys)},
+		{"sys", fmt.Sprint(ms.Sys)},
+		{"gc_cycles", fmt.Sprint(ms.NumGC)},
+		{"maxmemory", fmt.Sprint(config.maxmemory)},
+		{"maxmemory_policy", findConfigParam("maxmemory-policy").get()},
+	}
+}
+
+func infoPersistence() [][2]string {
+	res := [][2]string{
+		{"loading", boolInfo(loading)},
+		{"cmdlog_enabled", boolInfo(cmdlogger != nil)},
+	}
+	if cmdlogger != nil {
+		var size int64
+		if fi, err := cmdlogger.file.Stat(); err == nil {
+			size = fi.Size()
+		}
+		res = append(res,
+			[2]string{"cmdlog_fsync", fsyncPolicyNames[atomic.LoadInt32(&config.cmdlogFsync)]},
+			[2]string{"cmdlog_current_size", fmt.Sprint(size)},
+			[2]string{"cmdlog_last_fsync", fmt.Sprint(atomic.LoadInt64(&cmdlogger.lastSync) / 1000)},
+		)
+	}
+	return append(res, [2]string{"snapshot", config.snapshot})
+}
+
+func infoStats() [][2]string {
+	clientsMutex.Lock()
+	received, rejected := stats.connectionsReceived, stats.rejectedConnections
+	clientsMutex.Unlock()
+
+	return [][2]string{
+		{"total_connections_received", fmt.Sprint(received)},
+		{"rejected_connections", fmt.Sprint(rejected)},
+		{"total_commands_processed", fmt.Sprint(stats.commandsProcessed)},
+		{"instantaneous_ops_per_sec", fmt.Sprint(stats.opsPerSec)},
+		{"keyspace_hits", fmt.Sprint(stats.keyspaceHits)},
+		{"keyspace_misses", fmt.Sprint(stats.keyspaceMisses)},
+		{"expired_keys", fmt.Sprint(stats.expiredKeys)},
+		{"evicted_keys", fmt.Sprint(stats.evictedKeys)},
+		{"pubsub_channels", fmt.Sprint(len(pubsubChannels))},
+	}
+}
+
+func infoKeyspace() [][2]string {
+	var res [][2]string
+	for i, s := range databases {
+		s.mutex.RLock()
+		keys, expires := s.len()
+		s.mutex.RUnlock()
+		if keys > 0 {
+			res = append(res, [2]string{fmt.Sprintf("db%d", i), fmt.Sprintf("keys=%d,expires=%d", keys, expires)})
+		}
+	}
+	return res
+}
+
+func infoCommandStats() [][2]string {
+	names := make([]string, 0, len(stats.commands))
+	for name := range stats.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([][2]string, 0, len(names))
+	for _, name := range names {
+		cs := stats.commands[name]
+		res = append(res, [2]string{"cmdstat_" + name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d",
+			cs.calls, cs.usec, float64(cs.usec)/float64(cs.calls), cs.failedCalls)})
+	}
+	return res
+}
+
+func boolInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// INFO [section [section ...]]
+// INFO summary
+// Sections are server, clients, memory, persistence, stats, keyspace and
+// commandstats. Without arguments all of them except commandstats are
+// returned, all returns every section. Every section starts with
+// "# Name" followed by "field:value" lines.
+func infoCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc == 1 && strings.ToLower(r.argv[0]) == "summary" {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		kln, xln := s.len()
+		return fmt.Sprintf("Number of Keys: %d\nNumber of Expiries %d", kln, xln), nil
+	}
+
+	requested := make(map[string]bool)
+	for _, v := range r.argv {
+		requested[strings.ToLower(v)] = true
+	}
+	all := requested["all"] || requested["everything"]
+	def := r.argc == 0 || requested["default"]
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !all && !requested[sec.name] && (!def || sec.extra) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(sec.name[:1]) + sec.name[1:] + "\r\n")
+		for _, f := range sec.fn() {
+			b.WriteString(f[0] + ":" + f[1] + "\r\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// infoFields returns section names and fields of the INFO reply.
+func infoFields(info string) ([]string, map[string]string) {
+	sections := []string{}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\r\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			sections = append(sections, line[2:])
+		case line != "":
+			kv := strings.SplitN(line, ":", 2)
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return sections, fields
+}
+
+func TestInfoSections(t *testing.T) {
+	defer func(dbs []*storage) { databases = dbs }(databases)
+	databases = newDatabases(3)
+
+	def := []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Keyspace"}
+	tests := []struct {
+		argv []string
+		want []string
+	}{
+		{nil, def},
+		{[]string{"default"}, def},
+		{[]string{"memory"}, []string{"Memory"}},
+		{[]string{"KEYSPACE", "clients"}, []string{"Clients", "Keyspace"}},
+		{[]string{"commandstats"}, []string{"Commandstats"}},
+		{[]string{"default", "commandstats"}, append(def, "Commandstats")},
+		{[]string{"all"}, append(def, "Commandstats")},
+		{[]string{"everything"}, append(def, "Commandstats")},
+		{[]string{"nosuchsection"}, []string{}},
+	}
+
+	t.Log("Given INFO sections")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen requesting %v", i, tt.argv)
+
+		res, err := execute(databases[0], "info", tt.argv...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := infoFields(res.(string)); reflect.DeepEqual(got, tt.want) {
+			t.Logf("\t%s\tShould get sections %v", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get sections %v, got %v", failed, tt.want, got)
+		}
+	}
+}
+
+func TestInfoFields(t *testing.T) {
+	defer func(dbs []*storage, commands map[string]*commandStats) {
+		databases, stats.commands = dbs, commands
+	}(databases, stats.commands)
+	databases = newDatabases(3)
+	stats.commands = make(map[string]*commandStats)
+
+	execute(databases[1], "set", "a", "1")
+	execute(databases[1], "set", "b", "1")
+	databases[1].setExpire("b", mstime()+60000)
+	execute(databases[2], "set", "c", "1")
+	recordCommand("get", 3*time.Microsecond, nil)
+	recordCommand("get", 5*time.Microsecond, errors.New("ERR"))
+
+	t.Log("Given keys in databases 1 and 2 and two calls of GET")
+	{
+		t.Log("\tWhen requesting keyspace and commandstats")
+		{
+			res, _ := execute(databases[0], "info", "keyspace", "commandstats")
+			_, got := infoFields(res.(string))
+			want := map[string]string{
+				"db1":         "keys=2,expires=1",
+				"db2":         "keys=1,expires=0",
+				"cmdstat_get": "calls=2,usec=8,usec_per_call=4.00,failed_calls=1",
+			}
+			if reflect.DeepEqual(got, want) {
+				t.Logf("\t%s\tShould get %v", succeed, want)
+			} else {
+				t.Errorf("\t%s\tShould get %v, got %v", failed, want, got)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"container/heap"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -9,19 +10,40 @@ import (
 type entry struct {
 	value  interface{}
 	expiry *expiry
+	fields map[string]*expiry // expiries of hash fields
+	atime  int64              // last access time in milliseconds, used by LRU eviction
 }
 
 type storage struct {
-	mutex    sync.RWMutex
-	entries  map[string]entry
-	expiries expiryPriorityQueue
+	mutex         sync.RWMutex
+	entries       map[string]entry
+	expiries      expiryPriorityQueue
+	fieldExpiries expiryPriorityQueue
+	index         *keyIndex
+	types         map[string]int // number of keys by the type of their values
+}
+
+// mstime returns the current unix time in milliseconds.
+func mstime() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
 func newStorage() *storage {
 	e := make(map[string]entry)
 	pq := make(expiryPriorityQueue, 0, 100)
 	heap.Init(&pq)
-	return &storage{sync.RWMutex{}, e, pq}
+	fpq := make(expiryPriorityQueue, 0, 100)
+	heap.Init(&fpq)
+	return &storage{sync.RWMutex{}, e, pq, fpq, newKeyIndex(), make(map[string]int)}
+}
+
+// countKey adds n to the number of keys holding values of the type of v.
+// The caller must hold the mutex.
+func (s *storage) countKey(v interface{}, n int) {
+	t := typeName(v)
+	if s.types[t] += n; s.types[t] == 0 {
+		delete(s.types, t)
+	}
 }
 
 func (s *storage) set(k string, v interface{}) bool {
@@ -31,10 +53,44 @@ func (s *storage) set(k string, v interface{}) bool {
 	}
 
 	s.mutex.Lock()
-	if e, ok := s.entries[k]; ok && e.expiry != nil {
-		s.expiries.del(e.expiry)
+	if e, ok := s.entries[k]; ok {
+		s.dropExpiries(e)
+		s.unlinkSeries(k, e.value)
+		s.countKey(e.value, -1)
+	}
+	s.entries[k] = entry{value: v, atime: mstime()}
+	s.index.add(k)
+	s.countKey(v, 1)
+	s.mutex.Unlock()
+
+	return true
+}
+
+// setKeepTTL works like set but retains the expiry of the key.
+// Expiries of hash fields are retained only if v is a hash.
+func (s *storage) setKeepTTL(k string, v interface{}) bool {
+	if v == nil {
+		s.del(k)
+		return false
 	}
-	s.entries[k] = entry{value: v}
+
+	s.mutex.Lock()
+	e, ok := s.entries[k]
+	if ok {
+		s.unlinkSeries(k, e.value)
+		s.countKey(e.value, -1)
+	}
+	if _, ok := v.(map[string]string); !ok && e.fields != nil {
+		for _, x := range e.fields {
+			s.fieldExpiries.del(x)
+		}
+		e.fields = nil
+	}
+	e.value = v
+	e.atime = mstime()
+	s.entries[k] = e
+	s.index.add(k)
+	s.countKey(v, 1)
 	s.mutex.Unlock()
 
 	return true
@@ -47,27 +103,267 @@ func (s *storage) get(k string) interface{} {
 	return nil
 }
 
+// getEntry returns the entry of the key and updates its access time.
 func (s *storage) getEntry(k string) *entry {
 	s.expireIfNeeded(k)
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	if e, ok := s.entries[k]; ok {
+		e.atime = mstime()
+		s.entries[k] = e
+		if stats.countLookups {
+			stats.keyspaceHits++
+		}
 		return &e
 	}
+	if stats.countLookups {
+		stats.keyspaceMisses++
+	}
 	return nil
 }
 
-func (s *storage) del(k string) {
+// del removes the key. It returns false if the key does not exist.
+func (s *storage) del(k string) bool {
+	_, ok := s.unlink(k)
+	return ok
+}
+
+// unlink removes the key and returns its value.
+func (s *storage) unlink(k string) (interface{}, bool) {
+	s.expireIfNeeded(k)
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if e, ok := s.entries[k]; ok {
-		if e.expiry != nil {
-			s.expiries.del(e.expiry)
+	e, ok := s.entries[k]
+	if !ok {
+		return nil, false
+	}
+	s.dropExpiries(e)
+	s.unlinkSeries(k, e.value)
+	delete(s.entries, k)
+	s.index.remove(k)
+	s.countKey(e.value, -1)
+
+	return e.value, true
+}
+
+// rename moves the value of src along with its expiries to dst replacing
+// the value of dst. Compaction rules of a time series follow it.
+// It returns false if src does not exist.
+func (s *storage) rename(src string, dst string) bool {
+	s.expireIfNeeded(src)
+	s.expireIfNeeded(dst)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[src]
+	if !ok {
+		return false
+	}
+	if src == dst {
+		return true
+	}
+
+	if old, ok := s.entries[dst]; ok {
+		s.dropExpiries(old)
+		s.unlinkSeries(dst, old.value)
+		s.countKey(old.value, -1)
+	}
+	delete(s.entries, src)
+	s.index.remove(src)
+
+	s.renameSeries(src, dst, e.value)
+	if e.expiry != nil {
+		e.expiry.key = dst
+	}
+	for _, x := range e.fields {
+		x.key = dst
+	}
+	s.entries[dst] = e
+	s.index.add(dst)
+
+	return true
+}
+
+// copy stores a deep copy of the value of src along with its expiries
+// at dst of the database d. Unless replace is set, an existing dst is left
+// intact. It returns false if nothing was copied.
+func (s *storage) copy(src string, d *storage, dst string, replace bool) bool {
+	s.expireIfNeeded(src)
+	d.expireIfNeeded(dst)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if d != s {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+	}
+
+	e, ok := s.entries[src]
+	if !ok {
+		return false
+	}
+	if old, ok := d.entries[dst]; ok {
+		if !replace {
+			return false
 		}
-		delete(s.entries, k)
+		d.dropExpiries(old)
+		d.unlinkSeries(dst, old.value)
+		d.countKey(old.value, -1)
+	}
+
+	c := entry{value: copyValue(e.value), atime: mstime()}
+	if e.expiry != nil {
+		c.expiry = newExpiry(dst, e.expiry.ttl)
+		d.expiries.add(c.expiry)
+	}
+	if len(e.fields) > 0 {
+		c.fields = make(map[string]*expiry, len(e.fields))
+		for f, x := range e.fields {
+			c.fields[f] = newFieldExpiry(dst, f, x.ttl)
+			d.fieldExpiries.add(c.fields[f])
+		}
+	}
+	d.entries[dst] = c
+	d.index.add(dst)
+	d.countKey(c.value, 1)
+
+	return true
+}
+
+// move moves the key along with its expiries to the database d. Compaction
+// rules of a time series are dropped, as they link keys of one database.
+// It returns false if the key does not exist or d already holds it.
+func (s *storage) move(k string, d *storage) bool {
+	s.expireIfNeeded(k)
+	d.expireIfNeeded(k)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	e, ok := s.entries[k]
+	if !ok {
+		return false
+	}
+	if _, ok := d.entries[k]; ok {
+		return false
+	}
+
+	s.dropExpiries(e)
+	s.unlinkSeries(k, e.value)
+	delete(s.entries, k)
+	s.index.remove(k)
+	s.countKey(e.value, -1)
+
+	if e.expiry != nil {
+		d.expiries.add(e.expiry)
+	}
+	for _, x := range e.fields {
+		d.fieldExpiries.add(x)
+	}
+	d.entries[k] = e
+	d.index.add(k)
+	d.countKey(e.value, 1)
+
+	return true
+}
+
+// flush removes all keys.
+func (s *storage) flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries = make(map[string]entry)
+	s.expiries = make(expiryPriorityQueue, 0, 100)
+	s.fieldExpiries = make(expiryPriorityQueue, 0, 100)
+	s.index = newKeyIndex()
+	s.types = make(map[string]int)
+}
+
+// randomKey returns a random key which is not expired
+// or an empty string if there are no such keys.
+func (s *storage) randomKey() string {
+	now := mstime()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.index.size == 0 {
+		return ""
+	}
+
+	// at least one of eight buckets holds a key, so it takes a few tries to hit one
+	for tries := 0; tries < 100; tries++ {
+		b := s.index.buckets[rand.Intn(len(s.index.buckets))]
+		if len(b) == 0 {
+			continue
+		}
+
+		n := rand.Intn(len(b))
+		for k := range b {
+			if n > 0 {
+				n--
+				continue
+			}
+			if e := s.entries[k]; e.expiry == nil || e.expiry.ttl > now {
+				return k
+			}
+			break
+		}
+	}
+
+	// too many keys are expired
+	for k, e := range s.entries {
+		if e.expiry == nil || e.expiry.ttl > now {
+			return k
+		}
+	}
+	return ""
+}
+
+// sampleKeys returns up to n random keys which are not expired. If volatile
+// is set, only keys with an expiry are sampled. A key may be returned twice.
+func (s *storage) sampleKeys(n int, volatile bool) []string {
+	if !volatile {
+		keys := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			k := s.randomKey()
+			if k == "" {
+				break
+			}
+			keys = append(keys, k)
+		}
+		return keys
+	}
+
+	now := mstime()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]string, 0, n)
+	for i := 0; i < n && len(s.expiries) > 0; i++ {
+		if x := s.expiries[rand.Intn(len(s.expiries))]; x.ttl > now {
+			keys = append(keys, x.key)
+		}
+	}
+	return keys
+}
+
+// dropExpiries removes expiries of the entry and its fields from the queues.
+// The caller must hold the mutex.
+func (s *storage) dropExpiries(e entry) {
+	if e.expiry != nil {
+		s.expiries.del(e.expiry)
+	}
+	for _, x := range e.fields {
+		s.fieldExpiries.del(x)
 	}
 }
 
@@ -75,6 +371,7 @@ func (s *storage) exists(k string) bool {
 	return s.get(k) != nil
 }
 
+// setExpire sets a deadline (unix time in milliseconds) on the key.
 func (s *storage) setExpire(k string, ttl int64) {
 	e := s.getEntry(k)
 	if e == nil {
@@ -96,13 +393,168 @@ func (s *storage) setExpire(k string, ttl int64) {
 	s.expireIfNeeded(k)
 }
 
+// persist removes the expiry of the key. It returns false if the key
+// does not exist or has no expiry.
+func (s *storage) persist(k string) bool {
+	s.expireIfNeeded(k)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[k]
+	if !ok || e.expiry == nil {
+		return false
+	}
+	s.expiries.del(e.expiry)
+	e.expiry = nil
+	s.entries[k] = e
+
+	return true
+}
+
 func (s *storage) expireIfNeeded(k string) {
+	expired := false
+
 	s.mutex.Lock()
-	if e, ok := s.entries[k]; ok && e.expiry != nil && e.expiry.ttl < time.Now().Unix() {
-		s.expiries.del(e.expiry)
+	if e, ok := s.entries[k]; ok && e.expiry != nil && e.expiry.ttl <= mstime() {
+		s.dropExpiries(e)
+		s.unlinkSeries(k, e.value)
 		delete(s.entries, k)
+		s.index.remove(k)
+		s.countKey(e.value, -1)
+		stats.expiredKeys++
+		expired = true
 	}
 	s.mutex.Unlock()
+
+	if expired {
+		notifyKeyspaceEvent(notifyExpired, "expired", k, dbIndex(s))
+	}
+}
+
+// setFieldExpire sets a deadline (unix time in milliseconds) on the field f
+// of the hash stored at k. It returns false if there is no such field.
+func (s *storage) setFieldExpire(k string, f string, ttl int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[k]
+	if !ok {
+		return false
+	}
+	h, ok := e.value.(map[string]string)
+	if !ok {
+		return false
+	}
+	if _, ok := h[f]; !ok {
+		return false
+	}
+
+	if x, ok := e.fields[f]; ok {
+		s.fieldExpiries.update(x, ttl)
+		return true
+	}
+
+	if e.fields == nil {
+		e.fields = make(map[string]*expiry)
+		s.entries[k] = e
+	}
+	x := newFieldExpiry(k, f, ttl)
+	e.fields[f] = x
+	s.fieldExpiries.add(x)
+
+	return true
+}
+
+// getFieldExpire returns the deadline of the field f of the hash stored at k.
+// The second value is false if the field has no deadline.
+func (s *storage) getFieldExpire(k string, f string) (int64, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if x, ok := s.entries[k].fields[f]; ok {
+		return x.ttl, true
+	}
+	return 0, false
+}
+
+// persistField removes the deadline of the field f of the hash stored at k.
+// It returns false if the field has no deadline.
+func (s *storage) persistField(k string, f string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	x, ok := s.entries[k].fields[f]
+	if !ok {
+		return false
+	}
+	s.fieldExpiries.del(x)
+	delete(s.entries[k].fields, f)
+
+	return true
+}
+
+// expireFieldsIfNeeded removes fields of the hash stored at k whose deadline
+// has passed. The key is removed as well if no fields are left.
+func (s *storage) expireFieldsIfNeeded(k string) {
+	now := mstime()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[k]
+	if !ok || len(e.fields) == 0 {
+		return
+	}
+
+	h := e.value.(map[string]string)
+	expired := false
+	for f, x := range e.fields {
+		if x.ttl <= now {
+			s.fieldExpiries.del(x)
+			delete(e.fields, f)
+			delete(h, f)
+			expired = true
+		}
+	}
+	if expired {
+		notifyKeyspaceEvent(notifyExpired, "hexpired", k, dbIndex(s))
+	}
+
+	if len(h) == 0 {
+		s.dropExpiries(e)
+		delete(s.entries, k)
+		s.index.remove(k)
+		s.countKey(h, -1)
+		notifyKeyspaceEvent(notifyGeneric, "del", k, dbIndex(s))
+	}
+}
+
+// scan calls fn for keys of buckets of the index starting from the one
+// pointed by the cursor until about count keys are visited. Expired keys
+// are skipped. It returns the cursor to continue from or 0 once every
+// bucket was visited.
+func (s *storage) scan(cursor uint64, count int, fn func(k string, v interface{})) uint64 {
+	now := mstime()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	visited := 0
+	for buckets := count * 10; buckets > 0; buckets-- {
+		cursor = s.index.scan(cursor, func(k string) {
+			visited++
+			e := s.entries[k]
+			if e.expiry == nil || e.expiry.ttl > now {
+				fn(k, e.value)
+			}
+		})
+		if cursor == 0 || visited >= count {
+			break
+		}
+	}
+
+	return cursor
 }
 
 func (s *storage) len() (entries int, expires int) {
@@ -110,7 +562,7 @@ func (s *storage) len() (entries int, expires int) {
 }
 
 func (s *storage) removeExpired() {
-	now := time.Now().Unix()
+	now := mstime()
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -121,8 +573,39 @@ func (s *storage) removeExpired() {
 		}
 
 		expiry := heap.Pop(&s.expiries).(*expiry)
-		if _, ok := s.entries[expiry.key]; ok {
+		if e, ok := s.entries[expiry.key]; ok {
+			for _, x := range e.fields {
+				s.fieldExpiries.del(x)
+			}
+			s.unlinkSeries(expiry.key, e.value)
+			delete(s.entries, expiry.key)
+			s.index.remove(expiry.key)
+			s.countKey(e.value, -1)
+			stats.expiredKeys++
+			notifyKeyspaceEvent(notifyExpired, "expired", expiry.key, dbIndex(s))
+		}
+	}
+
+	for s.fieldExpiries.Len() > 0 {
+		if s.fieldExpiries[0].ttl > now {
+			break
+		}
+
+		expiry := heap.Pop(&s.fieldExpiries).(*expiry)
+		e, ok := s.entries[expiry.key]
+		if !ok {
+			continue
+		}
+		h := e.value.(map[string]string)
+		delete(h, expiry.field)
+		delete(e.fields, expiry.field)
+		notifyKeyspaceEvent(notifyExpired, "hexpired", expiry.key, dbIndex(s))
+		if len(h) == 0 {
+			s.dropExpiries(e)
 			delete(s.entries, expiry.key)
+			s.index.remove(expiry.key)
+			s.countKey(h, -1)
+			notifyKeyspaceEvent(notifyGeneric, "del", expiry.key, dbIndex(s))
 		}
 	}
 }
@@ -8,6 +8,12 @@ import (
 const succeed = "\u2713"
 const failed = "\u2717"
 
+// execute runs the command against the storage like executeCmd does,
+// but without permission checks and the cmdlog.
+func execute(s *storage, cmd string, argv ...string) (interface{}, error) {
+	return cmdList[cmd].fn(s, &request{cmd: cmd, argv: argv, argc: len(argv), client: &client{}})
+}
+
 func TestNewStorage(t *testing.T) {
 	t.Log("Given the need to test allocation of a new storage")
 	{
@@ -118,3 +124,90 @@ func TestDel(t *testing.T) {
 		t.Errorf("\t%s\tShould have only %q", succeed, keySurvivor)
 	}
 }
+
+func TestFieldExpire(t *testing.T) {
+	key := "hash-key"
+	t.Logf("Given storage with a hash %q of two fields", key)
+	s := newStorage()
+	s.set(key, map[string]string{"short": "1", "long": "2"})
+
+	now := mstime()
+	s.setFieldExpire(key, "short", now-1)
+	s.setFieldExpire(key, "long", now+60000)
+
+	t.Log("\tWhen deadline of one field has passed")
+	s.expireFieldsIfNeeded(key)
+	if h, ok := s.get(key).(map[string]string); ok && len(h) == 1 && h["long"] == "2" {
+		t.Logf("\t%s\tShould keep only the field %q", succeed, "long")
+	} else {
+		t.Errorf("\t%s\tShould keep only the field %q", failed, "long")
+	}
+
+	t.Log("\tWhen deadline of the last field has passed")
+	s.setFieldExpire(key, "long", now-1)
+	s.removeExpired()
+	if !s.exists(key) && s.fieldExpiries.Len() == 0 {
+		t.Logf("\t%s\tShould remove the key", succeed)
+	} else {
+		t.Errorf("\t%s\tShould remove the key", failed)
+	}
+}
+
+// expireNow makes the deadline of the key pass without removing the key.
+func expireNow(s *storage, k string) {
+	s.mutex.Lock()
+	s.expiries.update(s.entries[k].expiry, mstime()-1)
+	s.mutex.Unlock()
+}
+
+func TestRenameCopyMoveExpiry(t *testing.T) {
+	t.Log("Given two storages with keys with deadlines")
+	s, d := newStorage(), newStorage()
+	ttl := mstime() + 60000
+
+	t.Log("\tWhen renaming a key with a deadline")
+	s.set("a", "1")
+	s.setExpire("a", ttl)
+	s.set("b", "2")
+	s.setExpire("b", ttl+1000)
+	if s.rename("a", "b") && !s.exists("a") && s.getEntry("b").expiry.ttl == ttl &&
+		s.getEntry("b").expiry.key == "b" && s.expiries.Len() == 1 {
+		t.Logf("\t%s\tShould move the deadline to the new key and drop the replaced one", succeed)
+	} else {
+		t.Errorf("\t%s\tShould move the deadline to the new key and drop the replaced one", failed)
+	}
+
+	t.Log("\tWhen copying a key with a deadline to another storage")
+	if s.copy("b", d, "c", false) && d.getEntry("c").expiry.ttl == ttl &&
+		d.getEntry("c").expiry != s.getEntry("b").expiry && d.expiries.Len() == 1 && s.expiries.Len() == 1 {
+		t.Logf("\t%s\tShould copy the deadline", succeed)
+	} else {
+		t.Errorf("\t%s\tShould copy the deadline", failed)
+	}
+
+	t.Log("\tWhen moving a key with a deadline to another storage")
+	if s.move("b", d) && !s.exists("b") && d.getEntry("b").expiry.ttl == ttl &&
+		s.expiries.Len() == 0 && d.expiries.Len() == 2 {
+		t.Logf("\t%s\tShould move the deadline", succeed)
+	} else {
+		t.Errorf("\t%s\tShould move the deadline", failed)
+	}
+
+	t.Log("\tWhen the deadline of the source has passed")
+	expireNow(d, "b")
+	if !d.rename("b", "x") && !d.copy("b", s, "x", true) && !d.move("b", s) &&
+		!s.exists("x") && !s.exists("b") && !d.exists("x") {
+		t.Logf("\t%s\tShould not rename, copy or move the key", succeed)
+	} else {
+		t.Errorf("\t%s\tShould not rename, copy or move the key", failed)
+	}
+
+	t.Log("\tWhen the deadline of the destination has passed")
+	s.set("c", "3")
+	expireNow(d, "c")
+	if s.move("c", d) && d.getEntry("c").expiry == nil && d.expiries.Len() == 0 {
+		t.Logf("\t%s\tShould replace the expired key", succeed)
+	} else {
+		t.Errorf("\t%s\tShould replace the expired key", failed)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A bloomFilter is a scalable Bloom filter. It is a chain of fixed size
+// Bloom filters: once the last one holds as many items as it was sized for,
+// a new one with expansion times bigger capacity and tighter error rate is
+// added, so the overall error rate stays within the requested one.
+// Filters are filled deterministically, so replaying the command log
+// rebuilds exactly the same filter.
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+	bloomTighteningRatio  = 0.5
+
+	// filterMaxLayerSize limits memory of a single layer of both filters
+	// (in bytes), so a huge capacity doesn't take the server down.
+	filterMaxLayerSize = 256 << 20
+)
+
+var (
+	// ErrFilterExists ...
+	ErrFilterExists = errors.New("ERR item exists")
+	// ErrFilterFull ...
+	ErrFilterFull = errors.New("ERR filter is full")
+	// ErrFilterTooBig ...
+	ErrFilterTooBig = errors.New("ERR filter would exceed the maximum size")
+)
+
+type bloomLayer struct {
+	bits     []uint64
+	m        uint64 // number of bits
+	k        uint64 // number of hash functions
+	capacity int64
+	count    int64
+}
+
+type bloomFilter struct {
+	layers    []*bloomLayer
+	errorRate float64
+	expansion int64
+}
+
+// bloomLayerBits returns the number of bits of a layer for capacity items.
+func bloomLayerBits(capacity int64, errorRate float64) float64 {
+	return math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+}
+
+// bloomLayerFits reports whether the layer is within filterMaxLayerSize.
+func bloomLayerFits(capacity int64, errorRate float64) bool {
+	return bloomLayerBits(capacity, errorRate) <= filterMaxLayerSize*8
+}
+
+func newBloomLayer(capacity int64, errorRate float64) *bloomLayer {
+	m := uint64(bloomLayerBits(capacity, errorRate))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Ceil(-math.Log2(errorRate)))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloomLayer{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+// bloomHash returns two hashes of the item which are combined to get
+// positions of its bits (double hashing).
+func bloomHash(item string) (uint64, uint64) {
+	b := []byte(item)
+	return murmurHash64A(b, 0xc6a4a793), murmurHash64A(b, 0x5bd1e995) | 1
+}
+
+func (l *bloomLayer) test(h1 uint64, h2 uint64) bool {
+	for i := uint64(0); i < l.k; i++ {
+		pos := (h1 + i*h2) % l.m
+		if l.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) add(h1 uint64, h2 uint64) {
+	for i := uint64(0); i < l.k; i++ {
+		pos := (h1 + i*h2) % l.m
+		l.bits[pos/64] |= 1 << (pos % 64)
+	}
+	l.count++
+}
+
+// newBloomFilter returns a filter. Zero expansion makes it non-scaling.
+func newBloomFilter(capacity int64, errorRate float64, expansion int64) *bloomFilter {
+	return &bloomFilter{
+		layers:    []*bloomLayer{newBloomLayer(capacity, errorRate*bloomTighteningRatio)},
+		errorRate: errorRate,
+		expansion: expansion,
+	}
+}
+
+func (f *bloomFilter) copy() *bloomFilter {
+	c := *f
+	c.layers = make([]*bloomLayer, len(f.layers))
+	for i, l := range f.layers {
+		cl := *l
+		cl.bits = append([]uint64(nil), l.bits...)
+		c.layers[i] = &cl
+	}
+	return &c
+}
+
+func (f *bloomFilter) exists(item string) bool {
+	h1, h2 := bloomHash(item)
+	for _, l := range f.layers {
+		if l.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// add returns true if the item was added and false if it may already exist.
+func (f *bloomFilter) add(item string) (bool, error) {
+	h1, h2 := bloomHash(item)
+	for _, l := range f.layers {
+		if l.test(h1, h2) {
+			return false, nil
+		}
+	}
+
+	last := f.layers[len(f.layers)-1]
+	if last.count >= last.capacity {
+		if f.expansion == 0 {
+			return false, ErrFilterFull
+		}
+		rate := f.errorRate * math.Pow(bloomTighteningRatio, float64(len(f.layers)+1))
+		last = newBloomLayer(last.capacity*f.expansion, rate)
+		f.layers = append(f.layers, last)
+	}
+	last.add(h1, h2)
+
+	return true, nil
+}
+
+// reserve returns an error if the filter can't take n more items, because
+// it is non-scaling and full or a layer it has to add would be too big.
+func (f *bloomFilter) reserve(n int64) error {
+	last := f.layers[len(f.layers)-1]
+	free, capacity, layers := last.capacity-last.count, last.capacity, len(f.layers)
+	for free < n {
+		if f.expansion == 0 {
+			return ErrFilterFull
+		}
+		if capacity > math.MaxInt64/f.expansion {
+			return ErrFilterTooBig
+		}
+		capacity *= f.expansion
+		layers++
+		if !bloomLayerFits(capacity, f.errorRate*math.Pow(bloomTighteningRatio, float64(layers))) {
+			return ErrFilterTooBig
+		}
+		free += capacity
+	}
+	return nil
+}
+
+func (f *bloomFilter) capacity() int64 {
+	var c int64
+	for _, l := range f.layers {
+		c += l.capacity
+	}
+	return c
+}
+
+func (f *bloomFilter) count() int64 {
+	var c int64
+	for _, l := range f.layers {
+		c += l.count
+	}
+	return c
+}
+
+func findBloomEntry(s *storage, k string) (*bloomFilter, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*bloomFilter); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// parseFilterOptions parses EXPANSION and NONSCALING options shared by
+// both filters. Expansion is 0 for a non-scaling filter.
+func parseFilterOptions(argv []string, expansion int64, extra func(opt string, argv []string) (int, error)) (int64, error) {
+	for i := 0; i < len(argv); i++ {
+		switch opt := strings.ToLower(argv[i]); {
+		case opt == "nonscaling":
+			expansion = 0
+		case opt == "expansion" && i+1 < len(argv):
+			n, err := strconv.ParseInt(argv[i+1], 10, 64)
+			if err != nil || n < 1 {
+				return 0, ErrBadArguments
+			}
+			expansion = n
+			i++
+		default:
+			if extra == nil {
+				return 0, ErrBadArguments
+			}
+			n, err := extra(opt, argv[i+1:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return expansion, nil
+}
+
+// BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func bfreserveCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	rate, err := strconv.ParseFloat(r.argv[1], 64)
+	if err != nil || rate <= 0 || rate >= 1 {
+		return nil, ErrBadArguments
+	}
+	capacity, err := strconv.ParseInt(r.argv[2], 10, 64)
+	if err != nil || capacity < 1 {
+		return nil, ErrBadArguments
+	}
+	expansion, err := parseFilterOptions(r.argv[3:], bloomDefaultExpansion, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !bloomLayerFits(capacity, rate*bloomTighteningRatio) {
+		return nil, ErrFilterTooBig
+	}
+
+	if s.get(r.argv[0]) != nil {
+		return nil, ErrFilterExists
+	}
+	s.set(r.argv[0], newBloomFilter(capacity, rate, expansion))
+
+	return 1, nil
+}
+
+// bloomAdd adds items to the filter stored at key creating it with
+// default parameters if necessary.
+func bloomAdd(s *storage, k string, items []string) ([]int, error) {
+	f, err := findBloomEntry(s, k)
+	if err != nil {
+		return nil, err
+	}
+	created := f == nil
+	if created {
+		f = newBloomFilter(bloomDefaultCapacity, bloomDefaultErrorRate, bloomDefaultExpansion)
+	}
+
+	// the filter must take all new items or none of them and a new filter
+	// is stored only once it can take them, since a failed command
+	// is not written to the command log
+	missing := make(map[string]bool, len(items))
+	for _, item := range items {
+		if !f.exists(item) {
+			missing[item] = true
+		}
+	}
+	if err := f.reserve(int64(len(missing))); err != nil {
+		return nil, err
+	}
+	if created {
+		s.set(k, f)
+	}
+
+	res := make([]int, len(items))
+	for i, item := range items {
+		added, err := f.add(item)
+		if err != nil {
+			return nil, err
+		}
+		if added {
+			res[i] = 1
+		}
+	}
+
+	return res, nil
+}
+
+// BF.ADD key item
+// Return value is 1 if the item was added and 0 if it may already exist.
+func bfaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	res, err := bloomAdd(s, r.argv[0], r.argv[1:])
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// BF.MADD key item [item ...]
+// Return value is a list of BF.ADD results for every item.
+func bfmaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	return bloomAdd(s, r.argv[0], r.argv[1:])
+}
+
+// BF.EXISTS key item
+// Return value is 0 if the item definitely does not exist and 1 if it may exist.
+func bfexistsCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findBloomEntry(s, r.argv[0])
+	if err != nil || f == nil {
+		return 0, err
+	}
+
+	if f.exists(r.argv[1]) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// BF.MEXISTS key item [item ...]
+// Return value is a list of BF.EXISTS results for every item.
+func bfmexistsCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findBloomEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]int, r.argc-1)
+	if f == nil {
+		return res, nil
+	}
+	for i, item := range r.argv[1:] {
+		if f.exists(item) {
+			res[i] = 1
+		}
+	}
+
+	return res, nil
+}
+
+type bloomInfoReply struct {
+	Capacity  int64 `json:"capacity"`
+	Items     int64 `json:"items"`
+	Filters   int   `json:"filters"`
+	Expansion int64 `json:"expansion"`
+}
+
+// BF.INFO key
+func bfinfoCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findBloomEntry(s, r.argv[0])
+	if err != nil || f == nil {
+		return nil, err
+	}
+
+	return bloomInfoReply{f.capacity(), f.count(), len(f.layers), f.expansion}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestFilterMaxSize(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+		err  error
+	}{
+		{"bf.reserve", []string{"b", "0.01", "4611686018427387904"}, "null", ErrFilterTooBig},
+		{"bf.reserve", []string{"b", "0.01", "1000000000"}, "null", ErrFilterTooBig},
+		{"bf.reserve", []string{"b", "0.000001", "100000000"}, "null", ErrFilterTooBig},
+		{"bf.reserve", []string{"b", "0.01", "1000"}, "1", nil},
+		{"cf.reserve", []string{"c", "4611686018427387904"}, "null", ErrFilterTooBig},
+		{"cf.reserve", []string{"c", "100000000", "BUCKETSIZE", "1"}, "null", ErrFilterTooBig},
+		{"cf.reserve", []string{"c", "1000"}, "1", nil},
+		{"exists", []string{"b", "c"}, "2", nil},
+	}
+
+	t.Log("Given filters reserved with huge capacities")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %v", i, tt.cmd, tt.argv)
+
+		res, err := execute(s, tt.cmd, tt.argv...)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want && err == tt.err {
+			t.Logf("\t%s\tShould get %s, %v", succeed, tt.want, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %s, %v, got %s, %v", failed, tt.want, tt.err, got, err)
+		}
+	}
+
+	t.Log("Given full filters which would grow too big")
+	{
+		t.Log("\tWhen items are added to a Bloom filter")
+		bf := newBloomFilter(10, 0.01, 1<<40)
+		s.set("full-bloom", bf)
+		items := []string{"full-bloom"}
+		for i := 0; i < 11; i++ {
+			items = append(items, strconv.Itoa(i))
+		}
+		if _, err := execute(s, "bf.madd", items...); err == ErrFilterTooBig && bf.count() == 0 && len(bf.layers) == 1 {
+			t.Logf("\t%s\tShould add none of them", succeed)
+		} else {
+			t.Errorf("\t%s\tShould add none of them, got %v, %d items", failed, err, bf.count())
+		}
+
+		t.Log("\tWhen items are added to a cuckoo filter")
+		cf := newCuckooFilter(1, 1, 1, 1<<40)
+		s.set("full-cuckoo", cf)
+		var err error
+		for i := 0; i < 10 && err == nil; i++ {
+			_, err = execute(s, "cf.add", "full-cuckoo", strconv.Itoa(i))
+		}
+		if err == ErrFilterTooBig && len(cf.layers) == 1 {
+			t.Logf("\t%s\tShould refuse to grow", succeed)
+		} else {
+			t.Errorf("\t%s\tShould refuse to grow, got %v, %d layers", failed, err, len(cf.layers))
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// A cuckooFilter keeps 8-bit fingerprints of items in buckets. Every item
+// has two candidate buckets: i1 derived from its hash and i2 = i1 ^ hash of
+// its fingerprint, so either one can be found from the other. If both are
+// full, a fingerprint is evicted to its alternative bucket and so on up to
+// maxIterations times. Unlike a Bloom filter it supports deletion and its
+// error rate is about 2*bucketSize/255.
+//
+// Like bloomFilter it scales by adding bigger filters once an item can't be
+// inserted, and victims of evictions are chosen deterministically so that
+// replaying the command log rebuilds the same filter.
+const (
+	cuckooDefaultCapacity      = 1024
+	cuckooDefaultBucketSize    = 2
+	cuckooDefaultMaxIterations = 20
+	cuckooDefaultExpansion     = 1
+	cuckooMaxBucketSize        = 255
+	cuckooBucketOverhead       = 24 // bytes of the slice header of a bucket
+)
+
+// ErrFilterNotFound ...
+var ErrFilterNotFound = errors.New("ERR not found")
+
+type cuckooLayer struct {
+	buckets    [][]uint8 // 0 is an empty slot
+	numBuckets uint64    // always a power of two
+}
+
+type cuckooFilter struct {
+	layers        []*cuckooLayer
+	capacity      int64
+	bucketSize    int
+	maxIterations int
+	expansion     int64
+	inserted      int64
+	deleted       int64
+}
+
+func nextPowerOfTwo(n uint64) uint64 {
+	p := uint64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// cuckooLayerBuckets returns the number of buckets of a layer for capacity items.
+func cuckooLayerBuckets(capacity int64, bucketSize int) uint64 {
+	return nextPowerOfTwo(uint64((capacity + int64(bucketSize) - 1) / int64(bucketSize)))
+}
+
+// cuckooLayerFits reports whether the layer is within filterMaxLayerSize.
+func cuckooLayerFits(capacity int64, bucketSize int) bool {
+	if capacity > filterMaxLayerSize {
+		return false
+	}
+	n := cuckooLayerBuckets(capacity, bucketSize)
+	return n*uint64(bucketSize+cuckooBucketOverhead) <= filterMaxLayerSize
+}
+
+func newCuckooLayer(capacity int64, bucketSize int) *cuckooLayer {
+	n := cuckooLayerBuckets(capacity, bucketSize)
+	l := &cuckooLayer{buckets: make([][]uint8, n), numBuckets: n}
+	slots := make([]uint8, n*uint64(bucketSize))
+	for i := range l.buckets {
+		l.buckets[i] = slots[i*bucketSize : (i+1)*bucketSize]
+	}
+	return l
+}
+
+func newCuckooFilter(capacity int64, bucketSize int, maxIterations int, expansion int64) *cuckooFilter {
+	return &cuckooFilter{
+		layers:        []*cuckooLayer{newCuckooLayer(capacity, bucketSize)},
+		capacity:      capacity,
+		bucketSize:    bucketSize,
+		maxIterations: maxIterations,
+		expansion:     expansion,
+	}
+}
+
+func (f *cuckooFilter) copy() *cuckooFilter {
+	c := *f
+	c.layers = make([]*cuckooLayer, len(f.layers))
+	for i, l := range f.layers {
+		cl := &cuckooLayer{buckets: make([][]uint8, l.numBuckets), numBuckets: l.numBuckets}
+		for j, b := range l.buckets {
+			cl.buckets[j] = append([]uint8(nil), b...)
+		}
+		c.layers[i] = cl
+	}
+	return &c
+}
+
+// cuckooHash returns the fingerprint of the item and the hash used
+// to find its first bucket.
+func cuckooHash(item string) (uint8, uint64) {
+	h := murmurHash64A([]byte(item), 0x9747b28c)
+	fp := uint8(h>>56%255) + 1
+	return fp, h
+}
+
+func (l *cuckooLayer) altIndex(i uint64, fp uint8) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & (l.numBuckets - 1)
+}
+
+func (l *cuckooLayer) indexes(fp uint8, h uint64) (uint64, uint64) {
+	i1 := h & (l.numBuckets - 1)
+	return i1, l.altIndex(i1, fp)
+}
+
+func (l *cuckooLayer) count(fp uint8, h uint64) int {
+	i1, i2 := l.indexes(fp, h)
+	n := 0
+	for _, slot := range l.buckets[i1] {
+		if slot == fp {
+			n++
+		}
+	}
+	if i2 != i1 {
+		for _, slot := range l.buckets[i2] {
+			if slot == fp {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (l *cuckooLayer) delete(fp uint8, h uint64) bool {
+	i1, i2 := l.indexes(fp, h)
+	for _, i := range []uint64{i1, i2} {
+		for j, slot := range l.buckets[i] {
+			if slot == fp {
+				l.buckets[i][j] = 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (l *cuckooLayer) place(i uint64, fp uint8) bool {
+	for j, slot := range l.buckets[i] {
+		if slot == 0 {
+			l.buckets[i][j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// insert puts the fingerprint into one of its buckets evicting others
+// if necessary. If it fails, all evictions are undone so the layer
+// is left as it was.
+func (l *cuckooLayer) insert(fp uint8, h uint64, maxIterations int) bool {
+	i1, i2 := l.indexes(fp, h)
+	if l.place(i1, fp) || l.place(i2, fp) {
+		return true
+	}
+
+	type swap struct {
+		i    uint64
+		slot int
+	}
+	swaps := make([]swap, 0, maxIterations)
+
+	i := i2
+	for n := 0; n < maxIterations; n++ {
+		slot := (int(fp) + n) % len(l.buckets[i])
+		fp, l.buckets[i][slot] = l.buckets[i][slot], fp
+		swaps = append(swaps, swap{i, slot})
+
+		i = l.altIndex(i, fp)
+		if l.place(i, fp) {
+			return true
+		}
+	}
+
+	for n := len(swaps) - 1; n >= 0; n-- {
+		sw := swaps[n]
+		fp, l.buckets[sw.i][sw.slot] = l.buckets[sw.i][sw.slot], fp
+	}
+	return false
+}
+
+func (f *cuckooFilter) count(item string) int {
+	fp, h := cuckooHash(item)
+	n := 0
+	for _, l := range f.layers {
+		n += l.count(fp, h)
+	}
+	return n
+}
+
+func (f *cuckooFilter) add(item string) error {
+	fp, h := cuckooHash(item)
+	for _, l := range f.layers {
+		if l.insert(fp, h, f.maxIterations) {
+			f.inserted++
+			return nil
+		}
+	}
+
+	if f.expansion == 0 {
+		return ErrFilterFull
+	}
+
+	capacity := f.capacity
+	for range f.layers {
+		if capacity > math.MaxInt64/f.expansion {
+			return ErrFilterTooBig
+		}
+		capacity *= f.expansion
+	}
+	if !cuckooLayerFits(capacity, f.bucketSize) {
+		return ErrFilterTooBig
+	}
+	l := newCuckooLayer(capacity, f.bucketSize)
+	f.layers = append(f.layers, l)
+	l.insert(fp, h, f.maxIterations)
+	f.inserted++
+
+	return nil
+}
+
+// delete removes one occurrence of the item, newest layers first.
+func (f *cuckooFilter) delete(item string) bool {
+	fp, h := cuckooHash(item)
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		if f.layers[i].delete(fp, h) {
+			f.deleted++
+			return true
+		}
+	}
+	return false
+}
+
+func findCuckooEntry(s *storage, k string) (*cuckooFilter, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*cuckooFilter); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// CF.RESERVE key capacity [BUCKETSIZE bucketsize] [MAXITERATIONS maxiterations]
+// [EXPANSION expansion] [NONSCALING]
+func cfreserveCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	capacity, err := strconv.ParseInt(r.argv[1], 10, 64)
+	if err != nil || capacity < 1 {
+		return nil, ErrBadArguments
+	}
+
+	bucketSize := cuckooDefaultBucketSize
+	maxIterations := cuckooDefaultMaxIterations
+	expansion, err := parseFilterOptions(r.argv[2:], cuckooDefaultExpansion, func(opt string, argv []string) (int, error) {
+		if len(argv) < 1 {
+			return 0, ErrBadArguments
+		}
+		n, err := strconv.Atoi(argv[0])
+		switch {
+		case opt == "bucketsize" && err == nil && n >= 1 && n <= cuckooMaxBucketSize:
+			bucketSize = n
+		case opt == "maxiterations" && err == nil && n >= 1:
+			maxIterations = n
+		default:
+			return 0, ErrBadArguments
+		}
+		return 1, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !cuckooLayerFits(capacity, bucketSize) {
+		return nil, ErrFilterTooBig
+	}
+
+	if s.get(r.argv[0]) != nil {
+		return nil, ErrFilterExists
+	}
+	s.set(r.argv[0], newCuckooFilter(capacity, bucketSize, maxIterations, expansion))
+
+	return 1, nil
+}
+
+// cuckooAdd adds the item to the filter stored at key creating it with
+// default parameters if necessary. With nx the item is added only
+// if it doesn't exist.
+func cuckooAdd(s *storage, k string, item string, nx bool) (interface{}, error) {
+	f, err := findCuckooEntry(s, k)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		f = newCuckooFilter(cuckooDefaultCapacity, cuckooDefaultBucketSize,
+			cuckooDefaultMaxIterations, cuckooDefaultExpansion)
+		s.set(k, f)
+	}
+
+	if nx && f.count(item) > 0 {
+		return 0, nil
+	}
+	if err := f.add(item); err != nil {
+		return nil, err
+	}
+	return 1, nil
+}
+
+// CF.ADD key item
+// Adds the item even if it already exists, so it can be deleted as many
+// times as it was added.
+func cfaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	return cuckooAdd(s, r.argv[0], r.argv[1], false)
+}
+
+// CF.ADDNX key item
+// Return value is 1 if the item was added and 0 if it may already exist.
+func cfaddnxCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	return cuckooAdd(s, r.argv[0], r.argv[1], true)
+}
+
+// CF.EXISTS key item
+// Return value is 0 if the item definitely does not exist and 1 if it may exist.
+func cfexistsCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findCuckooEntry(s, r.argv[0])
+	if err != nil || f == nil {
+		return 0, err
+	}
+
+	if f.count(r.argv[1]) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// CF.COUNT key item
+// Return value is the number of times the item may have been added.
+func cfcountCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findCuckooEntry(s, r.argv[0])
+	if err != nil || f == nil {
+		return 0, err
+	}
+
+	return f.count(r.argv[1]), nil
+}
+
+// CF.DEL key item
+// Deletes one occurrence of the item. Return value is 1 if it was deleted
+// and 0 if it was not found.
+func cfdelCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findCuckooEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrFilterNotFound
+	}
+
+	if f.delete(r.argv[1]) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+type cuckooInfoReply struct {
+	Buckets    uint64 `json:"buckets"`
+	Filters    int    `json:"filters"`
+	Items      int64  `json:"items"`
+	Deleted    int64  `json:"deleted"`
+	BucketSize int    `json:"bucketSize"`
+	Expansion  int64  `json:"expansion"`
+}
+
+// CF.INFO key
+func cfinfoCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	f, err := findCuckooEntry(s, r.argv[0])
+	if err != nil || f == nil {
+		return nil, err
+	}
+
+	var buckets uint64
+	for _, l := range f.layers {
+		buckets += l.numBuckets
+	}
+	return cuckooInfoReply{buckets, len(f.layers), f.inserted - f.deleted, f.deleted, f.bucketSize, f.expansion}, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	t.Log("Given a small cuckoo filter which has to scale")
+	{
+		f := newCuckooFilter(64, 2, 20, 2)
+		n := 1000
+
+		for i := 0; i < n; i++ {
+			if err := f.add(strconv.Itoa(i)); err != nil {
+				t.Fatalf("\t%s\tShould add item %d: %v", failed, i, err)
+			}
+		}
+
+		t.Logf("\tWhen %d items are added", n)
+		{
+			missing := 0
+			for i := 0; i < n; i++ {
+				if f.count(strconv.Itoa(i)) == 0 {
+					missing++
+				}
+			}
+
+			if missing == 0 && len(f.layers) > 1 {
+				t.Logf("\t%s\tShould find every item in %d filters", succeed, len(f.layers))
+			} else {
+				t.Errorf("\t%s\tShould find every item in several filters, %d missing in %d filters", failed, missing, len(f.layers))
+			}
+		}
+
+		t.Log("\tWhen every other item is deleted")
+		{
+			for i := 0; i < n; i += 2 {
+				if !f.delete(strconv.Itoa(i)) {
+					t.Fatalf("\t%s\tShould delete item %d", failed, i)
+				}
+			}
+
+			missing := 0
+			for i := 1; i < n; i += 2 {
+				if f.count(strconv.Itoa(i)) == 0 {
+					missing++
+				}
+			}
+
+			if missing == 0 {
+				t.Logf("\t%s\tShould still find the rest of items", succeed)
+			} else {
+				t.Errorf("\t%s\tShould still find the rest of items, %d missing", failed, missing)
+			}
+		}
+	}
+}
@@ -1,11 +1,27 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // ErrHashEmpty ...
 var ErrHashEmpty = errors.New("Hash is empty")
 
+const (
+	fieldNoSuchField    = -2
+	fieldNoExpiry       = -1
+	fieldConditionUnmet = 0
+	fieldExpireSet      = 1
+	fieldDeleted        = 2
+)
+
 func findHashEntry(s *storage, k string) (map[string]string, error) {
+	s.expireFieldsIfNeeded(k)
+
 	v := s.get(k)
 	if v == nil {
 		return make(map[string]string, 0), nil
@@ -30,7 +46,9 @@ func hsetCommand(s *storage, r *request) (interface{}, error) {
 	}
 
 	h[r.argv[1]] = r.argv[2]
-	s.set(r.argv[0], h)
+	s.setKeepTTL(r.argv[0], h)
+	s.persistField(r.argv[0], r.argv[1])
+	notifyKeyspaceEvent(notifyHash, "hset", r.argv[0], r.db)
 
 	return 1, nil
 }
@@ -128,14 +146,19 @@ func hdelCommand(s *storage, r *request) (interface{}, error) {
 	sln := len(h)
 	for i := 1; i < r.argc; i++ {
 		delete(h, r.argv[i])
+		s.persistField(r.argv[0], r.argv[i])
 	}
 	fln := len(h)
 	deleted = sln - fln
 
+	if deleted > 0 {
+		notifyKeyspaceEvent(notifyHash, "hdel", r.argv[0], r.db)
+	}
 	if fln < 1 {
 		s.del(r.argv[0])
+		notifyKeyspaceEvent(notifyGeneric, "del", r.argv[0], r.db)
 	} else {
-		s.set(r.argv[0], h)
+		s.setKeepTTL(r.argv[0], h)
 	}
 
 	return deleted, nil
@@ -176,3 +199,217 @@ func hlenCommand(s *storage, r *request) (interface{}, error) {
 
 	return len(h), nil
 }
+
+// HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func hexpireCommand(s *storage, r *request) (interface{}, error) {
+	return hexpireGenericCommand(s, r, time.Now(), time.Second)
+}
+
+// HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func hpexpireCommand(s *storage, r *request) (interface{}, error) {
+	return hexpireGenericCommand(s, r, time.Now(), time.Millisecond)
+}
+
+// HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func hexpireatCommand(s *storage, r *request) (interface{}, error) {
+	return hexpireGenericCommand(s, r, time.Unix(0, 0), time.Second)
+}
+
+// HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func hpexpireatCommand(s *storage, r *request) (interface{}, error) {
+	return hexpireGenericCommand(s, r, time.Unix(0, 0), time.Millisecond)
+}
+
+// Return value is a list with a result code for every field.
+// The command is logged as HPEXPIREAT, so cmdlog restores the same deadlines.
+func hexpireGenericCommand(s *storage, r *request, base time.Time, unit time.Duration) (interface{}, error) {
+	if r.argc < 5 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	t, err := strconv.ParseInt(r.argv[1], 10, 64)
+	if err != nil || t < 0 {
+		return nil, ErrBadArguments
+	}
+	if t > math.MaxInt64/int64(unit/time.Millisecond) {
+		return nil, ErrBadArguments
+	}
+	t *= int64(unit / time.Millisecond)
+	from := base.UnixNano() / int64(time.Millisecond)
+	if t > math.MaxInt64-from {
+		return nil, ErrBadArguments
+	}
+	deadline := from + t
+
+	cond := ""
+	i := 2
+	if strings.ToLower(r.argv[i]) != "fields" {
+		cond = strings.ToLower(r.argv[i])
+		if cond != "nx" && cond != "xx" && cond != "gt" && cond != "lt" {
+			return nil, ErrBadArguments
+		}
+		i++
+	}
+
+	fields, err := parseFieldsArgument(r.argv[i:])
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := findHashEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	exists := len(h) > 0
+
+	now := mstime()
+	res := make([]int, len(fields))
+	expired, deleted := false, false
+	for j, f := range fields {
+		if _, ok := h[f]; !ok {
+			res[j] = fieldNoSuchField
+			continue
+		}
+
+		current, ok := s.getFieldExpire(r.argv[0], f)
+		if (cond == "nx" && ok) || (cond == "xx" && !ok) ||
+			(cond == "gt" && (!ok || deadline <= current)) ||
+			(cond == "lt" && ok && deadline >= current) {
+			res[j] = fieldConditionUnmet
+			continue
+		}
+
+		if deadline <= now {
+			delete(h, f)
+			s.persistField(r.argv[0], f)
+			res[j] = fieldDeleted
+			deleted = true
+			continue
+		}
+
+		s.setFieldExpire(r.argv[0], f, deadline)
+		res[j] = fieldExpireSet
+		expired = true
+	}
+
+	if expired {
+		notifyKeyspaceEvent(notifyHash, "hexpire", r.argv[0], r.db)
+	}
+	if deleted {
+		notifyKeyspaceEvent(notifyHash, "hdel", r.argv[0], r.db)
+	}
+	if exists && len(h) < 1 {
+		s.del(r.argv[0])
+		notifyKeyspaceEvent(notifyGeneric, "del", r.argv[0], r.db)
+	}
+	if !expired && !deleted {
+		r.nolog = true
+	}
+
+	argv := []string{r.argv[0], strconv.FormatInt(deadline, 10)}
+	argv = append(argv, r.argv[2:]...)
+	r.rewrite("hpexpireat", argv...)
+
+	return res, nil
+}
+
+// HTTL key FIELDS numfields field [field ...]
+func httlCommand(s *storage, r *request) (interface{}, error) {
+	return httlGenericCommand(s, r, time.Second)
+}
+
+// HPTTL key FIELDS numfields field [field ...]
+func hpttlCommand(s *storage, r *request) (interface{}, error) {
+	return httlGenericCommand(s, r, time.Millisecond)
+}
+
+// Return value is a list with the remaining time to live of every field
+// or a negative result code.
+func httlGenericCommand(s *storage, r *request, unit time.Duration) (interface{}, error) {
+	if r.argc < 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	fields, err := parseFieldsArgument(r.argv[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := findHashEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	now := mstime()
+	ms := int64(unit / time.Millisecond)
+	res := make([]int64, len(fields))
+	for i, f := range fields {
+		if _, ok := h[f]; !ok {
+			res[i] = fieldNoSuchField
+			continue
+		}
+
+		deadline, ok := s.getFieldExpire(r.argv[0], f)
+		if !ok {
+			res[i] = fieldNoExpiry
+			continue
+		}
+		res[i] = (deadline - now + ms/2) / ms
+	}
+
+	return res, nil
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func hpersistCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	fields, err := parseFieldsArgument(r.argv[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := findHashEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]int, len(fields))
+	persisted := false
+	for i, f := range fields {
+		if _, ok := h[f]; !ok {
+			res[i] = fieldNoSuchField
+		} else if s.persistField(r.argv[0], f) {
+			res[i] = fieldExpireSet
+			persisted = true
+		} else {
+			res[i] = fieldNoExpiry
+		}
+	}
+
+	if persisted {
+		notifyKeyspaceEvent(notifyHash, "hpersist", r.argv[0], r.db)
+	}
+
+	return res, nil
+}
+
+// parseFieldsArgument parses the "FIELDS numfields field [field ...]" part
+// of the field expiry commands.
+func parseFieldsArgument(argv []string) ([]string, error) {
+	if len(argv) < 3 || strings.ToLower(argv[0]) != "fields" {
+		return nil, ErrBadArguments
+	}
+
+	n, err := strconv.Atoi(argv[1])
+	if err != nil || n < 1 {
+		return nil, ErrBadArguments
+	}
+	if n != len(argv)-2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	return argv[2:], nil
+}
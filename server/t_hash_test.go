@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHashFieldExpire(t *testing.T) {
+	t.Log("Given a hash with a field")
+	s := newStorage()
+	execute(s, "hset", "h", "f", "v")
+
+	t.Log("\tWhen setting a TTL that doesn't fit into a deadline")
+	for i, argv := range [][]string{
+		{"hexpire", "h", "9223372036854775807", "FIELDS", "1", "f"},
+		{"hpexpire", "h", "9223372036854775807", "FIELDS", "1", "f"},
+		{"hexpireat", "h", "9223372036854775807", "FIELDS", "1", "f"},
+	} {
+		_, err := execute(s, argv[0], argv[1:]...)
+		if err == ErrBadArguments {
+			t.Logf("\t%s\tTest: %d\t%s should fail", succeed, i, argv[0])
+		} else {
+			t.Errorf("\t%s\tTest: %d\t%s should fail, got %v", failed, i, argv[0], err)
+		}
+	}
+	if v, _ := execute(s, "hget", "h", "f"); v == "v" {
+		t.Logf("\t%s\tShould keep the field", succeed)
+	} else {
+		t.Errorf("\t%s\tShould keep the field, got %v", failed, v)
+	}
+
+	t.Log("\tWhen setting a TTL of a missing key")
+	r := &request{cmd: "hexpire", argv: []string{"x", "10", "FIELDS", "1", "f"}, argc: 5, client: &client{}}
+	res, err := hexpireCommand(s, r)
+	if err == nil && res.([]int)[0] == fieldNoSuchField && r.nolog {
+		t.Logf("\t%s\tShould change nothing and not be logged", succeed)
+	} else {
+		t.Errorf("\t%s\tShould change nothing and not be logged, got %v %v %v", failed, res, err, r.nolog)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// HyperLogLog estimates cardinality of a set with standard error of
+// 1.04/sqrt(m) = 0.81% using m = 16384 registers. Every register keeps
+// the longest run of trailing zeros (plus one) seen among hashes of
+// the elements which fall into it.
+//
+// A new HyperLogLog starts in the sparse encoding which keeps only non-zero
+// registers ordered by their indexes, three bytes each: the index (big endian)
+// followed by the value. It is converted into the dense encoding of a byte
+// per register once the sparse one takes more than hllSparseMaxBytes,
+// the same default as in Redis.
+const (
+	hllP              = 14
+	hllQ              = 64 - hllP
+	hllRegisters      = 1 << hllP
+	hllSparseRecord   = 3
+	hllSparseMaxBytes = 3000
+	hllAlphaInf       = 0.5 / math.Ln2
+)
+
+type hyperLogLog struct {
+	sparse []byte
+	dense  []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{}
+}
+
+func (h *hyperLogLog) copy() *hyperLogLog {
+	if !h.isSparse() {
+		return &hyperLogLog{dense: append([]uint8(nil), h.dense...)}
+	}
+	return &hyperLogLog{sparse: append([]byte(nil), h.sparse...)}
+}
+
+func (h *hyperLogLog) isSparse() bool {
+	return h.dense == nil
+}
+
+// sparseLen returns the number of non-zero registers of the sparse encoding.
+func (h *hyperLogLog) sparseLen() int {
+	return len(h.sparse) / hllSparseRecord
+}
+
+// sparseAt returns the index and the value of the j-th non-zero register
+// of the sparse encoding.
+func (h *hyperLogLog) sparseAt(j int) (uint16, uint8) {
+	r := h.sparse[j*hllSparseRecord:]
+	return binary.BigEndian.Uint16(r), r[2]
+}
+
+// sparseSearch returns the offset of the register i in the sparse encoding
+// or the offset to insert it at if the register is zero.
+func (h *hyperLogLog) sparseSearch(i uint16) (int, bool) {
+	n := h.sparseLen()
+	j := sort.Search(n, func(j int) bool {
+		x, _ := h.sparseAt(j)
+		return x >= i
+	})
+	if j == n {
+		return j * hllSparseRecord, false
+	}
+	x, _ := h.sparseAt(j)
+	return j * hllSparseRecord, x == i
+}
+
+func (h *hyperLogLog) get(i uint16) uint8 {
+	if !h.isSparse() {
+		return h.dense[i]
+	}
+	if off, ok := h.sparseSearch(i); ok {
+		return h.sparse[off+2]
+	}
+	return 0
+}
+
+// set updates the register i if v is greater than its current value.
+// It returns true if the register was changed.
+func (h *hyperLogLog) set(i uint16, v uint8) bool {
+	if v <= h.get(i) {
+		return false
+	}
+
+	if !h.isSparse() {
+		h.dense[i] = v
+		return true
+	}
+
+	off, ok := h.sparseSearch(i)
+	if !ok {
+		h.sparse = append(h.sparse, 0, 0, 0)
+		copy(h.sparse[off+hllSparseRecord:], h.sparse[off:])
+		binary.BigEndian.PutUint16(h.sparse[off:], i)
+	}
+	h.sparse[off+2] = v
+	if len(h.sparse) > hllSparseMaxBytes {
+		h.toDense()
+	}
+	return true
+}
+
+func (h *hyperLogLog) toDense() {
+	h.dense = make([]uint8, hllRegisters)
+	for j := 0; j < h.sparseLen(); j++ {
+		i, v := h.sparseAt(j)
+		h.dense[i] = v
+	}
+	h.sparse = nil
+}
+
+// add adds the element and returns true if any register was changed.
+func (h *hyperLogLog) add(element string) bool {
+	x := murmurHash64A([]byte(element), 0xadc83b19)
+	i := uint16(x & (hllRegisters - 1))
+	x >>= hllP
+	x |= 1 << hllQ // makes sure the loop terminates
+	return h.set(i, uint8(bits.TrailingZeros64(x)+1))
+}
+
+// merge sets every register to the maximum of its value and the value
+// of the same register of o.
+func (h *hyperLogLog) merge(o *hyperLogLog) {
+	if o.isSparse() {
+		for j := 0; j < o.sparseLen(); j++ {
+			h.set(o.sparseAt(j))
+		}
+		return
+	}
+
+	if h.isSparse() {
+		h.toDense()
+	}
+	for i, v := range o.dense {
+		if v > h.dense[i] {
+			h.dense[i] = v
+		}
+	}
+}
+
+// count returns estimated cardinality using the improved estimator
+// by Otmar Ertl ("New cardinality estimation algorithms for HyperLogLog
+// sketches"), which doesn't need bias correction for small cardinalities.
+func (h *hyperLogLog) count() int64 {
+	var hist [hllQ + 2]int
+	if h.isSparse() {
+		hist[0] = hllRegisters - h.sparseLen()
+		for j := 0; j < h.sparseLen(); j++ {
+			_, v := h.sparseAt(j)
+			hist[v]++
+		}
+	} else {
+		for _, v := range h.dense {
+			hist[v]++
+		}
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(hist[hllQ+1]))/m)
+	for k := hllQ; k >= 1; k-- {
+		z += float64(hist[k])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(hist[0])/m)
+
+	return int64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zp := z
+		z += x * y
+		y += y
+		if zp == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zp := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zp == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64-bit MurmurHash2 by Austin Appleby.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(key))*m
+
+	n := len(key) / 8
+	for i := 0; i < n; i++ {
+		k := uint64(key[i*8]) | uint64(key[i*8+1])<<8 | uint64(key[i*8+2])<<16 |
+			uint64(key[i*8+3])<<24 | uint64(key[i*8+4])<<32 | uint64(key[i*8+5])<<40 |
+			uint64(key[i*8+6])<<48 | uint64(key[i*8+7])<<56
+
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+	}
+
+	tail := key[n*8:]
+	switch len(tail) {
+	case 7:
+		h ^= uint64(tail[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(tail[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(tail[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(tail[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(tail[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
+
+func findHLLEntry(s *storage, k string) (*hyperLogLog, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*hyperLogLog); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// PFADD key [element ...]
+// Return value is 1 if at least one register was altered (or the key was
+// created) and 0 otherwise.
+func pfaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	h, err := findHLLEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	updated := 0
+	if h == nil {
+		h = newHyperLogLog()
+		s.set(r.argv[0], h)
+		updated = 1
+	}
+
+	for _, e := range r.argv[1:] {
+		if h.add(e) {
+			updated = 1
+		}
+	}
+
+	return updated, nil
+}
+
+// PFCOUNT key [key ...]
+// Return value is the approximated cardinality of the union of HyperLogLogs
+// stored at the keys. Missing keys are treated as empty.
+func pfcountCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	if r.argc == 1 {
+		h, err := findHLLEntry(s, r.argv[0])
+		if err != nil || h == nil {
+			return 0, err
+		}
+		return h.count(), nil
+	}
+
+	u := newHyperLogLog()
+	for _, k := range r.argv {
+		h, err := findHLLEntry(s, k)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			u.merge(h)
+		}
+	}
+
+	return u.count(), nil
+}
+
+// PFMERGE destkey [sourcekey ...]
+// Merges HyperLogLogs stored at source keys and destkey into destkey.
+func pfmergeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	srcs := make([]*hyperLogLog, 0, r.argc)
+	for _, k := range r.argv {
+		h, err := findHLLEntry(s, k)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			srcs = append(srcs, h)
+		}
+	}
+
+	dst := newHyperLogLog()
+	for _, h := range srcs {
+		dst.merge(h)
+	}
+	s.setKeepTTL(r.argv[0], dst)
+
+	return 1, nil
+}
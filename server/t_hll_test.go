@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	tests := []int{0, 1, 100, 2500, 10000, 100000, 1000000}
+
+	t.Log("Given the need to estimate cardinality of sets of different size")
+
+	for i, n := range tests {
+		tf := func(t *testing.T) {
+			t.Logf("\tTest: %d\tWhen adding %d unique elements", i, n)
+
+			h := newHyperLogLog()
+			for j := 0; j < n; j++ {
+				h.add("element:" + strconv.Itoa(j))
+			}
+
+			// 4 standard errors
+			c := h.count()
+			if math.Abs(float64(c)-float64(n)) <= float64(n)*0.0325 {
+				t.Logf("\t%s\tShould estimate %d within 3.25%%, got %d", succeed, n, c)
+			} else {
+				t.Errorf("\t%s\tShould estimate %d within 3.25%%, got %d", failed, n, c)
+			}
+		}
+
+		t.Run(strconv.Itoa(n), tf)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	t.Log("Given two HyperLogLogs of overlapping sets, one sparse and one dense")
+	a, b := newHyperLogLog(), newHyperLogLog()
+	for i := 0; i < 100; i++ {
+		a.add(strconv.Itoa(i))
+	}
+	for i := 50; i < 50000; i++ {
+		b.add(strconv.Itoa(i))
+	}
+
+	if a.isSparse() && !b.isSparse() {
+		t.Logf("\t%s\tShould use sparse and dense encodings", succeed)
+	} else {
+		t.Errorf("\t%s\tShould use sparse and dense encodings", failed)
+	}
+
+	t.Log("\tWhen merging them")
+	a.merge(b)
+	if c := a.count(); math.Abs(float64(c)-50000) <= 50000*0.0325 {
+		t.Logf("\t%s\tShould estimate cardinality of the union, got %d", succeed, c)
+	} else {
+		t.Errorf("\t%s\tShould estimate cardinality of the union, got %d", failed, c)
+	}
+}
+
+func TestHyperLogLogSparse(t *testing.T) {
+	t.Log("Given a sparse HyperLogLog")
+	h := newHyperLogLog()
+	for i := 0; h.isSparse(); i++ {
+		h.add(strconv.Itoa(i))
+
+		for j := 1; j < h.sparseLen(); j++ {
+			prev, _ := h.sparseAt(j - 1)
+			next, _ := h.sparseAt(j)
+			if prev >= next {
+				t.Fatalf("\t%s\tShould keep registers ordered by index", failed)
+			}
+		}
+		if len(h.sparse) > hllSparseMaxBytes {
+			t.Fatalf("\t%s\tShould not grow beyond %d bytes", failed, hllSparseMaxBytes)
+		}
+	}
+	t.Logf("\t%s\tShould keep registers ordered within %d bytes", succeed, hllSparseMaxBytes)
+
+	t.Log("\tWhen converting it into the dense encoding")
+	s := newHyperLogLog()
+	for i := 0; i < 500; i++ {
+		s.add(strconv.Itoa(i))
+	}
+	d := s.copy()
+	d.toDense()
+	ok := s.count() == d.count()
+	for i := 0; i < hllRegisters; i++ {
+		ok = ok && s.get(uint16(i)) == d.get(uint16(i))
+	}
+	if ok {
+		t.Logf("\t%s\tShould keep the registers and the count", succeed)
+	} else {
+		t.Errorf("\t%s\tShould keep the registers and the count", failed)
+	}
+}
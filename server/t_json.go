@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSON documents are kept decoded so a part of a document can be read or
+// changed without rewriting the whole value. Objects are stored as
+// map[string]interface{}, arrays as []interface{} and numbers as json.Number
+// to keep integers exact. Keys of objects are replied in sorted order.
+//
+// Paths follow JSONPath: $ is the root, .name and ['name'] select a member
+// of an object, [n] selects an element of an array (negative indexes are
+// counted from the end), * and [*] select all children and .. descends
+// recursively. Paths which don't start with $ use the legacy syntax (e.g.
+// .a.b or a.b) and select a single value: commands reply the value itself
+// instead of a list of matches and fail if the path does not exist or
+// points to a value of a wrong type.
+
+var (
+	// ErrJSONPath ...
+	ErrJSONPath = errors.New("ERR invalid JSON path")
+	// ErrJSONValue ...
+	ErrJSONValue = errors.New("ERR invalid JSON value")
+	// ErrJSONNoPath ...
+	ErrJSONNoPath = errors.New("ERR path does not exist")
+	// ErrJSONNewAtRoot ...
+	ErrJSONNewAtRoot = errors.New("ERR new objects must be created at the root")
+	// ErrJSONNumberOverflow ...
+	ErrJSONNumberOverflow = errors.New("ERR result is not a finite number")
+)
+
+type jsonDocument struct {
+	root interface{}
+}
+
+// jsonNode is a value matched by a path with functions to replace it
+// and to remove it from its parent.
+type jsonNode struct {
+	value interface{}
+	set   func(interface{})
+	del   func()
+}
+
+// jsonDeleted marks removed elements of arrays until they are compacted,
+// so that indexes of other matched elements stay valid.
+var jsonDeleted = &struct{}{}
+
+const (
+	jsonSegmentKey = iota
+	jsonSegmentIndex
+	jsonSegmentWildcard
+)
+
+type jsonSegment struct {
+	kind      int
+	key       string
+	index     int
+	recursive bool
+}
+
+type jsonPath struct {
+	segments []jsonSegment
+	legacy   bool
+}
+
+func (p *jsonPath) isRoot() bool {
+	return len(p.segments) == 0
+}
+
+func parseJSONPath(v string) (*jsonPath, error) {
+	p := &jsonPath{}
+	if strings.HasPrefix(v, "$") {
+		v = v[1:]
+	} else {
+		p.legacy = true
+		if v == "." {
+			v = ""
+		} else if !strings.HasPrefix(v, ".") && !strings.HasPrefix(v, "[") {
+			v = "." + v
+		}
+	}
+
+	for len(v) > 0 {
+		var seg jsonSegment
+		switch {
+		case strings.HasPrefix(v, ".."):
+			seg.recursive = true
+			v = v[2:]
+		case v[0] == '.':
+			v = v[1:]
+		case v[0] != '[':
+			return nil, ErrJSONPath
+		}
+
+		if len(v) == 0 {
+			return nil, ErrJSONPath
+		}
+
+		if v[0] == '[' {
+			end := strings.IndexByte(v, ']')
+			if end < 0 {
+				return nil, ErrJSONPath
+			}
+			inner := strings.TrimSpace(v[1:end])
+			v = v[end+1:]
+
+			switch {
+			case inner == "*":
+				seg.kind = jsonSegmentWildcard
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg.kind = jsonSegmentKey
+				seg.key = inner[1 : len(inner)-1]
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, ErrJSONPath
+				}
+				seg.kind = jsonSegmentIndex
+				seg.index = i
+			}
+		} else {
+			end := strings.IndexAny(v, ".[")
+			if end < 0 {
+				end = len(v)
+			}
+			name := v[:end]
+			v = v[end:]
+
+			if name == "" {
+				return nil, ErrJSONPath
+			}
+			if name == "*" {
+				seg.kind = jsonSegmentWildcard
+			} else {
+				seg.kind = jsonSegmentKey
+				seg.key = name
+			}
+		}
+
+		p.segments = append(p.segments, seg)
+	}
+
+	return p, nil
+}
+
+// children returns direct children of the node. Members of objects are
+// returned in the order of their keys.
+func (n jsonNode) children() []jsonNode {
+	switch v := n.value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		res := make([]jsonNode, len(keys))
+		for i, k := range keys {
+			res[i] = memberNode(v, k)
+		}
+		return res
+	case []interface{}:
+		res := make([]jsonNode, len(v))
+		for i := range v {
+			res[i] = elementNode(v, i)
+		}
+		return res
+	}
+	return nil
+}
+
+// descendants returns the node itself and all nodes below it.
+func (n jsonNode) descendants() []jsonNode {
+	res := []jsonNode{n}
+	for _, c := range n.children() {
+		res = append(res, c.descendants()...)
+	}
+	return res
+}
+
+func memberNode(m map[string]interface{}, k string) jsonNode {
+	return jsonNode{
+		value: m[k],
+		set:   func(v interface{}) { m[k] = v },
+		del:   func() { delete(m, k) },
+	}
+}
+
+func elementNode(a []interface{}, i int) jsonNode {
+	return jsonNode{
+		value: a[i],
+		set:   func(v interface{}) { a[i] = v },
+		del:   func() { a[i] = jsonDeleted },
+	}
+}
+
+// match returns children of the node selected by the segment.
+func (n jsonNode) match(seg jsonSegment) []jsonNode {
+	switch seg.kind {
+	case jsonSegmentWildcard:
+		return n.children()
+	case jsonSegmentKey:
+		if m, ok := n.value.(map[string]interface{}); ok {
+			if _, ok := m[seg.key]; ok {
+				return []jsonNode{memberNode(m, seg.key)}
+			}
+		}
+	case jsonSegmentIndex:
+		if a, ok := n.value.([]interface{}); ok {
+			i := seg.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				return []jsonNode{elementNode(a, i)}
+			}
+		}
+	}
+	return nil
+}
+
+func (d *jsonDocument) copy() *jsonDocument {
+	return &jsonDocument{root: copyJSON(d.root)}
+}
+
+func copyJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			c[k] = copyJSON(x)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, x := range v {
+			c[i] = copyJSON(x)
+		}
+		return c
+	}
+	return v
+}
+
+func (d *jsonDocument) rootNode() jsonNode {
+	return jsonNode{
+		value: d.root,
+		set:   func(v interface{}) { d.root = v },
+	}
+}
+
+func selectNodes(nodes []jsonNode, segments []jsonSegment) []jsonNode {
+	for _, seg := range segments {
+		var next []jsonNode
+		for _, n := range nodes {
+			if seg.recursive {
+				for _, d := range n.descendants() {
+					next = append(next, d.match(seg)...)
+				}
+			} else {
+				next = append(next, n.match(seg)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// find returns all nodes matched by the path.
+func (d *jsonDocument) find(p *jsonPath) []jsonNode {
+	return selectNodes([]jsonNode{d.rootNode()}, p.segments)
+}
+
+// compactJSON drops elements of arrays marked as deleted.
+func compactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, c := range v {
+			v[k] = compactJSON(c)
+		}
+		return v
+	case []interface{}:
+		res := v[:0]
+		for _, c := range v {
+			if c != jsonDeleted {
+				res = append(res, compactJSON(c))
+			}
+		}
+		return res
+	}
+	return v
+}
+
+func parseJSONValue(v string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.UseNumber()
+
+	var res interface{}
+	if err := dec.Decode(&res); err != nil {
+		return nil, ErrJSONValue
+	}
+	if dec.More() {
+		return nil, ErrJSONValue
+	}
+	return res, nil
+}
+
+func formatJSONValue(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func findJSONEntry(s *storage, k string) (*jsonDocument, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*jsonDocument); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// JSON.SET key path value [NX | XX]
+// Sets the value at the path. A new key can be created at the root only.
+// A missing member is added if its parent object exists. Return value is
+// 1 or nil if nothing was set.
+func jsonsetCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 && r.argc != 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	var nx, xx bool
+	if r.argc == 4 {
+		switch strings.ToLower(r.argv[3]) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	p, err := parseJSONPath(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseJSONValue(r.argv[2])
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := findJSONEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		if !p.isRoot() {
+			return nil, ErrJSONNewAtRoot
+		}
+		if xx {
+			return nil, nil
+		}
+		s.set(r.argv[0], &jsonDocument{root: value})
+		return 1, nil
+	}
+
+	nodes := d.find(p)
+	if nx && len(nodes) > 0 || xx && len(nodes) == 0 {
+		return nil, nil
+	}
+
+	// only a member of an object can be created
+	if len(nodes) == 0 {
+		last := p.segments[len(p.segments)-1]
+		if last.kind != jsonSegmentKey || last.recursive {
+			return nil, nil
+		}
+		parents := selectNodes([]jsonNode{d.rootNode()}, p.segments[:len(p.segments)-1])
+		for _, n := range parents {
+			if m, ok := n.value.(map[string]interface{}); ok {
+				nodes = append(nodes, memberNode(m, last.key))
+			}
+		}
+		if len(nodes) == 0 {
+			return nil, nil
+		}
+	}
+
+	for i, n := range nodes {
+		if i == 0 {
+			n.set(value)
+			continue
+		}
+		// every match gets its own copy of the value
+		c, _ := parseJSONValue(r.argv[2])
+		n.set(c)
+	}
+
+	return 1, nil
+}
+
+// JSON.GET key [path [path ...]]
+// Return value is the JSON text of the value at the path (the root by default)
+// or, for a JSONPath, of the list of all matching values. With several paths
+// it is an object which maps paths to values.
+func jsongetCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	paths := r.argv[1:]
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	parsed := make([]*jsonPath, len(paths))
+	for i, v := range paths {
+		p, err := parseJSONPath(v)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = p
+	}
+
+	d, err := findJSONEntry(s, r.argv[0])
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, len(parsed))
+	for i, p := range parsed {
+		nodes := d.find(p)
+		if p.legacy {
+			if len(nodes) == 0 {
+				return nil, ErrJSONNoPath
+			}
+			results[i] = nodes[0].value
+			continue
+		}
+
+		values := make([]interface{}, len(nodes))
+		for j, n := range nodes {
+			values[j] = n.value
+		}
+		results[i] = values
+	}
+
+	if len(results) == 1 {
+		return formatJSONValue(results[0])
+	}
+
+	res := make(map[string]interface{}, len(paths))
+	for i, v := range paths {
+		res[v] = results[i]
+	}
+	return formatJSONValue(res)
+}
+
+// JSON.DEL key [path]
+// Return value is the number of deleted values. Deleting the root deletes the key.
+func jsondelCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 && r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	path := "$"
+	if r.argc == 2 {
+		path = r.argv[1]
+	}
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := findJSONEntry(s, r.argv[0])
+	if err != nil || d == nil {
+		return 0, err
+	}
+
+	if p.isRoot() {
+		s.del(r.argv[0])
+		return 1, nil
+	}
+
+	nodes := d.find(p)
+	for _, n := range nodes {
+		n.del()
+	}
+	d.root = compactJSON(d.root)
+
+	return len(nodes), nil
+}
+
+// addJSONNumbers returns the sum keeping it an integer if both numbers are integers.
+func addJSONNumbers(a json.Number, b json.Number) (json.Number, error) {
+	x, xerr := a.Int64()
+	y, yerr := b.Int64()
+	if xerr == nil && yerr == nil {
+		sum := x + y
+		// no overflow if the signs of the operands differ or match the sign of the sum
+		if (x >= 0) != (y >= 0) || (sum >= 0) == (x >= 0) {
+			return json.Number(strconv.FormatInt(sum, 10)), nil
+		}
+	}
+
+	f, _ := a.Float64()
+	g, _ := b.Float64()
+	sum := f + g
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", ErrJSONNumberOverflow
+	}
+	if sum == math.Trunc(sum) && math.Abs(sum) < 1e15 {
+		return json.Number(strconv.FormatFloat(sum, 'f', 1, 64)), nil
+	}
+	return json.Number(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}
+
+// JSON.NUMINCRBY key path value
+// Return value is the JSON text of the new value or, for a JSONPath, of
+// the list of new values with null for matches which aren't numbers.
+func jsonnumincrbyCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	p, err := parseJSONPath(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseJSONValue(r.argv[2])
+	if err != nil {
+		return nil, err
+	}
+	incr, ok := v.(json.Number)
+	if !ok {
+		return nil, ErrNotFloat
+	}
+
+	d, err := findJSONEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrJSONNoPath
+	}
+
+	nodes := d.find(p)
+	if p.legacy && len(nodes) == 0 {
+		return nil, ErrJSONNoPath
+	}
+
+	// compute everything first so an overflow leaves the document intact
+	values := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		num, ok := n.value.(json.Number)
+		if !ok {
+			if p.legacy {
+				return nil, ErrOperationAgainstWrongType
+			}
+			continue
+		}
+		if values[i], err = addJSONNumbers(num, incr); err != nil {
+			return nil, err
+		}
+	}
+	for i, n := range nodes {
+		if values[i] != nil {
+			n.set(values[i])
+		}
+	}
+
+	if p.legacy {
+		return formatJSONValue(values[0])
+	}
+	return formatJSONValue(values)
+}
+
+// JSON.ARRAPPEND key path value [value ...]
+// Return value is the new length of the array or, for a JSONPath, the list
+// of new lengths with nil for matches which aren't arrays.
+func jsonarrappendCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	p, err := parseJSONPath(r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r.argv[2:] {
+		if _, err := parseJSONValue(v); err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := findJSONEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrJSONNoPath
+	}
+
+	nodes := d.find(p)
+	if p.legacy {
+		if len(nodes) == 0 {
+			return nil, ErrJSONNoPath
+		}
+		if _, ok := nodes[0].value.([]interface{}); !ok {
+			return nil, ErrOperationAgainstWrongType
+		}
+		nodes = nodes[:1]
+	}
+
+	// nested arrays go after their parents, so they are grown first to keep
+	// parents from holding stale copies of them
+	res := make([]interface{}, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := nodes[i]
+		a, ok := n.value.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, v := range r.argv[2:] {
+			e, _ := parseJSONValue(v)
+			a = append(a, e)
+		}
+		n.set(a)
+		res[i] = len(a)
+	}
+
+	if p.legacy {
+		return res[0], nil
+	}
+	return res, nil
+}
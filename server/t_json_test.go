@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	d := &jsonDocument{}
+	d.root, _ = parseJSONValue(`{"a":{"b":1,"c":[1,2,{"b":3}]},"b":"x"}`)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$", `[{"a":{"b":1,"c":[1,2,{"b":3}]},"b":"x"}]`},
+		{"$.a.b", `[1]`},
+		{"$['a'].c[-1]", `[{"b":3}]`},
+		{"$.a.c[*]", `[1,2,{"b":3}]`},
+		{"$..b", `["x",1,3]`},
+		{"$.a.missing", `[]`},
+		{".a.c[0]", `[1]`},
+		{"a.c", `[[1,2,{"b":3}]]`},
+	}
+
+	t.Log("Given a JSON document which should be queried by paths")
+
+	for i, tt := range tests {
+		tf := func(t *testing.T) {
+			t.Logf("\tTest: %d\tWhen selecting values by %q", i, tt.path)
+
+			p, err := parseJSONPath(tt.path)
+			if err != nil {
+				t.Fatalf("\t%s\tShould parse the path: %v", failed, err)
+			}
+
+			values := []interface{}{}
+			for _, n := range d.find(p) {
+				values = append(values, n.value)
+			}
+
+			if got, _ := formatJSONValue(values); got == tt.want {
+				t.Logf("\t%s\tShould select %s", succeed, tt.want)
+			} else {
+				t.Errorf("\t%s\tShould select %s, got %s", failed, tt.want, got)
+			}
+		}
+
+		t.Run(tt.path, tf)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -57,12 +58,15 @@ func pushGenericCommand(s *storage, r *request, where int) (int, error) {
 	}
 
 	if where == listHead {
-		l = append(r.argv[1:], l...)
+		// copy arguments, the list must not share the array with the request
+		// which may still be written to the cmdlog
+		l = append(append([]string(nil), r.argv[1:]...), l...)
 	} else {
 		l = append(l, r.argv[1:]...)
 	}
 
 	s.set(r.argv[0], l)
+	notifyKeyspaceEvent(notifyList, strings.ToLower(r.cmd), r.argv[0], r.db)
 
 	return len(l), nil
 }
@@ -167,6 +171,7 @@ func lsetCommand(s *storage, r *request) (interface{}, error) {
 
 	list[idx] = r.argv[2]
 	s.set(r.argv[0], list)
+	notifyKeyspaceEvent(notifyList, "lset", r.argv[0], r.db)
 
 	return 1, nil
 }
@@ -201,10 +206,12 @@ func popGenericCommand(s *storage, r *request, where int) (interface{}, error) {
 		x, list = list[len(list)-1], list[:len(list)-1]
 	}
 
+	notifyKeyspaceEvent(notifyList, strings.ToLower(r.cmd), r.argv[0], r.db)
 	if len(list) > 0 {
 		s.set(r.argv[0], list)
 	} else {
 		s.del(r.argv[0])
+		notifyKeyspaceEvent(notifyGeneric, "del", r.argv[0], r.db)
 	}
 
 	return x, nil
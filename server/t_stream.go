@@ -0,0 +1,1340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStreamID ...
+	ErrStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+	// ErrStreamIDTooSmall ...
+	ErrStreamIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	// ErrStreamNoGroup ...
+	ErrStreamNoGroup = errors.New("NOGROUP No such key or consumer group")
+	// ErrStreamGroupExists ...
+	ErrStreamGroupExists = errors.New("BUSYGROUP Consumer Group name already exists")
+
+	// streamReady is signaled every time new entries are added to a stream,
+	// so blocked XREAD and XREADGROUP commands could check their streams again.
+	streamReady = sync.NewCond(&execMutex)
+)
+
+// A streamID identifies an entry of a stream. It consists of the unix time
+// in milliseconds when the entry was added and a sequence number
+// of the entry within the same millisecond.
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || id.ms == o.ms && id.seq < o.seq
+}
+
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses an ID in the form ms-seq or ms. In the second form
+// seq is set to missingSeq.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	var id streamID
+	var err error
+
+	parts := strings.SplitN(s, "-", 2)
+	if id.ms, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return id, ErrStreamID
+	}
+	id.seq = missingSeq
+	if len(parts) == 2 {
+		if id.seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+			return id, ErrStreamID
+		}
+	}
+
+	return id, nil
+}
+
+// parseRangeID parses a start or an end of a range which could be also
+// "-" for the smallest ID, "+" for the greatest one or an ID prefixed
+// with "(" to exclude it from the range.
+func parseRangeID(s string, isEnd bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+
+	missingSeq := uint64(0)
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+
+	if !strings.HasPrefix(s, "(") {
+		return parseStreamID(s, missingSeq)
+	}
+
+	id, err := parseStreamID(s[1:], missingSeq)
+	if err != nil {
+		return id, err
+	}
+	ok := false
+	if isEnd {
+		id, ok = id.prev()
+	} else {
+		id, ok = id.next()
+	}
+	if !ok {
+		return id, ErrStreamID
+	}
+	return id, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string // field value pairs
+}
+
+// A streamPending is an entry of a pending entries list, which was delivered
+// to a consumer of a group but was not acknowledged yet.
+type streamPending struct {
+	consumer      string
+	deliveryTime  int64 // unix time in milliseconds
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	seenTime int64 // unix time in milliseconds
+	pending  int
+}
+
+type streamGroup struct {
+	lastID    streamID
+	pending   map[streamID]*streamPending
+	consumers map[string]*streamConsumer
+}
+
+func newStreamGroup(lastID streamID) *streamGroup {
+	return &streamGroup{
+		lastID:    lastID,
+		pending:   make(map[streamID]*streamPending),
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+// consumer returns the consumer with the given name creating it if needed.
+func (g *streamGroup) consumer(name string) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &streamConsumer{}
+		g.consumers[name] = c
+	}
+	c.seenTime = mstime()
+	return c
+}
+
+// pendingIDs returns IDs of the pending entries in ascending order.
+func (g *streamGroup) pendingIDs() []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// deliver adds the entry to the pending entries list of the consumer.
+// If the entry is already pending its delivery counter is incremented.
+func (g *streamGroup) deliver(id streamID, consumer string, now int64) *streamPending {
+	p, ok := g.pending[id]
+	if !ok {
+		p = &streamPending{consumer: consumer}
+		g.pending[id] = p
+		g.consumer(consumer).pending++
+	} else if p.consumer != consumer {
+		g.consumer(p.consumer).pending--
+		g.consumer(consumer).pending++
+		p.consumer = consumer
+	}
+	p.deliveryTime = now
+	p.deliveryCount++
+	return p
+}
+
+func (g *streamGroup) ack(id streamID) bool {
+	p, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	if c, ok := g.consumers[p.consumer]; ok {
+		c.pending--
+	}
+	delete(g.pending, id)
+	return true
+}
+
+type stream struct {
+	entries []streamEntry // sorted by ID
+	lastID  streamID
+	groups  map[string]*streamGroup
+
+	// trimmed is the number of entries trimmed off the head of the entries
+	// array, they are dropped once the array is compacted
+	trimmed int
+}
+
+// streamNodeEntries is the number of entries approximate trimming
+// removes at once, the same as the default size of stream nodes in Redis.
+const streamNodeEntries = 100
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*streamGroup)}
+}
+
+// copy returns a deep copy of the stream. Fields of entries are shared
+// since they are never modified.
+func (st *stream) copy() *stream {
+	c := &stream{
+		entries: append([]streamEntry(nil), st.entries...),
+		lastID:  st.lastID,
+		groups:  make(map[string]*streamGroup, len(st.groups)),
+	}
+	for name, g := range st.groups {
+		cg := newStreamGroup(g.lastID)
+		for id, p := range g.pending {
+			pc := *p
+			cg.pending[id] = &pc
+		}
+		for name, consumer := range g.consumers {
+			cc := *consumer
+			cg.consumers[name] = &cc
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
+
+// nextID generates an ID for a new entry. The ID is based on the current time
+// unless the clock went backwards or ms was set explicitly.
+func (st *stream) nextID(ms *uint64) (streamID, error) {
+	if ms != nil {
+		if *ms < st.lastID.ms {
+			return streamID{}, ErrStreamIDTooSmall
+		}
+		if *ms > st.lastID.ms {
+			return streamID{*ms, 0}, nil
+		}
+	} else if now := uint64(mstime()); now > st.lastID.ms {
+		return streamID{now, 0}, nil
+	}
+
+	id, ok := st.lastID.next()
+	if !ok || (ms != nil && id.ms != *ms) {
+		return streamID{}, ErrStreamIDTooSmall
+	}
+	return id, nil
+}
+
+func (st *stream) add(id streamID, fields []string) {
+	st.entries = append(st.entries, streamEntry{id, fields})
+	st.lastID = id
+}
+
+// search returns the index of the first entry with ID not less than id.
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+func (st *stream) find(id streamID) (streamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// rangeEntries returns up to count entries between start and end (both
+// inclusive) in ascending or descending order. Zero count means no limit.
+func (st *stream) rangeEntries(start streamID, end streamID, count int, rev bool) []streamEntry {
+	if end.less(start) {
+		return nil
+	}
+
+	i, j := st.search(start), st.search(end)
+	if j < len(st.entries) && st.entries[j].id == end {
+		j++
+	}
+
+	res := make([]streamEntry, 0, j-i)
+	if rev {
+		for k := j - 1; k >= i && (count == 0 || len(res) < count); k-- {
+			res = append(res, st.entries[k])
+		}
+	} else {
+		for k := i; k < j && (count == 0 || len(res) < count); k++ {
+			res = append(res, st.entries[k])
+		}
+	}
+	return res
+}
+
+// trim removes the oldest entries so only maxlen entries are left.
+// Approximate trimming removes them in batches of streamNodeEntries only,
+// so up to streamNodeEntries-1 extra entries are kept. The entries are
+// resliced and the array is compacted once its trimmed head is longer
+// than the entries left. It returns the number of removed entries.
+func (st *stream) trim(maxlen int, approx bool) int {
+	n := len(st.entries) - maxlen
+	if approx && n > 0 {
+		n -= n % streamNodeEntries
+	}
+	if n <= 0 {
+		return 0
+	}
+
+	// fields of trimmed entries are released before the array is compacted
+	for i := range st.entries[:n] {
+		st.entries[i] = streamEntry{}
+	}
+	st.entries = st.entries[n:]
+	st.trimmed += n
+	if st.trimmed > len(st.entries) {
+		st.entries = append(make([]streamEntry, 0, len(st.entries)+streamNodeEntries), st.entries...)
+		st.trimmed = 0
+	}
+	return n
+}
+
+func (st *stream) del(id streamID) bool {
+	i := st.search(id)
+	if i == len(st.entries) || st.entries[i].id != id {
+		return false
+	}
+	st.entries = append(st.entries[:i], st.entries[i+1:]...)
+	return true
+}
+
+// streamEntryReply is the representation of an entry in command replies.
+// Fields are nil for entries which were deleted while still pending.
+type streamEntryReply struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type streamReply struct {
+	Stream  string             `json:"stream"`
+	Entries []streamEntryReply `json:"entries"`
+}
+
+func replyEntries(entries []streamEntry) []streamEntryReply {
+	res := make([]streamEntryReply, len(entries))
+	for i, e := range entries {
+		res[i] = streamEntryReply{e.id.String(), e.fields}
+	}
+	return res
+}
+
+func findStreamEntry(s *storage, k string) (*stream, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*stream); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// findStreamGroup returns the stream stored at k and its consumer group.
+func findStreamGroup(s *storage, k string, group string) (*stream, *streamGroup, error) {
+	st, err := findStreamEntry(s, k)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		return nil, nil, ErrStreamNoGroup
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return nil, nil, ErrStreamNoGroup
+	}
+	return st, g, nil
+}
+
+// parseMaxLen parses "MAXLEN [= | ~] threshold" option starting at argv[i].
+// It returns the threshold, whether trimming is approximate and the index
+// of the next argument.
+func parseMaxLen(argv []string, i int) (int, bool, int, error) {
+	i++
+	approx := false
+	if i < len(argv) && (argv[i] == "=" || argv[i] == "~") {
+		approx = argv[i] == "~"
+		i++
+	}
+	if i >= len(argv) {
+		return 0, false, i, ErrBadArguments
+	}
+	n, err := strconv.Atoi(argv[i])
+	if err != nil || n < 0 {
+		return 0, false, i, ErrBadArguments
+	}
+	return n, approx, i + 1, nil
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN [= | ~] threshold] <* | id> field value [field value ...]
+// Return value is the ID of the added entry. The command is logged with
+// the generated ID, so cmdlog restores entries with the same IDs.
+func xaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	nomkstream := false
+	maxlen, approx := -1, false
+	i := 1
+	for ; i < r.argc; i++ {
+		switch strings.ToLower(r.argv[i]) {
+		case "nomkstream":
+			nomkstream = true
+			continue
+		case "maxlen":
+			n, a, next, err := parseMaxLen(r.argv, i)
+			if err != nil {
+				return nil, err
+			}
+			maxlen, approx = n, a
+			i = next - 1
+			continue
+		}
+		break
+	}
+	if i >= r.argc || (r.argc-i-1) < 2 || (r.argc-i-1)%2 != 0 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	st, err := findStreamEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		if nomkstream {
+			return nil, nil
+		}
+		st = newStream()
+	}
+
+	var id streamID
+	switch arg := r.argv[i]; {
+	case arg == "*":
+		id, err = st.nextID(nil)
+	case strings.HasSuffix(arg, "-*"):
+		var ms uint64
+		if ms, err = strconv.ParseUint(strings.TrimSuffix(arg, "-*"), 10, 64); err != nil {
+			return nil, ErrStreamID
+		}
+		id, err = st.nextID(&ms)
+	default:
+		if id, err = parseStreamID(arg, 0); err != nil {
+			return nil, err
+		}
+		if id == (streamID{}) {
+			return nil, ErrStreamID
+		}
+		if !st.lastID.less(id) {
+			err = ErrStreamIDTooSmall
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, r.argc-i-1)
+	copy(fields, r.argv[i+1:])
+	st.add(id, fields)
+	trimmed := 0
+	if maxlen >= 0 {
+		trimmed = st.trim(maxlen, approx)
+	}
+	if !s.exists(r.argv[0]) {
+		s.set(r.argv[0], st)
+	}
+	streamReady.Broadcast()
+	notifyKeyspaceEvent(notifyStream, "xadd", r.argv[0], r.db)
+	if trimmed > 0 {
+		notifyKeyspaceEvent(notifyStream, "xtrim", r.argv[0], r.db)
+	}
+
+	argv := append([]string{}, r.argv[:i]...)
+	argv = append(argv, id.String())
+	r.rewrite("xadd", append(argv, fields...)...)
+
+	return id.String(), nil
+}
+
+// XLEN key
+func xlenCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	st, err := findStreamEntry(s, r.argv[0])
+	if err != nil || st == nil {
+		return 0, err
+	}
+
+	return len(st.entries), nil
+}
+
+// XRANGE key start end [COUNT count]
+func xrangeCommand(s *storage, r *request) (interface{}, error) {
+	return xrangeGenericCommand(s, r, false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func xrevrangeCommand(s *storage, r *request) (interface{}, error) {
+	return xrangeGenericCommand(s, r, true)
+}
+
+func xrangeGenericCommand(s *storage, r *request, rev bool) (interface{}, error) {
+	if r.argc != 3 && r.argc != 5 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	first, last := r.argv[1], r.argv[2]
+	if rev {
+		first, last = last, first
+	}
+	start, err := parseRangeID(first, false)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseRangeID(last, true)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	if r.argc == 5 {
+		if strings.ToLower(r.argv[3]) != "count" {
+			return nil, ErrBadArguments
+		}
+		if count, err = strconv.Atoi(r.argv[4]); err != nil || count < 0 {
+			return nil, ErrBadArguments
+		}
+		if count == 0 {
+			return []streamEntryReply{}, nil
+		}
+	}
+
+	st, err := findStreamEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return []streamEntryReply{}, nil
+	}
+
+	return replyEntries(st.rangeEntries(start, end, count, rev)), nil
+}
+
+// readOptions are options of XREAD and XREADGROUP commands.
+type readOptions struct {
+	count int
+	block time.Duration // negative if the command should not block
+	noack bool
+	keys  []string
+	ids   []string
+	argv  []string // arguments without BLOCK option
+}
+
+// parseReadOptions parses "[COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...]" part of XREAD and XREADGROUP.
+func parseReadOptions(argv []string, group bool) (*readOptions, error) {
+	o := &readOptions{block: -1}
+
+	for i := 0; i < len(argv); i++ {
+		opt := strings.ToLower(argv[i])
+		switch {
+		case opt == "streams":
+			rest := argv[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, ErrWrongNumOfArguments
+			}
+			o.keys = rest[:len(rest)/2]
+			o.ids = rest[len(rest)/2:]
+			o.argv = append(o.argv, argv[i:]...)
+			return o, nil
+		case opt == "count" && i+1 < len(argv):
+			n, err := strconv.Atoi(argv[i+1])
+			if err != nil || n < 0 {
+				return nil, ErrBadArguments
+			}
+			o.count = n
+			o.argv = append(o.argv, argv[i:i+2]...)
+			i++
+		case opt == "block" && i+1 < len(argv):
+			ms, err := strconv.ParseInt(argv[i+1], 10, 64)
+			if err != nil || ms < 0 {
+				return nil, ErrBadArguments
+			}
+			o.block = time.Duration(ms) * time.Millisecond
+			i++
+		case opt == "noack" && group:
+			o.noack = true
+			o.argv = append(o.argv, argv[i])
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	return nil, ErrBadArguments
+}
+
+// waitStreams calls read until it returns a non-nil result or the timeout
+// passes. Zero timeout means to wait forever. While waiting execMutex is
+// released, so other commands could add entries to the streams, swap
+// databases or kill the client. read gets the database of the request
+// looked up again after every wakeup. Nil is returned once the client
+// is killed, closes the connection or the server is shutting down.
+func waitStreams(s *storage, r *request, timeout time.Duration, read func(*storage) ([]streamReply, error)) ([]streamReply, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		t := time.AfterFunc(timeout, func() {
+			execMutex.Lock()
+			streamReady.Broadcast()
+			execMutex.Unlock()
+		})
+		defer t.Stop()
+	}
+
+	for {
+		res, err := read(s)
+		if err != nil || res != nil {
+			return res, err
+		}
+		if timeout > 0 && !time.Now().Before(deadline) {
+			return nil, nil
+		}
+		r.client.watchHangup()
+		waitStart := time.Now()
+		streamReady.Wait()
+		r.blocked += time.Since(waitStart)
+
+		clientsMutex.Lock()
+		stop := shuttingDown
+		clientsMutex.Unlock()
+		if stop || r.client.closed {
+			return nil, nil
+		}
+		s = databases[r.db]
+	}
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// Return value is a list of streams with entries having IDs greater than
+// the given ones or nil if there are no such entries. The special ID $
+// means the last ID of a stream at the time the command was called.
+func xreadCommand(s *storage, r *request) (interface{}, error) {
+	o, err := parseReadOptions(r.argv, false)
+	if err != nil {
+		return nil, err
+	}
+
+	after := make([]streamID, len(o.keys))
+	for i, k := range o.keys {
+		if o.ids[i] == "$" {
+			st, err := findStreamEntry(s, k)
+			if err != nil {
+				return nil, err
+			}
+			if st != nil {
+				after[i] = st.lastID
+			}
+			continue
+		}
+		if after[i], err = parseStreamID(o.ids[i], 0); err != nil {
+			return nil, err
+		}
+	}
+
+	read := func(s *storage) ([]streamReply, error) {
+		var res []streamReply
+		for i, k := range o.keys {
+			st, err := findStreamEntry(s, k)
+			if err != nil {
+				return nil, err
+			}
+			if st == nil {
+				continue
+			}
+			start, ok := after[i].next()
+			if !ok {
+				continue
+			}
+			if entries := st.rangeEntries(start, maxStreamID, o.count, false); len(entries) > 0 {
+				res = append(res, streamReply{k, replyEntries(entries)})
+			}
+		}
+		return res, nil
+	}
+
+	if o.block < 0 {
+		return read(s)
+	}
+	return waitStreams(s, r, o.block, read)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...]
+// The special ID > reads entries which were never delivered to other consumers
+// of the group. Any other ID reads entries pending for the consumer.
+// The command is logged without BLOCK option.
+func xreadgroupCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 6 {
+		return nil, ErrWrongNumOfArguments
+	}
+	if strings.ToLower(r.argv[0]) != "group" {
+		return nil, ErrBadArguments
+	}
+	group, consumer := r.argv[1], r.argv[2]
+
+	o, err := parseReadOptions(r.argv[3:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	after := make([]streamID, len(o.keys))
+	for i, k := range o.keys {
+		if _, _, err := findStreamGroup(s, k, group); err != nil {
+			return nil, err
+		}
+		if o.ids[i] == ">" {
+			continue
+		}
+		if after[i], err = parseStreamID(o.ids[i], 0); err != nil {
+			return nil, err
+		}
+	}
+
+	r.rewrite("xreadgroup", append(r.argv[:3:3], o.argv...)...)
+
+	read := func(s *storage) ([]streamReply, error) {
+		var res []streamReply
+		now := mstime()
+		for i, k := range o.keys {
+			st, g, err := findStreamGroup(s, k, group)
+			if err != nil {
+				return nil, err
+			}
+			g.consumer(consumer)
+
+			var entries []streamEntry
+			if o.ids[i] == ">" {
+				start, ok := g.lastID.next()
+				if !ok {
+					continue
+				}
+				entries = st.rangeEntries(start, maxStreamID, o.count, false)
+				for _, e := range entries {
+					g.lastID = e.id
+					if !o.noack {
+						g.deliver(e.id, consumer, now)
+					}
+				}
+				if len(entries) > 0 {
+					res = append(res, streamReply{k, replyEntries(entries)})
+				}
+				continue
+			}
+
+			// history of the consumer is returned even if it's empty
+			for _, id := range g.pendingIDs() {
+				if o.count > 0 && len(entries) >= o.count {
+					break
+				}
+				p := g.pending[id]
+				if p.consumer != consumer || !after[i].less(id) {
+					continue
+				}
+				e, ok := st.find(id)
+				if !ok {
+					e = streamEntry{id: id}
+				}
+				entries = append(entries, e)
+				g.deliver(id, consumer, now)
+			}
+			res = append(res, streamReply{k, replyEntries(entries)})
+		}
+		return res, nil
+	}
+
+	if o.block < 0 {
+		return read(s)
+	}
+	return waitStreams(s, r, o.block, read)
+}
+
+// XGROUP CREATE key group <id | $> [MKSTREAM]
+// XGROUP SETID key group <id | $>
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func xgroupCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	sub := strings.ToLower(r.argv[0])
+	key, group := r.argv[1], r.argv[2]
+
+	st, err := findStreamEntry(s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sub {
+	case "create", "setid":
+		if r.argc != 4 && !(sub == "create" && r.argc == 5) {
+			return nil, ErrWrongNumOfArguments
+		}
+		if r.argc == 5 && strings.ToLower(r.argv[4]) != "mkstream" {
+			return nil, ErrBadArguments
+		}
+		created := st == nil
+		if created {
+			if r.argc != 5 {
+				return nil, ErrStreamNoGroup
+			}
+			st = newStream()
+		}
+
+		id := st.lastID
+		if r.argv[3] != "$" {
+			if id, err = parseStreamID(r.argv[3], 0); err != nil {
+				return nil, err
+			}
+		}
+		argv := []string{sub, key, group, id.String()}
+		r.rewrite("xgroup", append(argv, r.argv[4:]...)...)
+
+		if sub == "setid" {
+			g, ok := st.groups[group]
+			if !ok {
+				return nil, ErrStreamNoGroup
+			}
+			g.lastID = id
+			return 1, nil
+		}
+		if _, ok := st.groups[group]; ok {
+			return nil, ErrStreamGroupExists
+		}
+		// the stream is created once the command can't fail
+		if created {
+			s.set(key, st)
+		}
+		st.groups[group] = newStreamGroup(id)
+		notifyKeyspaceEvent(notifyStream, "xgroup-create", key, r.db)
+		return 1, nil
+
+	case "destroy":
+		if r.argc != 3 {
+			return nil, ErrWrongNumOfArguments
+		}
+		if st == nil {
+			return nil, ErrStreamNoGroup
+		}
+		if _, ok := st.groups[group]; !ok {
+			return 0, nil
+		}
+		delete(st.groups, group)
+		return 1, nil
+
+	case "createconsumer", "delconsumer":
+		if r.argc != 4 {
+			return nil, ErrWrongNumOfArguments
+		}
+		_, g, err := findStreamGroup(s, key, group)
+		if err != nil {
+			return nil, err
+		}
+		name := r.argv[3]
+		c, ok := g.consumers[name]
+
+		if sub == "createconsumer" {
+			if ok {
+				return 0, nil
+			}
+			g.consumer(name)
+			return 1, nil
+		}
+
+		if !ok {
+			return 0, nil
+		}
+		for id, p := range g.pending {
+			if p.consumer == name {
+				delete(g.pending, id)
+			}
+		}
+		delete(g.consumers, name)
+		return c.pending, nil
+	}
+
+	return nil, ErrBadArguments
+}
+
+// XACK key group id [id ...]
+// Return value is the number of acknowledged entries.
+func xackCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	ids := make([]streamID, r.argc-2)
+	for i, v := range r.argv[2:] {
+		id, err := parseStreamID(v, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+
+	st, err := findStreamEntry(s, r.argv[0])
+	if err != nil || st == nil {
+		return 0, err
+	}
+	g, ok := st.groups[r.argv[1]]
+	if !ok {
+		return 0, nil
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+
+	return acked, nil
+}
+
+type streamPendingSummary struct {
+	Count     int            `json:"count"`
+	Min       string         `json:"min,omitempty"`
+	Max       string         `json:"max,omitempty"`
+	Consumers map[string]int `json:"consumers"`
+}
+
+type streamPendingReply struct {
+	ID         string `json:"id"`
+	Consumer   string `json:"consumer"`
+	Idle       int64  `json:"idle"`
+	Deliveries int64  `json:"deliveries"`
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+// Without a range the summary of the pending entries list is returned.
+func xpendingCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 && (r.argc < 5 || r.argc > 8) {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	_, g, err := findStreamGroup(s, r.argv[0], r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	ids := g.pendingIDs()
+
+	if r.argc == 2 {
+		sum := streamPendingSummary{Count: len(ids), Consumers: make(map[string]int)}
+		if len(ids) > 0 {
+			sum.Min, sum.Max = ids[0].String(), ids[len(ids)-1].String()
+		}
+		for name, c := range g.consumers {
+			if c.pending > 0 {
+				sum.Consumers[name] = c.pending
+			}
+		}
+		return sum, nil
+	}
+
+	argv := r.argv[2:]
+	var minIdle int64
+	if strings.ToLower(argv[0]) == "idle" {
+		if minIdle, err = strconv.ParseInt(argv[1], 10, 64); err != nil {
+			return nil, ErrBadArguments
+		}
+		argv = argv[2:]
+	}
+	if len(argv) != 3 && len(argv) != 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	start, err := parseRangeID(argv[0], false)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseRangeID(argv[1], true)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(argv[2])
+	if err != nil || count < 0 {
+		return nil, ErrBadArguments
+	}
+
+	now := mstime()
+	res := []streamPendingReply{}
+	for _, id := range ids {
+		if len(res) >= count {
+			break
+		}
+		p := g.pending[id]
+		if id.less(start) || end.less(id) || now-p.deliveryTime < minIdle {
+			continue
+		}
+		if len(argv) == 4 && p.consumer != argv[3] {
+			continue
+		}
+		res = append(res, streamPendingReply{id.String(), p.consumer, now - p.deliveryTime, p.deliveryCount})
+	}
+
+	return res, nil
+}
+
+// claim transfers the pending entry to the consumer if it's idle for at least
+// minIdle milliseconds. Entries deleted from the stream are removed from the
+// pending entries list. It returns the entry and whether it was claimed.
+func claim(st *stream, g *streamGroup, id streamID, consumer string, minIdle int64, now int64, justid bool) (streamEntry, bool) {
+	p, ok := g.pending[id]
+	if !ok || now-p.deliveryTime < minIdle {
+		return streamEntry{}, false
+	}
+
+	e, ok := st.find(id)
+	if !ok {
+		g.ack(id)
+		return streamEntry{}, false
+	}
+
+	count := p.deliveryCount
+	g.deliver(id, consumer, now)
+	if justid {
+		p.deliveryCount = count
+	}
+	return e, true
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID]
+// Return value is a list of claimed entries (or their IDs with JUSTID option).
+// The command is logged with claimed IDs only and the time of the claim,
+// so cmdlog replay doesn't depend on idle times. FORCE is logged if it
+// created a pending entry, otherwise replay wouldn't claim the entry.
+// The command is not logged if it claimed nothing.
+func xclaimCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 5 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	key, consumer := r.argv[0], r.argv[2]
+	minIdle, err := strconv.ParseInt(r.argv[3], 10, 64)
+	if err != nil {
+		return nil, ErrBadArguments
+	}
+
+	var ids []streamID
+	i := 4
+	for ; i < r.argc; i++ {
+		id, err := parseStreamID(r.argv[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, ErrStreamID
+	}
+
+	now := mstime()
+	deliveryTime := now
+	retrycount := int64(-1)
+	var force, justid bool
+	for ; i < r.argc; i++ {
+		opt := strings.ToLower(r.argv[i])
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justid = true
+		case (opt == "idle" || opt == "time" || opt == "retrycount") && i+1 < r.argc:
+			n, err := strconv.ParseInt(r.argv[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return nil, ErrBadArguments
+			}
+			switch opt {
+			case "idle":
+				deliveryTime = now - n
+			case "time":
+				deliveryTime = n
+			case "retrycount":
+				retrycount = n
+			}
+			i++
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	st, g, err := findStreamGroup(s, key, r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []streamEntry
+	forced := false
+	logged := []string{key, r.argv[1], consumer, "0"}
+	for _, id := range ids {
+		idle := minIdle
+		if _, ok := g.pending[id]; !ok && force {
+			if _, ok := st.find(id); ok {
+				g.deliver(id, consumer, now).deliveryCount = 0
+				idle = 0
+				forced = true
+			}
+		}
+		e, ok := claim(st, g, id, consumer, idle, now, justid)
+		if !ok {
+			if _, pending := g.pending[id]; !pending {
+				logged = append(logged, id.String())
+			}
+			continue
+		}
+		logged = append(logged, id.String())
+		p := g.pending[id]
+		p.deliveryTime = deliveryTime
+		if retrycount >= 0 {
+			p.deliveryCount = retrycount
+		}
+		claimed = append(claimed, e)
+	}
+
+	if len(logged) > 4 {
+		logged = append(logged, "TIME", strconv.FormatInt(deliveryTime, 10))
+		if retrycount >= 0 {
+			logged = append(logged, "RETRYCOUNT", strconv.FormatInt(retrycount, 10))
+		}
+		if forced {
+			logged = append(logged, "FORCE")
+		}
+		if justid {
+			logged = append(logged, "JUSTID")
+		}
+		r.rewrite("xclaim", logged...)
+	} else {
+		// replayed later the entries would be idle for longer and get claimed
+		r.nolog = true
+	}
+
+	if justid {
+		res := make([]string, len(claimed))
+		for i, e := range claimed {
+			res[i] = e.id.String()
+		}
+		return res, nil
+	}
+	return replyEntries(claimed), nil
+}
+
+type streamAutoClaimReply struct {
+	Next    string      `json:"next"`
+	Entries interface{} `json:"entries"`
+	Deleted []string    `json:"deleted"`
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// Return value is the ID to continue scanning from ("0-0" when the whole
+// pending entries list was scanned), claimed entries and IDs of entries
+// which were removed from the pending entries list because they no longer
+// exist in the stream. The command is logged as XCLAIM of those entries,
+// it is not logged if there are none.
+func xautoclaimCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 5 || r.argc > 8 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	key, group, consumer := r.argv[0], r.argv[1], r.argv[2]
+	minIdle, err := strconv.ParseInt(r.argv[3], 10, 64)
+	if err != nil {
+		return nil, ErrBadArguments
+	}
+	start, err := parseRangeID(r.argv[4], false)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 100
+	justid := false
+	for i := 5; i < r.argc; i++ {
+		switch opt := strings.ToLower(r.argv[i]); {
+		case opt == "count" && i+1 < r.argc:
+			if count, err = strconv.Atoi(r.argv[i+1]); err != nil || count < 1 {
+				return nil, ErrBadArguments
+			}
+			i++
+		case opt == "justid":
+			justid = true
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	st, g, err := findStreamGroup(s, key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	now := mstime()
+	var claimed []streamEntry
+	deleted := []string{}
+	logged := []string{key, group, consumer, "0"}
+	next := streamID{}
+	for _, id := range g.pendingIDs() {
+		if id.less(start) {
+			continue
+		}
+		if len(claimed)+len(deleted) >= count {
+			next = id
+			break
+		}
+		e, ok := claim(st, g, id, consumer, minIdle, now, justid)
+		if ok {
+			claimed = append(claimed, e)
+		} else if _, pending := g.pending[id]; !pending {
+			deleted = append(deleted, id.String())
+		} else {
+			continue
+		}
+		logged = append(logged, id.String())
+	}
+
+	if len(logged) > 4 {
+		logged = append(logged, "TIME", strconv.FormatInt(now, 10))
+		if justid {
+			logged = append(logged, "JUSTID")
+		}
+		r.rewrite("xclaim", logged...)
+	} else {
+		r.nolog = true
+	}
+
+	res := streamAutoClaimReply{Next: next.String(), Deleted: deleted}
+	if justid {
+		ids := make([]string, len(claimed))
+		for i, e := range claimed {
+			ids[i] = e.id.String()
+		}
+		res.Entries = ids
+	} else {
+		res.Entries = replyEntries(claimed)
+	}
+
+	return res, nil
+}
+
+// XTRIM key MAXLEN [= | ~] threshold
+// Return value is the number of removed entries.
+func xtrimCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 && r.argc != 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+	if strings.ToLower(r.argv[1]) != "maxlen" {
+		return nil, ErrBadArguments
+	}
+
+	maxlen, approx, next, err := parseMaxLen(r.argv, 1)
+	if err != nil || next != r.argc {
+		return nil, ErrBadArguments
+	}
+
+	st, err := findStreamEntry(s, r.argv[0])
+	if err != nil || st == nil {
+		return 0, err
+	}
+
+	trimmed := st.trim(maxlen, approx)
+	if trimmed > 0 {
+		notifyKeyspaceEvent(notifyStream, "xtrim", r.argv[0], r.db)
+	}
+
+	return trimmed, nil
+}
+
+// XDEL key id [id ...]
+// Return value is the number of removed entries.
+func xdelCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	ids := make([]streamID, r.argc-1)
+	for i, v := range r.argv[1:] {
+		id, err := parseStreamID(v, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+
+	st, err := findStreamEntry(s, r.argv[0])
+	if err != nil || st == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if st.del(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		notifyKeyspaceEvent(notifyStream, "xdel", r.argv[0], r.db)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redislike "github.com/bannerlog/redislike/protocol"
+)
+
+// captureCmdlog executes the commands against a new database and returns
+// the requests written to the cmdlog and the stream stored at key.
+func captureCmdlog(t *testing.T, cmds [][]string, key string) ([]*request, *stream) {
+	t.Helper()
+	defer func(dbs []*storage, l *cmdlog) { databases, cmdlogger = dbs, l }(databases, cmdlogger)
+
+	databases = newDatabases(1)
+	cmdlogger = &cmdlog{logchan: make(chan *request, len(cmds))}
+	c := &client{}
+	var logged []*request
+	for _, cmd := range cmds {
+		r := &request{cmd: cmd[0], argv: cmd[1:], argc: len(cmd) - 1, client: c}
+		if _, err := executeCmd(r); err != nil {
+			t.Fatalf("\t%s\tShould execute %v: %v", failed, cmd, err)
+		}
+		select {
+		case lr := <-cmdlogger.logchan:
+			t.Logf("\t\t%v is logged as %s %v", cmd, lr.cmd, lr.argv)
+			logged = append(logged, lr)
+		default:
+		}
+	}
+	st, _ := databases[0].get(key).(*stream)
+	return logged, st
+}
+
+// replayStream executes the requests against a new database and returns
+// the stream stored at key.
+func replayStream(t *testing.T, reqs []*request, key string) *stream {
+	t.Helper()
+	defer func(dbs []*storage, l *cmdlog) { databases, cmdlogger = dbs, l }(databases, cmdlogger)
+
+	databases = newDatabases(1)
+	cmdlogger = nil
+	c := &client{}
+	for _, lr := range reqs {
+		r := &request{cmd: lr.cmd, argv: lr.argv, argc: lr.argc, client: c}
+		if _, err := executeCmd(r); err != nil {
+			t.Fatalf("\t%s\tShould replay %s %v: %v", failed, lr.cmd, lr.argv, err)
+		}
+	}
+	st, _ := databases[0].get(key).(*stream)
+	return st
+}
+
+// pendingState returns owners and delivery counters of pending entries
+// of the group. Delivery times of XREADGROUP depend on the clock, so they
+// are left out.
+func pendingState(st *stream, group string) map[string][2]interface{} {
+	res := make(map[string][2]interface{})
+	if st == nil || st.groups[group] == nil {
+		return res
+	}
+	for id, p := range st.groups[group].pending {
+		res[id.String()] = [2]interface{}{p.consumer, p.deliveryCount}
+	}
+	return res
+}
+
+func TestStreamCmdlogReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		cmds [][]string
+	}{
+		{"XREADGROUP and XCLAIM", [][]string{
+			{"xadd", "s", "1-1", "f", "v"},
+			{"xadd", "s", "1-2", "f", "v"},
+			{"xgroup", "CREATE", "s", "g", "0"},
+			{"xreadgroup", "GROUP", "g", "alice", "COUNT", "1", "BLOCK", "10", "STREAMS", "s", ">"},
+			{"xclaim", "s", "g", "bob", "0", "1-1", "RETRYCOUNT", "5"},
+			{"xclaim", "s", "g", "carol", "3600000", "1-1"},
+		}},
+		{"XCLAIM with FORCE", [][]string{
+			{"xadd", "s", "1-1", "f", "v"},
+			{"xadd", "s", "1-2", "f", "v"},
+			{"xadd", "s", "1-3", "f", "v"},
+			{"xgroup", "CREATE", "s", "g", "$"},
+			{"xclaim", "s", "g", "bob", "0", "1-2", "FORCE"},
+			{"xclaim", "s", "g", "carol", "0", "1-3", "1-9", "FORCE", "JUSTID"},
+		}},
+		{"XAUTOCLAIM of deleted entries", [][]string{
+			{"xadd", "s", "1-1", "f", "v"},
+			{"xadd", "s", "1-2", "f", "v"},
+			{"xadd", "s", "1-3", "f", "v"},
+			{"xgroup", "CREATE", "s", "g", "0"},
+			{"xreadgroup", "GROUP", "g", "alice", "STREAMS", "s", ">"},
+			{"xdel", "s", "1-2"},
+			{"xautoclaim", "s", "g", "bob", "0", "0-0", "COUNT", "2"},
+			{"xautoclaim", "s", "g", "carol", "0", "1-3", "JUSTID"},
+			{"xautoclaim", "s", "g", "dave", "3600000", "0-0"},
+		}},
+	}
+
+	t.Log("Given stream commands rewritten in the cmdlog")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen replaying %s", i, tt.name)
+
+		reqs, live := captureCmdlog(t, tt.cmds, "s")
+		for _, r := range reqs {
+			if strings.EqualFold(r.cmd, "xreadgroup") && strings.Contains(strings.ToLower(strings.Join(r.argv, " ")), "block") {
+				t.Errorf("\t%s\tShould log XREADGROUP without BLOCK, got %v", failed, r.argv)
+			}
+			if strings.EqualFold(r.cmd, "xclaim") && r.argv[3] != "0" {
+				t.Errorf("\t%s\tShould log XCLAIM of claimed entries only, got %v", failed, r.argv)
+			}
+		}
+
+		replayed := replayStream(t, reqs, "s")
+
+		if want, got := pendingState(live, "g"), pendingState(replayed, "g"); reflect.DeepEqual(want, got) {
+			t.Logf("\t%s\tShould rebuild pending entries %v", succeed, want)
+		} else {
+			t.Errorf("\t%s\tShould rebuild pending entries %v, got %v", failed, want, got)
+		}
+		if live.groups["g"].lastID == replayed.groups["g"].lastID && len(live.entries) == len(replayed.entries) {
+			t.Logf("\t%s\tShould rebuild entries and the last delivered ID", succeed)
+		} else {
+			t.Errorf("\t%s\tShould rebuild entries and the last delivered ID", failed)
+		}
+	}
+}
+
+func TestXGroupCreateMkstream(t *testing.T) {
+	tests := []struct {
+		argv   []string
+		err    error
+		exists bool // the stream exists after the command
+	}{
+		{[]string{"CREATE", "s", "g", "bad-id", "MKSTREAM"}, ErrStreamID, false},
+		{[]string{"CREATE", "s", "g", "0", "MKSTREAM"}, nil, true},
+		{[]string{"CREATE", "s", "g", "0", "MKSTREAM"}, ErrStreamGroupExists, true},
+		{[]string{"CREATE", "t", "g", "0"}, ErrStreamNoGroup, false},
+		{[]string{"CREATE", "t", "g", "$", "NOSTREAM"}, ErrBadArguments, false},
+	}
+
+	t.Log("Given XGROUP CREATE with MKSTREAM")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing XGROUP %v", i, tt.argv)
+
+		_, err := execute(s, "xgroup", tt.argv...)
+		if exists := s.exists(tt.argv[1]); err == tt.err && exists == tt.exists {
+			t.Logf("\t%s\tShould get %v and the stream existing %t", succeed, tt.err, tt.exists)
+		} else {
+			t.Errorf("\t%s\tShould get %v and the stream existing %t, got %v, %t", failed, tt.err, tt.exists, err, exists)
+		}
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	add := func(st *stream, n int) {
+		for i := 0; i < n; i++ {
+			st.add(streamID{st.lastID.ms + 1, 0}, []string{"f", "v"})
+		}
+	}
+	first := func(st *stream) uint64 {
+		return st.entries[0].id.ms
+	}
+
+	t.Log("Given a stream capped with MAXLEN")
+
+	t.Log("\tTest: 0\tWhen trimming exactly on every add")
+	{
+		st := newStream()
+		add(st, 10)
+		ok := true
+		for i := 0; i < 1000; i++ {
+			add(st, 1)
+			if st.trim(10, false) != 1 || len(st.entries) != 10 || first(st) != uint64(i+2) {
+				ok = false
+			}
+		}
+		if ok && st.trimmed <= len(st.entries) {
+			t.Logf("\t%s\tShould keep the newest 10 entries", succeed)
+		} else {
+			t.Errorf("\t%s\tShould keep the newest 10 entries, got %d from %d", failed, len(st.entries), first(st))
+		}
+	}
+
+	t.Log("\tTest: 1\tWhen trimming approximately")
+	{
+		st := newStream()
+		add(st, 250)
+		if n := st.trim(10, true); n == 200 && len(st.entries) == 50 && first(st) == 201 {
+			t.Logf("\t%s\tShould remove whole batches of entries", succeed)
+		} else {
+			t.Errorf("\t%s\tShould remove whole batches of entries, got %d, %d", failed, n, len(st.entries))
+		}
+		if n := st.trim(0, true); n == 0 && len(st.entries) == 50 {
+			t.Logf("\t%s\tShould keep an incomplete batch", succeed)
+		} else {
+			t.Errorf("\t%s\tShould keep an incomplete batch, got %d, %d", failed, n, len(st.entries))
+		}
+	}
+}
+
+// blockStream executes the blocking command in a goroutine and waits
+// until the command is blocked. The reply is sent to the returned channel.
+func blockStream(t *testing.T, c *client, cmd ...string) <-chan string {
+	t.Helper()
+
+	done := make(chan string, 1)
+	go func() {
+		res, err := executeCmd(&request{cmd: cmd[0], argv: cmd[1:], argc: len(cmd) - 1, client: c})
+		done <- fmt.Sprintf("%s %v", res, err)
+	}()
+
+	for i := 0; ; i++ {
+		time.Sleep(10 * time.Millisecond)
+		execMutex.Lock()
+		blocked := c.inCommand
+		execMutex.Unlock()
+		if blocked {
+			return done
+		}
+		if i == 100 {
+			t.Fatalf("\t%s\tShould block %v", failed, cmd)
+		}
+	}
+}
+
+func TestStreamBlocking(t *testing.T) {
+	defer func(dbs []*storage, l *cmdlog) { databases, cmdlogger = dbs, l }(databases, cmdlogger)
+	defer atomic.StoreInt32(&config.maxclients, atomic.LoadInt32(&config.maxclients))
+	databases = newDatabases(2)
+	cmdlogger = nil
+	atomic.StoreInt32(&config.maxclients, 10)
+
+	exec := func(db int, cmd ...string) {
+		t.Helper()
+		if _, err := executeCmd(&request{cmd: cmd[0], argv: cmd[1:], argc: len(cmd) - 1, client: &client{db: db}}); err != nil {
+			t.Fatalf("\t%s\tShould execute %v: %v", failed, cmd, err)
+		}
+	}
+	wait := func(done <-chan string) (string, bool) {
+		select {
+		case res := <-done:
+			return res, true
+		case <-time.After(time.Second):
+			return "", false
+		}
+	}
+
+	t.Log("Given clients blocked on streams forever")
+
+	t.Log("\tTest: 0\tWhen the client is killed")
+	{
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		c := &client{conn: conn}
+		done := blockStream(t, c, "xread", "BLOCK", "0", "STREAMS", "s", "$")
+
+		execMutex.Lock()
+		c.kill()
+		execMutex.Unlock()
+		if res, ok := wait(done); ok && res == "null <nil>" {
+			t.Logf("\t%s\tShould return nil", succeed)
+		} else {
+			t.Errorf("\t%s\tShould return nil, got %q, %t", failed, res, ok)
+		}
+	}
+
+	t.Log("\tTest: 1\tWhen the server is shutting down")
+	{
+		done := blockStream(t, &client{}, "xread", "BLOCK", "0", "STREAMS", "s", "$")
+
+		execMutex.Lock()
+		clientsMutex.Lock()
+		shuttingDown = true
+		clientsMutex.Unlock()
+		streamReady.Broadcast()
+		execMutex.Unlock()
+		res, ok := wait(done)
+		clientsMutex.Lock()
+		shuttingDown = false
+		clientsMutex.Unlock()
+		if ok && res == "null <nil>" {
+			t.Logf("\t%s\tShould return nil", succeed)
+		} else {
+			t.Errorf("\t%s\tShould return nil, got %q, %t", failed, res, ok)
+		}
+	}
+
+	t.Log("\tTest: 2\tWhen databases are swapped")
+	{
+		for db := 0; db < 2; db++ {
+			exec(db, "xadd", "s", "1-1", "f", "v")
+			exec(db, "xgroup", "CREATE", "s", "g", "$")
+		}
+		old := databases[0]
+		done := blockStream(t, &client{}, "xreadgroup", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+
+		exec(0, "swapdb", "0", "1")
+		exec(0, "xadd", "s", "2-1", "f", "v")
+		res, ok := wait(done)
+		st, _ := databases[0].get("s").(*stream)
+		if ok && len(st.groups["g"].pending) == 1 &&
+			len(old.get("s").(*stream).groups["g"].pending) == 0 {
+			t.Logf("\t%s\tShould read the stream of the database the client selected", succeed)
+		} else {
+			t.Errorf("\t%s\tShould read the stream of the database the client selected, got %q, %t", failed, res, ok)
+		}
+	}
+
+	t.Log("\tTest: 3\tWhen the client closes the connection")
+	{
+		conn, peer := net.Pipe()
+		c := &client{conn: conn}
+		if !addClient(c) {
+			t.Fatalf("\t%s\tShould register the client", failed)
+		}
+		done := make(chan string, 1)
+		go func() {
+			handleConnection(c)
+			done <- "closed"
+		}()
+
+		req := &redislike.Request{Command: "xread", Args: []string{"BLOCK", "0", "STREAMS", "s", "$"}}
+		if err := req.Write(peer); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			time.Sleep(10 * time.Millisecond)
+			execMutex.Lock()
+			blocked := c.inCommand
+			execMutex.Unlock()
+			if blocked {
+				break
+			}
+			if i == 100 {
+				t.Fatalf("\t%s\tShould block the client", failed)
+			}
+		}
+
+		peer.Close()
+		_, ok := wait(done)
+		clientsMutex.Lock()
+		_, registered := clients[c]
+		clientsMutex.Unlock()
+		if ok && !registered {
+			t.Logf("\t%s\tShould remove the client", succeed)
+		} else {
+			t.Errorf("\t%s\tShould remove the client, got %t, %t", failed, ok, registered)
+		}
+	}
+
+	t.Log("\tTest: 4\tWhen the client pipelines a request while blocked")
+	{
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		c := &client{conn: conn}
+		if !addClient(c) {
+			t.Fatalf("\t%s\tShould register the client", failed)
+		}
+		go handleConnection(c)
+		defer conn.Close()
+
+		go func() {
+			(&redislike.Request{Command: "xread", Args: []string{"BLOCK", "0", "STREAMS", "p", "$"}}).Write(peer)
+			(&redislike.Request{Command: "ping"}).Write(peer)
+		}()
+		for i := 0; ; i++ {
+			time.Sleep(10 * time.Millisecond)
+			execMutex.Lock()
+			blocked := c.inCommand
+			execMutex.Unlock()
+			if blocked {
+				break
+			}
+			if i == 100 {
+				t.Fatalf("\t%s\tShould block the client", failed)
+			}
+		}
+
+		exec(0, "xadd", "p", "1-1", "f", "v")
+		var got []string
+		rd := bufio.NewReader(peer)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		for i := 0; i < 2; i++ {
+			resp, err := redislike.ReadResponse(rd)
+			if err != nil {
+				break
+			}
+			got = append(got, resp.Values...)
+		}
+		if len(got) == 2 && strings.Contains(got[0], "1-1") && got[1] == "\"PONG\"" {
+			t.Logf("\t%s\tShould execute the pipelined request", succeed)
+		} else {
+			t.Errorf("\t%s\tShould execute the pipelined request, got %q", failed, got)
+		}
+	}
+}
@@ -1,42 +1,494 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
 	"strconv"
-	"time"
+	"strings"
+	"unicode/utf8"
 )
 
-// GET key
-func getCommand(s *storage, r *request) (interface{}, error) {
-	if r.argc != 1 {
-		return nil, ErrWrongNumOfArguments
+// stringMaxSize is the maximum length of a string value in bytes.
+const stringMaxSize = 512 * 1024 * 1024
+
+// ErrStringTooLong ...
+var ErrStringTooLong = errors.New("ERR string exceeds maximum allowed size")
+
+// Strings are stored as string values until they are modified by APPEND,
+// SETRANGE or bit operations. From then on they are kept as []byte,
+// so they could be changed in place without copying the whole value.
+
+// stringValue returns the string held by v. The second value is false
+// if v is not a string.
+func stringValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
 	}
+	return "", false
+}
 
-	v := s.get(r.argv[0])
+// binaryString is a string reply which may hold arbitrary bytes, e.g. a bitmap.
+// JSON strings can't hold bytes which are not valid UTF-8, so such a string
+// is replied as an object with the base64 encoded bytes: {"base64": "gA=="}.
+type binaryString string
+
+func (b binaryString) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(b)) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(struct {
+		Base64 []byte `json:"base64"`
+	}{[]byte(b)})
+}
+
+// findStringEntry returns the string stored at k as binaryString or nil
+// if the key does not exist.
+func findStringEntry(s *storage, k string) (interface{}, error) {
+	v := s.get(k)
 	if v == nil {
 		return nil, nil
 	}
 
-	if v, ok := v.(string); ok {
+	if v, ok := stringValue(v); ok {
+		return binaryString(v), nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// findBytesEntry returns the string stored at k as a slice of bytes which
+// could be modified in place. If the key does not exist nil is returned.
+func findBytesEntry(s *storage, k string) ([]byte, error) {
+	switch v := s.get(k).(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		b := []byte(v)
+		s.setKeepTTL(k, b)
+		return b, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+// peekBytesEntry returns the string stored at k as a slice of bytes
+// which must not be modified. If the key does not exist nil is returned.
+func peekBytesEntry(s *storage, k string) ([]byte, error) {
+	switch v := s.get(k).(type) {
+	case nil:
+		return nil, nil
+	case []byte:
 		return v, nil
+	case string:
+		return []byte(v), nil
 	}
 
 	return nil, ErrOperationAgainstWrongType
 }
 
-// SET key value [ttl]
+// growBytes extends b with zero bytes up to the length n.
+func growBytes(b []byte, n int) []byte {
+	if n <= len(b) {
+		return b
+	}
+	if n <= cap(b) {
+		return b[:n]
+	}
+	return append(b, make([]byte, n-len(b))...)
+}
+
+// normalizeRange converts inclusive start and end indexes, which could be
+// negative to count from the end, into a valid range of a sequence of
+// the length n. The last value is false if the range is empty.
+func normalizeRange(start int64, end int64, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start = n + start
+	}
+	if end < 0 {
+		end = n + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+
+	return start, end, n > 0 && start <= end
+}
+
+// parseExpireTime converts the value of EX, PX, EXAT or PXAT option
+// into a deadline (unix time in milliseconds).
+func parseExpireTime(opt string, v string) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, ErrBadArguments
+	}
+
+	if opt == "ex" || opt == "exat" {
+		if n > math.MaxInt64/1000 {
+			return 0, ErrBadArguments
+		}
+		n *= 1000
+	}
+
+	switch opt {
+	case "ex", "px":
+		now := mstime()
+		if n > math.MaxInt64-now {
+			return 0, ErrBadArguments
+		}
+		return now + n, nil
+	case "exat", "pxat":
+		return n, nil
+	}
+
+	return 0, ErrBadArguments
+}
+
+// GET key
+func getCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	return findStringEntry(s, r.argv[0])
+}
+
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+// SET key value ttl
+//
+// Return value is 1 if the key was set or 0 if it was not because of NX or XX.
+// With GET option the old string stored at key is returned instead.
 func setCommand(s *storage, r *request) (interface{}, error) {
-	if r.argc != 2 && r.argc != 3 {
+	if r.argc < 2 {
+		return 0, ErrWrongNumOfArguments
+	}
+
+	var nx, xx, get, keepttl bool
+	var expire string
+	var deadline int64
+
+	if _, err := strconv.ParseInt(r.argv[r.argc-1], 10, 64); r.argc == 3 && err == nil {
+		// legacy form with a ttl in seconds
+		d, err := parseExpireTime("ex", r.argv[2])
+		if err != nil {
+			return 0, err
+		}
+		expire, deadline = "ex", d
+	} else {
+		for i := 2; i < r.argc; i++ {
+			switch opt := strings.ToLower(r.argv[i]); opt {
+			case "nx":
+				nx = true
+			case "xx":
+				xx = true
+			case "get":
+				get = true
+			case "keepttl":
+				keepttl = true
+			case "ex", "px", "exat", "pxat":
+				if expire != "" || i+1 >= r.argc {
+					return 0, ErrBadArguments
+				}
+				d, err := parseExpireTime(opt, r.argv[i+1])
+				if err != nil {
+					return 0, err
+				}
+				expire, deadline = opt, d
+				i++
+			default:
+				return 0, ErrBadArguments
+			}
+		}
+	}
+	if (nx && xx) || (keepttl && expire != "") {
+		return 0, ErrBadArguments
+	}
+
+	var old interface{}
+	v := s.get(r.argv[0])
+	if get && v != nil {
+		sv, ok := stringValue(v)
+		if !ok {
+			return nil, ErrOperationAgainstWrongType
+		}
+		old = binaryString(sv)
+	}
+
+	// log the command with an absolute deadline
+	argv := []string{r.argv[0], r.argv[1]}
+	if nx {
+		argv = append(argv, "NX")
+	}
+	if xx {
+		argv = append(argv, "XX")
+	}
+	if keepttl {
+		argv = append(argv, "KEEPTTL")
+	}
+	if expire != "" {
+		argv = append(argv, "PXAT", strconv.FormatInt(deadline, 10))
+	}
+	r.rewrite("set", argv...)
+
+	if (nx && v != nil) || (xx && v == nil) {
+		if get {
+			return old, nil
+		}
+		return 0, nil
+	}
+
+	if keepttl {
+		s.setKeepTTL(r.argv[0], r.argv[1])
+	} else {
+		s.set(r.argv[0], r.argv[1])
+	}
+	notifyKeyspaceEvent(notifyString, "set", r.argv[0], r.db)
+	if expire != "" {
+		s.setExpire(r.argv[0], deadline)
+		notifyKeyspaceEvent(notifyGeneric, "expire", r.argv[0], r.db)
+	}
+
+	if get {
+		return old, nil
+	}
+	return 1, nil
+}
+
+// SETNX key value
+// Return value is 1 if the key was set or 0 if the key already exists.
+func setnxCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
 		return 0, ErrWrongNumOfArguments
 	}
 
-	if !s.set(r.argv[0], r.argv[1]) {
+	if s.exists(r.argv[0]) {
 		return 0, nil
 	}
 
-	if r.argc == 3 {
-		sec, _ := strconv.ParseInt(r.argv[2], 10, 64)
-		s.setExpire(r.argv[0], time.Now().Unix()+sec)
+	s.set(r.argv[0], r.argv[1])
+	notifyKeyspaceEvent(notifyString, "set", r.argv[0], r.db)
+	return 1, nil
+}
+
+// GETSET key value
+// Return value is the old string stored at key or nil if the key did not exist.
+func getsetCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	old, err := findStringEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	s.set(r.argv[0], r.argv[1])
+	notifyKeyspaceEvent(notifyString, "set", r.argv[0], r.db)
+	return old, nil
+}
+
+// GETDEL key
+// Return value is the string stored at key or nil if the key did not exist.
+func getdelCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	v, err := findStringEntry(s, r.argv[0])
+	if err != nil || v == nil {
+		return v, err
+	}
+
+	s.del(r.argv[0])
+	notifyKeyspaceEvent(notifyGeneric, "del", r.argv[0], r.db)
+	return v, nil
+}
+
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST]
+func getexCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 && r.argc != 2 && r.argc != 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	var opt string
+	var deadline int64
+	if r.argc > 1 {
+		opt = strings.ToLower(r.argv[1])
+		switch {
+		case opt == "persist" && r.argc == 2:
+		case opt != "persist" && r.argc == 3:
+			d, err := parseExpireTime(opt, r.argv[2])
+			if err != nil {
+				return nil, err
+			}
+			deadline = d
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	v, err := findStringEntry(s, r.argv[0])
+	if err != nil || v == nil {
+		return v, err
+	}
+
+	switch opt {
+	case "persist":
+		if s.persist(r.argv[0]) {
+			notifyKeyspaceEvent(notifyGeneric, "persist", r.argv[0], r.db)
+		}
+	case "":
+	default:
+		s.setExpire(r.argv[0], deadline)
+		r.rewrite("getex", r.argv[0], "PXAT", strconv.FormatInt(deadline, 10))
+		notifyKeyspaceEvent(notifyGeneric, "expire", r.argv[0], r.db)
+	}
+
+	return v, nil
+}
+
+// MSET key value [key value ...]
+func msetCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 || r.argc%2 != 0 {
+		return 0, ErrWrongNumOfArguments
+	}
+
+	for i := 0; i < r.argc; i += 2 {
+		s.set(r.argv[i], r.argv[i+1])
+		notifyKeyspaceEvent(notifyString, "set", r.argv[i], r.db)
 	}
 
 	return 1, nil
 }
+
+// MGET key [key ...]
+// Return value is a list of values stored at keys. For every key that does
+// not exist or does not hold a string nil is returned.
+func mgetCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	vs := make([]interface{}, r.argc)
+	for i, k := range r.argv {
+		if v, ok := stringValue(s.get(k)); ok {
+			vs[i] = binaryString(v)
+		}
+	}
+
+	return vs, nil
+}
+
+// APPEND key value
+// Return value is the length of the string after the append operation.
+func appendCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	b, err := findBytesEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(b)+len(r.argv[1]) > stringMaxSize {
+		return nil, ErrStringTooLong
+	}
+
+	if b == nil {
+		s.set(r.argv[0], r.argv[1])
+		notifyKeyspaceEvent(notifyString, "append", r.argv[0], r.db)
+		return len(r.argv[1]), nil
+	}
+
+	b = append(b, r.argv[1]...)
+	s.setKeepTTL(r.argv[0], b)
+	notifyKeyspaceEvent(notifyString, "append", r.argv[0], r.db)
+
+	return len(b), nil
+}
+
+// STRLEN key
+func strlenCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	v, err := findStringEntry(s, r.argv[0])
+	if err != nil || v == nil {
+		return 0, err
+	}
+
+	return len(v.(binaryString)), nil
+}
+
+// GETRANGE key start end
+// Return value is the substring between start and end offsets (both inclusive).
+// Negative offsets are counted from the end of the string.
+func getrangeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	start, serr := strconv.ParseInt(r.argv[1], 10, 64)
+	end, eerr := strconv.ParseInt(r.argv[2], 10, 64)
+	if serr != nil || eerr != nil {
+		return nil, ErrBadArguments
+	}
+
+	v, err := findStringEntry(s, r.argv[0])
+	if err != nil || v == nil {
+		return "", err
+	}
+
+	str := v.(binaryString)
+	start, end, ok := normalizeRange(start, end, int64(len(str)))
+	if !ok {
+		return "", nil
+	}
+
+	return str[start : end+1], nil
+}
+
+// SETRANGE key offset value
+// Return value is the length of the string after it was modified.
+func setrangeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	offset, err := strconv.ParseInt(r.argv[1], 10, 64)
+	if err != nil || offset < 0 {
+		return nil, ErrBadArguments
+	}
+	if offset+int64(len(r.argv[2])) > stringMaxSize {
+		return nil, ErrStringTooLong
+	}
+
+	b, err := findBytesEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(r.argv[2]) == 0 {
+		return len(b), nil
+	}
+
+	b = growBytes(b, int(offset)+len(r.argv[2]))
+	copy(b[offset:], r.argv[2])
+	s.setKeepTTL(r.argv[0], b)
+	notifyKeyspaceEvent(notifyString, "setrange", r.argv[0], r.db)
+
+	return len(b), nil
+}
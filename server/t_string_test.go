@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestStringCommands(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+		err  error
+	}{
+		{"set", []string{"a"}, "0", ErrWrongNumOfArguments},
+		{"set", []string{"a", "1", "NX", "XX"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EX"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EX", "0"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EX", "-1"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EX", "ten"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "PX", "0"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "PX", "-100"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "PX", "1.5"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EXAT", "0"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EXAT", "-1"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EXAT", "now"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "PXAT", "0"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "PXAT", "-1"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "PXAT", "1e3"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EX", "10", "PX", "100"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "KEEPTTL", "EX", "10"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "EX", "10", "KEEPTTL"}, "0", ErrBadArguments},
+		{"set", []string{"a", "1", "NOPE"}, "0", ErrBadArguments},
+		{"get", []string{"a"}, "null", nil},
+
+		{"set", []string{"a", "1", "XX"}, "0", nil},
+		{"set", []string{"a", "1", "NX"}, "1", nil},
+		{"set", []string{"a", "2", "NX", "GET"}, `"1"`, nil},
+		{"set", []string{"a", "3", "XX", "GET"}, `"1"`, nil},
+		{"set", []string{"a", "4", "GET"}, `"3"`, nil},
+		{"set", []string{"b", "1", "GET"}, "null", nil},
+
+		// the legacy form with a ttl in seconds
+		{"set", []string{"c", "1", "100"}, "1", nil},
+		{"set", []string{"c", "1", "0"}, "0", ErrBadArguments},
+		{"set", []string{"c", "1", "-100"}, "0", ErrBadArguments},
+		{"get", []string{"c"}, `"1"`, nil},
+
+		{"rpush", []string{"l", "x"}, "1", nil},
+		{"set", []string{"l", "v", "GET"}, "null", ErrOperationAgainstWrongType},
+		{"lrange", []string{"l", "0", "-1"}, `["x"]`, nil},
+		{"set", []string{"l", "v", "NX", "GET"}, "null", ErrOperationAgainstWrongType},
+		{"get", []string{"l"}, "null", ErrOperationAgainstWrongType},
+
+		{"setnx", []string{"a", "5"}, "0", nil},
+		{"setnx", []string{"d", "5"}, "1", nil},
+		{"setnx", []string{"d"}, "0", ErrWrongNumOfArguments},
+
+		{"getset", []string{"d", "6"}, `"5"`, nil},
+		{"getset", []string{"e", "1"}, "null", nil},
+		{"getset", []string{"l", "1"}, "null", ErrOperationAgainstWrongType},
+		{"getset", []string{"e"}, "null", ErrWrongNumOfArguments},
+
+		{"getdel", []string{"e"}, `"1"`, nil},
+		{"getdel", []string{"e"}, "null", nil},
+		{"getdel", []string{"l"}, "null", ErrOperationAgainstWrongType},
+
+		{"getex", []string{"d"}, `"6"`, nil},
+		{"getex", []string{"d", "EX", "0"}, "null", ErrBadArguments},
+		{"getex", []string{"d", "PX", "-1"}, "null", ErrBadArguments},
+		{"getex", []string{"d", "EXAT", "soon"}, "null", ErrBadArguments},
+		{"getex", []string{"d", "PERSIST", "1"}, "null", ErrBadArguments},
+		{"getex", []string{"d", "EX"}, "null", ErrBadArguments},
+		{"getex", []string{"d", "EX", "100"}, `"6"`, nil},
+		{"getex", []string{"d", "PERSIST"}, `"6"`, nil},
+		{"getex", []string{"none", "EX", "100"}, "null", nil},
+		{"getex", []string{"l"}, "null", ErrOperationAgainstWrongType},
+
+		{"mset", []string{"a"}, "0", ErrWrongNumOfArguments},
+		{"mset", []string{"a", "1", "b"}, "0", ErrWrongNumOfArguments},
+		{"mset", []string{"a", "1", "b", "2"}, "1", nil},
+		{"mget", []string{"a", "b", "none", "l"}, `["1","2",null,null]`, nil},
+		{"mget", []string{}, "null", ErrWrongNumOfArguments},
+	}
+
+	t.Log("Given string commands")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %v", i, tt.cmd, tt.argv)
+
+		res, err := execute(s, tt.cmd, tt.argv...)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want && err == tt.err {
+			t.Logf("\t%s\tShould get %s, %v", succeed, tt.want, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %s, %v, got %s, %v", failed, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+func TestSetExpire(t *testing.T) {
+	deadline := func(s *storage, k string) int64 {
+		if e := s.getEntry(k); e != nil && e.expiry != nil {
+			return e.expiry.ttl
+		}
+		return 0
+	}
+
+	t.Log("Given keys set with expiry options")
+	{
+		s := newStorage()
+
+		t.Log("\tWhen setting the key with the legacy ttl")
+		{
+			now := mstime()
+			execute(s, "set", "a", "1", "100")
+			if d := deadline(s, "a"); d >= now+100000 && d <= mstime()+100000 {
+				t.Logf("\t%s\tShould expire in 100 seconds", succeed)
+			} else {
+				t.Errorf("\t%s\tShould expire in 100 seconds, got %d", failed, d-now)
+			}
+		}
+
+		t.Log("\tWhen setting the key with KEEPTTL")
+		{
+			execute(s, "set", "b", "1", "EXAT", "4102444800")
+			execute(s, "set", "b", "2", "KEEPTTL")
+			if d := deadline(s, "b"); d == 4102444800000 {
+				t.Logf("\t%s\tShould keep the deadline", succeed)
+			} else {
+				t.Errorf("\t%s\tShould keep the deadline, got %d", failed, d)
+			}
+
+			execute(s, "set", "b", "3")
+			if d := deadline(s, "b"); d == 0 {
+				t.Logf("\t%s\tShould drop the deadline without KEEPTTL", succeed)
+			} else {
+				t.Errorf("\t%s\tShould drop the deadline without KEEPTTL, got %d", failed, d)
+			}
+		}
+	}
+}
+
+func TestSetCmdlog(t *testing.T) {
+	defer func(dbs []*storage, l *cmdlog) { databases, cmdlogger = dbs, l }(databases, cmdlogger)
+
+	databases = newDatabases(1)
+	cmdlogger = &cmdlog{logchan: make(chan *request, 1)}
+
+	tests := []struct {
+		cmd  []string
+		want []string // the logged command, PXAT is replaced with the deadline
+	}{
+		{[]string{"set", "a", "1", "ex", "100", "nx", "get"}, []string{"set", "a", "1", "NX", "PXAT"}},
+		{[]string{"set", "a", "1"}, []string{"set", "a", "1"}},
+		{[]string{"set", "a", "1", "100"}, []string{"set", "a", "1", "PXAT"}},
+		{[]string{"set", "a", "1", "PX", "100000", "XX"}, []string{"set", "a", "1", "XX", "PXAT"}},
+		{[]string{"set", "a", "1", "EXAT", "4102444800"}, []string{"set", "a", "1", "PXAT"}},
+		{[]string{"set", "a", "2", "KEEPTTL"}, []string{"set", "a", "2", "KEEPTTL"}},
+		{[]string{"getex", "a", "EX", "100"}, []string{"getex", "a", "PXAT"}},
+		{[]string{"getex", "a"}, []string{"getex", "a"}},
+	}
+
+	t.Log("Given commands setting expiries")
+
+	c := &client{}
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %v", i, tt.cmd)
+
+		r := &request{cmd: tt.cmd[0], argv: tt.cmd[1:], argc: len(tt.cmd) - 1, client: c}
+		if _, err := executeCmd(r); err != nil {
+			t.Fatalf("\t%s\tShould execute %v: %v", failed, tt.cmd, err)
+		}
+
+		var got []string
+		select {
+		case lr := <-cmdlogger.logchan:
+			got = append([]string{lr.cmd}, lr.argv...)
+		default:
+		}
+
+		want := tt.want
+		if n := len(want); n > 0 && want[n-1] == "PXAT" {
+			e := databases[0].getEntry("a")
+			want = append(want[:n:n], strconv.FormatInt(e.expiry.ttl, 10))
+		}
+		if reflect.DeepEqual(got, want) {
+			t.Logf("\t%s\tShould log %v", succeed, want)
+		} else {
+			t.Errorf("\t%s\tShould log %v, got %v", failed, want, got)
+		}
+	}
+}
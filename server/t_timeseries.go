@@ -0,0 +1,638 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A timeSeries keeps samples ordered by their timestamps (in milliseconds).
+// Samples older than retention relative to the latest sample are dropped.
+//
+// Compaction rules maintain downsampled series in other keys: once a sample
+// opens a new bucket of a rule, the previous bucket is aggregated into the
+// destination series. A sample added out of order into a closed bucket makes
+// the bucket recomputed. A destination of a rule can't have rules itself and
+// a series can be the destination of a single rule, so rules never chain.
+// Everything depends on timestamps of samples only, so replaying the command
+// log rebuilds the same series.
+
+var (
+	// ErrTSKeyExists ...
+	ErrTSKeyExists = errors.New("ERR key already exists")
+	// ErrTSNoKey ...
+	ErrTSNoKey = errors.New("ERR the key does not exist")
+	// ErrTSDuplicateSample ...
+	ErrTSDuplicateSample = errors.New("ERR duplicate sample is blocked by the duplicate policy")
+	// ErrTSTooOld ...
+	ErrTSTooOld = errors.New("ERR timestamp is older than retention")
+	// ErrTSBadRule ...
+	ErrTSBadRule = errors.New("ERR invalid compaction rule")
+	// ErrTSNoRule ...
+	ErrTSNoRule = errors.New("ERR compaction rule does not exist")
+)
+
+const (
+	tsPolicyBlock = "block"
+	tsPolicyFirst = "first"
+	tsPolicyLast  = "last"
+	tsPolicyMin   = "min"
+	tsPolicyMax   = "max"
+	tsPolicySum   = "sum"
+)
+
+var (
+	tsPolicies = map[string]bool{
+		tsPolicyBlock: true,
+		tsPolicyFirst: true,
+		tsPolicyLast:  true,
+		tsPolicyMin:   true,
+		tsPolicyMax:   true,
+		tsPolicySum:   true,
+	}
+
+	tsAggregators = map[string]func([]float64) float64{
+		"avg": func(v []float64) float64 {
+			sum := 0.0
+			for _, x := range v {
+				sum += x
+			}
+			return sum / float64(len(v))
+		},
+		"sum": func(v []float64) float64 {
+			sum := 0.0
+			for _, x := range v {
+				sum += x
+			}
+			return sum
+		},
+		"min": func(v []float64) float64 {
+			m := v[0]
+			for _, x := range v[1:] {
+				m = math.Min(m, x)
+			}
+			return m
+		},
+		"max": func(v []float64) float64 {
+			m := v[0]
+			for _, x := range v[1:] {
+				m = math.Max(m, x)
+			}
+			return m
+		},
+		"count": func(v []float64) float64 { return float64(len(v)) },
+		"first": func(v []float64) float64 { return v[0] },
+		"last":  func(v []float64) float64 { return v[len(v)-1] },
+	}
+)
+
+type tsSample struct {
+	ts    int64
+	value float64
+}
+
+type tsRule struct {
+	dest        string
+	aggregation string
+	bucket      int64
+}
+
+func (r *tsRule) bucketStart(ts int64) int64 {
+	return tsBucketStart(ts, r.bucket)
+}
+
+// tsBucketStart returns the start of the bucket containing ts.
+// Buckets are aligned to the epoch.
+func tsBucketStart(ts int64, bucket int64) int64 {
+	return ts - ts%bucket
+}
+
+type timeSeries struct {
+	samples   []tsSample
+	retention int64 // 0 means samples are kept forever
+	policy    string
+	rules     []*tsRule
+	source    string // key of the series this one is the destination of a rule of
+}
+
+func newTimeSeries(retention int64, policy string) *timeSeries {
+	return &timeSeries{retention: retention, policy: policy}
+}
+
+// copy returns a copy of samples and settings of the series.
+// Compaction rules are not copied, as they belong to the keys.
+func (t *timeSeries) copy() *timeSeries {
+	c := newTimeSeries(t.retention, t.policy)
+	c.samples = append([]tsSample(nil), t.samples...)
+	return c
+}
+
+// search returns the index of the first sample with timestamp not less than ts.
+func (t *timeSeries) search(ts int64) int {
+	return sort.Search(len(t.samples), func(i int) bool { return t.samples[i].ts >= ts })
+}
+
+func (t *timeSeries) last() (tsSample, bool) {
+	if len(t.samples) == 0 {
+		return tsSample{}, false
+	}
+	return t.samples[len(t.samples)-1], true
+}
+
+// add inserts the sample resolving a duplicate timestamp with the policy.
+func (t *timeSeries) add(ts int64, value float64, policy string) error {
+	if last, ok := t.last(); ok && t.retention > 0 && ts < last.ts-t.retention {
+		return ErrTSTooOld
+	}
+
+	i := t.search(ts)
+	if i < len(t.samples) && t.samples[i].ts == ts {
+		old := t.samples[i].value
+		switch policy {
+		case tsPolicyBlock:
+			return ErrTSDuplicateSample
+		case tsPolicyFirst:
+			value = old
+		case tsPolicyMin:
+			value = math.Min(old, value)
+		case tsPolicyMax:
+			value = math.Max(old, value)
+		case tsPolicySum:
+			value += old
+		}
+		t.samples[i].value = value
+		return nil
+	}
+
+	t.samples = append(t.samples, tsSample{})
+	copy(t.samples[i+1:], t.samples[i:])
+	t.samples[i] = tsSample{ts, value}
+	t.trim()
+
+	return nil
+}
+
+// trim drops samples which are out of retention.
+func (t *timeSeries) trim() {
+	last, ok := t.last()
+	if !ok || t.retention == 0 {
+		return
+	}
+	if i := t.search(last.ts - t.retention); i > 0 {
+		t.samples = append(t.samples[:0], t.samples[i:]...)
+	}
+}
+
+// values returns values of samples with timestamps in [from, to].
+func (t *timeSeries) values(from int64, to int64) []tsSample {
+	i := t.search(from)
+	j := t.search(to)
+	if j < len(t.samples) && t.samples[j].ts == to {
+		j++
+	}
+	if i >= j {
+		return nil
+	}
+	return t.samples[i:j]
+}
+
+// compact aggregates the bucket starting at b into the destination series.
+func (t *timeSeries) compact(r *tsRule, dest *timeSeries, b int64) {
+	samples := t.values(b, b+r.bucket-1)
+	if len(samples) == 0 {
+		return
+	}
+
+	values := make([]float64, len(samples))
+	for i, smp := range samples {
+		values[i] = smp.value
+	}
+	dest.add(b, tsAggregators[r.aggregation](values), tsPolicyLast)
+}
+
+// applyRules updates destinations of rules after a sample with timestamp ts
+// was added. prev is the timestamp of the latest sample before that.
+func (t *timeSeries) applyRules(s *storage, ts int64, prev int64) {
+	for _, r := range t.rules {
+		dest, err := findTSEntry(s, r.dest)
+		if err != nil || dest == nil {
+			continue
+		}
+
+		b, pb := r.bucketStart(ts), r.bucketStart(prev)
+		if b > pb {
+			// the bucket of the previous latest sample is closed now
+			t.compact(r, dest, pb)
+		} else if b < pb {
+			// a closed bucket was changed
+			t.compact(r, dest, b)
+		}
+	}
+}
+
+// unlinkSeries drops compaction rules between the series v stored at k
+// and other series of the database once v is removed from k. Values of
+// other types are ignored. The caller must hold the mutex of s.
+func (s *storage) unlinkSeries(k string, v interface{}) {
+	t, ok := v.(*timeSeries)
+	if !ok {
+		return
+	}
+
+	if src, ok := s.entries[t.source].value.(*timeSeries); ok && t.source != "" {
+		for i, r := range src.rules {
+			if r.dest == k {
+				src.rules = append(src.rules[:i], src.rules[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, r := range t.rules {
+		if dest, ok := s.entries[r.dest].value.(*timeSeries); ok && dest.source == k {
+			dest.source = ""
+		}
+	}
+	t.rules, t.source = nil, ""
+}
+
+// renameSeries points compaction rules linking the series v with other
+// series of the database at its new key dst. Values of other types are
+// ignored. The caller must hold the mutex of s.
+func (s *storage) renameSeries(src string, dst string, v interface{}) {
+	t, ok := v.(*timeSeries)
+	if !ok {
+		return
+	}
+
+	if source, ok := s.entries[t.source].value.(*timeSeries); ok && t.source != "" {
+		for _, r := range source.rules {
+			if r.dest == src {
+				r.dest = dst
+			}
+		}
+	}
+	for _, r := range t.rules {
+		if dest, ok := s.entries[r.dest].value.(*timeSeries); ok && dest.source == src {
+			dest.source = dst
+		}
+	}
+}
+
+func findTSEntry(s *storage, k string) (*timeSeries, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*timeSeries); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+func parseSampleValue(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrNotFloat
+	}
+	return f, nil
+}
+
+func parseTimestamp(v string) (int64, error) {
+	ts, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, ErrBadArguments
+	}
+	return ts, nil
+}
+
+// parseTSOptions parses RETENTION and the given policy option of TS.CREATE
+// and TS.ADD.
+func parseTSOptions(argv []string, policyOpt string) (retention int64, policy string, err error) {
+	for i := 0; i < len(argv); i += 2 {
+		if i+1 >= len(argv) {
+			return 0, "", ErrBadArguments
+		}
+
+		switch opt := strings.ToLower(argv[i]); opt {
+		case "retention":
+			if retention, err = strconv.ParseInt(argv[i+1], 10, 64); err != nil || retention < 0 {
+				return 0, "", ErrBadArguments
+			}
+		case policyOpt:
+			policy = strings.ToLower(argv[i+1])
+			if !tsPolicies[policy] {
+				return 0, "", ErrBadArguments
+			}
+		default:
+			return 0, "", ErrBadArguments
+		}
+	}
+	return retention, policy, nil
+}
+
+// TS.CREATE key [RETENTION retentionPeriod] [DUPLICATE_POLICY policy]
+func tscreateCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	retention, policy, err := parseTSOptions(r.argv[1:], "duplicate_policy")
+	if err != nil {
+		return nil, err
+	}
+	if policy == "" {
+		policy = tsPolicyBlock
+	}
+
+	if s.get(r.argv[0]) != nil {
+		return nil, ErrTSKeyExists
+	}
+	s.set(r.argv[0], newTimeSeries(retention, policy))
+
+	return 1, nil
+}
+
+// TS.ADD key timestamp|* value [RETENTION retentionPeriod] [ON_DUPLICATE policy]
+// Adds a sample creating the series if it does not exist. * means
+// the current time. RETENTION is only used when the series is created.
+// Return value is the timestamp of the sample.
+func tsaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	var ts int64
+	var err error
+	if r.argv[1] == "*" {
+		ts = mstime()
+	} else if ts, err = parseTimestamp(r.argv[1]); err != nil {
+		return nil, err
+	}
+	value, err := parseSampleValue(r.argv[2])
+	if err != nil {
+		return nil, err
+	}
+	retention, policy, err := parseTSOptions(r.argv[3:], "on_duplicate")
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := findTSEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		t = newTimeSeries(retention, tsPolicyBlock)
+		s.set(r.argv[0], t)
+	}
+	if policy == "" {
+		policy = t.policy
+	}
+
+	prev, hadSamples := t.last()
+	if err := t.add(ts, value, policy); err != nil {
+		return nil, err
+	}
+	if hadSamples {
+		t.applyRules(s, ts, prev.ts)
+	}
+
+	if r.argv[1] == "*" {
+		argv := append([]string{r.argv[0], strconv.FormatInt(ts, 10)}, r.argv[2:]...)
+		r.rewrite("ts.add", argv...)
+	}
+
+	return ts, nil
+}
+
+type tsSampleReply struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+func formatSampleValue(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// TS.GET key
+// Return value is the latest sample or nil if the series is empty.
+func tsgetCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	t, err := findTSEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrTSNoKey
+	}
+
+	if last, ok := t.last(); ok {
+		return tsSampleReply{last.ts, formatSampleValue(last.value)}, nil
+	}
+	return nil, nil
+}
+
+// TS.RANGE key fromTimestamp toTimestamp [COUNT count]
+// [AGGREGATION avg|sum|min|max|count|first|last bucketDuration]
+// Timestamps - and + mean the earliest and the latest sample. Return value
+// is a list of samples or, with AGGREGATION, a list of aggregated buckets
+// (aligned to the epoch) within the range.
+func tsrangeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	from, to := int64(0), int64(math.MaxInt64)
+	var err error
+	if r.argv[1] != "-" {
+		if from, err = parseTimestamp(r.argv[1]); err != nil {
+			return nil, err
+		}
+	}
+	if r.argv[2] != "+" {
+		if to, err = parseTimestamp(r.argv[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	count := 0
+	var aggregate func([]float64) float64
+	var bucket int64
+	for i := 3; i < r.argc; i++ {
+		switch opt := strings.ToLower(r.argv[i]); {
+		case opt == "count" && i+1 < r.argc:
+			if count, err = strconv.Atoi(r.argv[i+1]); err != nil || count < 1 {
+				return nil, ErrBadArguments
+			}
+			i++
+		case opt == "aggregation" && i+2 < r.argc:
+			var ok bool
+			if aggregate, ok = tsAggregators[strings.ToLower(r.argv[i+1])]; !ok {
+				return nil, ErrBadArguments
+			}
+			if bucket, err = strconv.ParseInt(r.argv[i+2], 10, 64); err != nil || bucket < 1 {
+				return nil, ErrBadArguments
+			}
+			i += 2
+		default:
+			return nil, ErrBadArguments
+		}
+	}
+
+	t, err := findTSEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrTSNoKey
+	}
+
+	samples := t.values(from, to)
+	res := []tsSampleReply{}
+	if aggregate == nil {
+		for _, smp := range samples {
+			if count > 0 && len(res) == count {
+				break
+			}
+			res = append(res, tsSampleReply{smp.ts, formatSampleValue(smp.value)})
+		}
+		return res, nil
+	}
+
+	for len(samples) > 0 {
+		if count > 0 && len(res) == count {
+			break
+		}
+
+		b := tsBucketStart(samples[0].ts, bucket)
+		values := []float64{}
+		for len(samples) > 0 && samples[0].ts < b+bucket {
+			values = append(values, samples[0].value)
+			samples = samples[1:]
+		}
+		res = append(res, tsSampleReply{b, formatSampleValue(aggregate(values))})
+	}
+
+	return res, nil
+}
+
+// TS.CREATERULE sourceKey destKey AGGREGATION avg|sum|min|max|count|first|last bucketDuration
+func tscreateruleCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 5 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	aggregation := strings.ToLower(r.argv[3])
+	if strings.ToLower(r.argv[2]) != "aggregation" || tsAggregators[aggregation] == nil {
+		return nil, ErrBadArguments
+	}
+	bucket, err := strconv.ParseInt(r.argv[4], 10, 64)
+	if err != nil || bucket < 1 {
+		return nil, ErrBadArguments
+	}
+
+	src, err := findTSEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	dest, err := findTSEntry(s, r.argv[1])
+	if err != nil {
+		return nil, err
+	}
+	if src == nil || dest == nil {
+		return nil, ErrTSNoKey
+	}
+
+	if r.argv[0] == r.argv[1] || src.source != "" || len(dest.rules) > 0 || dest.source != "" {
+		return nil, ErrTSBadRule
+	}
+
+	src.rules = append(src.rules, &tsRule{r.argv[1], aggregation, bucket})
+	dest.source = r.argv[0]
+
+	return 1, nil
+}
+
+// TS.DELETERULE sourceKey destKey
+func tsdeleteruleCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	src, err := findTSEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		return nil, ErrTSNoKey
+	}
+
+	for i, rule := range src.rules {
+		if rule.dest != r.argv[1] {
+			continue
+		}
+
+		src.rules = append(src.rules[:i], src.rules[i+1:]...)
+		if dest, _ := findTSEntry(s, r.argv[1]); dest != nil {
+			dest.source = ""
+		}
+		return 1, nil
+	}
+
+	return nil, ErrTSNoRule
+}
+
+type tsRuleReply struct {
+	Dest        string `json:"dest"`
+	Bucket      int64  `json:"bucket"`
+	Aggregation string `json:"aggregation"`
+}
+
+type tsInfoReply struct {
+	TotalSamples    int           `json:"totalSamples"`
+	FirstTimestamp  int64         `json:"firstTimestamp"`
+	LastTimestamp   int64         `json:"lastTimestamp"`
+	RetentionTime   int64         `json:"retentionTime"`
+	DuplicatePolicy string        `json:"duplicatePolicy"`
+	SourceKey       string        `json:"sourceKey"`
+	Rules           []tsRuleReply `json:"rules"`
+}
+
+// TS.INFO key
+func tsinfoCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	t, err := findTSEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrTSNoKey
+	}
+
+	info := tsInfoReply{
+		TotalSamples:    len(t.samples),
+		RetentionTime:   t.retention,
+		DuplicatePolicy: t.policy,
+		SourceKey:       t.source,
+		Rules:           []tsRuleReply{},
+	}
+	if len(t.samples) > 0 {
+		info.FirstTimestamp = t.samples[0].ts
+		info.LastTimestamp = t.samples[len(t.samples)-1].ts
+	}
+	for _, rule := range t.rules {
+		info.Rules = append(info.Rules, tsRuleReply{rule.dest, rule.bucket, rule.aggregation})
+	}
+
+	return info, nil
+}
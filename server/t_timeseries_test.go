@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTimeSeries(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+		err  error
+	}{
+		// aggregation of TS.RANGE
+		{"ts.add", []string{"a", "1", "1"}, "1", nil},
+		{"ts.add", []string{"a", "5", "3"}, "5", nil},
+		{"ts.add", []string{"a", "12", "10"}, "12", nil},
+		{"ts.add", []string{"a", "15", "20"}, "15", nil},
+		{"ts.add", []string{"a", "25", "1"}, "25", nil},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "avg", "10"},
+			`[{"timestamp":0,"value":"2"},{"timestamp":10,"value":"15"},{"timestamp":20,"value":"1"}]`, nil},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "sum", "20"},
+			`[{"timestamp":0,"value":"34"},{"timestamp":20,"value":"1"}]`, nil},
+		{"ts.range", []string{"a", "5", "15", "AGGREGATION", "count", "10"},
+			`[{"timestamp":0,"value":"1"},{"timestamp":10,"value":"2"}]`, nil},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "max", "10", "COUNT", "2"},
+			`[{"timestamp":0,"value":"3"},{"timestamp":10,"value":"20"}]`, nil},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "first", "1000"},
+			`[{"timestamp":0,"value":"1"}]`, nil},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "last", "1000"},
+			`[{"timestamp":0,"value":"1"}]`, nil},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "min", "0"}, "null", ErrBadArguments},
+		{"ts.range", []string{"a", "-", "+", "AGGREGATION", "median", "10"}, "null", ErrBadArguments},
+
+		// compaction rules
+		{"ts.create", []string{"src"}, "1", nil},
+		{"ts.create", []string{"avg"}, "1", nil},
+		{"ts.create", []string{"cnt"}, "1", nil},
+		{"ts.createrule", []string{"src", "avg", "AGGREGATION", "avg", "10"}, "1", nil},
+		{"ts.createrule", []string{"src", "cnt", "AGGREGATION", "count", "20"}, "1", nil},
+		{"ts.createrule", []string{"avg", "cnt", "AGGREGATION", "sum", "10"}, "null", ErrTSBadRule},
+		{"ts.createrule", []string{"src", "none", "AGGREGATION", "sum", "10"}, "null", ErrTSNoKey},
+		{"ts.add", []string{"src", "1", "1"}, "1", nil},
+		{"ts.add", []string{"src", "5", "3"}, "5", nil},
+		{"ts.range", []string{"avg", "-", "+"}, `[]`, nil},
+		{"ts.add", []string{"src", "12", "10"}, "12", nil},
+		{"ts.range", []string{"avg", "-", "+"}, `[{"timestamp":0,"value":"2"}]`, nil},
+		{"ts.add", []string{"src", "15", "20"}, "15", nil},
+		{"ts.add", []string{"src", "21", "5"}, "21", nil},
+		{"ts.range", []string{"avg", "-", "+"}, `[{"timestamp":0,"value":"2"},{"timestamp":10,"value":"15"}]`, nil},
+		{"ts.range", []string{"cnt", "-", "+"}, `[{"timestamp":0,"value":"4"}]`, nil},
+		{"ts.add", []string{"src", "7", "8"}, "7", nil},
+		{"ts.range", []string{"avg", "-", "+"}, `[{"timestamp":0,"value":"4"},{"timestamp":10,"value":"15"}]`, nil},
+		{"ts.range", []string{"cnt", "-", "+"}, `[{"timestamp":0,"value":"5"}]`, nil},
+		{"ts.deleterule", []string{"src", "avg"}, "1", nil},
+		{"ts.add", []string{"src", "31", "1"}, "31", nil},
+		{"ts.range", []string{"avg", "-", "+"}, `[{"timestamp":0,"value":"4"},{"timestamp":10,"value":"15"}]`, nil},
+		{"ts.deleterule", []string{"src", "avg"}, "null", ErrTSNoRule},
+
+		// duplicate policy
+		{"ts.add", []string{"d", "1", "100"}, "1", nil},
+		{"ts.add", []string{"d", "1", "1"}, "null", ErrTSDuplicateSample},
+		{"ts.add", []string{"d", "1", "1", "ON_DUPLICATE", "sum"}, "1", nil},
+		{"ts.get", []string{"d"}, `{"timestamp":1,"value":"101"}`, nil},
+		{"ts.add", []string{"d", "1", "50", "ON_DUPLICATE", "min"}, "1", nil},
+		{"ts.add", []string{"d", "1", "70", "ON_DUPLICATE", "max"}, "1", nil},
+		{"ts.add", []string{"d", "1", "0", "ON_DUPLICATE", "first"}, "1", nil},
+		{"ts.get", []string{"d"}, `{"timestamp":1,"value":"70"}`, nil},
+		{"ts.add", []string{"d", "1", "3", "ON_DUPLICATE", "last"}, "1", nil},
+		{"ts.get", []string{"d"}, `{"timestamp":1,"value":"3"}`, nil},
+		{"ts.add", []string{"d", "1", "3", "ON_DUPLICATE", "any"}, "null", ErrBadArguments},
+		{"ts.create", []string{"dmax", "DUPLICATE_POLICY", "max"}, "1", nil},
+		{"ts.add", []string{"dmax", "1", "5"}, "1", nil},
+		{"ts.add", []string{"dmax", "1", "3"}, "1", nil},
+		{"ts.add", []string{"dmax", "1", "4", "ON_DUPLICATE", "last"}, "1", nil},
+		{"ts.range", []string{"dmax", "-", "+"}, `[{"timestamp":1,"value":"4"}]`, nil},
+
+		// retention is set only when TS.ADD creates the series
+		{"ts.add", []string{"r", "100", "1", "RETENTION", "50"}, "100", nil},
+		{"ts.add", []string{"r", "200", "2"}, "200", nil},
+		{"ts.range", []string{"r", "-", "+"}, `[{"timestamp":200,"value":"2"}]`, nil},
+		{"ts.add", []string{"r", "140", "0"}, "null", ErrTSTooOld},
+		{"ts.add", []string{"r", "140", "0", "RETENTION", "0"}, "null", ErrTSTooOld},
+		{"ts.add", []string{"r", "160", "0", "RETENTION", "0"}, "160", nil},
+		{"ts.add", []string{"r", "220", "3", "RETENTION", "100"}, "220", nil},
+		{"ts.range", []string{"r", "-", "+"}, `[{"timestamp":200,"value":"2"},{"timestamp":220,"value":"3"}]`, nil},
+		{"ts.add", []string{"r", "220", "3", "RETENTION", "-1"}, "null", ErrBadArguments},
+	}
+
+	t.Log("Given time series commands")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %v", i, tt.cmd, tt.argv)
+
+		res, err := execute(s, tt.cmd, tt.argv...)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want && err == tt.err {
+			t.Logf("\t%s\tShould get %s, %v", succeed, tt.want, tt.err)
+		} else {
+			t.Errorf("\t%s\tShould get %s, %v, got %s, %v", failed, tt.want, tt.err, got, err)
+		}
+	}
+}
+
+// seriesLinks describes compaction rules of series of the database,
+// e.g. "dst<-src src->dst".
+func seriesLinks(s *storage) string {
+	var links []string
+	for k, e := range s.entries {
+		t, ok := e.value.(*timeSeries)
+		if !ok {
+			continue
+		}
+		if t.source != "" {
+			links = append(links, k+"<-"+t.source)
+		}
+		for _, r := range t.rules {
+			links = append(links, k+"->"+r.dest)
+		}
+	}
+	sort.Strings(links)
+	return strings.Join(links, " ")
+}
+
+func TestTimeSeriesRuleLinks(t *testing.T) {
+	defer func(dbs []*storage) { databases = dbs }(databases)
+
+	tests := []struct {
+		name string
+		cmds [][]string
+		want string
+	}{
+		{"the source is renamed", [][]string{{"rename", "src", "a"}}, "a->dst dst<-a"},
+		{"the destination is renamed", [][]string{{"rename", "dst", "b"}}, "b<-src src->b"},
+		{"the source is renamed to the destination", [][]string{{"rename", "src", "dst"}}, ""},
+		{"the destination is renamed to the source", [][]string{{"rename", "dst", "src"}}, ""},
+		{"the source is deleted", [][]string{{"del", "src"}}, ""},
+		{"the destination is deleted", [][]string{{"del", "dst"}}, ""},
+		{"the destination is overwritten", [][]string{{"set", "dst", "x"}}, ""},
+		{"the destination is replaced with a copy", [][]string{{"ts.create", "c"}, {"copy", "c", "dst", "REPLACE"}}, ""},
+		{"the source is copied", [][]string{{"copy", "src", "c"}}, "dst<-src src->dst"},
+		{"the source is moved", [][]string{{"move", "src", "1"}}, ""},
+		{"the destination is moved", [][]string{{"move", "dst", "1"}}, ""},
+	}
+
+	t.Log("Given a compaction rule from src to dst")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen %s", i, tt.name)
+
+		databases = newDatabases(2)
+		s := databases[0]
+		execute(s, "ts.create", "src")
+		execute(s, "ts.create", "dst")
+		execute(s, "ts.createrule", "src", "dst", "AGGREGATION", "sum", "10")
+		for _, cmd := range tt.cmds {
+			if _, err := execute(s, cmd[0], cmd[1:]...); err != nil {
+				t.Fatalf("\t%s\tShould execute %v: %v", failed, cmd, err)
+			}
+		}
+
+		if got := seriesLinks(s); got == tt.want {
+			t.Logf("\t%s\tShould get rules %q", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get rules %q, got %q", failed, tt.want, got)
+		}
+		if got := seriesLinks(databases[1]); got != "" {
+			t.Errorf("\t%s\tShould drop rules of moved series, got %q", failed, got)
+		}
+	}
+
+	t.Log("Given a renamed source of a compaction rule")
+	{
+		t.Log("\tWhen samples are added to it")
+		{
+			s := newStorage()
+			execute(s, "ts.create", "src")
+			execute(s, "ts.create", "dst")
+			execute(s, "ts.createrule", "src", "dst", "AGGREGATION", "sum", "10")
+			execute(s, "rename", "src", "a")
+			execute(s, "rename", "dst", "b")
+			execute(s, "ts.add", "a", "1", "1")
+			execute(s, "ts.add", "a", "2", "2")
+			execute(s, "ts.add", "a", "11", "5")
+
+			res, _ := execute(s, "ts.range", "b", "-", "+")
+			b, _ := json.Marshal(res)
+			if want := `[{"timestamp":0,"value":"3"}]`; string(b) == want {
+				t.Logf("\t%s\tShould compact them into the renamed destination", succeed)
+			} else {
+				t.Errorf("\t%s\tShould compact them into the renamed destination, got %s", failed, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFloat ...
+var ErrNotFloat = errors.New("ERR value is not a valid float")
+
+type zsetMember struct {
+	member string
+	score  float64
+}
+
+func (m zsetMember) less(o zsetMember) bool {
+	return m.score < o.score || m.score == o.score && m.member < o.member
+}
+
+// A sortedSet keeps unique members ordered by their scores. Members with
+// the same score are ordered lexicographically.
+type sortedSet struct {
+	scores  map[string]float64
+	members []zsetMember
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64)}
+}
+
+func (z *sortedSet) copy() *sortedSet {
+	c := &sortedSet{
+		scores:  make(map[string]float64, len(z.scores)),
+		members: append([]zsetMember(nil), z.members...),
+	}
+	for m, score := range z.scores {
+		c.scores[m] = score
+	}
+	return c
+}
+
+func (z *sortedSet) len() int {
+	return len(z.members)
+}
+
+// search returns the index of the first member not less than m.
+func (z *sortedSet) search(m zsetMember) int {
+	return sort.Search(len(z.members), func(i int) bool {
+		return !z.members[i].less(m)
+	})
+}
+
+// add adds the member or updates its score. It returns true if the member is new.
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.rem(member)
+	}
+
+	m := zsetMember{member, score}
+	i := z.search(m)
+	z.members = append(z.members, zsetMember{})
+	copy(z.members[i+1:], z.members[i:])
+	z.members[i] = m
+	z.scores[member] = score
+
+	return !exists
+}
+
+func (z *sortedSet) rem(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+
+	i := z.search(zsetMember{member, score})
+	z.members = append(z.members[:i], z.members[i+1:]...)
+	delete(z.scores, member)
+
+	return true
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// rangeByScore returns members with scores in [min, max).
+func (z *sortedSet) rangeByScore(min float64, max float64) []zsetMember {
+	i := sort.Search(len(z.members), func(i int) bool { return z.members[i].score >= min })
+	j := sort.Search(len(z.members), func(i int) bool { return z.members[i].score >= max })
+	if i >= j {
+		return nil
+	}
+	return z.members[i:j]
+}
+
+func findZSetEntry(s *storage, k string) (*sortedSet, error) {
+	v := s.get(k)
+	if v == nil {
+		return nil, nil
+	}
+
+	if v, ok := v.(*sortedSet); ok {
+		return v, nil
+	}
+
+	return nil, ErrOperationAgainstWrongType
+}
+
+func parseScore(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, ErrNotFloat
+	}
+	return f, nil
+}
+
+// ZADD key score member [score member ...]
+// Return value is the number of added members.
+func zaddCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 3 || r.argc%2 != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	scores := make([]float64, 0, r.argc/2)
+	for i := 1; i < r.argc; i += 2 {
+		f, err := parseScore(r.argv[i])
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, f)
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		z = newSortedSet()
+		s.set(r.argv[0], z)
+	}
+
+	added := 0
+	for i, f := range scores {
+		if z.add(r.argv[i*2+2], f) {
+			added++
+		}
+	}
+	notifyKeyspaceEvent(notifyZset, "zadd", r.argv[0], r.db)
+
+	return added, nil
+}
+
+// ZSCORE key member
+func zscoreCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil || z == nil {
+		return nil, err
+	}
+
+	if f, ok := z.score(r.argv[1]); ok {
+		return formatScore(f), nil
+	}
+	return nil, nil
+}
+
+// ZREM key member [member ...]
+// Return value is the number of removed members.
+func zremCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc < 2 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil || z == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, m := range r.argv[1:] {
+		if z.rem(m) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		notifyKeyspaceEvent(notifyZset, "zrem", r.argv[0], r.db)
+	}
+	if z.len() == 0 {
+		s.del(r.argv[0])
+		notifyKeyspaceEvent(notifyGeneric, "del", r.argv[0], r.db)
+	}
+
+	return removed, nil
+}
+
+// ZCARD key
+func zcardCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 1 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil || z == nil {
+		return 0, err
+	}
+
+	return z.len(), nil
+}
+
+// Scores are replied as strings, since JSON can't represent infinity.
+type zsetMemberReply struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+// formatScore formats integral scores without an exponent, so geohash
+// scores are replied as integers the way Redis does.
+func formatScore(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ZRANGE key start stop [WITHSCORES]
+// Return value is a list of members between start and stop indexes (both
+// inclusive) ordered by score. Negative indexes are counted from the end.
+func zrangeCommand(s *storage, r *request) (interface{}, error) {
+	if r.argc != 3 && r.argc != 4 {
+		return nil, ErrWrongNumOfArguments
+	}
+
+	start, serr := strconv.ParseInt(r.argv[1], 10, 64)
+	stop, eerr := strconv.ParseInt(r.argv[2], 10, 64)
+	if serr != nil || eerr != nil {
+		return nil, ErrBadArguments
+	}
+	withscores := r.argc == 4
+	if withscores && strings.ToLower(r.argv[3]) != "withscores" {
+		return nil, ErrBadArguments
+	}
+
+	z, err := findZSetEntry(s, r.argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		return []string{}, nil
+	}
+
+	start, stop, ok := normalizeRange(start, stop, int64(z.len()))
+	if !ok {
+		return []string{}, nil
+	}
+
+	members := z.members[start : stop+1]
+	if withscores {
+		res := make([]zsetMemberReply, len(members))
+		for i, m := range members {
+			res[i] = zsetMemberReply{m.member, formatScore(m.score)}
+		}
+		return res, nil
+	}
+
+	res := make([]string, len(members))
+	for i, m := range members {
+		res[i] = m.member
+	}
+	return res, nil
+}
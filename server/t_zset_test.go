@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestZRange(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		argv []string
+		want string // the reply encoded to JSON
+	}{
+		{"zadd", []string{"z", "2", "b", "1", "a", "3", "c", "2", "ab"}, "4"},
+		{"zadd", []string{"z", "-inf", "min", "+inf", "max"}, "2"},
+		{"zrange", []string{"z", "0", "-1"}, `["min","a","ab","b","c","max"]`},
+		{"zrange", []string{"z", "1", "2"}, `["a","ab"]`},
+		{"zrange", []string{"z", "-2", "-1"}, `["c","max"]`},
+		{"zrange", []string{"z", "-100", "0"}, `["min"]`},
+		{"zrange", []string{"z", "4", "100"}, `["c","max"]`},
+		{"zrange", []string{"z", "3", "1"}, `[]`},
+		{"zrange", []string{"z", "10", "20"}, `[]`},
+		{"zrange", []string{"z", "0", "1", "WITHSCORES"},
+			`[{"member":"min","score":"-Inf"},{"member":"a","score":"1"}]`},
+		{"zadd", []string{"z", "0.5", "c"}, "0"},
+		{"zrange", []string{"z", "0", "2", "WITHSCORES"},
+			`[{"member":"min","score":"-Inf"},{"member":"c","score":"0.5"},{"member":"a","score":"1"}]`},
+		{"zrem", []string{"z", "c", "x"}, "1"},
+		{"zcard", []string{"z"}, "5"},
+		{"zrange", []string{"none", "0", "-1"}, `[]`},
+		{"zrange", []string{"z", "0", "-1", "SCORES"}, "null"},
+	}
+
+	t.Log("Given a sorted set")
+
+	s := newStorage()
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen executing %s %v", i, tt.cmd, tt.argv)
+
+		res, _ := execute(s, tt.cmd, tt.argv...)
+		b, _ := json.Marshal(res)
+		if got := string(b); got == tt.want {
+			t.Logf("\t%s\tShould get %s", succeed, tt.want)
+		} else {
+			t.Errorf("\t%s\tShould get %s, got %s", failed, tt.want, got)
+		}
+	}
+}
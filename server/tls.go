@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TLS listeners are started on addresses of the tls-addr parameter, next
+// to plain ones of addr or instead of them if addr is empty. Certificates
+// and other TLS parameters can be changed with CONFIG SET, certificate
+// files are also reloaded when they change on disk, so renewed certificates
+// are picked up without a restart. Established connections are not affected.
+//
+// With tls-auth-clients set to yes or optional, certificates of clients
+// are verified against tls-ca-cert-file. With tls-auth-clients-user set to CN,
+// a client with a verified certificate is authenticated as the ACL user named
+// by the common name of the certificate if there is such an enabled user.
+const tlsReloadInterval = 10 * time.Second
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuthModes = map[string]tls.ClientAuthType{
+	"no":       tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"yes":      tls.RequireAndVerifyClientCert,
+}
+
+var (
+	// tlsConfig holds the current *tls.Config, it is replaced on reload.
+	tlsConfig atomic.Value
+
+	// modification times of files tlsConfig is built from
+	tlsFilesModTime map[string]time.Time
+)
+
+// buildTLSConfig creates the TLS configuration from the parameters.
+func buildTLSConfig() (*tls.Config, error) {
+	if config.tlsCertFile == "" || config.tlsKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(config.tlsCertFile, config.tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load TLS certificate: %v", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tlsVersions[config.tlsMinVersion],
+		ClientAuth:   tlsClientAuthModes[config.tlsAuthClients],
+	}
+
+	if config.tlsCiphers != "" {
+		suites := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range strings.Split(config.tlsCiphers, ":") {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown or insecure TLS cipher suite %s", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if config.tlsCACertFile != "" {
+		pem, err := os.ReadFile(config.tlsCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load CA certificates: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Could not load CA certificates: no certificates found")
+		}
+	} else if cfg.ClientAuth != tls.NoClientCert {
+		return nil, errors.New("tls-ca-cert-file is required to authenticate clients")
+	}
+
+	return cfg, nil
+}
+
+func tlsFiles() []string {
+	files := []string{config.tlsCertFile, config.tlsKeyFile}
+	if config.tlsCACertFile != "" {
+		files = append(files, config.tlsCACertFile)
+	}
+	return files
+}
+
+func modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			times[f] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// reloadTLS replaces the TLS configuration used for new connections.
+// Nothing is changed if the configuration is invalid. It does nothing
+// unless the server has TLS listeners. The caller must hold execMutex
+// or be the only goroutine running.
+func reloadTLS() error {
+	if len(config.tlsAddrs) == 0 {
+		return nil
+	}
+
+	times := modTimes(tlsFiles())
+	cfg, err := buildTLSConfig()
+	if err != nil {
+		return err
+	}
+	tlsConfig.Store(cfg)
+	tlsFilesModTime = times
+	return nil
+}
+
+// runTLSReloader reloads the TLS configuration when its files change.
+func runTLSReloader() {
+	ticker := time.NewTicker(tlsReloadInterval)
+	for range ticker.C {
+		execMutex.Lock()
+		times := modTimes(tlsFiles())
+		changed := false
+		for f, t := range times {
+			if !t.Equal(tlsFilesModTime[f]) {
+				changed = true
+			}
+		}
+		if changed {
+			if err := reloadTLS(); err != nil {
+				logf(logWarning, "Could not reload TLS certificates: %v\n", err)
+				// don't retry until the files change again
+				tlsFilesModTime = times
+			} else {
+				logf(logNotice, "TLS certificates are reloaded\n")
+			}
+		}
+		execMutex.Unlock()
+	}
+}
+
+// listenTLS returns a listener of TLS connections using
+// the current configuration for every handshake.
+func listenTLS(addr string) (net.Listener, error) {
+	li, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(li, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return tlsConfig.Load().(*tls.Config), nil
+		},
+	}), nil
+}
+
+// tlsHandshake completes the handshake of a TLS connection and returns
+// the common name of the verified client certificate if there is one.
+func tlsHandshake(conn *tls.Conn) (string, error) {
+	// deadlines are changed under clientsMutex, so the read deadline
+	// set by shutdown is never overwritten
+	setDeadline := func(t time.Time) {
+		clientsMutex.Lock()
+		defer clientsMutex.Unlock()
+
+		conn.SetWriteDeadline(t)
+		if !shuttingDown {
+			conn.SetReadDeadline(t)
+		}
+	}
+	setDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer setDeadline(time.Time{})
+
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return "", nil
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName, nil
+}
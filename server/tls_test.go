@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a certificate with the common name and its key to dir
+// and returns the certificate and the paths of the files. The certificate
+// is signed by the parent or self-signed if the parent is nil.
+func writeCert(t *testing.T, dir string, cn string, parent *tls.Certificate) (tls.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return cert, certFile, keyFile
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	dir := t.TempDir()
+	ca, caFile, caKeyFile := writeCert(t, dir, "ca", nil)
+	_, certFile, keyFile := writeCert(t, dir, "server", &ca)
+
+	tests := []struct {
+		cert, key, ca string
+		authClients   string
+		ciphers       string
+		ok            bool
+	}{
+		{"", keyFile, "", "no", "", false},
+		{certFile, "", "", "no", "", false},
+		{certFile, filepath.Join(dir, "none"), "", "no", "", false},
+		{certFile, caKeyFile, "", "no", "", false},
+		{certFile, keyFile, "", "no", "", true},
+		{certFile, keyFile, "", "yes", "", false},
+		{certFile, keyFile, "", "optional", "", false},
+		{certFile, keyFile, caFile, "yes", "", true},
+		{certFile, keyFile, filepath.Join(dir, "none"), "no", "", false},
+		{certFile, keyFile, caKeyFile, "yes", "", false},
+		{certFile, keyFile, "", "no", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", true},
+		{certFile, keyFile, "", "no", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:TLS_RSA_WITH_RC4_128_SHA", false},
+		{certFile, keyFile, "", "no", "NOPE", false},
+	}
+
+	t.Log("Given TLS parameters")
+
+	config.tlsMinVersion = "1.2"
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen building the config of cert %q, key %q, CA %q, auth %s, ciphers %q",
+			i, filepath.Base(tt.cert), filepath.Base(tt.key), filepath.Base(tt.ca), tt.authClients, tt.ciphers)
+
+		config.tlsCertFile, config.tlsKeyFile, config.tlsCACertFile = tt.cert, tt.key, tt.ca
+		config.tlsAuthClients, config.tlsCiphers = tt.authClients, tt.ciphers
+		cfg, err := buildTLSConfig()
+		if (err == nil) == tt.ok {
+			t.Logf("\t%s\tShould succeed: %v", succeed, tt.ok)
+		} else {
+			t.Errorf("\t%s\tShould succeed: %v, got %v", failed, tt.ok, err)
+		}
+		if err == nil && (cfg.ClientAuth != tlsClientAuthModes[tt.authClients] || cfg.MinVersion != tls.VersionTLS12) {
+			t.Errorf("\t%s\tShould apply parameters, got %v and %x", failed, cfg.ClientAuth, cfg.MinVersion)
+		}
+	}
+}
+
+func TestTLSClientUser(t *testing.T) {
+	saved, users := config, aclUsers
+	defer func() { config, aclUsers = saved, users }()
+
+	dir := t.TempDir()
+	ca, caFile, _ := writeCert(t, dir, "ca", nil)
+	_, certFile, keyFile := writeCert(t, dir, "server", &ca)
+	aliceCert, _, _ := writeCert(t, dir, "alice", &ca)
+	bobCert, _, _ := writeCert(t, dir, "bob", &ca)
+
+	config.tlsCertFile, config.tlsKeyFile, config.tlsCACertFile = certFile, keyFile, caFile
+	config.tlsAuthClients, config.tlsMinVersion = "optional", "1.2"
+	cfg, err := buildTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aclUsers = map[string]*aclUser{"default": newDefaultACLUser(), "alice": newACLUser("alice"), "bob": newACLUser("bob")}
+	aclUsers["default"].setRules([]string{"resetpass", ">secret"})
+	aclUsers["alice"].setRules([]string{"on", "allcommands", "allkeys"})
+
+	// connect returns the client of the connection made with the certificate.
+	connect := func(certs []tls.Certificate) *client {
+		conn, peer := net.Pipe()
+		t.Cleanup(func() {
+			conn.Close()
+			peer.Close()
+		})
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Leaf)
+		go tls.Client(peer, &tls.Config{Certificates: certs, RootCAs: roots, ServerName: "server"}).Handshake()
+
+		c := &client{conn: conn}
+		name, err := tlsHandshake(tls.Server(conn, cfg))
+		if err != nil {
+			t.Fatalf("\t%s\tShould complete the handshake: %v", failed, err)
+		}
+		c.certName = name
+		return c
+	}
+
+	tests := []struct {
+		certs  []tls.Certificate
+		userCN string
+		user   string
+		auth   bool
+	}{
+		{[]tls.Certificate{aliceCert}, "CN", "alice", true},
+		{[]tls.Certificate{aliceCert}, "off", "default", false},
+		{[]tls.Certificate{bobCert}, "CN", "default", false}, // bob is disabled
+		{nil, "CN", "default", false},
+	}
+
+	t.Log("Given users alice and disabled bob")
+
+	for i, tt := range tests {
+		t.Logf("\tTest: %d\tWhen connecting with %d certificates and tls-auth-clients-user %s", i, len(tt.certs), tt.userCN)
+
+		config.tlsAuthClientsUser = tt.userCN
+		c := connect(tt.certs)
+		checkPermission(c, "ping", nil)
+		if c.user.name == tt.user && c.authenticated == tt.auth {
+			t.Logf("\t%s\tShould be the user %s authenticated: %v", succeed, tt.user, tt.auth)
+		} else {
+			t.Errorf("\t%s\tShould be the user %s authenticated: %v, got %s %v", failed, tt.user, tt.auth, c.user.name, c.authenticated)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Log("Given a path of a Unix socket")
+	{
+		t.Log("\tWhen a regular file exists at the path")
+		{
+			path := filepath.Join(dir, "file")
+			os.WriteFile(path, []byte("data"), 0644)
+
+			_, err := listenUnix(path, 0)
+			if b, _ := os.ReadFile(path); err != nil && string(b) == "data" {
+				t.Logf("\t%s\tShould fail keeping the file", succeed)
+			} else {
+				t.Errorf("\t%s\tShould fail keeping the file, got %v", failed, err)
+			}
+		}
+
+		t.Log("\tWhen a socket is left by a previous run")
+		{
+			path := filepath.Join(dir, "stale.sock")
+			li, err := net.Listen("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			li.(*net.UnixListener).SetUnlinkOnClose(false)
+			li.Close()
+
+			li, err = listenUnix(path, 0700)
+			if err != nil {
+				t.Fatalf("\t%s\tShould replace the socket: %v", failed, err)
+			}
+			t.Logf("\t%s\tShould replace the socket", succeed)
+
+			if fi, err := os.Stat(path); err == nil && fi.Mode().Perm() == 0700 {
+				t.Logf("\t%s\tShould set permissions", succeed)
+			} else {
+				t.Errorf("\t%s\tShould set permissions 0700, got %v", failed, fi)
+			}
+
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			sc, err := li.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sc.Close()
+			if addr := (&client{conn: sc, id: 7}).addr(); addr == "unix:"+path+":7" {
+				t.Logf("\t%s\tShould name clients after the socket", succeed)
+			} else {
+				t.Errorf("\t%s\tShould name clients after the socket, got %s", failed, addr)
+			}
+
+			li.Close()
+			if _, err := os.Lstat(path); os.IsNotExist(err) {
+				t.Logf("\t%s\tShould remove the socket on close", succeed)
+			} else {
+				t.Errorf("\t%s\tShould remove the socket on close, got %v", failed, err)
+			}
+		}
+	}
+}